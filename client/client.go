@@ -2,7 +2,9 @@ package client
 
 import (
 	"bufio"
+	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
 	"math"
 	"net"
@@ -19,6 +21,7 @@ import (
 	"github.com/lbryio/transcoder/pkg/logging"
 	"github.com/lbryio/transcoder/pkg/resolve"
 	"github.com/lbryio/transcoder/pkg/timer"
+	"github.com/lbryio/transcoder/pkg/urlsign"
 
 	"github.com/karlseguin/ccache/v2"
 	"github.com/karrick/godirwalk"
@@ -42,6 +45,10 @@ const (
 
 	fragmentRetrievalRetries = 3
 
+	signatureRenewMargin = time.Minute
+	// manifestRetryInterval is how long a failure to fetch the stream manifest is cached before fetching it again.
+	manifestRetryInterval = 30 * time.Second
+
 	defaultRemoteServer = "https://cache-us.transcoder.odysee.com"
 
 	fragmentCacheDuration  = time.Hour * 24 * 30
@@ -57,6 +64,7 @@ const (
 var (
 	ErrNotOK             = errors.New("http response not OK")
 	ErrNotFound          = errors.New("fragment not found")
+	ErrFragmentCorrupt   = errors.New("fragment does not match stream manifest")
 	ErrChannelNotEnabled = resolve.ErrChannelNotEnabled
 
 	errRefetch = errors.New("should refetch")
@@ -78,6 +86,8 @@ type Client struct {
 
 	cache      *ccache.Cache
 	streamURLs *sync.Map
+	// manifests keeps sizes and hashes of stream files by SD hash.
+	manifests *sync.Map
 }
 
 type Configuration struct {
@@ -97,6 +107,21 @@ type Fragment struct {
 
 type streamLocation struct {
 	path, origin string
+	// signature holds query params of signed locations, sent along with every stream file request.
+	signature url.Values
+	expires   time.Time
+}
+
+// manifestEntries is a cached result of fetching the stream manifest. Failed fetches are only cached until retryAt,
+// so the manifest is not requested for every fragment while origin is failing.
+type manifestEntries struct {
+	entries map[string]library.FileEntry
+	retryAt time.Time
+}
+
+// expired is true when a signed location is about to expire and should be requested again.
+func (l streamLocation) expired() bool {
+	return !l.expires.IsZero() && time.Until(l.expires) < signatureRenewMargin
 }
 
 func Configure() *Configuration {
@@ -175,6 +200,7 @@ func New(cfg *Configuration) Client {
 	c := Client{
 		Configuration: cfg,
 		streamURLs:    &sync.Map{},
+		manifests:     &sync.Map{},
 	}
 	if c.logLevel == Dev {
 		c.logger = logging.Create("client", logging.Dev)
@@ -262,7 +288,12 @@ func (c Client) tmpDir() string {
 }
 
 func (c Client) BuildURL(loc streamLocation, filename string) string {
-	return fmt.Sprintf("%s%s%s?origin=%s", c.remoteServer, loc.path, filename, loc.origin)
+	q := url.Values{}
+	for k, v := range loc.signature {
+		q[k] = v
+	}
+	q.Set("origin", loc.origin)
+	return fmt.Sprintf("%s%s%s?%s", c.remoteServer, loc.path, filename, q.Encode())
 }
 
 // GetPlaybackPath returns a root HLS playlist path.
@@ -296,13 +327,17 @@ func (c Client) deleteCachedFragment(i *ccache.Item) {
 
 func (c Client) discardFragmentURL(sdHash string) {
 	c.streamURLs.Delete(sdHash)
+	c.manifests.Delete(sdHash)
 	c.cache.DeletePrefix(cacheFragmentKey(sdHash, ""))
 }
 
 func (c Client) getFragmentURL(lbryURL, sdHash, name string) (string, error) {
 	if d, ok := c.streamURLs.Load(sdHash); ok {
 		loc, _ := d.(streamLocation)
-		return c.BuildURL(loc, name), nil
+		if !loc.expired() {
+			return c.BuildURL(loc, name), nil
+		}
+		c.streamURLs.Delete(sdHash)
 	}
 
 	// Getting root playlist location from transcoder.
@@ -351,6 +386,48 @@ func (c Client) callAPI(lbryURL string) (*http.Response, error) {
 	return c.httpClient.Do(req)
 }
 
+// fileEntries returns sizes and hashes of stream files recorded in the stream manifest.
+// Streams with manifests missing or lacking file entries are not verified.
+func (c Client) fileEntries(sdHash string) map[string]library.FileEntry {
+	if e, ok := c.manifests.Load(sdHash); ok {
+		me := e.(manifestEntries)
+		if me.retryAt.IsZero() || time.Now().Before(me.retryAt) {
+			return me.entries
+		}
+	}
+	d, ok := c.streamURLs.Load(sdHash)
+	if !ok {
+		return nil
+	}
+	req, err := http.NewRequest(http.MethodGet, c.BuildURL(d.(streamLocation), library.ManifestName), nil)
+	if err != nil {
+		return nil
+	}
+	failed := manifestEntries{retryAt: time.Now().Add(manifestRetryInterval)}
+	r, err := c.httpClient.Do(req)
+	if err != nil {
+		c.logger.Debugw("cannot fetch stream manifest", "sd_hash", sdHash, "err", err)
+		c.manifests.Store(sdHash, failed)
+		return nil
+	}
+	defer r.Body.Close()
+	me := manifestEntries{}
+	switch {
+	case r.StatusCode == http.StatusOK:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			c.logger.Debugw("cannot read stream manifest", "sd_hash", sdHash, "err", err)
+			me = failed
+		} else if m, err := library.DecodeManifest(data); err == nil {
+			me.entries = m.EntryMap()
+		}
+	case r.StatusCode >= http.StatusInternalServerError:
+		me = failed
+	}
+	c.manifests.Store(sdHash, me)
+	return me.entries
+}
+
 func (c Client) fetchFragment(url, sdHash, name string) (int64, error) {
 	var (
 		src        string
@@ -419,12 +496,26 @@ func (c Client) fetchFragment(url, sdHash, name string) (int64, error) {
 	dstName := path.Join(c.videoPath, sdHash, name)
 	tmpName := path.Join(c.tmpDir(), fmt.Sprintf("%s-%s", sdHash, name))
 
+	// Master playlist is modified above so it cannot be checked against the manifest.
+	var hasher hash.Hash
+	entry, verify := c.fileEntries(sdHash)[name]
+	if verify && name != MasterPlaylistName {
+		hasher = library.GetStreamHasher()
+		bodyReader = io.TeeReader(bodyReader, hasher)
+	}
+
 	size, err := directCopy(tmpName, bodyReader)
 	FetchSizeBytes.WithLabelValues(src).Add(float64(size))
 
 	if err != nil {
 		return size, err
 	}
+	if hasher != nil && (size != entry.Size || hex.EncodeToString(hasher.Sum(nil)) != entry.Hash) {
+		os.Remove(tmpName)
+		FetchFailureCount.WithLabelValues(src, failureCorrupt).Inc()
+		c.logger.Warnw("fragment corrupt", "url", url, "size", size, "expected_size", entry.Size)
+		return size, ErrFragmentCorrupt
+	}
 	err = os.Rename(tmpName, dstName)
 	if err != nil {
 		return size, err
@@ -529,5 +620,9 @@ func buildStreamLocation(uri string) (streamLocation, error) {
 	}
 	loc.path = parsed.Path
 	loc.origin = parsed.Host
+	if q := parsed.Query(); q.Get(urlsign.ParamSignature) != "" {
+		loc.signature = q
+		loc.expires = urlsign.Expires(q)
+	}
 	return loc, nil
 }
@@ -1,21 +1,25 @@
 package client
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/lbryio/transcoder/library"
 	"github.com/lbryio/transcoder/pkg/resolve"
+	"github.com/lbryio/transcoder/pkg/urlsign"
 
 	"github.com/karrick/godirwalk"
 	"github.com/stretchr/testify/suite"
@@ -244,6 +248,27 @@ func (s *clientSuite) TestRemoteURL() {
 	)
 }
 
+func (s *clientSuite) TestSignedRemoteURL() {
+	sdhash := "bec50ab288153ed03b0eb8dafd814daf19a187e07f8da4ad91cf778f5c39ac74d9d92ad6e3ebf2ddb6b7acea3cb8893a"
+	signer, err := urlsign.New(time.Hour, urlsign.Key{ID: "k1", Secret: "secret"})
+	s.Require().NoError(err)
+	q := signer.Sign(sdhash)
+	cl := dummyRedirectClient(fmt.Sprintf("remote://storage1/%v/?%v", sdhash, q.Encode()))
+	c := New(Configure().HTTPClient(cl))
+
+	c.streamURLs.Store(sdhash, streamLocation{
+		path: "/" + sdhash + "/", origin: "storage1",
+		signature: signer.SignAt(sdhash, time.Now()), expires: time.Now(),
+	})
+	u, err := c.getFragmentURL("morgan", sdhash, "master.m3u8")
+	s.Require().NoError(err)
+	parsed, err := url.Parse(u)
+	s.Require().NoError(err)
+	s.Equal("storage1", parsed.Query().Get("origin"))
+	s.Equal(q.Get(urlsign.ParamExpires), parsed.Query().Get(urlsign.ParamExpires))
+	s.NoError(signer.Verify(parsed.Path, parsed.Query()))
+}
+
 func (s *clientSuite) TestGetPlaybackPath() {
 	url := "morgan"
 	sdhash := "bec50ab288153ed03b0eb8dafd814daf19a187e07f8da4ad91cf778f5c39ac74d9d92ad6e3ebf2ddb6b7acea3cb8893a"
@@ -269,6 +294,54 @@ func (s *clientSuite) Test_getFragmentURL() {
 	s.Equal("https://cache-us.transcoder.odysee.com/sdhash/master.m3u8?origin=storage1", u)
 }
 
+func (s *clientSuite) TestFetchFragmentCorrupt() {
+	dir := s.T().TempDir()
+	s.Require().NoError(os.MkdirAll(path.Join(dir, "sdhash"), os.ModePerm))
+	data := []byte(randomString(1000))
+	s.Require().NoError(os.WriteFile(path.Join(dir, "sdhash", "s0_000000.ts"), data, 0644))
+	e, err := library.HashFile("s0_000000.ts", bytes.NewReader(data))
+	s.Require().NoError(err)
+	m := library.Manifest{Entries: []library.FileEntry{e}}
+	mdata, err := m.EncodeYAML()
+	s.Require().NoError(err)
+	s.Require().NoError(os.WriteFile(path.Join(dir, "sdhash", library.ManifestName), mdata, 0644))
+
+	srv := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer srv.Close()
+	c := New(Configure().VideoPath(s.T().TempDir()).RemoteServer(srv.URL).LogLevel(Dev))
+	loc := streamLocation{path: "/sdhash/", origin: "storage1"}
+	c.streamURLs.Store("sdhash", loc)
+
+	size, err := c.fetchFragment(c.BuildURL(loc, "s0_000000.ts"), "sdhash", "s0_000000.ts")
+	s.Require().NoError(err)
+	s.EqualValues(len(data), size)
+
+	s.Require().NoError(os.WriteFile(path.Join(dir, "sdhash", "s0_000000.ts"), append(data, 1), 0644))
+	_, err = c.fetchFragment(c.BuildURL(loc, "s0_000000.ts"), "sdhash", "s0_000000.ts")
+	s.ErrorIs(err, ErrFragmentCorrupt)
+}
+
+func (s *clientSuite) TestFileEntriesFailureCached() {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		panic(http.ErrAbortHandler)
+	}))
+	defer srv.Close()
+	c := New(Configure().VideoPath(s.T().TempDir()).RemoteServer(srv.URL).LogLevel(Dev))
+	c.streamURLs.Store("sdhash", streamLocation{path: "/sdhash/", origin: "storage1"})
+
+	s.Nil(c.fileEntries("sdhash"))
+	s.Nil(c.fileEntries("sdhash"))
+	s.EqualValues(1, atomic.LoadInt32(&hits), "failed manifest fetch should be cached")
+
+	e, _ := c.manifests.Load("sdhash")
+	s.WithinDuration(time.Now().Add(manifestRetryInterval), e.(manifestEntries).retryAt, time.Second)
+	c.manifests.Store("sdhash", manifestEntries{retryAt: time.Now().Add(-time.Second)})
+	s.Nil(c.fileEntries("sdhash"))
+	s.EqualValues(2, atomic.LoadInt32(&hits), "manifest should be fetched again after retry interval")
+}
+
 func randomString(n int) string {
 	var letter = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
 
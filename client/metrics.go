@@ -11,6 +11,7 @@ const (
 	failureNotFound    = "not_found"
 	failureTransport   = "transport_error"
 	failureServerError = "server_error"
+	failureCorrupt     = "corrupt"
 
 	resultUnderway = "underway"
 	resultFound    = "found"
@@ -0,0 +1,295 @@
+package encoder
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lbryio/transcoder/ladder"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// ChunkName is ffmpeg segment muxer output pattern for source chunks.
+	ChunkName = "chunk%04d.mkv"
+
+	// stitchedSegmentName must match hls_segment_filename pattern used for regular encodes.
+	stitchedSegmentName = "v%d_s%06d.ts"
+
+	tagDiscontinuity  = "#EXT-X-DISCONTINUITY"
+	tagTargetDuration = "#EXT-X-TARGETDURATION:"
+	tagMediaSequence  = "#EXT-X-MEDIA-SEQUENCE:"
+	tagEndList        = "#EXT-X-ENDLIST"
+	tagInf            = "#EXTINF:"
+)
+
+var (
+	variantPlaylistRe = regexp.MustCompile(`^v(\d+)\.m3u8$`)
+	bandwidthRe       = regexp.MustCompile(`([^-]BANDWIDTH=)(\d+)`)
+)
+
+// SplitResult describes a source cut into chunks for independent encoding.
+type SplitResult struct {
+	// Chunks are full paths to chunk files in playback order.
+	Chunks []string
+	// Extras is a directory with files that should be included into the final stream, like sprites.
+	Extras   string
+	OrigMeta *ladder.Metadata
+	// Ladder is adjusted to the whole source and should be used as-is for encoding every chunk.
+	Ladder ladder.Ladder
+}
+
+// Split cuts input into chunks of approximately chunkDuration at keyframes without re-encoding.
+// Chunks are written into out/chunks and sprites, if configured, are generated into out/extras.
+// If l is nil, the configured ladder is used.
+func (e encoder) Split(input, out string, l *ladder.Ladder, chunkDuration time.Duration) (*SplitResult, error) {
+	meta, err := e.GetMetadata(input)
+	if err != nil {
+		return nil, err
+	}
+	if l == nil {
+		l = &e.ladder
+	}
+	targetLadder, err := l.Tweak(meta)
+	if err != nil {
+		return nil, err
+	}
+	res := &SplitResult{
+		Extras:   path.Join(out, "extras"),
+		OrigMeta: meta,
+		Ladder:   targetLadder,
+	}
+	chunksDir := path.Join(out, "chunks")
+	for _, d := range []string{chunksDir, res.Extras} {
+		if err := os.MkdirAll(d, os.ModePerm); err != nil {
+			return nil, err
+		}
+	}
+
+	if e.spriteGen != nil {
+		if err := e.spriteGen.Generate(input, res.Extras); err != nil {
+			return nil, errors.Wrap(err, "could not start spritegen")
+		}
+		os.RemoveAll(path.Join(res.Extras, "processing"))
+	}
+
+	args := []string{
+		"-i", input,
+		"-map", "0:v:0", "-map", "0:a:0?",
+		"-c", "copy",
+		"-f", "segment",
+		"-segment_time", strconv.FormatFloat(chunkDuration.Seconds(), 'f', 3, 64),
+		"-reset_timestamps", "1",
+		"-avoid_negative_ts", "make_zero",
+		ChunkName,
+	}
+	e.log.Info("splitting source", "input", input, "args", strings.Join(args, " "))
+	cmd := exec.Command(e.ffmpegPath, args...)
+	cmd.Dir = chunksDir
+	var errb bytes.Buffer
+	cmd.Stderr = &errb
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("splitting failed: %w: %s", err, errb.String())
+	}
+
+	entries, err := os.ReadDir(chunksDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, en := range entries {
+		res.Chunks = append(res.Chunks, path.Join(chunksDir, en.Name()))
+	}
+	sort.Strings(res.Chunks)
+	if len(res.Chunks) == 0 {
+		return nil, errors.New("splitting produced no chunks")
+	}
+	e.log.Info("source split", "input", input, "chunks", len(res.Chunks))
+	return res, nil
+}
+
+// EncodeChunk transcodes a single chunk produced by Split.
+// Unlike EncodeWithLadder, the ladder is not adjusted to the chunk so all chunks end up with the same tiers.
+func (e encoder) EncodeChunk(input, output string, l ladder.Ladder) (*Result, error) {
+	meta, err := e.GetMetadata(input)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(output, os.ModePerm); err != nil {
+		return nil, err
+	}
+	res := &Result{Input: input, Output: output, OrigMeta: meta, Ladder: l}
+	return e.transcode(input, meta, res, l.ArgumentSet(output, meta), e.log.With("input", input, "output", output))
+}
+
+type playlistSegment struct {
+	inf, uri      string
+	discontinuity bool
+}
+
+// StitchChunks joins HLS output of chunks encoded with EncodeChunk into a single VOD stream in out.
+// Segments are moved and renumbered consecutively, chunk boundaries are marked with discontinuity tags
+// since every chunk starts from zero timestamp.
+func StitchChunks(chunkDirs []string, out string) error {
+	if len(chunkDirs) == 0 {
+		return errors.New("no chunks to stitch")
+	}
+	if err := os.MkdirAll(out, os.ModePerm); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(chunkDirs[0])
+	if err != nil {
+		return err
+	}
+	variants := []int{}
+	for _, en := range entries {
+		if m := variantPlaylistRe.FindStringSubmatch(en.Name()); m != nil {
+			v, _ := strconv.Atoi(m[1])
+			variants = append(variants, v)
+		}
+	}
+	if len(variants) == 0 {
+		return fmt.Errorf("no variant playlists found in %s", chunkDirs[0])
+	}
+	sort.Ints(variants)
+
+	for _, v := range variants {
+		if err := stitchVariant(chunkDirs, out, v); err != nil {
+			return errors.Wrapf(err, "cannot stitch variant %v", v)
+		}
+	}
+	return stitchMaster(chunkDirs, out)
+}
+
+func stitchVariant(chunkDirs []string, out string, v int) error {
+	name := fmt.Sprintf(VariantPlaylist, v)
+	var targetDuration, seq int
+	stitched := []playlistSegment{}
+	for n, dir := range chunkDirs {
+		pl, err := readMediaPlaylist(path.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		if pl.targetDuration > targetDuration {
+			targetDuration = pl.targetDuration
+		}
+		for i, s := range pl.segments {
+			segName := fmt.Sprintf(stitchedSegmentName, v, seq)
+			if err := os.Rename(path.Join(dir, s.uri), path.Join(out, segName)); err != nil {
+				return err
+			}
+			stitched = append(stitched, playlistSegment{inf: s.inf, uri: segName, discontinuity: n > 0 && i == 0})
+			seq++
+		}
+	}
+	return writeMediaPlaylist(path.Join(out, name), targetDuration, stitched)
+}
+
+// stitchMaster writes master playlist of the first chunk, with each variant bandwidth raised to the maximum across chunks.
+func stitchMaster(chunkDirs []string, out string) error {
+	var lines []string
+	for n, dir := range chunkDirs {
+		data, err := os.ReadFile(path.Join(dir, MasterPlaylist))
+		if err != nil {
+			return err
+		}
+		chunkLines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+		if n == 0 {
+			lines = chunkLines
+			continue
+		}
+		if len(chunkLines) != len(lines) {
+			return fmt.Errorf("master playlist in %s does not match the first chunk", dir)
+		}
+		for i, l := range chunkLines {
+			m := bandwidthRe.FindStringSubmatch(l)
+			if m == nil {
+				continue
+			}
+			cur := bandwidthRe.FindStringSubmatch(lines[i])
+			if cur == nil {
+				continue
+			}
+			bw, _ := strconv.Atoi(m[2])
+			curBW, _ := strconv.Atoi(cur[2])
+			if bw > curBW {
+				lines[i] = bandwidthRe.ReplaceAllString(lines[i], "${1}"+m[2])
+			}
+		}
+	}
+	return os.WriteFile(path.Join(out, MasterPlaylist), []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+type mediaPlaylist struct {
+	targetDuration int
+	segments       []playlistSegment
+	// complete is set when playlist has the end tag, meaning ffmpeg has finished writing it.
+	complete bool
+}
+
+func readMediaPlaylist(name string) (*mediaPlaylist, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var inf string
+	var discontinuity bool
+	pl := &mediaPlaylist{segments: []playlistSegment{}}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		l := strings.TrimSpace(scanner.Text())
+		switch {
+		case l == "":
+		case strings.HasPrefix(l, tagTargetDuration):
+			pl.targetDuration, err = strconv.Atoi(strings.TrimPrefix(l, tagTargetDuration))
+			if err != nil {
+				return nil, fmt.Errorf("malformed target duration in %s: %w", name, err)
+			}
+		case strings.HasPrefix(l, tagInf):
+			inf = l
+		case l == tagDiscontinuity:
+			discontinuity = true
+		case l == tagEndList:
+			pl.complete = true
+		case strings.HasPrefix(l, "#"):
+		default:
+			if inf == "" {
+				return nil, fmt.Errorf("segment %s has no duration in %s", l, name)
+			}
+			pl.segments = append(pl.segments, playlistSegment{inf: inf, uri: l, discontinuity: discontinuity})
+			inf = ""
+			discontinuity = false
+		}
+	}
+	return pl, scanner.Err()
+}
+
+func writeMediaPlaylist(name string, targetDuration int, segments []playlistSegment) error {
+	pl := &strings.Builder{}
+	fmt.Fprintln(pl, "#EXTM3U")
+	fmt.Fprintln(pl, "#EXT-X-VERSION:3")
+	fmt.Fprintf(pl, "%s%d\n", tagTargetDuration, targetDuration)
+	fmt.Fprintf(pl, "%s0\n", tagMediaSequence)
+	fmt.Fprintln(pl, "#EXT-X-PLAYLIST-TYPE:VOD")
+	fmt.Fprintln(pl, "#EXT-X-INDEPENDENT-SEGMENTS")
+	for _, s := range segments {
+		if s.discontinuity {
+			fmt.Fprintln(pl, tagDiscontinuity)
+		}
+		fmt.Fprintln(pl, s.inf)
+		fmt.Fprintln(pl, s.uri)
+	}
+	fmt.Fprintln(pl, tagEndList)
+	return os.WriteFile(name, []byte(pl.String()), 0644)
+}
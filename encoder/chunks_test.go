@@ -0,0 +1,72 @@
+package encoder
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeChunk(t *testing.T, dir string, bandwidth int, durations ...string) {
+	require.NoError(t, os.MkdirAll(dir, os.ModePerm))
+	for v := 0; v < 2; v++ {
+		pl := fmt.Sprintf("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%v\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n", len(durations)+8)
+		for n, d := range durations {
+			seg := fmt.Sprintf("v%v_s%06d.ts", v, n)
+			require.NoError(t, os.WriteFile(path.Join(dir, seg), []byte(dir+seg), 0644))
+			pl += fmt.Sprintf("#EXTINF:%s,\n%s\n", d, seg)
+		}
+		pl += "#EXT-X-ENDLIST\n"
+		require.NoError(t, os.WriteFile(path.Join(dir, fmt.Sprintf("v%v.m3u8", v)), []byte(pl), 0644))
+	}
+	master := fmt.Sprintf(
+		"#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-STREAM-INF:BANDWIDTH=%v,RESOLUTION=1280x720,CODECS=\"avc1.64001f,mp4a.40.2\"\nv0.m3u8\n\n"+
+			"#EXT-X-STREAM-INF:BANDWIDTH=500000,RESOLUTION=640x360,CODECS=\"avc1.64001e,mp4a.40.2\"\nv1.m3u8\n",
+		bandwidth)
+	require.NoError(t, os.WriteFile(path.Join(dir, MasterPlaylist), []byte(master), 0644))
+}
+
+func TestStitchChunks(t *testing.T) {
+	tmp := t.TempDir()
+	c0, c1, out := path.Join(tmp, "c0"), path.Join(tmp, "c1"), path.Join(tmp, "out")
+	writeChunk(t, c0, 2000000, "10.000000", "4.500000")
+	writeChunk(t, c1, 2500000, "10.000000", "10.000000", "1.200000")
+
+	require.NoError(t, StitchChunks([]string{c0, c1}, out))
+
+	v0, err := os.ReadFile(path.Join(out, "v0.m3u8"))
+	require.NoError(t, err)
+	assert.Equal(t, `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:11
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-PLAYLIST-TYPE:VOD
+#EXT-X-INDEPENDENT-SEGMENTS
+#EXTINF:10.000000,
+v0_s000000.ts
+#EXTINF:4.500000,
+v0_s000001.ts
+#EXT-X-DISCONTINUITY
+#EXTINF:10.000000,
+v0_s000002.ts
+#EXTINF:10.000000,
+v0_s000003.ts
+#EXTINF:1.200000,
+v0_s000004.ts
+#EXT-X-ENDLIST
+`, string(v0))
+
+	seg, err := os.ReadFile(path.Join(out, "v1_s000002.ts"))
+	require.NoError(t, err)
+	assert.Equal(t, c1+"v1_s000000.ts", string(seg))
+
+	master, err := os.ReadFile(path.Join(out, MasterPlaylist))
+	require.NoError(t, err)
+	assert.Contains(t, string(master), "BANDWIDTH=2500000,RESOLUTION=1280x720")
+	assert.Contains(t, string(master), "BANDWIDTH=500000,RESOLUTION=640x360")
+
+	assert.Error(t, StitchChunks([]string{}, out))
+}
@@ -9,6 +9,7 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/karrick/godirwalk"
 	"github.com/lbryio/transcoder/internal/metrics"
@@ -20,24 +21,43 @@ import (
 	"github.com/pkg/errors"
 )
 
-const MasterPlaylist = "master.m3u8"
+const (
+	MasterPlaylist = "master.m3u8"
+	// VariantPlaylist is ffmpeg output name pattern for tier playlists, %v is substituted by tier index.
+	VariantPlaylist = "v%v.m3u8"
+)
+
+// FirstPassOutput drops audio and discards muxed output of the first pass of two-pass encoding.
+var FirstPassOutput = []string{"-an", "-f", "null", os.DevNull}
 
 type Encoder interface {
 	Encode(in, out string) (*Result, error)
+	EncodeWithLadder(in, out string, l ladder.Ladder) (*Result, error)
+	Split(in, out string, l *ladder.Ladder, chunkDuration time.Duration) (*SplitResult, error)
+	EncodeChunk(in, out string, l ladder.Ladder) (*Result, error)
+	Resume(in, out string, l *ladder.Ladder) (*Result, error)
 	GetMetadata(input string) (*ladder.Metadata, error)
+	ScoreQuality(res *Result) ([]ladder.QualityScore, error)
+	// Encrypted returns an encoder producing AES-128 encrypted segments with the key described by ffmpeg key info file.
+	Encrypted(keyInfoFile string) Encoder
 }
 
 type Configuration struct {
 	ffmpegPath, ffprobePath,
 	spritegenPath string
+	noSprites     bool
 
 	ladder ladder.Ladder
 	log    logging.KVLogger
+
+	qualityMetric  ladder.QualityMetric
+	qualitySamples int
 }
 
 type encoder struct {
 	*Configuration
-	spriteGen *SpriteGenerator
+	spriteGen   *SpriteGenerator
+	keyInfoFile string
 }
 
 type Result struct {
@@ -60,6 +80,8 @@ func Configure() *Configuration {
 		spritegenPath: spritegenPath,
 		ladder:        ladder.Default,
 		log:           logging.NoopKVLogger{},
+
+		qualitySamples: defaultQualitySamples,
 	}
 }
 
@@ -73,6 +95,9 @@ func NewEncoder(cfg *Configuration) (Encoder, error) {
 	if len(cfg.ladder.Tiers) == 0 {
 		return nil, errors.New("encoding ladder not configured")
 	}
+	if err := cfg.ladder.Validate(); err != nil {
+		return nil, err
+	}
 
 	var cmd *exec.Cmd
 	cmd = exec.Command(cfg.ffmpegPath, "-h")
@@ -87,14 +112,16 @@ func NewEncoder(cfg *Configuration) (Encoder, error) {
 
 	e := encoder{Configuration: cfg}
 
-	spriteGen, err := NewSpriteGenerator(e.spritegenPath, e.log)
-	if err != nil {
-		e.log.Info("sprite generator was not configured", "err", err)
-	} else {
-		e.spriteGen = spriteGen
+	if !cfg.noSprites {
+		spriteGen, err := NewSpriteGenerator(e.spritegenPath, e.log)
+		if err != nil {
+			e.log.Info("sprite generator was not configured", "err", err)
+		} else {
+			e.spriteGen = spriteGen
+		}
 	}
 
-	e.log.Info("encoder configured", "ffmpeg", e.ffmpegPath, "ffprobe", e.ffprobePath, "spritegen", e.spritegenPath)
+	e.log.Info("encoder configured", "ffmpeg", e.ffmpegPath, "ffprobe", e.ffprobePath, "spritegen", e.spritegenPath, "quality_metric", e.qualityMetric)
 	return &e, nil
 }
 
@@ -113,6 +140,12 @@ func (c *Configuration) SpritegenPath(p string) *Configuration {
 	return c
 }
 
+// NoSprites disables sprite generation even if sprite generator is available.
+func (c *Configuration) NoSprites() *Configuration {
+	c.noSprites = true
+	return c
+}
+
 // Log configures encoder logging. Default configuration is a no-op logger.
 func (c *Configuration) Log(l logging.KVLogger) *Configuration {
 	c.log = l
@@ -125,8 +158,26 @@ func (c *Configuration) Ladder(l ladder.Ladder) *Configuration {
 	return c
 }
 
+// QualityMetric enables post-encode quality scoring of produced tiers with the specified metric.
+// Scoring is disabled by default.
+func (c *Configuration) QualityMetric(m ladder.QualityMetric) *Configuration {
+	c.qualityMetric = m
+	return c
+}
+
+// QualitySamples sets how many source segments are compared for each tier during quality scoring.
+func (c *Configuration) QualitySamples(n int) *Configuration {
+	c.qualitySamples = n
+	return c
+}
+
 // Encode does transcoding of specified video file into a series of HLS streams.
 func (e encoder) Encode(input, output string) (*Result, error) {
+	return e.EncodeWithLadder(input, output, e.ladder)
+}
+
+// EncodeWithLadder does the same as Encode but uses the supplied ladder instead of the configured one.
+func (e encoder) EncodeWithLadder(input, output string, l ladder.Ladder) (*Result, error) {
 	meta, err := e.GetMetadata(input)
 	if err != nil {
 		return nil, err
@@ -148,7 +199,7 @@ func (e encoder) Encode(input, output string) (*Result, error) {
 		return nil, err
 	}
 
-	targetLadder, err := e.ladder.Tweak(meta)
+	targetLadder, err := l.Tweak(meta)
 	if err != nil {
 		return nil, err
 	}
@@ -171,10 +222,39 @@ func (e encoder) Encode(input, output string) (*Result, error) {
 		}
 	}
 
-	args := targetLadder.ArgumentSet(output, meta)
+	return e.transcode(input, meta, res, targetLadder.ArgumentSet(output, meta), ll)
+}
+
+func (e encoder) Encrypted(keyInfoFile string) Encoder {
+	e.keyInfoFile = keyInfoFile
+	return &e
+}
+
+// transcode runs ffmpeg for the ladder contained in res, which must already be adjusted to the input.
+func (e encoder) transcode(input string, meta *ladder.Metadata, res *Result, args *ladder.ArgumentSet, ll logging.KVLogger) (*Result, error) {
+	var err error
+	args.KeyInfoFile = e.keyInfoFile
+	output := res.Output
+	targetLadder := res.Ladder
+	var passLogDir string
+	if targetLadder.TwoPass() {
+		passLogDir, err = os.MkdirTemp("", "passlog")
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot create two-pass log directory")
+		}
+		args.PassLogPrefix = path.Join(passLogDir, "passlog")
+		ll.Info("starting first pass", "args", strings.Join(args.BuildFirstPass().Strings(), " "))
+		if err := e.runFirstPass(input, passLogDir, args); err != nil {
+			os.RemoveAll(passLogDir)
+			return nil, err
+		}
+		args.Pass = 2
+	}
+
 	vs := meta.VideoStream
 	ll.Info(
 		"starting transcoding",
+		"ladder", targetLadder.Name,
 		"args", strings.Join(args.GetStrArguments(), " "),
 		"media_duration", meta.FMeta.GetFormat().GetDuration(),
 		"media_bitrate", meta.FMeta.GetFormat().GetBitRate(),
@@ -196,16 +276,55 @@ func (e encoder) Encode(input, output string) (*Result, error) {
 			OutputDir:       output,
 		}).
 		Input(input).
-		Output("v%v.m3u8").
+		Output(VariantPlaylist).
 		Start(args)
 	if err != nil {
+		if passLogDir != "" {
+			os.RemoveAll(passLogDir)
+		}
 		return nil, err
 	}
 
+	if passLogDir != "" {
+		progress = afterProgress(progress, func() { os.RemoveAll(passLogDir) })
+	}
 	res.Progress = progress
 	return res, nil
 }
 
+// runFirstPass blocks until the first pass of two-pass encoding is done.
+// Only video of two-pass tiers is encoded and the output is discarded, pass statistics are written into workDir.
+func (e encoder) runFirstPass(input, workDir string, args *ladder.ArgumentSet) error {
+	cmdArgs := append([]string{"-i", input}, args.BuildFirstPass().Strings()...)
+	cmdArgs = append(cmdArgs, FirstPassOutput...)
+	cmd := exec.Command(e.ffmpegPath, cmdArgs...)
+	cmd.Dir = workDir
+	var errb bytes.Buffer
+	cmd.Stderr = &errb
+	if err := cmd.Run(); err != nil {
+		stderr := errb.String()
+		if len(stderr) > 1000 {
+			stderr = stderr[len(stderr)-1000:]
+		}
+		return fmt.Errorf("first pass failed: %w: %s", err, stderr)
+	}
+	return nil
+}
+
+// afterProgress relays progress updates and calls fn once the progress channel is closed,
+// before closing the returned channel.
+func afterProgress(progress <-chan ffmpegt.Progress, fn func()) <-chan ffmpegt.Progress {
+	out := make(chan ffmpegt.Progress)
+	go func() {
+		defer close(out)
+		defer fn()
+		for p := range progress {
+			out <- p
+		}
+	}()
+	return out
+}
+
 // getMetadata uses ffprobe to parse video file metadata.
 func (e encoder) GetMetadata(input string) (*ladder.Metadata, error) {
 	meta := &ffmpeg.Metadata{}
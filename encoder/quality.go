@@ -0,0 +1,147 @@
+package encoder
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+
+	"github.com/lbryio/transcoder/internal/metrics"
+	"github.com/lbryio/transcoder/ladder"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultQualitySamples = 3
+	qualitySampleSeconds  = 5.0
+
+	// maxPSNR is reported instead of infinity for bit-exact samples.
+	maxPSNR = 100.0
+)
+
+var qualityScorePatterns = map[ladder.QualityMetric]*regexp.Regexp{
+	ladder.QualityVMAF: regexp.MustCompile(`VMAF score[:=]\s*([\d.]+)`),
+	ladder.QualitySSIM: regexp.MustCompile(`SSIM .*All:([\d.]+)`),
+	ladder.QualityPSNR: regexp.MustCompile(`PSNR .*average:([\d.]+|inf)`),
+}
+
+var qualityFilters = map[ladder.QualityMetric]string{
+	ladder.QualityVMAF: "libvmaf",
+	ladder.QualitySSIM: "ssim",
+	ladder.QualityPSNR: "psnr",
+}
+
+// ScoreQuality compares every tier of a finished encode against its source on a few sampled segments
+// and returns an averaged score per tier. It is a no-op unless a quality metric has been configured.
+// Must be called after encoding progress channel is drained.
+func (e encoder) ScoreQuality(res *Result) ([]ladder.QualityScore, error) {
+	if e.qualityMetric == "" {
+		return nil, nil
+	}
+	dur, err := strconv.ParseFloat(res.OrigMeta.FMeta.GetFormat().GetDuration(), 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot determine source duration")
+	}
+	vs := res.OrigMeta.VideoStream
+	offsets := sampleOffsets(dur, e.qualitySamples)
+
+	scores := []ladder.QualityScore{}
+	for n, tier := range res.Ladder.Tiers {
+		var total float64
+		distorted := path.Join(res.Output, fmt.Sprintf(VariantPlaylist, n))
+		for _, offset := range offsets {
+			args := qualityArgs(e.qualityMetric, res.Input, distorted, offset, qualitySampleSeconds, vs.GetWidth(), vs.GetHeight())
+			var out bytes.Buffer
+			cmd := exec.Command(e.ffmpegPath, args...)
+			cmd.Stdout = &out
+			cmd.Stderr = &out
+			if err := cmd.Run(); err != nil {
+				return scores, fmt.Errorf("error scoring tier %v at %.2fs: %w", tier.Height, offset, err)
+			}
+			s, err := parseQualityScore(e.qualityMetric, out.String())
+			if err != nil {
+				return scores, fmt.Errorf("error scoring tier %v at %.2fs: %w", tier.Height, offset, err)
+			}
+			total += s
+		}
+		score := ladder.QualityScore{
+			Definition: tier.Definition,
+			Width:      tier.Width,
+			Height:     tier.Height,
+			Metric:     e.qualityMetric,
+			Score:      total / float64(len(offsets)),
+			Samples:    len(offsets),
+		}
+		observeQualityScore(score)
+		e.log.Info("tier quality scored", "height", score.Height, "metric", score.Metric, "score", score.Score)
+		scores = append(scores, score)
+	}
+	return scores, nil
+}
+
+// sampleOffsets spreads n sample start positions evenly across the source, excluding the very beginning and end.
+func sampleOffsets(duration float64, n int) []float64 {
+	if duration <= qualitySampleSeconds || n < 1 {
+		return []float64{0}
+	}
+	offsets := make([]float64, n)
+	for i := range offsets {
+		offsets[i] = (duration - qualitySampleSeconds) * float64(i+1) / float64(n+1)
+	}
+	return offsets
+}
+
+// qualityArgs builds ffmpeg arguments for comparing a sample of the distorted stream against the reference.
+// Both inputs are scaled to the source resolution before comparison.
+func qualityArgs(metric ladder.QualityMetric, reference, distorted string, offset, duration float64, w, h int) []string {
+	ss := strconv.FormatFloat(offset, 'f', 3, 64)
+	t := strconv.FormatFloat(duration, 'f', 3, 64)
+	filter := fmt.Sprintf(
+		"[0:v]scale=%[1]d:%[2]d:flags=bicubic,setpts=PTS-STARTPTS[dist];"+
+			"[1:v]scale=%[1]d:%[2]d:flags=bicubic,setpts=PTS-STARTPTS[ref];"+
+			"[dist][ref]%[3]s",
+		w, h, qualityFilters[metric],
+	)
+	return []string{
+		"-hide_banner", "-nostats",
+		"-ss", ss, "-t", t, "-i", distorted,
+		"-ss", ss, "-t", t, "-i", reference,
+		"-lavfi", filter,
+		"-f", "null", "-",
+	}
+}
+
+func parseQualityScore(metric ladder.QualityMetric, out string) (float64, error) {
+	re, ok := qualityScorePatterns[metric]
+	if !ok {
+		return 0, fmt.Errorf("unknown quality metric: %s", metric)
+	}
+	m := re.FindStringSubmatch(out)
+	if len(m) < 2 {
+		return 0, fmt.Errorf("no %s score found in ffmpeg output", metric)
+	}
+	s, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsInf(s, 1) {
+		return maxPSNR, nil
+	}
+	return s, nil
+}
+
+func observeQualityScore(s ladder.QualityScore) {
+	res := fmt.Sprintf("%v", s.Height)
+	switch s.Metric {
+	case ladder.QualityVMAF:
+		metrics.EncodedVMAF.WithLabelValues(res).Observe(s.Score)
+	case ladder.QualitySSIM:
+		metrics.EncodedSSIM.WithLabelValues(res).Observe(s.Score)
+	case ladder.QualityPSNR:
+		metrics.EncodedPSNR.WithLabelValues(res).Observe(s.Score)
+	}
+}
@@ -0,0 +1,68 @@
+package encoder
+
+import (
+	"testing"
+
+	"github.com/lbryio/transcoder/ladder"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseQualityScore(t *testing.T) {
+	testCases := []struct {
+		metric   ladder.QualityMetric
+		out      string
+		expected float64
+	}{
+		{
+			ladder.QualityVMAF,
+			"[Parsed_libvmaf_4 @ 0x55d3c6f0a2c0] VMAF score: 93.218342\n",
+			93.218342,
+		},
+		{
+			ladder.QualitySSIM,
+			"[Parsed_ssim_4 @ 0x5581d4c0] SSIM Y:0.981562 (17.343335) U:0.990013 (20.006214) V:0.989406 (19.748574) All:0.984682 (18.148201)\n",
+			0.984682,
+		},
+		{
+			ladder.QualityPSNR,
+			"[Parsed_psnr_4 @ 0x5581d4c0] PSNR y:39.942471 u:44.716839 v:45.230126 average:41.156713 min:36.412035 max:47.553519\n",
+			41.156713,
+		},
+		{
+			ladder.QualityPSNR,
+			"[Parsed_psnr_4 @ 0x5581d4c0] PSNR y:inf u:inf v:inf average:inf min:inf max:inf\n",
+			maxPSNR,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(string(tc.metric), func(t *testing.T) {
+			s, err := parseQualityScore(tc.metric, tc.out)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, s)
+		})
+	}
+
+	_, err := parseQualityScore(ladder.QualityVMAF, "Conversion failed!")
+	assert.Error(t, err)
+}
+
+func TestSampleOffsets(t *testing.T) {
+	assert.Equal(t, []float64{0}, sampleOffsets(3, 3))
+	assert.Equal(t, []float64{0}, sampleOffsets(600, 0))
+	assert.Equal(t, []float64{23.75, 47.5, 71.25}, sampleOffsets(100, 3))
+}
+
+func TestQualityArgs(t *testing.T) {
+	args := qualityArgs(ladder.QualitySSIM, "in.mp4", "out/v1.m3u8", 23.75, 5, 1920, 1080)
+	assert.Equal(t, []string{
+		"-hide_banner", "-nostats",
+		"-ss", "23.750", "-t", "5.000", "-i", "out/v1.m3u8",
+		"-ss", "23.750", "-t", "5.000", "-i", "in.mp4",
+		"-lavfi",
+		"[0:v]scale=1920:1080:flags=bicubic,setpts=PTS-STARTPTS[dist];" +
+			"[1:v]scale=1920:1080:flags=bicubic,setpts=PTS-STARTPTS[ref];[dist][ref]ssim",
+		"-f", "null", "-",
+	}, args)
+}
@@ -0,0 +1,237 @@
+package encoder
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	ffmpegt "github.com/floostack/transcoder"
+	"github.com/lbryio/transcoder/ladder"
+
+	"github.com/pkg/errors"
+)
+
+// resumeDirName holds playlists of segments completed before the encode was interrupted.
+const resumeDirName = ".resume"
+
+var segmentNameRe = regexp.MustCompile(`^v(\d+)_s(\d+)\.ts$`)
+
+// encodeProgress describes HLS output left in a directory by a previous, possibly interrupted, encode.
+type encodeProgress struct {
+	// segments are completed segments of each variant.
+	segments [][]playlistSegment
+	complete bool
+}
+
+// Resume continues an interrupted encode in output from the last segment completed in all variants.
+// Output of a finished encode is returned as-is with a closed progress channel. If there is nothing to resume,
+// or the ladder requires two passes, output is cleared and the input is encoded from scratch.
+// If l is nil, the configured ladder is used.
+func (e encoder) Resume(input, output string, l *ladder.Ladder) (*Result, error) {
+	if l == nil {
+		l = &e.ladder
+	}
+	meta, err := e.GetMetadata(input)
+	if err != nil {
+		return nil, err
+	}
+	targetLadder, err := l.Tweak(meta)
+	if err != nil {
+		return nil, err
+	}
+	ll := e.log.With("input", input, "output", output)
+
+	var p *encodeProgress
+	if !targetLadder.TwoPass() {
+		p, err = readEncodeProgress(output, len(targetLadder.Tiers))
+		if err != nil {
+			ll.Warn("cannot read encoding progress, starting over", "err", err)
+			p = nil
+		}
+	}
+	if p == nil {
+		if err := os.RemoveAll(output); err != nil {
+			return nil, err
+		}
+		return e.EncodeWithLadder(input, output, *l)
+	}
+
+	res := &Result{Input: input, Output: output, OrigMeta: meta, Ladder: targetLadder}
+	if p.complete {
+		ll.Info("encoding already complete")
+		if err := mergeResumed(output, len(targetLadder.Tiers)); err != nil {
+			return nil, err
+		}
+		progress := make(chan ffmpegt.Progress)
+		close(progress)
+		res.Progress = progress
+		return res, nil
+	}
+
+	start, offset, err := p.prepare(output)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot prepare output for resuming")
+	}
+	ll.Info("resuming encoding", "segment", start, "offset", offset)
+
+	args := targetLadder.ArgumentSet(output, meta)
+	args.StartSegment = start
+	args.StartOffset = offset
+	res, err = e.transcode(input, meta, res, args, ll)
+	if err != nil {
+		return nil, err
+	}
+	res.Progress = afterProgress(res.Progress, func() {
+		if err := mergeResumed(output, len(targetLadder.Tiers)); err != nil {
+			ll.Error("cannot merge resumed playlists", "err", err)
+		}
+	})
+	return res, nil
+}
+
+// readEncodeProgress collects segments listed in variant playlists, which ffmpeg rewrites after every
+// completed segment, and in playlists saved by a previous resume. Returns nil if no segments are complete.
+func readEncodeProgress(output string, variants int) (*encodeProgress, error) {
+	if _, err := os.Stat(output); os.IsNotExist(err) {
+		return nil, nil
+	}
+	p := &encodeProgress{segments: make([][]playlistSegment, variants), complete: true}
+	for v := 0; v < variants; v++ {
+		name := fmt.Sprintf(VariantPlaylist, v)
+		prev, err := readMediaPlaylist(path.Join(output, resumeDirName, name))
+		if err == nil {
+			p.segments[v] = prev.segments
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+		cur, err := readMediaPlaylist(path.Join(output, name))
+		if os.IsNotExist(err) {
+			p.complete = false
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		p.segments[v] = append(p.segments[v], cur.segments...)
+		p.complete = p.complete && cur.complete
+	}
+	if _, err := os.Stat(path.Join(output, MasterPlaylist)); err != nil {
+		p.complete = false
+	}
+	if !p.complete && p.done() == 0 {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// done returns the number of segments completed in every variant.
+func (p *encodeProgress) done() int {
+	n := -1
+	for _, s := range p.segments {
+		if n == -1 || len(s) < n {
+			n = len(s)
+		}
+	}
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// prepare saves playlists of completed segments and removes everything produced after them.
+// Returns the number of the first segment to encode and its offset in the source in seconds.
+func (p *encodeProgress) prepare(output string) (int, float64, error) {
+	start := p.done()
+	resumeDir := path.Join(output, resumeDirName)
+	if err := os.MkdirAll(resumeDir, os.ModePerm); err != nil {
+		return 0, 0, err
+	}
+
+	var offset float64
+	for v, segments := range p.segments {
+		name := fmt.Sprintf(VariantPlaylist, v)
+		if err := writeMediaPlaylist(path.Join(resumeDir, name), targetDuration(segments[:start]), segments[:start]); err != nil {
+			return 0, 0, err
+		}
+		if err := os.Remove(path.Join(output, name)); err != nil && !os.IsNotExist(err) {
+			return 0, 0, err
+		}
+		if v == 0 {
+			for _, s := range segments[:start] {
+				d, err := segmentDuration(s)
+				if err != nil {
+					return 0, 0, err
+				}
+				offset += d
+			}
+		}
+	}
+
+	entries, err := os.ReadDir(output)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, en := range entries {
+		m := segmentNameRe.FindStringSubmatch(en.Name())
+		if m == nil {
+			continue
+		}
+		if n, _ := strconv.Atoi(m[2]); n >= start {
+			if err := os.Remove(path.Join(output, en.Name())); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+	os.Remove(path.Join(output, MasterPlaylist))
+	return start, offset, nil
+}
+
+// mergeResumed prepends segments saved before resuming to variant playlists and removes the saved copies.
+func mergeResumed(output string, variants int) error {
+	resumeDir := path.Join(output, resumeDirName)
+	if _, err := os.Stat(resumeDir); os.IsNotExist(err) {
+		return nil
+	}
+	for v := 0; v < variants; v++ {
+		name := fmt.Sprintf(VariantPlaylist, v)
+		prev, err := readMediaPlaylist(path.Join(resumeDir, name))
+		if err != nil {
+			return err
+		}
+		cur, err := readMediaPlaylist(path.Join(output, name))
+		if err != nil {
+			return err
+		}
+		if !cur.complete {
+			return fmt.Errorf("playlist %s is incomplete", name)
+		}
+		segments := append(prev.segments, cur.segments...)
+		td := prev.targetDuration
+		if cur.targetDuration > td {
+			td = cur.targetDuration
+		}
+		if err := writeMediaPlaylist(path.Join(output, name), td, segments); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(resumeDir)
+}
+
+func segmentDuration(s playlistSegment) (float64, error) {
+	d := strings.SplitN(strings.TrimPrefix(s.inf, tagInf), ",", 2)[0]
+	return strconv.ParseFloat(d, 64)
+}
+
+func targetDuration(segments []playlistSegment) int {
+	var td int
+	for _, s := range segments {
+		d, _ := segmentDuration(s)
+		if c := int(math.Ceil(d)); c > td {
+			td = c
+		}
+	}
+	return td
+}
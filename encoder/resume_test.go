@@ -0,0 +1,79 @@
+package encoder
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeVariant(t *testing.T, dir string, v, first, count int, complete bool) {
+	pl := fmt.Sprintf("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:10\n#EXT-X-MEDIA-SEQUENCE:%v\n", first)
+	for n := first; n < first+count; n++ {
+		seg := fmt.Sprintf("v%v_s%06d.ts", v, n)
+		require.NoError(t, os.WriteFile(path.Join(dir, seg), []byte(seg), 0644))
+		pl += fmt.Sprintf("#EXTINF:10.000000,\n%s\n", seg)
+	}
+	if complete {
+		pl += "#EXT-X-ENDLIST\n"
+	}
+	require.NoError(t, os.WriteFile(path.Join(dir, fmt.Sprintf(VariantPlaylist, v)), []byte(pl), 0644))
+}
+
+func TestResumeProgress(t *testing.T) {
+	out := t.TempDir()
+
+	p, err := readEncodeProgress(path.Join(out, "missing"), 2)
+	require.NoError(t, err)
+	assert.Nil(t, p)
+
+	// Interrupted encode: variant 0 has one more listed segment and a partially written one.
+	writeVariant(t, out, 0, 0, 3, false)
+	writeVariant(t, out, 1, 0, 2, false)
+	require.NoError(t, os.WriteFile(path.Join(out, "v0_s000003.ts"), []byte("partial"), 0644))
+	require.NoError(t, os.WriteFile(path.Join(out, "v1_s000002.ts"), []byte("partial"), 0644))
+	require.NoError(t, os.WriteFile(path.Join(out, "stream.vtt"), []byte("sprites"), 0644))
+
+	p, err = readEncodeProgress(out, 2)
+	require.NoError(t, err)
+	require.NotNil(t, p)
+	assert.False(t, p.complete)
+	assert.Equal(t, 2, p.done())
+
+	start, offset, err := p.prepare(out)
+	require.NoError(t, err)
+	assert.Equal(t, 2, start)
+	assert.Equal(t, 20.0, offset)
+	for _, f := range []string{"v0_s000002.ts", "v0_s000003.ts", "v1_s000002.ts", "v0.m3u8", "v1.m3u8"} {
+		assert.NoFileExists(t, path.Join(out, f))
+	}
+	for _, f := range []string{"v0_s000001.ts", "v1_s000001.ts", "stream.vtt", ".resume/v0.m3u8"} {
+		assert.FileExists(t, path.Join(out, f))
+	}
+
+	// Resumed encode interrupted again before producing anything still resumes from the same point.
+	p, err = readEncodeProgress(out, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 2, p.done())
+
+	// Resumed encode finished.
+	writeVariant(t, out, 0, 2, 2, true)
+	writeVariant(t, out, 1, 2, 2, true)
+	require.NoError(t, os.WriteFile(path.Join(out, MasterPlaylist), []byte("#EXTM3U\n"), 0644))
+	p, err = readEncodeProgress(out, 2)
+	require.NoError(t, err)
+	assert.True(t, p.complete)
+
+	require.NoError(t, mergeResumed(out, 2))
+	assert.NoDirExists(t, path.Join(out, resumeDirName))
+	pl, err := readMediaPlaylist(path.Join(out, "v1.m3u8"))
+	require.NoError(t, err)
+	assert.True(t, pl.complete)
+	require.Len(t, pl.segments, 4)
+	for n, s := range pl.segments {
+		assert.Equal(t, fmt.Sprintf("v1_s%06d.ts", n), s.uri)
+	}
+}
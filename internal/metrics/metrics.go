@@ -31,6 +31,27 @@ var (
 		},
 		[]string{"resolution"},
 	)
+	EncodedVMAF = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "encoded_vmaf",
+			Buckets: []float64{20, 40, 60, 70, 75, 80, 85, 88, 90, 92, 94, 96, 98, 100},
+		},
+		[]string{"resolution"},
+	)
+	EncodedSSIM = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "encoded_ssim",
+			Buckets: []float64{0.5, 0.7, 0.8, 0.85, 0.9, 0.92, 0.94, 0.95, 0.96, 0.97, 0.98, 0.99, 1},
+		},
+		[]string{"resolution"},
+	)
+	EncodedPSNR = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "encoded_psnr_db",
+			Buckets: []float64{20, 25, 28, 30, 32, 34, 36, 38, 40, 42, 45, 50, 60},
+		},
+		[]string{"resolution"},
+	)
 
 	StreamsRequestedCount = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "streams_requested_count",
@@ -50,6 +71,7 @@ func RegisterMetrics() {
 	once.Do(func() {
 		prometheus.MustRegister(
 			DownloadedSizeMB, S3UploadedSizeMB, EncodedDurationSeconds, EncodedBitrateMbit,
+			EncodedVMAF, EncodedSSIM, EncodedPSNR,
 			StreamsRequestedCount, HTTPAPIRequests,
 		)
 	})
@@ -2,7 +2,9 @@ package ladder
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
+	"strings"
 )
 
 const (
@@ -17,67 +19,211 @@ const (
 	argVarStreamMap = "var_stream_map"
 )
 
+// Argument is a single ffmpeg option, serialized as `-Name Value`.
+type Argument struct {
+	Name  string
+	Value string
+}
+
+// Arguments is an ordered list of ffmpeg options. Serialization order is always the order of insertion.
+type Arguments []Argument
+
 type ArgumentSet struct {
 	Output    string
 	Ladder    Ladder
-	Arguments map[string]string
+	Arguments Arguments
 	Meta      *Metadata
+
+	// Pass is 2 for the second pass of ladders containing two-pass tiers, 0 otherwise.
+	// Options of the first pass are built separately by BuildFirstPass.
+	Pass int
+	// PassLogPrefix is a path prefix for two-pass statistics files.
+	PassLogPrefix string
+
+	// StartSegment and StartOffset are set when resuming an interrupted encode:
+	// source is read from StartOffset seconds and output segment numbering begins at StartSegment.
+	StartSegment int
+	StartOffset  float64
+
+	// KeyInfoFile is a path to ffmpeg HLS key info file, segments are encrypted with AES-128 when it is set.
+	KeyInfoFile string
 }
 
-var hlsDefaultArguments = map[string]string{
-	"preset":       preset,
-	"sc_threshold": "0",
-	"c:v":          "libx264",
-	"pix_fmt":      "yuv420p",
-	// "crf":                  constantRateFactor,
-	"c:a":                  "aac",
-	"ac":                   "2",
-	"ar":                   "44100",
-	"f":                    "hls",
-	"hls_time":             hlsTime,
-	"hls_playlist_type":    "vod",
-	"hls_flags":            "independent_segments",
-	"master_pl_name":       MasterPlaylist,
-	"strftime_mkdir":       "1",
-	"hls_segment_filename": "v%v_s%06d.ts",
+var hlsDefaultArguments = Arguments{
+	{"preset", preset},
+	{"sc_threshold", "0"},
+	{"c:v", videoCodec},
+	{"pix_fmt", "yuv420p"},
+	// {"crf", constantRateFactor},
+	{"c:a", "aac"},
+	{"ac", "2"},
+	{"ar", "44100"},
+	{"f", "hls"},
+	{"hls_time", hlsTime},
+	{"hls_playlist_type", "vod"},
+	{"hls_flags", "independent_segments"},
+	{"master_pl_name", MasterPlaylist},
+	{"strftime_mkdir", "1"},
+	{"hls_segment_filename", "v%v_s%06d.ts"},
 }
 
-// GetStrArguments serializes ffmpeg arguments in a format sutable for ffmpeg.Transcoder.Start.
-func (a *ArgumentSet) GetStrArguments() []string {
-	strArgs := []string{}
+// firstPassArguments are output options of the first pass, which only collects statistics for two-pass tiers.
+var firstPassArguments = Arguments{
+	{"preset", preset},
+	{"sc_threshold", "0"},
+	{"c:v", videoCodec},
+	{"pix_fmt", "yuv420p"},
+}
+
+// Set replaces value of an existing option keeping its position or appends a new option to the end.
+func (a *Arguments) Set(name, value string) {
+	for i, arg := range *a {
+		if arg.Name == name {
+			(*a)[i].Value = value
+			return
+		}
+	}
+	*a = append(*a, Argument{name, value})
+}
 
-	args := a.Arguments
-	ladArgs := []string{}
-	args[argVarStreamMap] = ""
+// Add appends an option even if an option with the same name is already present.
+func (a *Arguments) Add(name, value string) {
+	*a = append(*a, Argument{name, value})
+}
+
+func (a Arguments) Get(name string) (string, bool) {
+	for _, arg := range a {
+		if arg.Name == name {
+			return arg.Value, true
+		}
+	}
+	return "", false
+}
+
+func (a Arguments) Copy() Arguments {
+	c := make(Arguments, len(a))
+	copy(c, a)
+	return c
+}
+
+// Strings serializes options in a format suitable for exec.Command.
+func (a Arguments) Strings() []string {
+	s := make([]string, 0, len(a)*2)
+	for _, arg := range a {
+		s = append(s, "-"+arg.Name, arg.Value)
+	}
+	return s
+}
+
+// String serializes options one per line, which is convenient for logging and diffing.
+func (a Arguments) String() string {
+	lines := make([]string, len(a))
+	for i, arg := range a {
+		lines[i] = fmt.Sprintf("-%s %s", arg.Name, arg.Value)
+	}
+	return strings.Join(lines, "\n")
+}
 
-	for k, v := range a.Ladder.Args {
-		args[k] = v
+// Build returns a complete list of ffmpeg output options for the argument set:
+// defaults, ladder args in alphabetical order, the stream map and per-tier options in tier order.
+// ArgumentSet itself is left unmodified so Build is safe to call repeatedly.
+func (a *ArgumentSet) Build() Arguments {
+	args := a.Arguments.Copy()
+	for _, k := range a.ladderArgNames() {
+		args.Set(k, a.Ladder.Args[k])
 	}
 
+	streamMap := make([]string, 0, len(a.Ladder.Tiers))
+	tierArgs := Arguments{}
 	for n, tier := range a.Ladder.Tiers {
 		s := strconv.Itoa(n)
-		args[argVarStreamMap] += fmt.Sprintf("v:%s,a:%s ", s, s)
-		vRate := strconv.Itoa(tier.VideoBitrate)
-		ladArgs = append(ladArgs,
-			"-map", "v:0",
-			"-filter:v:"+s, "scale=-2:"+strconv.Itoa(tier.Height),
-			"-b:v:"+s, vRate,
-			"-maxrate:v:"+s, vRate,
-			"-bufsize:v:"+s, vRate,
-		)
-
-		if tier.Framerate != 0 {
-			ladArgs = append(ladArgs, "-r:v:"+s, strconv.Itoa(tier.Framerate), "-g:v:"+s, strconv.Itoa(tier.Framerate*2))
-		} else {
-			ladArgs = append(ladArgs, "-g:v:"+s, strconv.Itoa(a.Meta.IntFPS*2))
+		streamMap = append(streamMap, fmt.Sprintf("v:%s,a:%s", s, s))
+		tierArgs = append(tierArgs, a.videoArgs(tier, n, n, a.Pass)...)
+		tierArgs.Add("map", "a:0")
+		tierArgs.Add("b:a:"+s, tier.AudioBitrate)
+	}
+	args.Set(argVarStreamMap, strings.Join(streamMap, " "))
+	if a.StartSegment > 0 {
+		offset := a.startOffset()
+		args.Set("ss", offset)
+		args.Set("output_ts_offset", offset)
+		args.Set("start_number", strconv.Itoa(a.StartSegment))
+	}
+	if a.KeyInfoFile != "" {
+		args.Set("hls_key_info_file", a.KeyInfoFile)
+	}
+
+	return append(args, tierArgs...)
+}
+
+// BuildFirstPass returns ffmpeg output options for the first pass of two-pass encoding.
+// Only video streams of two-pass tiers are included, without audio or muxer options,
+// so the output can be discarded (-an -f null) keeping just the pass log for the second pass.
+func (a *ArgumentSet) BuildFirstPass() Arguments {
+	args := firstPassArguments.Copy()
+	for _, k := range a.ladderArgNames() {
+		if firstPassArg(k) {
+			args.Set(k, a.Ladder.Args[k])
 		}
+	}
+	if a.StartSegment > 0 {
+		args.Set("ss", a.startOffset())
+	}
 
-		ladArgs = append(ladArgs, "-map", "a:0", "-b:a:"+s, tier.AudioBitrate)
+	var stream int
+	for n, tier := range a.Ladder.Tiers {
+		if tier.RateControl != RateControlVBR2Pass {
+			continue
+		}
+		args = append(args, a.videoArgs(tier, stream, n, 1)...)
+		stream++
 	}
+	return args
+}
 
-	for k, v := range args {
-		strArgs = append(strArgs, fmt.Sprintf("-%v", k), v)
+// videoArgs returns options for output video stream number n encoding tier number tn of the ladder.
+func (a *ArgumentSet) videoArgs(tier Tier, n, tn, pass int) Arguments {
+	s := strconv.Itoa(n)
+	args := Arguments{}
+	args.Add("map", "v:0")
+	args.Add("filter:v:"+s, "scale=-2:"+strconv.Itoa(tier.Height))
+	args = append(args, tier.rateControlArgs(n, pass, a.PassLogPrefix+"_v"+strconv.Itoa(tn))...)
+	if tier.Framerate != 0 {
+		args.Add("r:v:"+s, strconv.Itoa(tier.Framerate))
+		args.Add("g:v:"+s, strconv.Itoa(tier.Framerate*2))
+	} else {
+		args.Add("g:v:"+s, strconv.Itoa(a.Meta.IntFPS*2))
 	}
-	strArgs = append(strArgs, ladArgs...)
-	return strArgs
+	return args
+}
+
+// firstPassArg is false for audio and muxer options, which have nothing to apply to in the first pass.
+// All the rest are kept so both passes make the same frame types and GOPs.
+func firstPassArg(name string) bool {
+	if strings.HasPrefix(name, "hls_") {
+		return false
+	}
+	switch name {
+	case "f", "master_pl_name", "strftime_mkdir", "movflags", "ac", "ar", "acodec", "c:a", "codec:a", "b:a":
+		return false
+	}
+	return true
+}
+
+func (a *ArgumentSet) ladderArgNames() []string {
+	names := make([]string, 0, len(a.Ladder.Args))
+	for k := range a.Ladder.Args {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (a *ArgumentSet) startOffset() string {
+	return strconv.FormatFloat(a.StartOffset, 'f', 6, 64)
+}
+
+// GetStrArguments serializes ffmpeg arguments in a format sutable for ffmpeg.Transcoder.Start.
+func (a *ArgumentSet) GetStrArguments() []string {
+	return a.Build().Strings()
 }
@@ -0,0 +1,162 @@
+package ladder
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files in testdata")
+
+func TestArgumentsSet(t *testing.T) {
+	args := Arguments{{"preset", "veryfast"}, {"crf", "23"}}
+	args.Set("crf", "26")
+	args.Set("refs", "1")
+	args.Add("map", "v:0")
+	args.Add("map", "a:0")
+
+	v, ok := args.Get("crf")
+	assert.True(t, ok)
+	assert.Equal(t, "26", v)
+	_, ok = args.Get("tune")
+	assert.False(t, ok)
+	assert.Equal(t,
+		[]string{"-preset", "veryfast", "-crf", "26", "-refs", "1", "-map", "v:0", "-map", "a:0"},
+		args.Strings(),
+	)
+}
+
+func TestArgumentSetBuild(t *testing.T) {
+	testCases := []struct {
+		name string
+		w, h int
+		br   int
+		fps  int
+	}{
+		{"1080p30", 1920, 1080, 8000, FPS30},
+		{"720p60", 1280, 720, 5000, FPS60},
+		{"480p30", 720, 480, 5000, FPS30},
+		{"vertical1080p30", 1080, 1920, 3000, FPS30},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fmeta := generateMeta(tc.w, tc.h, tc.br, tc.fps)
+			meta, err := WrapMeta(&fmeta)
+			require.NoError(t, err)
+			l, err := Default.Tweak(meta)
+			require.NoError(t, err)
+
+			as := l.ArgumentSet("out", meta)
+			built := as.Build()
+			// Repeated builds must be identical and must not modify the set.
+			assert.Equal(t, built, as.Build())
+			assert.Equal(t, hlsDefaultArguments, as.Arguments)
+			assert.Equal(t, built.Strings(), as.GetStrArguments())
+
+			golden := filepath.Join("testdata", tc.name+".golden")
+			if *updateGolden {
+				require.NoError(t, os.WriteFile(golden, []byte(built.String()+"\n"), 0644))
+			}
+			expected, err := os.ReadFile(golden)
+			require.NoError(t, err)
+			assert.Equal(t, string(expected), built.String()+"\n")
+		})
+	}
+}
+
+func TestArgumentSetBuildResumed(t *testing.T) {
+	fmeta := generateMeta(1280, 720, 5000, FPS30)
+	meta, err := WrapMeta(&fmeta)
+	require.NoError(t, err)
+	l, err := Default.Tweak(meta)
+	require.NoError(t, err)
+
+	as := l.ArgumentSet("out", meta)
+	as.StartSegment = 12
+	as.StartOffset = 120.5
+	built := as.Build()
+	for name, value := range map[string]string{"ss": "120.500000", "output_ts_offset": "120.500000", "start_number": "12"} {
+		v, ok := built.Get(name)
+		assert.True(t, ok)
+		assert.Equal(t, value, v)
+	}
+}
+
+func TestArgumentSetBuildEncrypted(t *testing.T) {
+	fmeta := generateMeta(1280, 720, 5000, FPS30)
+	meta, err := WrapMeta(&fmeta)
+	require.NoError(t, err)
+	l, err := Default.Tweak(meta)
+	require.NoError(t, err)
+
+	as := l.ArgumentSet("out", meta)
+	_, ok := as.Build().Get("hls_key_info_file")
+	assert.False(t, ok)
+	as.KeyInfoFile = "/tmp/key/keyinfo"
+	v, ok := as.Build().Get("hls_key_info_file")
+	assert.True(t, ok)
+	assert.Equal(t, "/tmp/key/keyinfo", v)
+}
+
+func TestArgumentSetBuildFirstPass(t *testing.T) {
+	fmeta := generateMeta(1920, 1080, 8000, FPS30)
+	meta, err := WrapMeta(&fmeta)
+	require.NoError(t, err)
+	l, err := Load([]byte(`
+args:
+  profile:v: main
+  force_key_frames: "expr:gte(t,n_forced*2)"
+  preset: medium
+  hls_time: 6
+  ar: 48000
+tiers:
+  - definition: 720p
+    width: 1280
+    height: 720
+    bitrate: 2500_000
+    audio_bitrate: 128k
+    rate_control: capped_crf
+    crf: 23
+  - definition: 360p
+    width: 640
+    height: 360
+    bitrate: 500_000
+    audio_bitrate: 96k
+    rate_control: vbr_2pass
+`))
+	require.NoError(t, err)
+
+	as := l.ArgumentSet("out", meta)
+	as.PassLogPrefix = "/tmp/passlog"
+	first := as.BuildFirstPass()
+	for _, name := range []string{"c:a", "f", "hls_time", "ar", "map:a", "crf:v:0", "b:a:0", argVarStreamMap} {
+		_, ok := first.Get(name)
+		assert.False(t, ok, name)
+	}
+	maps := 0
+	for _, a := range first {
+		if a.Name == "map" {
+			maps++
+			assert.Equal(t, "v:0", a.Value)
+		}
+	}
+	assert.Equal(t, 1, maps, "only two-pass tiers are encoded in the first pass")
+	for name, value := range map[string]string{
+		"profile:v": "main", "force_key_frames": "expr:gte(t,n_forced*2)", "preset": "medium",
+		"filter:v:0": "scale=-2:360", "b:v:0": "500000", "pass:v:0": "1", "passlogfile:v:0": "/tmp/passlog_v1",
+	} {
+		v, ok := first.Get(name)
+		assert.True(t, ok, name)
+		assert.Equal(t, value, v, name)
+	}
+
+	as.Pass = 2
+	v, ok := as.Build().Get("passlogfile:v:1")
+	assert.True(t, ok)
+	assert.Equal(t, "/tmp/passlog_v1", v)
+}
@@ -1,6 +1,7 @@
 package ladder
 
 var defaultLadderYaml = []byte(`
+name: default
 args:
   sws_flags: bilinear
   profile:v: main
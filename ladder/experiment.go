@@ -0,0 +1,85 @@
+package ladder
+
+import (
+	"hash/fnv"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// Variant is a named ladder receiving a Share (in percent) of new transcoding requests.
+type Variant struct {
+	Name   string
+	Share  int
+	Ladder Ladder
+}
+
+// Experiment splits transcoding requests between several ladders.
+type Experiment struct {
+	variants []Variant
+}
+
+// NewExperiment validates variants and builds an experiment out of them.
+// Shares of all variants must add up to 100.
+func NewExperiment(variants ...Variant) (*Experiment, error) {
+	if len(variants) == 0 {
+		return nil, errors.New("no ladder variants supplied")
+	}
+	var total int
+	seen := map[string]bool{}
+	for _, v := range variants {
+		if v.Name == "" {
+			return nil, errors.New("ladder variant name cannot be empty")
+		}
+		if seen[v.Name] {
+			return nil, errors.Errorf("duplicate ladder variant: %s", v.Name)
+		}
+		if v.Share < 0 {
+			return nil, errors.Errorf("ladder variant %s has negative share", v.Name)
+		}
+		if err := v.Ladder.Validate(); err != nil {
+			return nil, errors.Wrapf(err, "ladder variant %s", v.Name)
+		}
+		seen[v.Name] = true
+		total += v.Share
+	}
+	if total != 100 {
+		return nil, errors.Errorf("ladder variant shares must add up to 100, got %v", total)
+	}
+	return &Experiment{variants: variants}, nil
+}
+
+// Assign deterministically picks a ladder for the stream identified by sdHash,
+// so retries and repeated requests always land on the same variant.
+// Returned ladder has its Name set to the variant name.
+func (e *Experiment) Assign(sdHash string) Ladder {
+	h := fnv.New32a()
+	h.Write([]byte(sdHash))
+	bucket := int(h.Sum32() % 100)
+
+	var acc int
+	v := e.variants[len(e.variants)-1]
+	for _, cv := range e.variants {
+		acc += cv.Share
+		if bucket < acc {
+			v = cv
+			break
+		}
+	}
+	l := v.Ladder
+	l.Name = v.Name
+	return l
+}
+
+func (e *Experiment) Variants() []Variant {
+	return e.variants
+}
+
+// LoadFile reads a ladder from a YAML file.
+func LoadFile(path string) (Ladder, error) {
+	d, err := os.ReadFile(path)
+	if err != nil {
+		return Ladder{}, err
+	}
+	return Load(d)
+}
@@ -0,0 +1,48 @@
+package ladder
+
+import (
+	"testing"
+
+	"github.com/Pallinder/go-randomdata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExperimentAssign(t *testing.T) {
+	alt := Default
+	alt.Args = map[string]string{"crf": "28"}
+
+	exp, err := NewExperiment(
+		Variant{Name: "default", Share: 80, Ladder: Default},
+		Variant{Name: "alt", Share: 20, Ladder: alt},
+	)
+	require.NoError(t, err)
+
+	counts := map[string]int{}
+	for range [5000]int{} {
+		sdHash := randomdata.Alphanumeric(96)
+		l := exp.Assign(sdHash)
+		assert.Equal(t, l, exp.Assign(sdHash))
+		counts[l.Name]++
+	}
+	assert.InDelta(t, 4000, counts["default"], 250)
+	assert.InDelta(t, 1000, counts["alt"], 250)
+}
+
+func TestNewExperimentValidation(t *testing.T) {
+	_, err := NewExperiment()
+	assert.Error(t, err)
+
+	_, err = NewExperiment(Variant{Name: "default", Share: 90, Ladder: Default})
+	assert.Error(t, err)
+
+	_, err = NewExperiment(Variant{Name: "default", Share: 50, Ladder: Default}, Variant{Name: "default", Share: 50, Ladder: Default})
+	assert.Error(t, err)
+
+	_, err = NewExperiment(Variant{Name: "default", Share: 50, Ladder: Default}, Variant{Name: "empty", Share: 50})
+	assert.Error(t, err)
+
+	exp, err := NewExperiment(Variant{Name: "default", Share: 100, Ladder: Default}, Variant{Name: "off", Share: 0, Ladder: Default})
+	require.NoError(t, err)
+	assert.Equal(t, "default", exp.Assign(randomdata.Alphanumeric(96)).Name)
+}
@@ -15,6 +15,7 @@ const (
 type Definition string
 
 type Ladder struct {
+	Name  string `yaml:",omitempty"`
 	Args  map[string]string
 	Tiers []Tier `yaml:",flow"`
 }
@@ -27,6 +28,12 @@ type Tier struct {
 	AudioBitrate  string `yaml:"audio_bitrate"`
 	Framerate     int    `yaml:",omitempty"`
 	BitrateCutoff int    `yaml:"bitrate_cutoff"`
+
+	// Rate control settings, see RateControl for how they are applied.
+	RateControl  RateControl `yaml:"rate_control,omitempty"`
+	CRF          int         `yaml:"crf,omitempty"`
+	MaxrateRatio float64     `yaml:"maxrate_ratio,omitempty"`
+	BufsizeRatio float64     `yaml:"bufsize_ratio,omitempty"`
 }
 
 func Load(yamlLadder []byte) (Ladder, error) {
@@ -67,11 +74,16 @@ func (l Ladder) Tweak(meta *Metadata) (Ladder, error) {
 	}
 
 	if !origResSeen && l.Tiers[0].Height >= h && len(tweakedTiers) > 0 {
+		top := l.Tiers[0]
 		tweakedTiers = append([]Tier{{
 			Height:       h,
 			Width:        w,
 			VideoBitrate: nsRate(w, h),
 			AudioBitrate: "128k",
+			RateControl:  top.RateControl,
+			CRF:          top.CRF,
+			MaxrateRatio: top.MaxrateRatio,
+			BufsizeRatio: top.BufsizeRatio,
 		}}, tweakedTiers...)
 	}
 
@@ -80,14 +92,20 @@ func (l Ladder) Tweak(meta *Metadata) (Ladder, error) {
 	return l, nil
 }
 
-func (l Ladder) ArgumentSet(out string, meta *Metadata) *ArgumentSet {
-	d := map[string]string{}
-	for k, v := range hlsDefaultArguments {
-		d[k] = v
+// TwoPass returns true if any of ladder tiers requires two-pass encoding.
+func (l Ladder) TwoPass() bool {
+	for _, t := range l.Tiers {
+		if t.RateControl == RateControlVBR2Pass {
+			return true
+		}
 	}
+	return false
+}
+
+func (l Ladder) ArgumentSet(out string, meta *Metadata) *ArgumentSet {
 	return &ArgumentSet{
 		Output:    out,
-		Arguments: d,
+		Arguments: hlsDefaultArguments.Copy(),
 		Ladder:    l,
 		Meta:      meta,
 	}
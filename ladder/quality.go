@@ -0,0 +1,30 @@
+package ladder
+
+import "fmt"
+
+type QualityMetric string
+
+const (
+	QualityVMAF QualityMetric = "vmaf"
+	QualitySSIM QualityMetric = "ssim"
+	QualityPSNR QualityMetric = "psnr"
+)
+
+// QualityScore is an objective quality measurement of a single encoded tier against its source.
+type QualityScore struct {
+	Definition Definition `yaml:",omitempty"`
+	Width      int
+	Height     int
+	Metric     QualityMetric
+	Score      float64
+	// Samples is the number of source segments the score was averaged over.
+	Samples int
+}
+
+func ParseQualityMetric(s string) (QualityMetric, error) {
+	switch m := QualityMetric(s); m {
+	case QualityVMAF, QualitySSIM, QualityPSNR:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown quality metric: %s", s)
+}
@@ -0,0 +1,85 @@
+package ladder
+
+import (
+	"math"
+	"strconv"
+)
+
+// RateControl defines how tier bitrate is enforced by the encoder.
+type RateControl string
+
+const (
+	// RateControlDefault sets average bitrate, maxrate and bufsize all equal to tier bitrate.
+	RateControlDefault RateControl = ""
+	// RateControlCBR keeps bitrate constant at tier bitrate. Bufsize is bitrate * bufsize_ratio.
+	RateControlCBR RateControl = "cbr"
+	// RateControlCappedCRF encodes at constant quality (crf) but never exceeds bitrate * maxrate_ratio.
+	// Bufsize is the resulting maxrate * bufsize_ratio. Requires an encoder supporting crf (libx264, libx265).
+	RateControlCappedCRF RateControl = "capped_crf"
+	// RateControlVBR2Pass runs two-pass variable bitrate encoding targeting tier bitrate on average,
+	// with peaks capped at bitrate * maxrate_ratio. Bufsize is the resulting maxrate * bufsize_ratio.
+	RateControlVBR2Pass RateControl = "vbr_2pass"
+)
+
+const (
+	defaultCBRBufsizeRatio = 1.0
+
+	defaultCRFMaxrateRatio = 1.0
+	defaultCRFBufsizeRatio = 2.0
+
+	defaultVBRMaxrateRatio = 1.5
+	defaultVBRBufsizeRatio = 2.0
+)
+
+var rateControlModes = map[RateControl]bool{
+	RateControlDefault:   true,
+	RateControlCBR:       true,
+	RateControlCappedCRF: true,
+	RateControlVBR2Pass:  true,
+}
+
+// rateControlArgs returns rate control options for output video stream number n.
+// For two-pass tiers pass number and log file options are only included when pass is non-zero.
+func (t Tier) rateControlArgs(n int, pass int, passLogFile string) Arguments {
+	s := strconv.Itoa(n)
+	vRate := strconv.Itoa(t.VideoBitrate)
+	args := Arguments{}
+
+	switch t.RateControl {
+	case RateControlCBR:
+		args.Add("b:v:"+s, vRate)
+		args.Add("minrate:v:"+s, vRate)
+		args.Add("maxrate:v:"+s, vRate)
+		args.Add("bufsize:v:"+s, scaleRate(t.VideoBitrate, ratioOr(t.BufsizeRatio, defaultCBRBufsizeRatio)))
+	case RateControlCappedCRF:
+		maxrate := scaleRate(t.VideoBitrate, ratioOr(t.MaxrateRatio, defaultCRFMaxrateRatio))
+		args.Add("crf:v:"+s, strconv.Itoa(t.CRF))
+		args.Add("maxrate:v:"+s, maxrate)
+		args.Add("bufsize:v:"+s, scaleRate(t.VideoBitrate, ratioOr(t.MaxrateRatio, defaultCRFMaxrateRatio)*ratioOr(t.BufsizeRatio, defaultCRFBufsizeRatio)))
+	case RateControlVBR2Pass:
+		maxRatio := ratioOr(t.MaxrateRatio, defaultVBRMaxrateRatio)
+		args.Add("b:v:"+s, vRate)
+		args.Add("maxrate:v:"+s, scaleRate(t.VideoBitrate, maxRatio))
+		args.Add("bufsize:v:"+s, scaleRate(t.VideoBitrate, maxRatio*ratioOr(t.BufsizeRatio, defaultVBRBufsizeRatio)))
+		if pass > 0 {
+			args.Add("pass:v:"+s, strconv.Itoa(pass))
+			args.Add("passlogfile:v:"+s, passLogFile)
+		}
+	default:
+		args.Add("b:v:"+s, vRate)
+		args.Add("maxrate:v:"+s, vRate)
+		args.Add("bufsize:v:"+s, vRate)
+	}
+	return args
+}
+
+func ratioOr(r, def float64) float64 {
+	if r == 0 {
+		return def
+	}
+	return r
+}
+
+func scaleRate(rate int, ratio float64) string {
+	return strconv.Itoa(int(math.Round(float64(rate) * ratio)))
+}
@@ -0,0 +1,84 @@
+package ladder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateControlArgs(t *testing.T) {
+	testCases := []struct {
+		name     string
+		tier     Tier
+		pass     int
+		expected []string
+	}{
+		{
+			"default",
+			Tier{VideoBitrate: 1000_000},
+			0,
+			[]string{"-b:v:1", "1000000", "-maxrate:v:1", "1000000", "-bufsize:v:1", "1000000"},
+		},
+		{
+			"cbr",
+			Tier{VideoBitrate: 1000_000, RateControl: RateControlCBR, BufsizeRatio: 0.5},
+			0,
+			[]string{"-b:v:1", "1000000", "-minrate:v:1", "1000000", "-maxrate:v:1", "1000000", "-bufsize:v:1", "500000"},
+		},
+		{
+			"capped_crf",
+			Tier{VideoBitrate: 1000_000, RateControl: RateControlCappedCRF, CRF: 23, MaxrateRatio: 1.2},
+			0,
+			[]string{"-crf:v:1", "23", "-maxrate:v:1", "1200000", "-bufsize:v:1", "2400000"},
+		},
+		{
+			"vbr_2pass_no_pass",
+			Tier{VideoBitrate: 1000_000, RateControl: RateControlVBR2Pass},
+			0,
+			[]string{"-b:v:1", "1000000", "-maxrate:v:1", "1500000", "-bufsize:v:1", "3000000"},
+		},
+		{
+			"vbr_2pass_second_pass",
+			Tier{VideoBitrate: 1000_000, RateControl: RateControlVBR2Pass},
+			2,
+			[]string{
+				"-b:v:1", "1000000", "-maxrate:v:1", "1500000", "-bufsize:v:1", "3000000",
+				"-pass:v:1", "2", "-passlogfile:v:1", "/tmp/passlog_v1",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.tier.rateControlArgs(1, tc.pass, "/tmp/passlog_v1").Strings())
+		})
+	}
+}
+
+func TestTwoPass(t *testing.T) {
+	assert.False(t, Default.TwoPass())
+
+	l, err := Load([]byte(`
+tiers:
+  - definition: 720p
+    width: 1280
+    height: 720
+    bitrate: 2500_000
+    audio_bitrate: 128k
+    rate_control: vbr_2pass
+    maxrate_ratio: 2
+  - definition: 360p
+    width: 640
+    height: 360
+    bitrate: 500_000
+    audio_bitrate: 96k
+    rate_control: capped_crf
+    crf: 26
+`))
+	require.NoError(t, err)
+	require.NoError(t, l.Validate())
+	assert.True(t, l.TwoPass())
+	assert.Equal(t, 2.0, l.Tiers[0].MaxrateRatio)
+	assert.Equal(t, 26, l.Tiers[1].CRF)
+}
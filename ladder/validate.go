@@ -0,0 +1,147 @@
+package ladder
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var supportedVideoCodecs = map[string]bool{
+	"libx264":    true,
+	"libx265":    true,
+	"h264_nvenc": true,
+	"hevc_nvenc": true,
+	"h264_qsv":   true,
+	"h264_vaapi": true,
+}
+
+var supportedAudioCodecs = map[string]bool{
+	"aac":        true,
+	"libfdk_aac": true,
+}
+
+var videoCodecArgs = []string{"c:v", "codec:v", "vcodec"}
+var audioCodecArgs = []string{"c:a", "codec:a", "acodec"}
+
+// knownArgs lists ffmpeg output options that can be set in ladder args.
+var knownArgs = map[string]bool{
+	"ac": true, "acodec": true, "ar": true, "aq-mode": true,
+	"b:a": true, "b:v": true, "bf": true, "bufsize": true,
+	"c:a": true, "c:v": true, "codec:a": true, "codec:v": true, "crf": true,
+	"f": true, "force_key_frames": true, "fps_mode": true, "g": true,
+	"hls_flags": true, "hls_list_size": true, "hls_playlist_type": true,
+	"hls_segment_filename": true, "hls_segment_type": true, "hls_time": true,
+	"keyint_min": true, "level": true, "level:v": true,
+	"master_pl_name": true, "maxrate": true, "movflags": true,
+	"pix_fmt": true, "preset": true, "profile:v": true,
+	"r": true, "refs": true,
+	"sc_threshold": true, "strftime_mkdir": true, "sws_flags": true,
+	"tag:v": true, "threads": true, "tune": true,
+	"vcodec": true, "vsync": true,
+	"x264-params": true, "x264opts": true, "x265-params": true,
+}
+
+// ValidationError contains all problems found in a ladder.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e ValidationError) Error() string {
+	return "invalid ladder: " + strings.Join(e.Problems, "; ")
+}
+
+// Validate checks ladder for mistakes that would otherwise only show up as ffmpeg failures on workers.
+// Returned error is of ValidationError type when ladder has problems.
+func (l Ladder) Validate() error {
+	problems := []string{}
+	addProblem := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	argNames := []string{}
+	for k := range l.Args {
+		argNames = append(argNames, k)
+	}
+	sort.Strings(argNames)
+	for _, k := range argNames {
+		if !knownArgs[k] {
+			addProblem("unknown ffmpeg argument: %s", k)
+		}
+	}
+	for _, k := range videoCodecArgs {
+		if c, ok := l.Args[k]; ok && !supportedVideoCodecs[c] {
+			addProblem("unsupported video codec: %s", c)
+		}
+	}
+	for _, k := range audioCodecArgs {
+		if c, ok := l.Args[k]; ok && !supportedAudioCodecs[c] {
+			addProblem("unsupported audio codec: %s", c)
+		}
+	}
+
+	if len(l.Tiers) == 0 {
+		addProblem("no tiers defined")
+	}
+	if _, ok := l.Args["crf"]; ok {
+		for _, t := range l.Tiers {
+			if t.RateControl != RateControlDefault {
+				addProblem("crf in args conflicts with per-tier rate_control, set crf on tiers instead")
+				break
+			}
+		}
+	}
+	for n, t := range l.Tiers {
+		name := fmt.Sprintf("tier %v (%v)", n, t.Definition)
+		if t.Width <= 0 || t.Height <= 0 {
+			addProblem("%s: width and height must be positive", name)
+		}
+		if t.VideoBitrate <= 0 {
+			addProblem("%s: bitrate must be positive", name)
+		}
+		if t.AudioBitrate == "" {
+			addProblem("%s: audio_bitrate missing", name)
+		}
+		if t.Framerate < 0 {
+			addProblem("%s: framerate cannot be negative", name)
+		}
+		if t.BitrateCutoff < 0 {
+			addProblem("%s: bitrate_cutoff cannot be negative", name)
+		} else if t.BitrateCutoff > 0 && t.BitrateCutoff < t.VideoBitrate {
+			addProblem("%s: bitrate_cutoff %v is lower than tier bitrate %v", name, t.BitrateCutoff, t.VideoBitrate)
+		}
+		if !rateControlModes[t.RateControl] {
+			addProblem("%s: unknown rate_control: %s", name, t.RateControl)
+		}
+		if t.RateControl == RateControlCappedCRF {
+			if t.CRF <= 0 || t.CRF > 51 {
+				addProblem("%s: crf must be between 1 and 51 for capped_crf", name)
+			}
+		} else if t.CRF != 0 {
+			addProblem("%s: crf is only used with capped_crf rate_control", name)
+		}
+		if t.MaxrateRatio < 0 || t.BufsizeRatio < 0 {
+			addProblem("%s: maxrate_ratio and bufsize_ratio cannot be negative", name)
+		}
+		if t.MaxrateRatio != 0 && t.MaxrateRatio < 1 {
+			addProblem("%s: maxrate_ratio %v is lower than 1", name, t.MaxrateRatio)
+		}
+		if n == 0 {
+			continue
+		}
+		prev := l.Tiers[n-1]
+		if t.Height >= prev.Height {
+			addProblem("%s: height %v is not lower than previous tier height %v", name, t.Height, prev.Height)
+		}
+		if t.VideoBitrate >= prev.VideoBitrate {
+			addProblem("%s: bitrate %v is not lower than previous tier bitrate %v", name, t.VideoBitrate, prev.VideoBitrate)
+		}
+		if t.BitrateCutoff > prev.BitrateCutoff {
+			addProblem("%s: bitrate_cutoff %v is higher than previous tier cutoff %v", name, t.BitrateCutoff, prev.BitrateCutoff)
+		}
+	}
+
+	if len(problems) > 0 {
+		return ValidationError{Problems: problems}
+	}
+	return nil
+}
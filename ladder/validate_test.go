@@ -0,0 +1,89 @@
+package ladder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, Default.Validate())
+
+	l, err := Load([]byte(`
+args:
+  preset: veryfast
+  c:v: libvpx
+  c:a: opus
+  bogus: 1
+tiers:
+  - definition: 360p
+    width: 640
+    height: 360
+    bitrate: 500_000
+  - definition: 720p
+    width: 1280
+    height: 720
+    bitrate: 2500_000
+    audio_bitrate: 128k
+    bitrate_cutoff: 1000_000
+`))
+	require.NoError(t, err)
+
+	err = l.Validate()
+	require.Error(t, err)
+	var verr ValidationError
+	require.ErrorAs(t, err, &verr)
+	assert.Equal(t, []string{
+		"unknown ffmpeg argument: bogus",
+		"unsupported video codec: libvpx",
+		"unsupported audio codec: opus",
+		"tier 0 (360p): audio_bitrate missing",
+		"tier 1 (720p): bitrate_cutoff 1000000 is lower than tier bitrate 2500000",
+		"tier 1 (720p): height 720 is not lower than previous tier height 360",
+		"tier 1 (720p): bitrate 2500000 is not lower than previous tier bitrate 500000",
+		"tier 1 (720p): bitrate_cutoff 1000000 is higher than previous tier cutoff 0",
+	}, verr.Problems)
+
+	assert.Error(t, Ladder{}.Validate())
+}
+
+func TestValidateRateControl(t *testing.T) {
+	l, err := Load([]byte(`
+args:
+  crf: 23
+tiers:
+  - definition: 720p
+    width: 1280
+    height: 720
+    bitrate: 2500_000
+    audio_bitrate: 128k
+    rate_control: capped_crf
+    maxrate_ratio: 0.8
+  - definition: 480p
+    width: 854
+    height: 480
+    bitrate: 1000_000
+    audio_bitrate: 96k
+    rate_control: vbr_2pass
+    crf: 23
+  - definition: 360p
+    width: 640
+    height: 360
+    bitrate: 500_000
+    audio_bitrate: 96k
+    rate_control: abr
+`))
+	require.NoError(t, err)
+
+	err = l.Validate()
+	var verr ValidationError
+	require.ErrorAs(t, err, &verr)
+	assert.Equal(t, []string{
+		"crf in args conflicts with per-tier rate_control, set crf on tiers instead",
+		"tier 0 (720p): crf must be between 1 and 51 for capped_crf",
+		"tier 0 (720p): maxrate_ratio 0.8 is lower than 1",
+		"tier 1 (480p): crf is only used with capped_crf rate_control",
+		"tier 2 (360p): unknown rate_control: abr",
+	}, verr.Problems)
+}
@@ -0,0 +1,86 @@
+package library
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/lbryio/transcoder/library/db"
+)
+
+// accessBufferSize limits the number of distinct videos with access events waiting to be written.
+const accessBufferSize = 100000
+
+// accessRecorder buffers video access events in memory so they can be written to the database in batches.
+type accessRecorder struct {
+	sync.Mutex
+	counts map[string]int32
+	size   int
+}
+
+func newAccessRecorder(size int) *accessRecorder {
+	return &accessRecorder{counts: map[string]int32{}, size: size}
+}
+
+// add records a single access, dropping it if the buffer is full.
+func (r *accessRecorder) add(sdHash string) {
+	r.merge(map[string]int32{sdHash: 1})
+}
+
+func (r *accessRecorder) merge(counts map[string]int32) {
+	r.Lock()
+	defer r.Unlock()
+	for h, c := range counts {
+		if _, ok := r.counts[h]; !ok && len(r.counts) >= r.size {
+			AccessEventsDropped.Add(float64(c))
+			continue
+		}
+		r.counts[h] += c
+	}
+}
+
+// take empties the buffer returning its contents.
+func (r *accessRecorder) take() map[string]int32 {
+	r.Lock()
+	defer r.Unlock()
+	counts := r.counts
+	r.counts = map[string]int32{}
+	return counts
+}
+
+// FlushAccess writes buffered access events to the database. Events are kept in the buffer if writing fails.
+func (lib *Library) FlushAccess() error {
+	if lib.access == nil {
+		return nil
+	}
+	counts := lib.access.take()
+	if len(counts) == 0 {
+		return nil
+	}
+	params := db.RecordVideoAccessesParams{
+		Hashes: make([]string, 0, len(counts)),
+		Counts: make([]int32, 0, len(counts)),
+	}
+	for h, c := range counts {
+		params.Hashes = append(params.Hashes, h)
+		params.Counts = append(params.Counts, c)
+	}
+
+	start := time.Now()
+	err := lib.db.RecordVideoAccesses(context.Background(), params)
+	AccessFlushDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		lib.access.merge(counts)
+		return err
+	}
+	return nil
+}
+
+// recordAccess marks the video as accessed, buffering the event if access recording is spawned.
+func (lib *Library) recordAccess(sdHash string) error {
+	if lib.access != nil {
+		lib.access.add(sdHash)
+		return nil
+	}
+	return lib.db.RecordVideoAccess(context.Background(), sdHash)
+}
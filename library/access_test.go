@@ -0,0 +1,48 @@
+package library
+
+import (
+	"testing"
+
+	"github.com/lbryio/transcoder/pkg/logging/zapadapter"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessRecorder(t *testing.T) {
+	r := newAccessRecorder(2)
+	r.add("a")
+	r.add("b")
+	r.add("a")
+	r.add("c")
+	assert.Equal(t, map[string]int32{"a": 2, "b": 1}, r.take())
+	assert.Empty(t, r.take())
+
+	r.add("c")
+	r.merge(map[string]int32{"a": 2, "c": 3})
+	assert.Len(t, r.take(), 2)
+}
+
+func (s *librarySuite) TestFlushAccess() {
+	lib := New(Config{DB: s.DB, Storage: NewDummyStorage("storage1", ""), Log: zapadapter.NewKV(nil)})
+	lib.access = newAccessRecorder(accessBufferSize)
+
+	stream := GenerateDummyStream()
+	s.Require().NoError(lib.AddRemoteStream(*stream))
+	before, err := lib.GetVideo(stream.SDHash())
+	s.Require().NoError(err)
+
+	for range [3]int{} {
+		_, err := lib.GetVideoURL(stream.SDHash())
+		s.Require().NoError(err)
+	}
+	v, err := lib.GetVideo(stream.SDHash())
+	s.Require().NoError(err)
+	s.Equal(before.AccessCount, v.AccessCount, "access must not be recorded before flushing")
+
+	s.Require().NoError(lib.FlushAccess())
+	v, err = lib.GetVideo(stream.SDHash())
+	s.Require().NoError(err)
+	s.EqualValues(before.AccessCount.Int32+3, v.AccessCount.Int32)
+	s.False(v.AccessedAt.Before(before.AccessedAt))
+	s.NoError(lib.FlushAccess())
+}
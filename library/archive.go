@@ -0,0 +1,139 @@
+package library
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lbryio/transcoder/library/db"
+
+	"github.com/pkg/errors"
+)
+
+// Reasons recorded for archived videos.
+const (
+	ArchiveReasonRetired    = "retired"
+	ArchiveReasonBroken     = "broken"
+	ArchiveReasonMissing    = "missing"
+	ArchiveReasonSuperseded = "superseded"
+)
+
+var ErrArchiveExpired = errors.New("archived stream files are no longer kept")
+
+// ArchivingStorage can keep files of removed streams for a while so they can be restored.
+type ArchivingStorage interface {
+	Storage
+	// ArchiveTTL is how long archived stream files are kept, zero if archiving is disabled.
+	ArchiveTTL() time.Duration
+	// Archive moves stream files out of the served location.
+	Archive(streamTID string) error
+	// Unarchive moves archived stream files back.
+	Unarchive(streamTID string) error
+	DeleteArchived(streamTID string) error
+}
+
+// archivingStorage returns the storage if it keeps archived stream files.
+func archivingStorage(s Storage) ArchivingStorage {
+	as, ok := s.(ArchivingStorage)
+	if !ok || as.ArchiveTTL() <= 0 {
+		return nil
+	}
+	return as
+}
+
+// archiveVideo moves the video record to the archive. keptOn is the storage holding archived stream files, if any.
+func (lib *Library) archiveVideo(tid, reason string, keptOn ArchivingStorage) error {
+	ctx := context.Background()
+	params := db.ArchiveVideoParams{TID: tid, Reason: reason}
+	if keptOn != nil {
+		params.ArchiveStorage = sql.NullString{String: keptOn.Name(), Valid: true}
+		params.ExpiresAt = sql.NullTime{Time: time.Now().Add(keptOn.ArchiveTTL()), Valid: true}
+	}
+	return lib.inTx(func(q *db.Queries) error {
+		if err := q.DeleteArchivedVideo(ctx, tid); err != nil {
+			return err
+		}
+		if err := q.ArchiveVideo(ctx, params); err != nil {
+			return errors.Wrap(err, "cannot archive video")
+		}
+		return q.DeleteVideo(ctx, tid)
+	})
+}
+
+// GetArchivedVideos returns up to limit most recently archived videos.
+func (lib *Library) GetArchivedVideos(limit int32) ([]db.ArchivedVideo, error) {
+	return lib.db.GetArchivedVideos(context.Background(), limit)
+}
+
+// Restore reinstates an archived video along with its stream files.
+// Returns ErrArchiveExpired if stream files were not kept or have already expired.
+func (lib *Library) Restore(tid string) (db.Video, error) {
+	ctx := context.Background()
+	av, err := lib.db.GetArchivedVideo(ctx, tid)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return db.Video{}, ErrStreamNotFound
+		}
+		return db.Video{}, err
+	}
+	if !av.ArchiveStorage.Valid || (av.ExpiresAt.Valid && av.ExpiresAt.Time.Before(time.Now())) {
+		return db.Video{}, ErrArchiveExpired
+	}
+	s, ok := lib.getStorage(av.ArchiveStorage.String).(ArchivingStorage)
+	if !ok {
+		return db.Video{}, errors.Errorf("storage %s is not configured or cannot archive", av.ArchiveStorage.String)
+	}
+	if err := s.Unarchive(av.Path); err != nil {
+		return db.Video{}, errors.Wrap(err, "cannot restore stream files")
+	}
+
+	var v db.Video
+	err = lib.inTx(func(q *db.Queries) error {
+		var err error
+		v, err = q.RestoreVideo(ctx, db.RestoreVideoParams{TID: tid, Storage: s.Name()})
+		if err != nil {
+			return errors.Wrap(err, "cannot restore video")
+		}
+		err = q.AddVideoLocation(ctx, db.AddVideoLocationParams{TID: tid, Storage: s.Name(), Path: av.Path})
+		if err != nil {
+			return err
+		}
+		return q.DeleteArchivedVideo(ctx, tid)
+	})
+	if err != nil {
+		// Put files back so the archive record keeps pointing at them.
+		if aerr := s.Archive(av.Path); aerr != nil {
+			lib.log.Warn("failed to archive stream files again", "tid", tid, "storage", s.Name(), "err", aerr)
+		}
+		return db.Video{}, err
+	}
+	lib.log.Info("video restored", "tid", tid, "url", v.URL, "storage", s.Name())
+	return v, nil
+}
+
+// PurgeArchive deletes stream files of up to limit archived videos which expired. Archive records are kept.
+// Returns the number of videos purged.
+func (lib *Library) PurgeArchive(limit int32) (int, error) {
+	ctx := context.Background()
+	items, err := lib.db.GetExpiredArchivedVideos(ctx, limit)
+	if err != nil {
+		return 0, err
+	}
+	var purged int
+	for _, av := range items {
+		s, ok := lib.getStorage(av.ArchiveStorage.String).(ArchivingStorage)
+		if !ok {
+			lib.log.Info("archive storage is not configured", "tid", av.TID, "storage", av.ArchiveStorage.String)
+			continue
+		}
+		if err := s.DeleteArchived(av.Path); err != nil {
+			lib.log.Warn("failed to delete archived stream", "tid", av.TID, "storage", s.Name(), "err", err)
+			continue
+		}
+		if err := lib.db.ExpireArchivedVideo(ctx, av.TID); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
@@ -0,0 +1,82 @@
+package library
+
+import (
+	"context"
+	"time"
+
+	"github.com/lbryio/transcoder/pkg/logging/zapadapter"
+)
+
+// archivingDummyStorage keeps track of archived stream TIDs.
+type archivingDummyStorage struct {
+	*DummyStorage
+	archived map[string]bool
+}
+
+func (s archivingDummyStorage) ArchiveTTL() time.Duration {
+	return 24 * time.Hour
+}
+
+func (s archivingDummyStorage) Archive(streamTID string) error {
+	s.archived[streamTID] = true
+	return nil
+}
+
+func (s archivingDummyStorage) Unarchive(streamTID string) error {
+	delete(s.archived, streamTID)
+	return nil
+}
+
+func (s archivingDummyStorage) DeleteArchived(streamTID string) error {
+	delete(s.archived, streamTID)
+	return nil
+}
+
+func (s *librarySuite) TestArchiveRestore() {
+	strg := archivingDummyStorage{NewDummyStorage("storage1", "http://storage1"), map[string]bool{}}
+	lib := New(Config{DB: s.DB, Storage: strg, Log: zapadapter.NewKV(nil)})
+
+	stream := GenerateDummyStream()
+	s.Require().NoError(lib.AddRemoteStream(*stream))
+	_, _, err := lib.RetireVideos("storage1", 0)
+	s.Require().NoError(err)
+	s.True(strg.archived[stream.TID()])
+	s.Empty(strg.Ops, "archived stream must not be deleted")
+	_, err = lib.GetVideo(stream.SDHash())
+	s.Error(err)
+
+	items, err := lib.GetArchivedVideos(10)
+	s.Require().NoError(err)
+	s.Require().Len(items, 1)
+	s.Equal(stream.TID(), items[0].TID)
+	s.Equal(ArchiveReasonRetired, items[0].Reason)
+	s.Equal("storage1", items[0].ArchiveStorage.String)
+	s.WithinDuration(time.Now().Add(24*time.Hour), items[0].ExpiresAt.Time, time.Minute)
+
+	v, err := lib.Restore(stream.TID())
+	s.Require().NoError(err)
+	s.Equal(stream.Checksum(), v.Checksum.String)
+	s.False(strg.archived[stream.TID()])
+	url, err := lib.GetVideoURL(stream.SDHash())
+	s.Require().NoError(err)
+	s.Equal("remote://storage1/"+stream.TID()+"/", url)
+	items, err = lib.GetArchivedVideos(10)
+	s.Require().NoError(err)
+	s.Empty(items)
+
+	s.Require().NoError(lib.Retire(v))
+	_, err = s.DB.ExecContext(context.Background(), "UPDATE archived_videos SET expires_at = $1", time.Now().Add(-time.Hour))
+	s.Require().NoError(err)
+	_, err = lib.Restore(stream.TID())
+	s.ErrorIs(err, ErrArchiveExpired)
+	s.True(strg.archived[stream.TID()], "expired archive must not be restored")
+	purged, err := lib.PurgeArchive(10)
+	s.Require().NoError(err)
+	s.Equal(1, purged)
+	s.Empty(strg.archived)
+
+	_, err = lib.Restore(stream.TID())
+	s.ErrorIs(err, ErrArchiveExpired)
+	_, err = lib.Restore("nonexistent")
+	s.ErrorIs(err, ErrStreamNotFound)
+}
@@ -0,0 +1,8 @@
+-- +migrate Up
+
+ALTER TABLE videos
+    ADD COLUMN ladder text;
+
+-- +migrate Down
+ALTER TABLE videos
+    DROP COLUMN ladder;
@@ -0,0 +1,21 @@
+-- +migrate Up
+
+CREATE TABLE video_locations (
+    id SERIAL NOT NULL PRIMARY KEY,
+
+    created_at timestamp NOT NULL DEFAULT NOW(),
+
+    tid text NOT NULL REFERENCES videos (tid) ON DELETE CASCADE,
+    storage text NOT NULL CHECK (storage <> ''),
+    path text NOT NULL CHECK (path <> ''),
+
+    UNIQUE (tid, storage)
+);
+
+CREATE INDEX video_locations_storage ON video_locations (storage);
+
+INSERT INTO video_locations (tid, storage, path)
+    SELECT tid, storage, path FROM videos;
+
+-- +migrate Down
+DROP TABLE video_locations;
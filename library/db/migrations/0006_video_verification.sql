@@ -0,0 +1,10 @@
+-- +migrate Up
+
+ALTER TABLE videos
+    ADD COLUMN verified_at timestamp,
+    ADD COLUMN checksum_valid boolean;
+
+-- +migrate Down
+ALTER TABLE videos
+    DROP COLUMN verified_at,
+    DROP COLUMN checksum_valid;
@@ -0,0 +1,38 @@
+-- +migrate Up
+
+CREATE TABLE archived_videos (
+    id SERIAL NOT NULL PRIMARY KEY,
+
+    archived_at timestamp NOT NULL DEFAULT NOW(),
+    reason text NOT NULL,
+    -- Storage keeping archived stream files until expires_at, if they are kept.
+    archive_storage text,
+    expires_at timestamp,
+
+    created_at timestamp NOT NULL,
+    updated_at timestamp,
+    accessed_at timestamp NOT NULL,
+    access_count integer,
+
+    tid text NOT NULL UNIQUE CHECK (tid <> ''),
+
+    url text NOT NULL,
+    sd_hash text NOT NULL,
+    channel text NOT NULL,
+
+    storage text NOT NULL,
+    path text NOT NULL,
+    size bigint NOT NULL,
+
+    checksum text,
+    manifest jsonb,
+    ladder text,
+    verified_at timestamp,
+    checksum_valid boolean
+);
+
+CREATE INDEX archived_videos_sd_hash ON archived_videos (sd_hash);
+CREATE INDEX archived_videos_expires_at ON archived_videos (expires_at);
+
+-- +migrate Down
+DROP TABLE archived_videos;
@@ -0,0 +1,21 @@
+-- +migrate Up
+
+ALTER TABLE videos
+    DROP CONSTRAINT videos_sd_hash_key,
+    ADD COLUMN active boolean NOT NULL DEFAULT true,
+    ADD COLUMN superseded_at timestamp;
+
+-- Only one version of a stream is served at a time.
+CREATE UNIQUE INDEX videos_active_sd_hash ON videos (sd_hash) WHERE active;
+CREATE INDEX videos_superseded_at ON videos (superseded_at) WHERE NOT active;
+
+-- +migrate Down
+DELETE FROM videos WHERE NOT active;
+
+DROP INDEX videos_superseded_at;
+DROP INDEX videos_active_sd_hash;
+
+ALTER TABLE videos
+    DROP COLUMN active,
+    DROP COLUMN superseded_at,
+    ADD CONSTRAINT videos_sd_hash_key UNIQUE (sd_hash);
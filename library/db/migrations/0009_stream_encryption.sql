@@ -0,0 +1,24 @@
+-- +migrate Up
+
+-- HLS encryption method for streams of the channel, empty for unencrypted output.
+ALTER TABLE channels
+    ADD COLUMN encryption text NOT NULL DEFAULT '' CHECK (encryption IN ('', 'aes-128'));
+
+CREATE TABLE stream_keys (
+    id SERIAL NOT NULL PRIMARY KEY,
+
+    created_at timestamp NOT NULL DEFAULT NOW(),
+
+    kid text NOT NULL UNIQUE CHECK (kid <> ''),
+    sd_hash text NOT NULL CHECK (sd_hash <> ''),
+    method text NOT NULL CHECK (method <> ''),
+    key bytea NOT NULL
+);
+
+CREATE INDEX stream_keys_sd_hash ON stream_keys (sd_hash);
+
+-- +migrate Down
+DROP TABLE stream_keys;
+
+ALTER TABLE channels
+    DROP COLUMN encryption;
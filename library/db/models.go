@@ -31,27 +31,74 @@ func (e *ChannelPriority) Scan(src interface{}) error {
 	return nil
 }
 
+type ArchivedVideo struct {
+	ID             int32
+	ArchivedAt     time.Time
+	Reason         string
+	ArchiveStorage sql.NullString
+	ExpiresAt      sql.NullTime
+	CreatedAt      time.Time
+	UpdatedAt      sql.NullTime
+	AccessedAt     time.Time
+	AccessCount    sql.NullInt32
+	TID            string
+	URL            string
+	SDHash         string
+	Channel        string
+	Storage        string
+	Path           string
+	Size           int64
+	Checksum       sql.NullString
+	Manifest       pqtype.NullRawMessage
+	Ladder         sql.NullString
+	VerifiedAt     sql.NullTime
+	ChecksumValid  sql.NullBool
+}
+
 type Channel struct {
+	ID         int32
+	CreatedAt  time.Time
+	URL        string
+	ClaimID    string
+	Priority   ChannelPriority
+	Encryption string
+}
+
+type StreamKey struct {
 	ID        int32
 	CreatedAt time.Time
-	URL       string
-	ClaimID   string
-	Priority  ChannelPriority
+	KID       string
+	SDHash    string
+	Method    string
+	Key       []byte
 }
 
 type Video struct {
-	ID          int32
-	CreatedAt   time.Time
-	UpdatedAt   sql.NullTime
-	AccessedAt  time.Time
-	AccessCount sql.NullInt32
-	TID         string
-	URL         string
-	SDHash      string
-	Channel     string
-	Storage     string
-	Path        string
-	Size        int64
-	Checksum    sql.NullString
-	Manifest    pqtype.NullRawMessage
+	ID            int32
+	CreatedAt     time.Time
+	UpdatedAt     sql.NullTime
+	AccessedAt    time.Time
+	AccessCount   sql.NullInt32
+	TID           string
+	URL           string
+	SDHash        string
+	Channel       string
+	Storage       string
+	Path          string
+	Size          int64
+	Checksum      sql.NullString
+	Manifest      pqtype.NullRawMessage
+	Ladder        sql.NullString
+	VerifiedAt    sql.NullTime
+	ChecksumValid sql.NullBool
+	Active        bool
+	SupersededAt  sql.NullTime
+}
+
+type VideoLocation struct {
+	ID        int32
+	CreatedAt time.Time
+	TID       string
+	Storage   string
+	Path      string
 }
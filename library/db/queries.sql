@@ -1,8 +1,8 @@
 -- name: AddVideo :one
 INSERT INTO videos (
-  tid, sd_hash, url, channel, storage, path, size, checksum, manifest
+  tid, sd_hash, url, channel, storage, path, size, checksum, manifest, ladder, active, superseded_at
 ) VALUES (
-  $1, $2, $3, $4, $5, $6, $7, $8, $9
+  $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12
 )
 RETURNING *;
 
@@ -10,28 +10,203 @@ RETURNING *;
 SELECT * FROM videos;
 
 -- name: GetAllVideosForStorage :many
-SELECT * FROM videos
-WHERE storage = $1;
+SELECT videos.* FROM videos
+JOIN video_locations ON video_locations.tid = videos.tid
+WHERE video_locations.storage = $1;
 
 -- name: GetAllVideosForStorageLimit :many
-SELECT * FROM videos
-WHERE storage = $1
-ORDER BY id ASC
+SELECT videos.* FROM videos
+JOIN video_locations ON video_locations.tid = videos.tid
+WHERE video_locations.storage = $1
+ORDER BY videos.id ASC
 LIMIT $2 OFFSET $3;
 
+-- name: GetVideosMissingFromStorage :many
+SELECT * FROM videos
+WHERE active AND NOT EXISTS (
+  SELECT 1 FROM video_locations
+  WHERE video_locations.tid = videos.tid AND video_locations.storage = $1
+)
+ORDER BY accessed_at DESC
+LIMIT $2;
+
 -- name: GetVideo :one
 SELECT * FROM videos
-WHERE sd_hash = $1 LIMIT 1;
+WHERE sd_hash = $1 AND active LIMIT 1;
+
+-- name: GetVideoVersions :many
+SELECT * FROM videos
+WHERE sd_hash = $1
+ORDER BY created_at DESC;
+
+-- name: GetSupersededVideos :many
+SELECT * FROM videos
+WHERE NOT active AND superseded_at < $1
+ORDER BY superseded_at ASC
+LIMIT $2;
+
+-- name: SupersedeVideos :exec
+UPDATE videos
+SET active = false, superseded_at = NOW()
+WHERE sd_hash = $1 AND active;
+
+-- name: ActivateVideo :exec
+UPDATE videos
+SET active = true, superseded_at = NULL
+WHERE tid = $1;
+
+-- name: GetVideoByTID :one
+SELECT * FROM videos
+WHERE tid = $1 LIMIT 1;
+
+-- name: ListVideos :many
+SELECT videos.* FROM videos
+WHERE
+  (sqlc.arg(channel)::text = '' OR videos.channel = sqlc.arg(channel))
+  AND (sqlc.arg(storage)::text = '' OR EXISTS (
+    SELECT 1 FROM video_locations
+    WHERE video_locations.tid = videos.tid AND video_locations.storage = sqlc.arg(storage)
+  ))
+  AND (sqlc.narg(created_after)::timestamp IS NULL OR videos.created_at >= sqlc.narg(created_after))
+  AND (sqlc.narg(created_before)::timestamp IS NULL OR videos.created_at < sqlc.narg(created_before))
+  AND (sqlc.narg(accessed_after)::timestamp IS NULL OR videos.accessed_at >= sqlc.narg(accessed_after))
+  AND (sqlc.narg(accessed_before)::timestamp IS NULL OR videos.accessed_at < sqlc.narg(accessed_before))
+  AND (sqlc.arg(min_size)::bigint = 0 OR videos.size >= sqlc.arg(min_size))
+  AND (sqlc.arg(max_size)::bigint = 0 OR videos.size <= sqlc.arg(max_size))
+  AND (sqlc.arg(ladder)::text = '' OR videos.ladder = sqlc.arg(ladder))
+  AND (sqlc.arg(worker)::text = '' OR videos.manifest->>'transcoded_by' = sqlc.arg(worker))
+ORDER BY videos.created_at DESC, videos.id DESC
+LIMIT sqlc.arg(limit) OFFSET sqlc.arg(offset);
+
+-- name: CountVideos :one
+SELECT COUNT(*) AS count, COALESCE(SUM(videos.size), 0)::bigint AS total_size FROM videos
+WHERE
+  (sqlc.arg(channel)::text = '' OR videos.channel = sqlc.arg(channel))
+  AND (sqlc.arg(storage)::text = '' OR EXISTS (
+    SELECT 1 FROM video_locations
+    WHERE video_locations.tid = videos.tid AND video_locations.storage = sqlc.arg(storage)
+  ))
+  AND (sqlc.narg(created_after)::timestamp IS NULL OR videos.created_at >= sqlc.narg(created_after))
+  AND (sqlc.narg(created_before)::timestamp IS NULL OR videos.created_at < sqlc.narg(created_before))
+  AND (sqlc.narg(accessed_after)::timestamp IS NULL OR videos.accessed_at >= sqlc.narg(accessed_after))
+  AND (sqlc.narg(accessed_before)::timestamp IS NULL OR videos.accessed_at < sqlc.narg(accessed_before))
+  AND (sqlc.arg(min_size)::bigint = 0 OR videos.size >= sqlc.arg(min_size))
+  AND (sqlc.arg(max_size)::bigint = 0 OR videos.size <= sqlc.arg(max_size))
+  AND (sqlc.arg(ladder)::text = '' OR videos.ladder = sqlc.arg(ladder))
+  AND (sqlc.arg(worker)::text = '' OR videos.manifest->>'transcoded_by' = sqlc.arg(worker));
+
+-- name: GetVideosForVerification :many
+SELECT * FROM videos
+WHERE checksum IS NOT NULL AND (verified_at IS NULL OR verified_at < $1)
+ORDER BY verified_at ASC NULLS FIRST
+LIMIT $2;
+
+-- name: RecordVideoVerification :exec
+UPDATE videos
+SET verified_at = NOW(), checksum_valid = $2
+WHERE tid = $1;
 
 -- name: RecordVideoAccess :exec
 UPDATE videos
 SET accessed_at = NOW(), access_count = access_count + 1
-WHERE sd_hash = $1;
+WHERE sd_hash = $1 AND active;
+
+-- name: RecordVideoAccesses :exec
+UPDATE videos
+SET
+  accessed_at = NOW(),
+  access_count = COALESCE(videos.access_count, 0) + accesses.count
+FROM unnest(sqlc.arg(hashes)::text[], sqlc.arg(counts)::integer[]) AS accesses (sd_hash, count)
+WHERE videos.sd_hash = accesses.sd_hash AND videos.active;
 
 -- name: DeleteVideo :exec
 DELETE from videos
 WHERE tid = $1;
 
+-- name: ArchiveVideo :exec
+INSERT INTO archived_videos (
+  reason, archive_storage, expires_at,
+  created_at, updated_at, accessed_at, access_count, tid, url, sd_hash, channel,
+  storage, path, size, checksum, manifest, ladder, verified_at, checksum_valid
+)
+SELECT
+  $2, $3, $4,
+  created_at, updated_at, accessed_at, access_count, tid, url, sd_hash, channel,
+  storage, path, size, checksum, manifest, ladder, verified_at, checksum_valid
+FROM videos
+WHERE tid = $1;
+
+-- name: GetArchivedVideo :one
+SELECT * FROM archived_videos
+WHERE tid = $1 LIMIT 1;
+
+-- name: GetArchivedVideos :many
+SELECT * FROM archived_videos
+ORDER BY archived_at DESC
+LIMIT $1;
+
+-- name: GetExpiredArchivedVideos :many
+SELECT * FROM archived_videos
+WHERE expires_at < NOW()
+ORDER BY expires_at ASC
+LIMIT $1;
+
+-- name: ExpireArchivedVideo :exec
+UPDATE archived_videos
+SET archive_storage = NULL, expires_at = NULL
+WHERE tid = $1;
+
+-- name: RestoreVideo :one
+INSERT INTO videos (
+  created_at, updated_at, accessed_at, access_count, tid, url, sd_hash, channel,
+  storage, path, size, checksum, manifest, ladder, verified_at, checksum_valid,
+  active, superseded_at
+)
+SELECT
+  created_at, updated_at, accessed_at, access_count, tid, url, sd_hash, channel,
+  sqlc.arg(storage)::text, path, size, checksum, manifest, ladder, verified_at, checksum_valid,
+  NOT active_version.found, CASE WHEN active_version.found THEN NOW() END
+FROM archived_videos,
+  LATERAL (SELECT EXISTS (
+    SELECT 1 FROM videos WHERE videos.sd_hash = archived_videos.sd_hash AND videos.active
+  ) AS found) AS active_version
+WHERE archived_videos.tid = sqlc.arg(tid)
+RETURNING *;
+
+-- name: DeleteArchivedVideo :exec
+DELETE FROM archived_videos
+WHERE tid = $1;
+
+-- name: GetVideosForManifestMigration :many
+SELECT * FROM videos
+WHERE manifest IS NOT NULL
+  AND COALESCE((manifest->>'schema')::int, 1) < sqlc.arg(schema)
+  AND id > sqlc.arg(after_id)
+ORDER BY id
+LIMIT sqlc.arg(limit);
+
+-- name: UpdateVideoManifest :exec
+UPDATE videos
+SET manifest = $2, updated_at = NOW()
+WHERE tid = $1;
+
+-- name: AddVideoLocation :exec
+INSERT INTO video_locations (
+  tid, storage, path
+) VALUES (
+  $1, $2, $3
+)
+ON CONFLICT (tid, storage) DO NOTHING;
+
+-- name: GetVideoLocations :many
+SELECT * FROM video_locations
+WHERE tid = $1
+ORDER BY id ASC;
+
+-- name: DeleteVideoLocation :exec
+DELETE FROM video_locations
+WHERE tid = $1 AND storage = $2;
+
 -- name: AddChannel :one
 INSERT into channels (
     url, claim_id, priority
@@ -46,3 +221,65 @@ WHERE claim_id = $1;
 
 -- name: GetAllChannels :many
 SELECT * from channels;
+
+-- name: SetChannelEncryption :one
+UPDATE channels SET encryption = $2
+WHERE claim_id = $1
+RETURNING *;
+
+-- name: AddStreamKey :one
+INSERT INTO stream_keys (
+    kid, sd_hash, method, key
+) VALUES (
+    $1, $2, $3, $4
+)
+RETURNING *;
+
+-- name: GetStreamKey :one
+SELECT * FROM stream_keys
+WHERE kid = $1;
+
+-- name: GetLatestStreamKey :one
+SELECT * FROM stream_keys
+WHERE sd_hash = $1 AND method = $2
+ORDER BY created_at DESC, id DESC
+LIMIT 1;
+
+-- name: GetStorageStats :many
+SELECT video_locations.storage, COUNT(*) AS count, COALESCE(SUM(videos.size), 0)::bigint AS total_size
+FROM video_locations
+JOIN videos ON videos.tid = video_locations.tid
+GROUP BY video_locations.storage
+ORDER BY video_locations.storage;
+
+-- name: GetChannelStats :many
+SELECT channel, COUNT(*) AS count, COALESCE(SUM(size), 0)::bigint AS total_size
+FROM videos
+GROUP BY channel
+ORDER BY total_size DESC
+LIMIT $1;
+
+-- name: GetTierStats :many
+SELECT COALESCE(
+    NULLIF(manifest->'Ladder'->'Tiers'->0->>'Definition', ''),
+    (manifest->'Ladder'->'Tiers'->0->>'Height') || 'p',
+    'unknown'
+  )::text AS tier, COUNT(*) AS count, COALESCE(SUM(size), 0)::bigint AS total_size
+FROM videos
+GROUP BY tier
+ORDER BY count DESC;
+
+-- name: GetCompressionStats :one
+SELECT COUNT(*) AS count,
+  COALESCE(SUM(size), 0)::bigint AS output_size,
+  COALESCE(SUM((manifest->>'input_size')::bigint), 0)::bigint AS input_size,
+  COALESCE(AVG(size::float8 / (manifest->>'input_size')::float8), 0)::float8 AS avg_ratio
+FROM videos
+WHERE COALESCE((manifest->>'input_size')::bigint, 0) > 0;
+
+-- name: GetTopAccessedVideos :many
+SELECT tid, url, sd_hash, channel, size, access_count, accessed_at
+FROM videos
+WHERE active
+ORDER BY access_count DESC NULLS LAST, accessed_at DESC
+LIMIT $1;
@@ -6,17 +6,30 @@ package db
 import (
 	"context"
 	"database/sql"
+	"time"
 
+	"github.com/lib/pq"
 	"github.com/tabbed/pqtype"
 )
 
+const activateVideo = `-- name: ActivateVideo :exec
+UPDATE videos
+SET active = true, superseded_at = NULL
+WHERE tid = $1
+`
+
+func (q *Queries) ActivateVideo(ctx context.Context, tid string) error {
+	_, err := q.db.ExecContext(ctx, activateVideo, tid)
+	return err
+}
+
 const addChannel = `-- name: AddChannel :one
 INSERT into channels (
     url, claim_id, priority
 ) VALUES (
     $1, $2, $3
 )
-RETURNING id, created_at, url, claim_id, priority
+RETURNING id, created_at, url, claim_id, priority, encryption
 `
 
 type AddChannelParams struct {
@@ -34,29 +47,68 @@ func (q *Queries) AddChannel(ctx context.Context, arg AddChannelParams) (Channel
 		&i.URL,
 		&i.ClaimID,
 		&i.Priority,
+		&i.Encryption,
+	)
+	return i, err
+}
+
+const addStreamKey = `-- name: AddStreamKey :one
+INSERT INTO stream_keys (
+    kid, sd_hash, method, key
+) VALUES (
+    $1, $2, $3, $4
+)
+RETURNING id, created_at, kid, sd_hash, method, key
+`
+
+type AddStreamKeyParams struct {
+	KID    string
+	SDHash string
+	Method string
+	Key    []byte
+}
+
+func (q *Queries) AddStreamKey(ctx context.Context, arg AddStreamKeyParams) (StreamKey, error) {
+	row := q.db.QueryRowContext(ctx, addStreamKey,
+		arg.KID,
+		arg.SDHash,
+		arg.Method,
+		arg.Key,
+	)
+	var i StreamKey
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.KID,
+		&i.SDHash,
+		&i.Method,
+		&i.Key,
 	)
 	return i, err
 }
 
 const addVideo = `-- name: AddVideo :one
 INSERT INTO videos (
-  tid, sd_hash, url, channel, storage, path, size, checksum, manifest
+  tid, sd_hash, url, channel, storage, path, size, checksum, manifest, ladder, active, superseded_at
 ) VALUES (
-  $1, $2, $3, $4, $5, $6, $7, $8, $9
+  $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12
 )
-RETURNING id, created_at, updated_at, accessed_at, access_count, tid, url, sd_hash, channel, storage, path, size, checksum, manifest
+RETURNING id, created_at, updated_at, accessed_at, access_count, tid, url, sd_hash, channel, storage, path, size, checksum, manifest, ladder, verified_at, checksum_valid, active, superseded_at
 `
 
 type AddVideoParams struct {
-	TID      string
-	SDHash   string
-	URL      string
-	Channel  string
-	Storage  string
-	Path     string
-	Size     int64
-	Checksum sql.NullString
-	Manifest pqtype.NullRawMessage
+	TID          string
+	SDHash       string
+	URL          string
+	Channel      string
+	Storage      string
+	Path         string
+	Size         int64
+	Checksum     sql.NullString
+	Manifest     pqtype.NullRawMessage
+	Ladder       sql.NullString
+	Active       bool
+	SupersededAt sql.NullTime
 }
 
 func (q *Queries) AddVideo(ctx context.Context, arg AddVideoParams) (Video, error) {
@@ -70,6 +122,9 @@ func (q *Queries) AddVideo(ctx context.Context, arg AddVideoParams) (Video, erro
 		arg.Size,
 		arg.Checksum,
 		arg.Manifest,
+		arg.Ladder,
+		arg.Active,
+		arg.SupersededAt,
 	)
 	var i Video
 	err := row.Scan(
@@ -87,10 +142,130 @@ func (q *Queries) AddVideo(ctx context.Context, arg AddVideoParams) (Video, erro
 		&i.Size,
 		&i.Checksum,
 		&i.Manifest,
+		&i.Ladder,
+		&i.VerifiedAt,
+		&i.ChecksumValid,
+		&i.Active,
+		&i.SupersededAt,
+	)
+	return i, err
+}
+
+const addVideoLocation = `-- name: AddVideoLocation :exec
+INSERT INTO video_locations (
+  tid, storage, path
+) VALUES (
+  $1, $2, $3
+)
+ON CONFLICT (tid, storage) DO NOTHING
+`
+
+type AddVideoLocationParams struct {
+	TID     string
+	Storage string
+	Path    string
+}
+
+func (q *Queries) AddVideoLocation(ctx context.Context, arg AddVideoLocationParams) error {
+	_, err := q.db.ExecContext(ctx, addVideoLocation, arg.TID, arg.Storage, arg.Path)
+	return err
+}
+
+const archiveVideo = `-- name: ArchiveVideo :exec
+INSERT INTO archived_videos (
+  reason, archive_storage, expires_at,
+  created_at, updated_at, accessed_at, access_count, tid, url, sd_hash, channel,
+  storage, path, size, checksum, manifest, ladder, verified_at, checksum_valid
+)
+SELECT
+  $2, $3, $4,
+  created_at, updated_at, accessed_at, access_count, tid, url, sd_hash, channel,
+  storage, path, size, checksum, manifest, ladder, verified_at, checksum_valid
+FROM videos
+WHERE tid = $1
+`
+
+type ArchiveVideoParams struct {
+	TID            string
+	Reason         string
+	ArchiveStorage sql.NullString
+	ExpiresAt      sql.NullTime
+}
+
+func (q *Queries) ArchiveVideo(ctx context.Context, arg ArchiveVideoParams) error {
+	_, err := q.db.ExecContext(ctx, archiveVideo,
+		arg.TID,
+		arg.Reason,
+		arg.ArchiveStorage,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
+const countVideos = `-- name: CountVideos :one
+SELECT COUNT(*) AS count, COALESCE(SUM(videos.size), 0)::bigint AS total_size FROM videos
+WHERE
+  ($1::text = '' OR videos.channel = $1)
+  AND ($2::text = '' OR EXISTS (
+    SELECT 1 FROM video_locations
+    WHERE video_locations.tid = videos.tid AND video_locations.storage = $2
+  ))
+  AND ($3::timestamp IS NULL OR videos.created_at >= $3)
+  AND ($4::timestamp IS NULL OR videos.created_at < $4)
+  AND ($5::timestamp IS NULL OR videos.accessed_at >= $5)
+  AND ($6::timestamp IS NULL OR videos.accessed_at < $6)
+  AND ($7::bigint = 0 OR videos.size >= $7)
+  AND ($8::bigint = 0 OR videos.size <= $8)
+  AND ($9::text = '' OR videos.ladder = $9)
+  AND ($10::text = '' OR videos.manifest->>'transcoded_by' = $10)
+`
+
+type CountVideosParams struct {
+	Channel        string
+	Storage        string
+	CreatedAfter   sql.NullTime
+	CreatedBefore  sql.NullTime
+	AccessedAfter  sql.NullTime
+	AccessedBefore sql.NullTime
+	MinSize        int64
+	MaxSize        int64
+	Ladder         string
+	Worker         string
+}
+
+type CountVideosRow struct {
+	Count     int64
+	TotalSize int64
+}
+
+func (q *Queries) CountVideos(ctx context.Context, arg CountVideosParams) (CountVideosRow, error) {
+	row := q.db.QueryRowContext(ctx, countVideos,
+		arg.Channel,
+		arg.Storage,
+		arg.CreatedAfter,
+		arg.CreatedBefore,
+		arg.AccessedAfter,
+		arg.AccessedBefore,
+		arg.MinSize,
+		arg.MaxSize,
+		arg.Ladder,
+		arg.Worker,
 	)
+	var i CountVideosRow
+	err := row.Scan(&i.Count, &i.TotalSize)
 	return i, err
 }
 
+const deleteArchivedVideo = `-- name: DeleteArchivedVideo :exec
+DELETE FROM archived_videos
+WHERE tid = $1
+`
+
+func (q *Queries) DeleteArchivedVideo(ctx context.Context, tid string) error {
+	_, err := q.db.ExecContext(ctx, deleteArchivedVideo, tid)
+	return err
+}
+
 const deleteVideo = `-- name: DeleteVideo :exec
 DELETE from videos
 WHERE tid = $1
@@ -101,6 +276,32 @@ func (q *Queries) DeleteVideo(ctx context.Context, tid string) error {
 	return err
 }
 
+const deleteVideoLocation = `-- name: DeleteVideoLocation :exec
+DELETE FROM video_locations
+WHERE tid = $1 AND storage = $2
+`
+
+type DeleteVideoLocationParams struct {
+	TID     string
+	Storage string
+}
+
+func (q *Queries) DeleteVideoLocation(ctx context.Context, arg DeleteVideoLocationParams) error {
+	_, err := q.db.ExecContext(ctx, deleteVideoLocation, arg.TID, arg.Storage)
+	return err
+}
+
+const expireArchivedVideo = `-- name: ExpireArchivedVideo :exec
+UPDATE archived_videos
+SET archive_storage = NULL, expires_at = NULL
+WHERE tid = $1
+`
+
+func (q *Queries) ExpireArchivedVideo(ctx context.Context, tid string) error {
+	_, err := q.db.ExecContext(ctx, expireArchivedVideo, tid)
+	return err
+}
+
 const getAllChannels = `-- name: GetAllChannels :many
 SELECT id, created_at, url, claim_id, priority from channels
 `
@@ -120,6 +321,7 @@ func (q *Queries) GetAllChannels(ctx context.Context) ([]Channel, error) {
 			&i.URL,
 			&i.ClaimID,
 			&i.Priority,
+			&i.Encryption,
 		); err != nil {
 			return nil, err
 		}
@@ -135,7 +337,7 @@ func (q *Queries) GetAllChannels(ctx context.Context) ([]Channel, error) {
 }
 
 const getAllVideos = `-- name: GetAllVideos :many
-SELECT id, created_at, updated_at, accessed_at, access_count, tid, url, sd_hash, channel, storage, path, size, checksum, manifest FROM videos
+SELECT id, created_at, updated_at, accessed_at, access_count, tid, url, sd_hash, channel, storage, path, size, checksum, manifest, ladder, verified_at, checksum_valid, active, superseded_at FROM videos
 `
 
 func (q *Queries) GetAllVideos(ctx context.Context) ([]Video, error) {
@@ -162,6 +364,11 @@ func (q *Queries) GetAllVideos(ctx context.Context) ([]Video, error) {
 			&i.Size,
 			&i.Checksum,
 			&i.Manifest,
+			&i.Ladder,
+			&i.VerifiedAt,
+			&i.ChecksumValid,
+			&i.Active,
+			&i.SupersededAt,
 		); err != nil {
 			return nil, err
 		}
@@ -177,8 +384,9 @@ func (q *Queries) GetAllVideos(ctx context.Context) ([]Video, error) {
 }
 
 const getAllVideosForStorage = `-- name: GetAllVideosForStorage :many
-SELECT id, created_at, updated_at, accessed_at, access_count, tid, url, sd_hash, channel, storage, path, size, checksum, manifest FROM videos
-WHERE storage = $1
+SELECT videos.id, videos.created_at, videos.updated_at, videos.accessed_at, videos.access_count, videos.tid, videos.url, videos.sd_hash, videos.channel, videos.storage, videos.path, videos.size, videos.checksum, videos.manifest, videos.ladder, videos.verified_at, videos.checksum_valid, videos.active, videos.superseded_at FROM videos
+JOIN video_locations ON video_locations.tid = videos.tid
+WHERE video_locations.storage = $1
 `
 
 func (q *Queries) GetAllVideosForStorage(ctx context.Context, storage string) ([]Video, error) {
@@ -205,6 +413,11 @@ func (q *Queries) GetAllVideosForStorage(ctx context.Context, storage string) ([
 			&i.Size,
 			&i.Checksum,
 			&i.Manifest,
+			&i.Ladder,
+			&i.VerifiedAt,
+			&i.ChecksumValid,
+			&i.Active,
+			&i.SupersededAt,
 		); err != nil {
 			return nil, err
 		}
@@ -220,9 +433,10 @@ func (q *Queries) GetAllVideosForStorage(ctx context.Context, storage string) ([
 }
 
 const getAllVideosForStorageLimit = `-- name: GetAllVideosForStorageLimit :many
-SELECT id, created_at, updated_at, accessed_at, access_count, tid, url, sd_hash, channel, storage, path, size, checksum, manifest FROM videos
-WHERE storage = $1
-ORDER BY id ASC
+SELECT videos.id, videos.created_at, videos.updated_at, videos.accessed_at, videos.access_count, videos.tid, videos.url, videos.sd_hash, videos.channel, videos.storage, videos.path, videos.size, videos.checksum, videos.manifest, videos.ladder, videos.verified_at, videos.checksum_valid, videos.active, videos.superseded_at FROM videos
+JOIN video_locations ON video_locations.tid = videos.tid
+WHERE video_locations.storage = $1
+ORDER BY videos.id ASC
 LIMIT $2 OFFSET $3
 `
 
@@ -256,6 +470,96 @@ func (q *Queries) GetAllVideosForStorageLimit(ctx context.Context, arg GetAllVid
 			&i.Size,
 			&i.Checksum,
 			&i.Manifest,
+			&i.Ladder,
+			&i.VerifiedAt,
+			&i.ChecksumValid,
+			&i.Active,
+			&i.SupersededAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getArchivedVideo = `-- name: GetArchivedVideo :one
+SELECT id, archived_at, reason, archive_storage, expires_at, created_at, updated_at, accessed_at, access_count, tid, url, sd_hash, channel, storage, path, size, checksum, manifest, ladder, verified_at, checksum_valid FROM archived_videos
+WHERE tid = $1 LIMIT 1
+`
+
+func (q *Queries) GetArchivedVideo(ctx context.Context, tid string) (ArchivedVideo, error) {
+	row := q.db.QueryRowContext(ctx, getArchivedVideo, tid)
+	var i ArchivedVideo
+	err := row.Scan(
+		&i.ID,
+		&i.ArchivedAt,
+		&i.Reason,
+		&i.ArchiveStorage,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.AccessedAt,
+		&i.AccessCount,
+		&i.TID,
+		&i.URL,
+		&i.SDHash,
+		&i.Channel,
+		&i.Storage,
+		&i.Path,
+		&i.Size,
+		&i.Checksum,
+		&i.Manifest,
+		&i.Ladder,
+		&i.VerifiedAt,
+		&i.ChecksumValid,
+	)
+	return i, err
+}
+
+const getArchivedVideos = `-- name: GetArchivedVideos :many
+SELECT id, archived_at, reason, archive_storage, expires_at, created_at, updated_at, accessed_at, access_count, tid, url, sd_hash, channel, storage, path, size, checksum, manifest, ladder, verified_at, checksum_valid FROM archived_videos
+ORDER BY archived_at DESC
+LIMIT $1
+`
+
+func (q *Queries) GetArchivedVideos(ctx context.Context, limit int32) ([]ArchivedVideo, error) {
+	rows, err := q.db.QueryContext(ctx, getArchivedVideos, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ArchivedVideo
+	for rows.Next() {
+		var i ArchivedVideo
+		if err := rows.Scan(
+			&i.ID,
+			&i.ArchivedAt,
+			&i.Reason,
+			&i.ArchiveStorage,
+			&i.ExpiresAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.AccessedAt,
+			&i.AccessCount,
+			&i.TID,
+			&i.URL,
+			&i.SDHash,
+			&i.Channel,
+			&i.Storage,
+			&i.Path,
+			&i.Size,
+			&i.Checksum,
+			&i.Manifest,
+			&i.Ladder,
+			&i.VerifiedAt,
+			&i.ChecksumValid,
 		); err != nil {
 			return nil, err
 		}
@@ -284,44 +588,920 @@ func (q *Queries) GetChannel(ctx context.Context, claimID string) (Channel, erro
 		&i.URL,
 		&i.ClaimID,
 		&i.Priority,
+		&i.Encryption,
 	)
 	return i, err
 }
 
-const getVideo = `-- name: GetVideo :one
-SELECT id, created_at, updated_at, accessed_at, access_count, tid, url, sd_hash, channel, storage, path, size, checksum, manifest FROM videos
-WHERE sd_hash = $1 LIMIT 1
+const getChannelStats = `-- name: GetChannelStats :many
+SELECT channel, COUNT(*) AS count, COALESCE(SUM(size), 0)::bigint AS total_size
+FROM videos
+GROUP BY channel
+ORDER BY total_size DESC
+LIMIT $1
 `
 
-func (q *Queries) GetVideo(ctx context.Context, sdHash string) (Video, error) {
-	row := q.db.QueryRowContext(ctx, getVideo, sdHash)
-	var i Video
+type GetChannelStatsRow struct {
+	Channel   string
+	Count     int64
+	TotalSize int64
+}
+
+func (q *Queries) GetChannelStats(ctx context.Context, limit int32) ([]GetChannelStatsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getChannelStats, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetChannelStatsRow
+	for rows.Next() {
+		var i GetChannelStatsRow
+		if err := rows.Scan(
+			&i.Channel,
+			&i.Count,
+			&i.TotalSize,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getCompressionStats = `-- name: GetCompressionStats :one
+SELECT COUNT(*) AS count,
+  COALESCE(SUM(size), 0)::bigint AS output_size,
+  COALESCE(SUM((manifest->>'input_size')::bigint), 0)::bigint AS input_size,
+  COALESCE(AVG(size::float8 / (manifest->>'input_size')::float8), 0)::float8 AS avg_ratio
+FROM videos
+WHERE COALESCE((manifest->>'input_size')::bigint, 0) > 0
+`
+
+type GetCompressionStatsRow struct {
+	Count      int64
+	OutputSize int64
+	InputSize  int64
+	AvgRatio   float64
+}
+
+func (q *Queries) GetCompressionStats(ctx context.Context) (GetCompressionStatsRow, error) {
+	row := q.db.QueryRowContext(ctx, getCompressionStats)
+	var i GetCompressionStatsRow
+	err := row.Scan(
+		&i.Count,
+		&i.OutputSize,
+		&i.InputSize,
+		&i.AvgRatio,
+	)
+	return i, err
+}
+
+const getExpiredArchivedVideos = `-- name: GetExpiredArchivedVideos :many
+SELECT id, archived_at, reason, archive_storage, expires_at, created_at, updated_at, accessed_at, access_count, tid, url, sd_hash, channel, storage, path, size, checksum, manifest, ladder, verified_at, checksum_valid FROM archived_videos
+WHERE expires_at < NOW()
+ORDER BY expires_at ASC
+LIMIT $1
+`
+
+func (q *Queries) GetExpiredArchivedVideos(ctx context.Context, limit int32) ([]ArchivedVideo, error) {
+	rows, err := q.db.QueryContext(ctx, getExpiredArchivedVideos, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ArchivedVideo
+	for rows.Next() {
+		var i ArchivedVideo
+		if err := rows.Scan(
+			&i.ID,
+			&i.ArchivedAt,
+			&i.Reason,
+			&i.ArchiveStorage,
+			&i.ExpiresAt,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.AccessedAt,
+			&i.AccessCount,
+			&i.TID,
+			&i.URL,
+			&i.SDHash,
+			&i.Channel,
+			&i.Storage,
+			&i.Path,
+			&i.Size,
+			&i.Checksum,
+			&i.Manifest,
+			&i.Ladder,
+			&i.VerifiedAt,
+			&i.ChecksumValid,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getLatestStreamKey = `-- name: GetLatestStreamKey :one
+SELECT id, created_at, kid, sd_hash, method, key FROM stream_keys
+WHERE sd_hash = $1 AND method = $2
+ORDER BY created_at DESC, id DESC
+LIMIT 1
+`
+
+type GetLatestStreamKeyParams struct {
+	SDHash string
+	Method string
+}
+
+func (q *Queries) GetLatestStreamKey(ctx context.Context, arg GetLatestStreamKeyParams) (StreamKey, error) {
+	row := q.db.QueryRowContext(ctx, getLatestStreamKey, arg.SDHash, arg.Method)
+	var i StreamKey
 	err := row.Scan(
 		&i.ID,
 		&i.CreatedAt,
-		&i.UpdatedAt,
-		&i.AccessedAt,
-		&i.AccessCount,
-		&i.TID,
-		&i.URL,
+		&i.KID,
 		&i.SDHash,
-		&i.Channel,
-		&i.Storage,
-		&i.Path,
-		&i.Size,
-		&i.Checksum,
-		&i.Manifest,
+		&i.Method,
+		&i.Key,
 	)
 	return i, err
 }
 
-const recordVideoAccess = `-- name: RecordVideoAccess :exec
-UPDATE videos
-SET accessed_at = NOW(), access_count = access_count + 1
-WHERE sd_hash = $1
+const getStorageStats = `-- name: GetStorageStats :many
+SELECT video_locations.storage, COUNT(*) AS count, COALESCE(SUM(videos.size), 0)::bigint AS total_size
+FROM video_locations
+JOIN videos ON videos.tid = video_locations.tid
+GROUP BY video_locations.storage
+ORDER BY video_locations.storage
 `
 
-func (q *Queries) RecordVideoAccess(ctx context.Context, sdHash string) error {
-	_, err := q.db.ExecContext(ctx, recordVideoAccess, sdHash)
+type GetStorageStatsRow struct {
+	Storage   string
+	Count     int64
+	TotalSize int64
+}
+
+func (q *Queries) GetStorageStats(ctx context.Context) ([]GetStorageStatsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getStorageStats)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetStorageStatsRow
+	for rows.Next() {
+		var i GetStorageStatsRow
+		if err := rows.Scan(
+			&i.Storage,
+			&i.Count,
+			&i.TotalSize,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getStreamKey = `-- name: GetStreamKey :one
+SELECT id, created_at, kid, sd_hash, method, key FROM stream_keys
+WHERE kid = $1
+`
+
+func (q *Queries) GetStreamKey(ctx context.Context, kid string) (StreamKey, error) {
+	row := q.db.QueryRowContext(ctx, getStreamKey, kid)
+	var i StreamKey
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.KID,
+		&i.SDHash,
+		&i.Method,
+		&i.Key,
+	)
+	return i, err
+}
+
+const getSupersededVideos = `-- name: GetSupersededVideos :many
+SELECT id, created_at, updated_at, accessed_at, access_count, tid, url, sd_hash, channel, storage, path, size, checksum, manifest, ladder, verified_at, checksum_valid, active, superseded_at FROM videos
+WHERE NOT active AND superseded_at < $1
+ORDER BY superseded_at ASC
+LIMIT $2
+`
+
+type GetSupersededVideosParams struct {
+	SupersededAt sql.NullTime
+	Limit        int32
+}
+
+func (q *Queries) GetSupersededVideos(ctx context.Context, arg GetSupersededVideosParams) ([]Video, error) {
+	rows, err := q.db.QueryContext(ctx, getSupersededVideos, arg.SupersededAt, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Video
+	for rows.Next() {
+		var i Video
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.AccessedAt,
+			&i.AccessCount,
+			&i.TID,
+			&i.URL,
+			&i.SDHash,
+			&i.Channel,
+			&i.Storage,
+			&i.Path,
+			&i.Size,
+			&i.Checksum,
+			&i.Manifest,
+			&i.Ladder,
+			&i.VerifiedAt,
+			&i.ChecksumValid,
+			&i.Active,
+			&i.SupersededAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTierStats = `-- name: GetTierStats :many
+SELECT COALESCE(
+    NULLIF(manifest->'Ladder'->'Tiers'->0->>'Definition', ''),
+    (manifest->'Ladder'->'Tiers'->0->>'Height') || 'p',
+    'unknown'
+  )::text AS tier, COUNT(*) AS count, COALESCE(SUM(size), 0)::bigint AS total_size
+FROM videos
+GROUP BY tier
+ORDER BY count DESC
+`
+
+type GetTierStatsRow struct {
+	Tier      string
+	Count     int64
+	TotalSize int64
+}
+
+func (q *Queries) GetTierStats(ctx context.Context) ([]GetTierStatsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getTierStats)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetTierStatsRow
+	for rows.Next() {
+		var i GetTierStatsRow
+		if err := rows.Scan(
+			&i.Tier,
+			&i.Count,
+			&i.TotalSize,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTopAccessedVideos = `-- name: GetTopAccessedVideos :many
+SELECT tid, url, sd_hash, channel, size, access_count, accessed_at
+FROM videos
+WHERE active
+ORDER BY access_count DESC NULLS LAST, accessed_at DESC
+LIMIT $1
+`
+
+type GetTopAccessedVideosRow struct {
+	TID         string
+	URL         string
+	SDHash      string
+	Channel     string
+	Size        int64
+	AccessCount sql.NullInt32
+	AccessedAt  time.Time
+}
+
+func (q *Queries) GetTopAccessedVideos(ctx context.Context, limit int32) ([]GetTopAccessedVideosRow, error) {
+	rows, err := q.db.QueryContext(ctx, getTopAccessedVideos, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetTopAccessedVideosRow
+	for rows.Next() {
+		var i GetTopAccessedVideosRow
+		if err := rows.Scan(
+			&i.TID,
+			&i.URL,
+			&i.SDHash,
+			&i.Channel,
+			&i.Size,
+			&i.AccessCount,
+			&i.AccessedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getVideo = `-- name: GetVideo :one
+SELECT id, created_at, updated_at, accessed_at, access_count, tid, url, sd_hash, channel, storage, path, size, checksum, manifest, ladder, verified_at, checksum_valid, active, superseded_at FROM videos
+WHERE sd_hash = $1 AND active LIMIT 1
+`
+
+func (q *Queries) GetVideo(ctx context.Context, sdHash string) (Video, error) {
+	row := q.db.QueryRowContext(ctx, getVideo, sdHash)
+	var i Video
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.AccessedAt,
+		&i.AccessCount,
+		&i.TID,
+		&i.URL,
+		&i.SDHash,
+		&i.Channel,
+		&i.Storage,
+		&i.Path,
+		&i.Size,
+		&i.Checksum,
+		&i.Manifest,
+		&i.Ladder,
+		&i.VerifiedAt,
+		&i.ChecksumValid,
+		&i.Active,
+		&i.SupersededAt,
+	)
+	return i, err
+}
+
+const getVideoByTID = `-- name: GetVideoByTID :one
+SELECT id, created_at, updated_at, accessed_at, access_count, tid, url, sd_hash, channel, storage, path, size, checksum, manifest, ladder, verified_at, checksum_valid, active, superseded_at FROM videos
+WHERE tid = $1 LIMIT 1
+`
+
+func (q *Queries) GetVideoByTID(ctx context.Context, tid string) (Video, error) {
+	row := q.db.QueryRowContext(ctx, getVideoByTID, tid)
+	var i Video
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.AccessedAt,
+		&i.AccessCount,
+		&i.TID,
+		&i.URL,
+		&i.SDHash,
+		&i.Channel,
+		&i.Storage,
+		&i.Path,
+		&i.Size,
+		&i.Checksum,
+		&i.Manifest,
+		&i.Ladder,
+		&i.VerifiedAt,
+		&i.ChecksumValid,
+		&i.Active,
+		&i.SupersededAt,
+	)
+	return i, err
+}
+
+const getVideoLocations = `-- name: GetVideoLocations :many
+SELECT id, created_at, tid, storage, path FROM video_locations
+WHERE tid = $1
+ORDER BY id ASC
+`
+
+func (q *Queries) GetVideoLocations(ctx context.Context, tid string) ([]VideoLocation, error) {
+	rows, err := q.db.QueryContext(ctx, getVideoLocations, tid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []VideoLocation
+	for rows.Next() {
+		var i VideoLocation
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.TID,
+			&i.Storage,
+			&i.Path,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getVideosForManifestMigration = `-- name: GetVideosForManifestMigration :many
+SELECT id, created_at, updated_at, accessed_at, access_count, tid, url, sd_hash, channel, storage, path, size, checksum, manifest, ladder, verified_at, checksum_valid, active, superseded_at FROM videos
+WHERE manifest IS NOT NULL
+  AND COALESCE((manifest->>'schema')::int, 1) < $1
+  AND id > $2
+ORDER BY id
+LIMIT $3
+`
+
+type GetVideosForManifestMigrationParams struct {
+	Schema  int32
+	AfterID int32
+	Limit   int32
+}
+
+func (q *Queries) GetVideosForManifestMigration(ctx context.Context, arg GetVideosForManifestMigrationParams) ([]Video, error) {
+	rows, err := q.db.QueryContext(ctx, getVideosForManifestMigration, arg.Schema, arg.AfterID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Video
+	for rows.Next() {
+		var i Video
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.AccessedAt,
+			&i.AccessCount,
+			&i.TID,
+			&i.URL,
+			&i.SDHash,
+			&i.Channel,
+			&i.Storage,
+			&i.Path,
+			&i.Size,
+			&i.Checksum,
+			&i.Manifest,
+			&i.Ladder,
+			&i.VerifiedAt,
+			&i.ChecksumValid,
+			&i.Active,
+			&i.SupersededAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getVideosForVerification = `-- name: GetVideosForVerification :many
+SELECT id, created_at, updated_at, accessed_at, access_count, tid, url, sd_hash, channel, storage, path, size, checksum, manifest, ladder, verified_at, checksum_valid, active, superseded_at FROM videos
+WHERE checksum IS NOT NULL AND (verified_at IS NULL OR verified_at < $1)
+ORDER BY verified_at ASC NULLS FIRST
+LIMIT $2
+`
+
+type GetVideosForVerificationParams struct {
+	VerifiedAt sql.NullTime
+	Limit      int32
+}
+
+func (q *Queries) GetVideosForVerification(ctx context.Context, arg GetVideosForVerificationParams) ([]Video, error) {
+	rows, err := q.db.QueryContext(ctx, getVideosForVerification, arg.VerifiedAt, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Video
+	for rows.Next() {
+		var i Video
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.AccessedAt,
+			&i.AccessCount,
+			&i.TID,
+			&i.URL,
+			&i.SDHash,
+			&i.Channel,
+			&i.Storage,
+			&i.Path,
+			&i.Size,
+			&i.Checksum,
+			&i.Manifest,
+			&i.Ladder,
+			&i.VerifiedAt,
+			&i.ChecksumValid,
+			&i.Active,
+			&i.SupersededAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getVideosMissingFromStorage = `-- name: GetVideosMissingFromStorage :many
+SELECT id, created_at, updated_at, accessed_at, access_count, tid, url, sd_hash, channel, storage, path, size, checksum, manifest, ladder, verified_at, checksum_valid, active, superseded_at FROM videos
+WHERE active AND NOT EXISTS (
+  SELECT 1 FROM video_locations
+  WHERE video_locations.tid = videos.tid AND video_locations.storage = $1
+)
+ORDER BY accessed_at DESC
+LIMIT $2
+`
+
+type GetVideosMissingFromStorageParams struct {
+	Storage string
+	Limit   int32
+}
+
+func (q *Queries) GetVideosMissingFromStorage(ctx context.Context, arg GetVideosMissingFromStorageParams) ([]Video, error) {
+	rows, err := q.db.QueryContext(ctx, getVideosMissingFromStorage, arg.Storage, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Video
+	for rows.Next() {
+		var i Video
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.AccessedAt,
+			&i.AccessCount,
+			&i.TID,
+			&i.URL,
+			&i.SDHash,
+			&i.Channel,
+			&i.Storage,
+			&i.Path,
+			&i.Size,
+			&i.Checksum,
+			&i.Manifest,
+			&i.Ladder,
+			&i.VerifiedAt,
+			&i.ChecksumValid,
+			&i.Active,
+			&i.SupersededAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getVideoVersions = `-- name: GetVideoVersions :many
+SELECT id, created_at, updated_at, accessed_at, access_count, tid, url, sd_hash, channel, storage, path, size, checksum, manifest, ladder, verified_at, checksum_valid, active, superseded_at FROM videos
+WHERE sd_hash = $1
+ORDER BY created_at DESC
+`
+
+func (q *Queries) GetVideoVersions(ctx context.Context, sdHash string) ([]Video, error) {
+	rows, err := q.db.QueryContext(ctx, getVideoVersions, sdHash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Video
+	for rows.Next() {
+		var i Video
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.AccessedAt,
+			&i.AccessCount,
+			&i.TID,
+			&i.URL,
+			&i.SDHash,
+			&i.Channel,
+			&i.Storage,
+			&i.Path,
+			&i.Size,
+			&i.Checksum,
+			&i.Manifest,
+			&i.Ladder,
+			&i.VerifiedAt,
+			&i.ChecksumValid,
+			&i.Active,
+			&i.SupersededAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listVideos = `-- name: ListVideos :many
+SELECT videos.id, videos.created_at, videos.updated_at, videos.accessed_at, videos.access_count, videos.tid, videos.url, videos.sd_hash, videos.channel, videos.storage, videos.path, videos.size, videos.checksum, videos.manifest, videos.ladder, videos.verified_at, videos.checksum_valid, videos.active, videos.superseded_at FROM videos
+WHERE
+  ($1::text = '' OR videos.channel = $1)
+  AND ($2::text = '' OR EXISTS (
+    SELECT 1 FROM video_locations
+    WHERE video_locations.tid = videos.tid AND video_locations.storage = $2
+  ))
+  AND ($3::timestamp IS NULL OR videos.created_at >= $3)
+  AND ($4::timestamp IS NULL OR videos.created_at < $4)
+  AND ($5::timestamp IS NULL OR videos.accessed_at >= $5)
+  AND ($6::timestamp IS NULL OR videos.accessed_at < $6)
+  AND ($7::bigint = 0 OR videos.size >= $7)
+  AND ($8::bigint = 0 OR videos.size <= $8)
+  AND ($9::text = '' OR videos.ladder = $9)
+  AND ($10::text = '' OR videos.manifest->>'transcoded_by' = $10)
+ORDER BY videos.created_at DESC, videos.id DESC
+LIMIT $12 OFFSET $11
+`
+
+type ListVideosParams struct {
+	Channel        string
+	Storage        string
+	CreatedAfter   sql.NullTime
+	CreatedBefore  sql.NullTime
+	AccessedAfter  sql.NullTime
+	AccessedBefore sql.NullTime
+	MinSize        int64
+	MaxSize        int64
+	Ladder         string
+	Worker         string
+	Offset         int32
+	Limit          int32
+}
+
+func (q *Queries) ListVideos(ctx context.Context, arg ListVideosParams) ([]Video, error) {
+	rows, err := q.db.QueryContext(ctx, listVideos,
+		arg.Channel,
+		arg.Storage,
+		arg.CreatedAfter,
+		arg.CreatedBefore,
+		arg.AccessedAfter,
+		arg.AccessedBefore,
+		arg.MinSize,
+		arg.MaxSize,
+		arg.Ladder,
+		arg.Worker,
+		arg.Offset,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Video
+	for rows.Next() {
+		var i Video
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.AccessedAt,
+			&i.AccessCount,
+			&i.TID,
+			&i.URL,
+			&i.SDHash,
+			&i.Channel,
+			&i.Storage,
+			&i.Path,
+			&i.Size,
+			&i.Checksum,
+			&i.Manifest,
+			&i.Ladder,
+			&i.VerifiedAt,
+			&i.ChecksumValid,
+			&i.Active,
+			&i.SupersededAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const recordVideoAccess = `-- name: RecordVideoAccess :exec
+UPDATE videos
+SET accessed_at = NOW(), access_count = access_count + 1
+WHERE sd_hash = $1 AND active
+`
+
+func (q *Queries) RecordVideoAccess(ctx context.Context, sdHash string) error {
+	_, err := q.db.ExecContext(ctx, recordVideoAccess, sdHash)
+	return err
+}
+
+const recordVideoAccesses = `-- name: RecordVideoAccesses :exec
+UPDATE videos
+SET
+  accessed_at = NOW(),
+  access_count = COALESCE(videos.access_count, 0) + accesses.count
+FROM unnest($1::text[], $2::integer[]) AS accesses (sd_hash, count)
+WHERE videos.sd_hash = accesses.sd_hash AND videos.active
+`
+
+type RecordVideoAccessesParams struct {
+	Hashes []string
+	Counts []int32
+}
+
+func (q *Queries) RecordVideoAccesses(ctx context.Context, arg RecordVideoAccessesParams) error {
+	_, err := q.db.ExecContext(ctx, recordVideoAccesses, pq.Array(arg.Hashes), pq.Array(arg.Counts))
+	return err
+}
+
+const recordVideoVerification = `-- name: RecordVideoVerification :exec
+UPDATE videos
+SET verified_at = NOW(), checksum_valid = $2
+WHERE tid = $1
+`
+
+type RecordVideoVerificationParams struct {
+	TID           string
+	ChecksumValid sql.NullBool
+}
+
+func (q *Queries) RecordVideoVerification(ctx context.Context, arg RecordVideoVerificationParams) error {
+	_, err := q.db.ExecContext(ctx, recordVideoVerification, arg.TID, arg.ChecksumValid)
+	return err
+}
+
+const restoreVideo = `-- name: RestoreVideo :one
+INSERT INTO videos (
+  created_at, updated_at, accessed_at, access_count, tid, url, sd_hash, channel,
+  storage, path, size, checksum, manifest, ladder, verified_at, checksum_valid,
+  active, superseded_at
+)
+SELECT
+  created_at, updated_at, accessed_at, access_count, tid, url, sd_hash, channel,
+  $1::text, path, size, checksum, manifest, ladder, verified_at, checksum_valid,
+  NOT active_version.found, CASE WHEN active_version.found THEN NOW() END
+FROM archived_videos,
+  LATERAL (SELECT EXISTS (
+    SELECT 1 FROM videos WHERE videos.sd_hash = archived_videos.sd_hash AND videos.active
+  ) AS found) AS active_version
+WHERE archived_videos.tid = $2
+RETURNING id, created_at, updated_at, accessed_at, access_count, tid, url, sd_hash, channel, storage, path, size, checksum, manifest, ladder, verified_at, checksum_valid, active, superseded_at
+`
+
+type RestoreVideoParams struct {
+	Storage string
+	TID     string
+}
+
+func (q *Queries) RestoreVideo(ctx context.Context, arg RestoreVideoParams) (Video, error) {
+	row := q.db.QueryRowContext(ctx, restoreVideo, arg.Storage, arg.TID)
+	var i Video
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.AccessedAt,
+		&i.AccessCount,
+		&i.TID,
+		&i.URL,
+		&i.SDHash,
+		&i.Channel,
+		&i.Storage,
+		&i.Path,
+		&i.Size,
+		&i.Checksum,
+		&i.Manifest,
+		&i.Ladder,
+		&i.VerifiedAt,
+		&i.ChecksumValid,
+		&i.Active,
+		&i.SupersededAt,
+	)
+	return i, err
+}
+
+const setChannelEncryption = `-- name: SetChannelEncryption :one
+UPDATE channels SET encryption = $2
+WHERE claim_id = $1
+RETURNING id, created_at, url, claim_id, priority, encryption
+`
+
+type SetChannelEncryptionParams struct {
+	ClaimID    string
+	Encryption string
+}
+
+func (q *Queries) SetChannelEncryption(ctx context.Context, arg SetChannelEncryptionParams) (Channel, error) {
+	row := q.db.QueryRowContext(ctx, setChannelEncryption, arg.ClaimID, arg.Encryption)
+	var i Channel
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.URL,
+		&i.ClaimID,
+		&i.Priority,
+		&i.Encryption,
+	)
+	return i, err
+}
+
+const supersedeVideos = `-- name: SupersedeVideos :exec
+UPDATE videos
+SET active = false, superseded_at = NOW()
+WHERE sd_hash = $1 AND active
+`
+
+func (q *Queries) SupersedeVideos(ctx context.Context, sdHash string) error {
+	_, err := q.db.ExecContext(ctx, supersedeVideos, sdHash)
+	return err
+}
+
+const updateVideoManifest = `-- name: UpdateVideoManifest :exec
+UPDATE videos
+SET manifest = $2, updated_at = NOW()
+WHERE tid = $1
+`
+
+type UpdateVideoManifestParams struct {
+	TID      string
+	Manifest pqtype.NullRawMessage
+}
+
+func (q *Queries) UpdateVideoManifest(ctx context.Context, arg UpdateVideoManifestParams) error {
+	_, err := q.db.ExecContext(ctx, updateVideoManifest, arg.TID, arg.Manifest)
 	return err
 }
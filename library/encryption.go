@@ -0,0 +1,184 @@
+package library
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/lbryio/transcoder/library/db"
+
+	"github.com/pkg/errors"
+)
+
+// EncryptionAES128 makes HLS segments encrypted in full with AES-128 (#EXT-X-KEY METHOD=AES-128).
+// SAMPLE-AES is not offered as ffmpeg HLS muxer cannot produce it.
+const EncryptionAES128 = "aes-128"
+
+// StreamKeySize is the length of AES-128 keys in bytes.
+const StreamKeySize = 16
+
+var (
+	ErrKeyNotFound     = errors.New("stream key not found")
+	ErrKeyAccessDenied = errors.New("stream key access denied")
+	// ErrPublicStorage is returned when encryption is requested but stream files, playlists included,
+	// can be fetched by anyone from one of the storages.
+	ErrPublicStorage = errors.New("encrypted streams require all storages to serve streams to signed requests only")
+)
+
+// PrivateStorage is implemented by storages which can serve stream files to signed requests only.
+type PrivateStorage interface {
+	Storage
+	Private() bool
+}
+
+// Encryption describes how segments of an encrypted stream are protected.
+// Keys themselves are kept in the library and handed to workers in task payloads, which are not retained once done.
+type Encryption struct {
+	Method string
+	KeyID  string `yaml:"key_id" json:"key_id"`
+}
+
+// ParseEncryptionMethod checks channel encryption setting, returning an empty method for unencrypted output.
+func ParseEncryptionMethod(m string) (string, error) {
+	switch m {
+	case "", "none":
+		return "", nil
+	case EncryptionAES128:
+		return m, nil
+	default:
+		return "", errors.Errorf("unsupported encryption method: %s", m)
+	}
+}
+
+// EncryptionSupported checks that keys of encrypted streams can be protected, which is done by handing them
+// only to requests carrying a signed video URL. Returns ErrPublicStorage if streams are not served privately.
+func (lib *Library) EncryptionSupported() error {
+	if lib.signer == nil {
+		return errors.Wrap(ErrPublicStorage, "video url signing is not configured")
+	}
+	if lib.storage == nil {
+		return errors.Wrap(ErrPublicStorage, "storage is not configured")
+	}
+	storages := []Storage{lib.storage}
+	for _, r := range lib.replicas {
+		storages = append(storages, r.Storage)
+	}
+	for _, s := range storages {
+		if ps, ok := s.(PrivateStorage); !ok || !ps.Private() {
+			return errors.Wrapf(ErrPublicStorage, "storage %s is public", s.Name())
+		}
+	}
+	return nil
+}
+
+// SetChannelEncryption changes the encryption method for streams of the channel transcoded from now on.
+func (lib *Library) SetChannelEncryption(claimID, method string) (db.Channel, error) {
+	method, err := ParseEncryptionMethod(method)
+	if err != nil {
+		return db.Channel{}, err
+	}
+	if method != "" {
+		if err := lib.EncryptionSupported(); err != nil {
+			return db.Channel{}, err
+		}
+	}
+	c, err := lib.db.SetChannelEncryption(context.Background(), db.SetChannelEncryptionParams{
+		ClaimID:    claimID,
+		Encryption: method,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return c, ErrChannelNotFound
+	}
+	return c, err
+}
+
+// UpdateChannelEncryption is SetChannelEncryption for a channel already added to the library by its URL or claim ID.
+func (lib *Library) UpdateChannelEncryption(uri, method string) (db.Channel, error) {
+	claim, err := resolveChannel(uri)
+	if err != nil {
+		return db.Channel{}, err
+	}
+	return lib.SetChannelEncryption(claim.ClaimID, method)
+}
+
+// ChannelEncryption returns the encryption method configured for the channel, empty if streams are not encrypted.
+func (lib *Library) ChannelEncryption(claimID string) (string, error) {
+	c, err := lib.db.GetChannel(context.Background(), claimID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return c.Encryption, nil
+}
+
+// StreamKey returns a key for encrypting a new version of the stream. A key created for the same stream
+// less than reuseWithin ago is returned instead of a new one, so repeated requests to transcode it get the same key.
+func (lib *Library) StreamKey(sdHash, method string, reuseWithin time.Duration) (db.StreamKey, error) {
+	ctx := context.Background()
+	k, err := lib.db.GetLatestStreamKey(ctx, db.GetLatestStreamKeyParams{SDHash: sdHash, Method: method})
+	if err == nil && time.Since(k.CreatedAt) < reuseWithin {
+		return k, nil
+	} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return k, err
+	}
+
+	key := make([]byte, StreamKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return k, err
+	}
+	kid := make([]byte, 16)
+	if _, err := rand.Read(kid); err != nil {
+		return k, err
+	}
+	return lib.db.AddStreamKey(ctx, db.AddStreamKeyParams{
+		KID:    hex.EncodeToString(kid),
+		SDHash: sdHash,
+		Method: method,
+		Key:    key,
+	})
+}
+
+// GetStreamKey returns the key by its ID, as referenced by #EXT-X-KEY URIs in stream playlists.
+func (lib *Library) GetStreamKey(kid string) (db.StreamKey, error) {
+	k, err := lib.db.GetStreamKey(context.Background(), kid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return k, ErrKeyNotFound
+	}
+	return k, err
+}
+
+// AuthorizeStreamKey returns the key kid if query params q are those of a signed video URL
+// for one of the versions of the stream encrypted with it, as returned by GetVideoURL.
+// Players are expected to pass them along with key requests the same way as with stream file requests.
+func (lib *Library) AuthorizeStreamKey(kid string, q url.Values) (db.StreamKey, error) {
+	ctx := context.Background()
+	if lib.signer == nil {
+		return db.StreamKey{}, ErrKeyAccessDenied
+	}
+	k, err := lib.GetStreamKey(kid)
+	if err != nil {
+		return db.StreamKey{}, err
+	}
+	versions, err := lib.db.GetVideoVersions(ctx, k.SDHash)
+	if err != nil {
+		return db.StreamKey{}, err
+	}
+	for _, v := range versions {
+		locs, err := lib.db.GetVideoLocations(ctx, v.TID)
+		if err != nil {
+			return db.StreamKey{}, err
+		}
+		for _, l := range locs {
+			// Signatures cover the stream directory, any file name in it will do.
+			if lib.signer.Verify(path.Join(l.Path, "key"), q) == nil {
+				return k, nil
+			}
+		}
+	}
+	return db.StreamKey{}, ErrKeyAccessDenied
+}
@@ -0,0 +1,115 @@
+package library
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/lbryio/transcoder/library/db"
+	"github.com/lbryio/transcoder/pkg/logging/zapadapter"
+	"github.com/lbryio/transcoder/pkg/urlsign"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEncryptionMethod(t *testing.T) {
+	for _, m := range []string{"", "none"} {
+		parsed, err := ParseEncryptionMethod(m)
+		assert.NoError(t, err)
+		assert.Empty(t, parsed)
+	}
+	parsed, err := ParseEncryptionMethod(EncryptionAES128)
+	assert.NoError(t, err)
+	assert.Equal(t, EncryptionAES128, parsed)
+	_, err = ParseEncryptionMethod("sample-aes")
+	assert.Error(t, err)
+}
+
+// privateDummyStorage serves streams to signed requests only.
+type privateDummyStorage struct {
+	*DummyStorage
+}
+
+func (s privateDummyStorage) Private() bool {
+	return true
+}
+
+func (s *librarySuite) TestStreamEncryption() {
+	signer, err := urlsign.New(time.Hour, urlsign.Key{ID: "k1", Secret: "secret1"})
+	s.Require().NoError(err)
+	lib := New(Config{
+		DB: s.DB, Storage: privateDummyStorage{NewDummyStorage("storage1", "")}, URLSigner: signer, Log: zapadapter.NewKV(nil),
+	})
+	_, err = lib.db.AddChannel(context.Background(), db.AddChannelParams{
+		URL: "lbry://@protected#1", ClaimID: "protected1", Priority: db.ChannelPriorityNormal,
+	})
+	s.Require().NoError(err)
+
+	method, err := lib.ChannelEncryption("protected1")
+	s.Require().NoError(err)
+	s.Empty(method)
+	public := New(Config{DB: s.DB, Storage: NewDummyStorage("storage1", ""), URLSigner: signer, Log: zapadapter.NewKV(nil)})
+	_, err = public.SetChannelEncryption("protected1", EncryptionAES128)
+	s.ErrorIs(err, ErrPublicStorage)
+	unsigned := New(Config{DB: s.DB, Storage: privateDummyStorage{NewDummyStorage("storage1", "")}, Log: zapadapter.NewKV(nil)})
+	_, err = unsigned.SetChannelEncryption("protected1", EncryptionAES128)
+	s.ErrorIs(err, ErrPublicStorage)
+	c, err := lib.SetChannelEncryption("protected1", EncryptionAES128)
+	s.Require().NoError(err)
+	s.Equal(EncryptionAES128, c.Encryption)
+	method, err = lib.ChannelEncryption("protected1")
+	s.Require().NoError(err)
+	s.Equal(EncryptionAES128, method)
+	_, err = lib.SetChannelEncryption("protected1", "sample-aes")
+	s.Error(err)
+	_, err = lib.SetChannelEncryption("unknown", EncryptionAES128)
+	s.Error(err)
+
+	k, err := lib.StreamKey("sdhash1", EncryptionAES128, time.Hour)
+	s.Require().NoError(err)
+	s.Len(k.Key, StreamKeySize)
+	s.NotEmpty(k.KID)
+	reused, err := lib.StreamKey("sdhash1", EncryptionAES128, time.Hour)
+	s.Require().NoError(err)
+	s.Equal(k.KID, reused.KID)
+	fresh, err := lib.StreamKey("sdhash1", EncryptionAES128, 0)
+	s.Require().NoError(err)
+	s.NotEqual(k.KID, fresh.KID)
+	s.NotEqual(k.Key, fresh.Key)
+
+	stored, err := lib.GetStreamKey(k.KID)
+	s.Require().NoError(err)
+	s.Equal(k.Key, stored.Key)
+	_, err = lib.GetStreamKey("unknown")
+	s.ErrorIs(err, ErrKeyNotFound)
+}
+
+func (s *librarySuite) TestAuthorizeStreamKey() {
+	signer, err := urlsign.New(time.Hour, urlsign.Key{ID: "k1", Secret: "secret1"})
+	s.Require().NoError(err)
+	lib := New(Config{
+		DB: s.DB, Storage: privateDummyStorage{NewDummyStorage("storage1", "")}, URLSigner: signer, Log: zapadapter.NewKV(nil),
+	})
+	stream := GenerateDummyStream()
+	s.Require().NoError(lib.AddRemoteStream(*stream))
+	k, err := lib.StreamKey(stream.SDHash(), EncryptionAES128, 0)
+	s.Require().NoError(err)
+
+	videoURL, err := lib.GetVideoURL(stream.SDHash())
+	s.Require().NoError(err)
+	u, err := url.Parse(videoURL)
+	s.Require().NoError(err)
+	authorized, err := lib.AuthorizeStreamKey(k.KID, u.Query())
+	s.Require().NoError(err)
+	s.Equal(k.Key, authorized.Key)
+
+	_, err = lib.AuthorizeStreamKey(k.KID, url.Values{})
+	s.ErrorIs(err, ErrKeyAccessDenied)
+	_, err = lib.AuthorizeStreamKey(k.KID, signer.Sign("othertid"))
+	s.ErrorIs(err, ErrKeyAccessDenied)
+	_, err = lib.AuthorizeStreamKey(k.KID, signer.SignAt(stream.TID(), time.Now().Add(-time.Second)))
+	s.ErrorIs(err, ErrKeyAccessDenied)
+	_, err = lib.AuthorizeStreamKey("unknown", u.Query())
+	s.ErrorIs(err, ErrKeyNotFound)
+}
@@ -0,0 +1,247 @@
+package library
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/lbryio/transcoder/library/db"
+	"github.com/lbryio/transcoder/pkg/resolve"
+
+	"github.com/panjf2000/ants/v2"
+	"github.com/pkg/errors"
+)
+
+type ImportOptions struct {
+	// Workers is a number of streams imported in parallel.
+	Workers int
+	// Prefix limits import to stream folders with names starting with it.
+	Prefix string
+	// Generate is called for local stream folders without a manifest and should call Stream.GenerateManifest.
+	// Such folders are skipped if it is not set.
+	Generate func(*Stream) error
+	// Upload makes local streams uploaded to the storage before being added to the library.
+	Upload bool
+}
+
+type ImportReport struct {
+	Imported []string
+	// Skipped are streams already present in the library.
+	Skipped []string
+	// Failed maps stream folder names to import errors.
+	Failed map[string]error
+}
+
+// ImportStream adds a stream to the library unless it is already there. If the stream is known under the same TID,
+// only its location is recorded. Streams of videos already in the library are added as superseded versions.
+// Returns false if the stream is already in the library at that location.
+func (lib *Library) ImportStream(stream Stream) (bool, error) {
+	if stream.Manifest == nil {
+		return false, errors.New("cannot import stream, manifest is missing")
+	}
+	ctx := context.Background()
+	tid := stream.Manifest.TID
+	_, err := lib.db.GetVideoByTID(ctx, tid)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Imported streams do not replace versions already being served.
+		_, err := lib.db.GetVideo(ctx, stream.Manifest.SDHash)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return false, err
+		}
+		return true, lib.addStream(stream, err != nil)
+	} else if err != nil {
+		return false, err
+	}
+	locs, err := lib.db.GetVideoLocations(ctx, tid)
+	if err != nil {
+		return false, err
+	}
+	for _, l := range locs {
+		if l.Storage == stream.RemoteStorage {
+			return false, nil
+		}
+	}
+	return true, lib.db.AddVideoLocation(ctx, db.AddVideoLocationParams{
+		TID:     tid,
+		Storage: stream.RemoteStorage,
+		Path:    tid,
+	})
+}
+
+// ImportStored adds streams found in the storage to the library using manifests stored along with them.
+func (lib *Library) ImportStored(storageName string, opts ImportOptions) (*ImportReport, error) {
+	s, ok := lib.getStorage(storageName).(InventoryStorage)
+	if !ok {
+		return nil, errors.Errorf("storage %s is not configured or cannot be listed", storageName)
+	}
+	stored, err := s.ListStreams()
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot list storage")
+	}
+	names := []string{}
+	for _, ss := range stored {
+		if tidRe.MatchString(ss.TID) && strings.HasPrefix(ss.TID, opts.Prefix) {
+			names = append(names, ss.TID)
+		}
+	}
+	return lib.importAll(names, opts.Workers, func(tid string) (bool, error) {
+		m, err := readStoredManifest(s, tid)
+		if err != nil {
+			return false, err
+		}
+		if m.TID != tid {
+			return false, errors.Errorf("manifest TID %s does not match the stream", m.TID)
+		}
+		return lib.ImportStream(Stream{RemoteStorage: storageName, Manifest: m})
+	})
+}
+
+// ImportDir adds stream folders found in dir to the library. Folders are expected to be named by TID
+// as they are in the storage, unless Upload option is set.
+func (lib *Library) ImportDir(dir, storageName string, opts ImportOptions) (*ImportReport, error) {
+	s := lib.getStorage(storageName)
+	if s == nil {
+		return nil, errors.Errorf("storage %s is not configured", storageName)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, e := range entries {
+		if e.IsDir() && !strings.HasPrefix(e.Name(), ".") && strings.HasPrefix(e.Name(), opts.Prefix) {
+			names = append(names, e.Name())
+		}
+	}
+	return lib.importAll(names, opts.Workers, func(name string) (bool, error) {
+		stream := InitStream(path.Join(dir, name), storageName)
+		var generated bool
+		if err := stream.ReadManifest(); err != nil {
+			if !errors.Is(err, os.ErrNotExist) || opts.Generate == nil {
+				return false, err
+			}
+			if err := opts.Generate(stream); err != nil {
+				return false, errors.Wrap(err, "cannot generate manifest")
+			}
+			generated = true
+		}
+		if !opts.Upload && name != stream.TID() {
+			if !generated {
+				return false, errors.Errorf("folder name does not match stream TID %s", stream.TID())
+			}
+			// Streams imported in place are served from their folder, so it becomes the TID of a new manifest.
+			stream.Manifest.TID = name
+			if err := stream.writeManifest(); err != nil {
+				return false, errors.Wrap(err, "cannot write manifest")
+			}
+		}
+		if opts.Upload {
+			if err := s.Put(stream, false); err != nil && !errors.Is(err, ErrStreamExists) {
+				return false, errors.Wrap(err, "cannot upload stream")
+			}
+		}
+		return lib.ImportStream(*stream)
+	})
+}
+
+func (lib *Library) importAll(names []string, workers int, importFn func(string) (bool, error)) (*ImportReport, error) {
+	if workers < 1 {
+		workers = 1
+	}
+	report := &ImportReport{Imported: []string{}, Skipped: []string{}, Failed: map[string]error{}}
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	p, err := ants.NewPoolWithFunc(workers, func(i interface{}) {
+		defer wg.Done()
+		name := i.(string)
+		imported, err := importFn(name)
+
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case err != nil:
+			lib.log.Info("stream import failed", "name", name, "err", err)
+			report.Failed[name] = err
+		case imported:
+			lib.log.Info("stream imported", "name", name)
+			report.Imported = append(report.Imported, name)
+		default:
+			report.Skipped = append(report.Skipped, name)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer p.Release()
+
+	for _, n := range names {
+		wg.Add(1)
+		if err := p.Invoke(n); err != nil {
+			wg.Done()
+			return nil, err
+		}
+	}
+	wg.Wait()
+	return report, nil
+}
+
+func readStoredManifest(s FragmentStorage, tid string) (*Manifest, error) {
+	r, err := s.GetFragment(tid, ManifestName)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read manifest")
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read manifest")
+	}
+	return DecodeManifest(data)
+}
+
+// ReadURLMap reads lines of "<stream folder name> <lbry url>" pairs. Empty lines and lines starting with # are skipped.
+func ReadURLMap(r io.Reader) (map[string]string, error) {
+	urls := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, errors.Errorf("line %v: expected folder name and url", n)
+		}
+		urls[fields[0]] = fields[1]
+	}
+	return urls, scanner.Err()
+}
+
+// ResolvingGenerator returns an ImportOptions.Generate function which resolves stream folder URLs from the urls map
+// and generates manifests for them. Folder modification time is used as the transcoding timestamp.
+func ResolvingGenerator(urls map[string]string, manifestFuncs ...func(*Manifest)) func(*Stream) error {
+	return func(stream *Stream) error {
+		name := filepath.Base(stream.LocalPath)
+		uri, ok := urls[name]
+		if !ok {
+			return errors.Errorf("no url known for %s", name)
+		}
+		fi, err := os.Stat(stream.LocalPath)
+		if err != nil {
+			return err
+		}
+		rr, err := resolve.ResolveStream(uri)
+		if err != nil {
+			return err
+		}
+		return stream.GenerateManifest(
+			rr.URI, rr.ChannelURI, rr.SDHash,
+			append([]func(*Manifest){WithTimestamp(fi.ModTime())}, manifestFuncs...)...,
+		)
+	}
+}
@@ -5,14 +5,16 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"sync"
+	"io"
+	"time"
 
 	"github.com/lbryio/transcoder/library/db"
 	"github.com/lbryio/transcoder/pkg/logging"
 	"github.com/lbryio/transcoder/pkg/resolve"
+	"github.com/lbryio/transcoder/pkg/urlsign"
 
 	"github.com/c2h5oh/datasize"
-	"github.com/panjf2000/ants/v2"
+	ljsonrpc "github.com/lbryio/lbry.go/v2/extras/jsonrpc"
 	"github.com/pkg/errors"
 	"github.com/tabbed/pqtype"
 )
@@ -21,37 +23,69 @@ const (
 	SchemeRemote = "remote"
 )
 
-var ErrStreamNotFound = errors.New("stream not found")
-var storageURLs = map[string]string{
-	"wasabi": "https://s3.wasabisys.com/t-na2.odycdn.com",
-	"legacy": "https://na-storage-1.transcoder.odysee.com/t-na",
-}
+var (
+	ErrStreamNotFound  = errors.New("stream not found")
+	ErrStreamExists    = errors.New("stream already exists")
+	ErrChannelNotFound = errors.New("channel not found")
+)
 
 type Storage interface {
 	Name() string
 	GetURL(tid string) string
 	Put(stream *Stream, _ bool) error
 	Delete(streamTID string) error
-	// GetFragment(sdHash, name string) (storage.StreamFragment, error)
+}
+
+type StreamFragment interface {
+	io.ReadCloser
+}
+
+// ListingStorage can list files stored for a stream without downloading them.
+type ListingStorage interface {
+	FragmentStorage
+	// ListFiles returns names of all files stored for the stream.
+	ListFiles(streamTID string) ([]string, error)
+}
+
+// FragmentStorage is a storage stream files can be read back from, which is required for replicating from it.
+type FragmentStorage interface {
+	Storage
+	GetFragment(streamTID, name string) (StreamFragment, error)
 }
 
 type Library struct {
-	db      *db.Queries
-	storage Storage
-	log     logging.KVLogger
+	db         *db.Queries
+	conn       db.DBTX
+	storage    Storage
+	replicas   []Replica
+	retirement RetirementPolicy
+	access     *accessRecorder
+	signer     *urlsign.Signer
+	log        logging.KVLogger
 }
 
 type Config struct {
+	// Storage is the primary storage new streams are uploaded to.
 	Storage Storage
-	DB      db.DBTX
-	Log     logging.KVLogger
+	// Replicas are additional storages in the order of preference for serving streams.
+	Replicas []Replica
+	// Retirement decides which videos are retired first when a storage is over its size limit.
+	Retirement RetirementPolicy
+	// URLSigner makes video URLs signed and expiring, for storages not serving streams publicly.
+	URLSigner *urlsign.Signer
+	DB        db.DBTX
+	Log       logging.KVLogger
 }
 
 func New(config Config) *Library {
 	return &Library{
-		db:      db.New(config.DB),
-		log:     config.Log,
-		storage: config.Storage,
+		db:         db.New(config.DB),
+		conn:       config.DB,
+		log:        config.Log,
+		storage:    config.Storage,
+		replicas:   config.Replicas,
+		retirement: config.Retirement,
+		signer:     config.URLSigner,
 	}
 }
 
@@ -68,15 +102,28 @@ func (lib *Library) GetVideoURL(sdHash string) (string, error) {
 		}
 		return "", err
 	}
-	err = lib.db.RecordVideoAccess(context.Background(), v.SDHash)
+	loc, err := lib.bestLocation(v)
 	if err != nil {
 		return "", err
 	}
-	url = fmt.Sprintf("%s://%s/%s/", SchemeRemote, v.Storage, v.Path)
+	err = lib.recordAccess(v.SDHash)
+	if err != nil {
+		return "", err
+	}
+	url = fmt.Sprintf("%s://%s/%s/", SchemeRemote, loc.Storage, loc.Path)
+	if lib.signer != nil {
+		url += "?" + lib.signer.Sign(loc.Path).Encode()
+	}
 	return url, nil
 }
 
+// AddRemoteStream adds the stream to the library as the active version of the video,
+// superseding previously active one with the same SD hash.
 func (lib *Library) AddRemoteStream(stream Stream) error {
+	return lib.addStream(stream, true)
+}
+
+func (lib *Library) addStream(stream Stream, activate bool) error {
 	if stream.Manifest == nil {
 		return errors.New("cannot add remote stream, manifest is missing")
 	}
@@ -95,20 +142,53 @@ func (lib *Library) AddRemoteStream(stream Stream) error {
 		Size:     stream.Size(),
 		Checksum: sql.NullString{String: stream.Checksum(), Valid: true},
 		Manifest: pqtype.NullRawMessage{RawMessage: bm, Valid: true},
+		Ladder:   sql.NullString{String: m.Ladder.Name, Valid: m.Ladder.Name != ""},
+		Active:   activate,
+	}
+	if !activate {
+		p.SupersededAt = sql.NullTime{Time: time.Now(), Valid: true}
 	}
-	_, err = lib.db.AddVideo(context.Background(), p)
-	return err
+	return lib.inTx(func(q *db.Queries) error {
+		ctx := context.Background()
+		if activate {
+			if err := q.SupersedeVideos(ctx, m.SDHash); err != nil {
+				return err
+			}
+		}
+		if _, err := q.AddVideo(ctx, p); err != nil {
+			return err
+		}
+		return q.AddVideoLocation(ctx, db.AddVideoLocationParams{
+			TID:     m.TID,
+			Storage: stream.RemoteStorage,
+			Path:    m.TID,
+		})
+	})
+}
+
+// inTx runs fn in a transaction if the library database connection can start one.
+func (lib *Library) inTx(fn func(q *db.Queries) error) error {
+	conn, ok := lib.conn.(*sql.DB)
+	if !ok {
+		return fn(lib.db)
+	}
+	tx, err := conn.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(lib.db.WithTx(tx)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
 
 func (lib *Library) AddChannel(uri string, priority db.ChannelPriority) (db.Channel, error) {
 	var c db.Channel
-	claim, err := resolve.Resolve(uri)
+	claim, err := resolveChannel(uri)
 	if err != nil {
 		return c, err
 	}
-	if claim.ClaimID == "" {
-		return c, errors.New("channel not found")
-	}
 
 	if priority == "" {
 		priority = db.ChannelPriorityNormal
@@ -120,31 +200,65 @@ func (lib *Library) AddChannel(uri string, priority db.ChannelPriority) (db.Chan
 	})
 }
 
+func resolveChannel(uri string) (*ljsonrpc.Claim, error) {
+	claim, err := resolve.Resolve(uri)
+	if err != nil {
+		return nil, err
+	}
+	if claim.ClaimID == "" {
+		return nil, ErrChannelNotFound
+	}
+	return claim, nil
+}
+
 func (lib *Library) GetAllChannels() ([]db.Channel, error) {
 	return lib.db.GetAllChannels(context.Background())
 }
 
-// RetireVideos deletes older videos from S3, keeping total size of remote videos at maxSize.
+// RetireVideos deletes videos from the storage in the order of retirement policy, keeping total size
+// of videos stored there at maxSize.
+// Videos are copied to replicas with ReplicateOnRetire policy before being deleted from the primary storage.
 func (lib *Library) RetireVideos(storageName string, maxSize uint64) (uint64, uint64, error) {
-	items, err := lib.db.GetAllVideosForStorage(context.Background(), storageName)
-	if err != nil {
-		return 0, 0, err
-	}
-	return tailVideos(items, maxSize, lib.Retire)
+	return lib.tailStorage(storageName, maxSize, func(v db.Video) error {
+		return lib.retireFrom(v, storageName)
+	})
 }
 
+// Retire deletes the video from all storages holding it and moves it to the archive.
+// Files of the video are kept on the first storage which supports archiving.
 func (lib *Library) Retire(v db.Video) error {
+	return lib.retire(v, ArchiveReasonRetired)
+}
+
+func (lib *Library) retire(v db.Video, reason string) error {
 	ll := lib.log.With("tid", v.TID, "sd_hash", v.SDHash)
 
-	err := lib.storage.Delete(v.TID)
+	locs, err := lib.db.GetVideoLocations(context.Background(), v.TID)
 	if err != nil {
-		ll.Warn("failed to delete remote video", "err", err)
 		return err
 	}
+	var keptOn ArchivingStorage
+	for _, l := range locs {
+		s := lib.getStorage(l.Storage)
+		if s == nil {
+			ll.Warn("storage is not configured, leaving remote video in place", "storage", l.Storage)
+			continue
+		}
+		if as := archivingStorage(s); keptOn == nil && as != nil {
+			keptOn = as
+			err = as.Archive(l.Path)
+		} else {
+			err = s.Delete(l.Path)
+		}
+		if err != nil {
+			ll.Warn("failed to delete remote video", "storage", l.Storage, "err", err)
+			return err
+		}
+	}
 
-	err = lib.db.DeleteVideo(context.Background(), v.TID)
+	err = lib.archiveVideo(v.TID, reason, keptOn)
 	if err != nil {
-		ll.Warn("failed to delete video record", "err", err)
+		ll.Warn("failed to archive video record", "err", err)
 		return err
 	}
 
@@ -152,72 +266,6 @@ func (lib *Library) Retire(v db.Video) error {
 	return nil
 }
 
-// RetireVideos deletes older videos from S3, keeping total size of remote videos at maxSize.
-func (lib *Library) ValidateStreams(storageName string, offset, limit int32, remove bool) ([]string, []string, error) {
-	broken := []string{}
-	valid := []string{}
-	tids := map[string]string{}
-	items, err := lib.db.GetAllVideosForStorageLimit(
-		context.Background(),
-		db.GetAllVideosForStorageLimitParams{
-			Storage: storageName,
-			Offset:  offset,
-			Limit:   limit,
-		},
-	)
-	if err != nil {
-		return nil, nil, err
-	}
-	wg := sync.WaitGroup{}
-	results := make(chan *ValidationResult)
-
-	go func() {
-		for vr := range results {
-			if len(vr.Missing) > 0 {
-				broken = append(broken, vr.URL)
-				lib.log.Info("broken stream", "url", vr.URL, "missing", vr.Missing)
-			} else {
-				valid = append(valid, vr.URL)
-			}
-		}
-	}()
-	pipe := func(i interface{}) error {
-		url := i.(string)
-		vr, _ := ValidateStream(url, true, true)
-		results <- vr
-		return nil
-	}
-
-	p, _ := ants.NewPoolWithFunc(10, func(i interface{}) {
-		err := pipe(i)
-		if err != nil {
-			lib.log.Error("error submitting a url", "err", err)
-		}
-		wg.Done()
-	})
-	defer p.Release()
-
-	for _, v := range items {
-		url := fmt.Sprintf("%s/%s", storageURLs[v.Storage], v.Path)
-		tids[url] = v.TID
-		wg.Add(1)
-		_ = p.Invoke(url)
-	}
-	wg.Wait()
-
-	if remove {
-		for _, u := range broken {
-			err := lib.db.DeleteVideo(context.Background(), tids[u])
-			if err != nil {
-				lib.log.Info("video removal failed", "tid", tids[u], "err", err)
-			} else {
-				lib.log.Info("video removed", "tid", tids[u], "url", u)
-			}
-		}
-	}
-	return valid, broken, nil
-}
-
 func StringToSize(s string) uint64 {
 	var size datasize.ByteSize
 	err := size.UnmarshalText([]byte(s))
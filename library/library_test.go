@@ -1,14 +1,22 @@
 package library
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/lbryio/transcoder/library/db"
 	"github.com/lbryio/transcoder/pkg/logging/zapadapter"
+	"github.com/lbryio/transcoder/pkg/urlsign"
 
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -58,6 +66,7 @@ func (s *librarySuite) TestAddGetVideo() {
 	v, err := lib.GetVideo(newStream.SDHash())
 	s.Require().NoError(err)
 	s.EqualValues(1, v.AccessCount.Int32)
+	s.Equal(newStream.Manifest.Ladder.Name, v.Ladder.String)
 	s.GreaterOrEqual(2, int(time.Since(v.AccessedAt).Seconds()))
 	m := &Manifest{}
 	err = json.Unmarshal(v.Manifest.RawMessage, m)
@@ -66,3 +75,204 @@ func (s *librarySuite) TestAddGetVideo() {
 	newStream.Manifest.TranscodedAt = time.Time{}
 	s.EqualValues(m, newStream.Manifest)
 }
+
+func (s *librarySuite) TestSignedVideoURL() {
+	signer, err := urlsign.New(time.Minute, urlsign.Key{ID: "k1", Secret: "secret"})
+	s.Require().NoError(err)
+	lib := New(Config{DB: s.DB, Storage: NewDummyStorage("storage1", "https://storage.host"), URLSigner: signer, Log: zapadapter.NewKV(nil)})
+	newStream := GenerateDummyStream()
+	s.Require().NoError(lib.AddRemoteStream(*newStream))
+
+	loc, err := lib.GetVideoURL(newStream.SDHash())
+	s.Require().NoError(err)
+	u, err := url.Parse(loc)
+	s.Require().NoError(err)
+	s.Equal("storage1", u.Host)
+	s.Equal("/"+newStream.Manifest.TID+"/", u.Path)
+	s.NoError(signer.Verify(u.Path+MasterPlaylistName, u.Query()))
+}
+
+func (s *librarySuite) TestVideoLocations() {
+	primary := NewDummyStorage("storage1", "https://storage.host")
+	cold := NewDummyStorage("cold", "https://cold.host")
+	lib := New(Config{
+		DB:       s.DB,
+		Storage:  primary,
+		Replicas: []Replica{{Storage: cold, Policy: ReplicateOnRetire}},
+		Log:      zapadapter.NewKV(nil),
+	})
+	newStream := GenerateDummyStream()
+	tid := newStream.Manifest.TID
+
+	s.Require().NoError(lib.AddRemoteStream(*newStream))
+	s.Require().NoError(lib.db.AddVideoLocation(context.Background(), db.AddVideoLocationParams{TID: tid, Storage: "cold", Path: tid}))
+
+	url, err := lib.GetVideoURL(newStream.SDHash())
+	s.Require().NoError(err)
+	s.Equal(fmt.Sprintf("remote://storage1/%s/", tid), url)
+
+	v, err := lib.GetVideo(newStream.SDHash())
+	s.Require().NoError(err)
+	s.Require().NoError(lib.retireFrom(v, "storage1"))
+	s.Equal([]StorageOp{{OpDelete, tid}}, primary.Ops)
+
+	url, err = lib.GetVideoURL(newStream.SDHash())
+	s.Require().NoError(err)
+	s.Equal(fmt.Sprintf("remote://cold/%s/", tid), url)
+
+	s.Require().NoError(lib.retireFrom(v, "cold"))
+	_, err = lib.GetVideoURL(newStream.SDHash())
+	s.ErrorIs(err, ErrStreamNotFound)
+}
+
+func TestStorageRank(t *testing.T) {
+	lib := New(Config{
+		Storage: NewDummyStorage("hot", ""),
+		Replicas: []Replica{
+			{Storage: NewDummyStorage("warm", ""), Policy: ReplicateAlways},
+			{Storage: NewDummyStorage("cold", ""), Policy: ReplicateOnRetire},
+		},
+	})
+	ranks := []int{}
+	for _, n := range []string{"hot", "warm", "cold", "legacy"} {
+		ranks = append(ranks, lib.storageRank(n))
+	}
+	assert.Equal(t, []int{0, 1, 2, 3}, ranks)
+	assert.Nil(t, lib.getStorage("legacy"))
+}
+
+func (s *librarySuite) TestReconcile() {
+	dir := s.T().TempDir()
+	strg := dirStorage{NewDummyStorage("local", "http://local"), dir}
+	lib := New(Config{DB: s.DB, Storage: strg, Log: zapadapter.NewKV(nil)})
+
+	known := storeDummyStream(s.T(), dir)
+	s.Require().NoError(lib.AddRemoteStream(*known))
+	orphan := storeDummyStream(s.T(), dir)
+	missing := GenerateDummyStream()
+	missing.RemoteStorage = "local"
+	s.Require().NoError(lib.AddRemoteStream(*missing))
+
+	report, err := lib.Reconcile("local", ReconcileOptions{})
+	s.Require().NoError(err)
+	s.Equal(map[string]int64{orphan.TID(): 1000}, report.Orphans)
+	s.Equal([]string{missing.TID()}, report.Missing)
+	s.Empty(report.Imported)
+
+	report, err = lib.Reconcile("local", ReconcileOptions{ImportOrphans: true, RemoveMissing: true})
+	s.Require().NoError(err)
+	s.Equal([]string{orphan.TID()}, report.Imported)
+	s.Equal([]string{missing.TID()}, report.Removed)
+	v, err := lib.GetVideo(orphan.SDHash())
+	s.Require().NoError(err)
+	s.Equal(orphan.Checksum(), v.Checksum.String)
+	_, err = lib.GetVideo(missing.SDHash())
+	s.Error(err)
+
+	deleted := storeDummyStream(s.T(), dir)
+	report, err = lib.Reconcile("local", ReconcileOptions{DeleteOrphans: true})
+	s.Require().NoError(err)
+	s.Equal([]string{deleted.TID()}, report.Deleted)
+	s.EqualValues(1000, report.ReclaimedBytes)
+	s.Equal([]StorageOp{{OpDelete, deleted.TID()}}, strg.Ops)
+}
+
+func (s *librarySuite) TestImport() {
+	dir := s.T().TempDir()
+	strg := dirStorage{NewDummyStorage("local", "http://local"), dir}
+	lib := New(Config{DB: s.DB, Storage: strg, Log: zapadapter.NewKV(nil)})
+
+	stored := storeDummyStream(s.T(), dir)
+	report, err := lib.ImportStored("local", ImportOptions{Workers: 2})
+	s.Require().NoError(err)
+	s.Equal([]string{stored.TID()}, report.Imported)
+	v, err := lib.GetVideo(stored.SDHash())
+	s.Require().NoError(err)
+	s.Equal(stored.Checksum(), v.Checksum.String)
+
+	report, err = lib.ImportStored("local", ImportOptions{})
+	s.Require().NoError(err)
+	s.Empty(report.Imported)
+	s.Equal([]string{stored.TID()}, report.Skipped)
+
+	localDir := s.T().TempDir()
+	local := storeDummyStream(s.T(), localDir)
+	noManifest := storeDummyStream(s.T(), localDir)
+	s.Require().NoError(os.Remove(path.Join(noManifest.LocalPath, ManifestName)))
+
+	report, err = lib.ImportDir(localDir, "local", ImportOptions{Upload: true})
+	s.Require().NoError(err)
+	s.Equal([]string{local.TID()}, report.Imported)
+	s.Contains(report.Failed, noManifest.TID())
+	s.Len(strg.Ops, 1, "only the stream with a manifest is uploaded")
+
+	report, err = lib.ImportDir(localDir, "local", ImportOptions{
+		Generate: func(stream *Stream) error {
+			return stream.GenerateManifest("url", "channel", noManifest.SDHash(), WithTimestamp(noManifest.Manifest.TranscodedAt))
+		},
+	})
+	s.Require().NoError(err)
+	s.Equal([]string{noManifest.TID()}, report.Imported)
+	s.Equal([]string{local.TID()}, report.Skipped)
+}
+
+func TestReadURLMap(t *testing.T) {
+	urls, err := ReadURLMap(strings.NewReader("# streams\nabc lbry://one#1\n\n def  lbry://two#2 \n"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"abc": "lbry://one#1", "def": "lbry://two#2"}, urls)
+
+	_, err = ReadURLMap(strings.NewReader("abc\n"))
+	assert.Error(t, err)
+}
+
+// copyingStorage stores uploaded streams in its dir.
+type copyingStorage struct {
+	dirStorage
+}
+
+func (s copyingStorage) Put(stream *Stream, _ bool) error {
+	dst := path.Join(s.dir, stream.TID())
+	if err := os.MkdirAll(dst, os.ModePerm); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(stream.LocalPath)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		data, err := os.ReadFile(path.Join(stream.LocalPath, e.Name()))
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(path.Join(dst, e.Name()), data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *librarySuite) TestReplicateExtraFiles() {
+	dir := s.T().TempDir()
+	replica := copyingStorage{dirStorage{NewDummyStorage("cold", "http://cold"), s.T().TempDir()}}
+	lib := New(Config{
+		DB:       s.DB,
+		Storage:  dirStorage{NewDummyStorage("local", "http://local"), dir},
+		Replicas: []Replica{{Storage: replica, Policy: ReplicateAlways}},
+		Log:      zapadapter.NewKV(nil),
+	})
+
+	stream := storeDummyStream(s.T(), dir)
+	s.Require().NoError(os.WriteFile(path.Join(stream.LocalPath, "sprite.png"), []byte("sprite"), 0644))
+	stream.Manifest.Files = append(stream.Manifest.Files, "sprite.png")
+	s.Require().NoError(lib.AddRemoteStream(*stream))
+
+	v, err := lib.GetVideo(stream.SDHash())
+	s.Require().NoError(err)
+	s.Require().NoError(lib.replicate(v, replica))
+
+	vr, err := ValidateStoredStream(replica, stream.TID(), stream.Manifest)
+	s.Require().NoError(err)
+	s.Empty(vr.Missing)
+	s.Contains(vr.Present, "sprite.png")
+	s.Contains(vr.Present, ManifestName)
+}
@@ -0,0 +1,104 @@
+package library
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lbryio/transcoder/library/db"
+)
+
+const (
+	DefaultListLimit = 100
+	MaxListLimit     = 1000
+)
+
+// VideoFilter narrows down videos returned by ListVideos. Zero values mean no filtering on the field.
+type VideoFilter struct {
+	Channel        string
+	Storage        string
+	CreatedAfter   time.Time
+	CreatedBefore  time.Time
+	AccessedAfter  time.Time
+	AccessedBefore time.Time
+	MinSize        int64
+	MaxSize        int64
+	Ladder         string
+	// Worker is the name of the worker which transcoded the video.
+	Worker string
+	Offset int32
+	// Limit is DefaultListLimit if not set and is capped at MaxListLimit.
+	Limit int32
+}
+
+// VideoList is a page of videos matching a filter along with totals for all matching videos.
+type VideoList struct {
+	Videos    []db.Video
+	Total     int64
+	TotalSize int64
+	Offset    int32
+	Limit     int32
+}
+
+// ListVideos returns videos matching the filter, most recently created first.
+func (lib *Library) ListVideos(f VideoFilter) (*VideoList, error) {
+	ctx := context.Background()
+	if f.Limit <= 0 {
+		f.Limit = DefaultListLimit
+	} else if f.Limit > MaxListLimit {
+		f.Limit = MaxListLimit
+	}
+	if f.Offset < 0 {
+		f.Offset = 0
+	}
+	params := db.ListVideosParams{
+		Channel:        f.Channel,
+		Storage:        f.Storage,
+		CreatedAfter:   nullTime(f.CreatedAfter),
+		CreatedBefore:  nullTime(f.CreatedBefore),
+		AccessedAfter:  nullTime(f.AccessedAfter),
+		AccessedBefore: nullTime(f.AccessedBefore),
+		MinSize:        f.MinSize,
+		MaxSize:        f.MaxSize,
+		Ladder:         f.Ladder,
+		Worker:         f.Worker,
+		Offset:         f.Offset,
+		Limit:          f.Limit,
+	}
+	videos, err := lib.db.ListVideos(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	totals, err := lib.db.CountVideos(ctx, db.CountVideosParams{
+		Channel:        params.Channel,
+		Storage:        params.Storage,
+		CreatedAfter:   params.CreatedAfter,
+		CreatedBefore:  params.CreatedBefore,
+		AccessedAfter:  params.AccessedAfter,
+		AccessedBefore: params.AccessedBefore,
+		MinSize:        params.MinSize,
+		MaxSize:        params.MaxSize,
+		Ladder:         params.Ladder,
+		Worker:         params.Worker,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if videos == nil {
+		videos = []db.Video{}
+	}
+	return &VideoList{
+		Videos:    videos,
+		Total:     totals.Count,
+		TotalSize: totals.TotalSize,
+		Offset:    f.Offset,
+		Limit:     f.Limit,
+	}, nil
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	if t.IsZero() {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
@@ -0,0 +1,55 @@
+package library
+
+import (
+	"time"
+
+	"github.com/lbryio/transcoder/pkg/logging/zapadapter"
+)
+
+func (s *librarySuite) TestListVideos() {
+	lib := New(Config{DB: s.DB, Storage: NewDummyStorage("storage1", ""), Log: zapadapter.NewKV(nil)})
+
+	var channelSize int64
+	channel := "@listing#1"
+	for i := 0; i < 5; i++ {
+		stream := GenerateDummyStream()
+		if i%2 == 0 {
+			stream.Manifest.ChannelURL = channel
+			stream.Manifest.TranscodedBy = "worker1"
+			channelSize += stream.Manifest.Size
+		}
+		s.Require().NoError(lib.AddRemoteStream(*stream))
+	}
+
+	list, err := lib.ListVideos(VideoFilter{})
+	s.Require().NoError(err)
+	s.EqualValues(5, list.Total)
+	s.Len(list.Videos, 5)
+	s.EqualValues(DefaultListLimit, list.Limit)
+
+	list, err = lib.ListVideos(VideoFilter{Channel: channel, Limit: 2})
+	s.Require().NoError(err)
+	s.EqualValues(3, list.Total)
+	s.Equal(channelSize, list.TotalSize)
+	s.Require().Len(list.Videos, 2)
+	for _, v := range list.Videos {
+		s.Equal(channel, v.Channel)
+	}
+	next, err := lib.ListVideos(VideoFilter{Channel: channel, Limit: 2, Offset: 2})
+	s.Require().NoError(err)
+	s.Require().Len(next.Videos, 1)
+	s.NotContains([]string{list.Videos[0].TID, list.Videos[1].TID}, next.Videos[0].TID)
+
+	list, err = lib.ListVideos(VideoFilter{Worker: "worker1", Storage: "storage1"})
+	s.Require().NoError(err)
+	s.EqualValues(3, list.Total)
+
+	list, err = lib.ListVideos(VideoFilter{Storage: "storage2"})
+	s.Require().NoError(err)
+	s.EqualValues(0, list.Total)
+	s.NotNil(list.Videos)
+
+	list, err = lib.ListVideos(VideoFilter{CreatedAfter: time.Now().Add(time.Hour)})
+	s.Require().NoError(err)
+	s.EqualValues(0, list.Total)
+}
@@ -2,7 +2,6 @@ package library
 
 import (
 	"fmt"
-	"sort"
 	"time"
 
 	"github.com/lbryio/transcoder/library/db"
@@ -10,6 +9,16 @@ import (
 	"github.com/c2h5oh/datasize"
 )
 
+const (
+	replicationBatchSize  = 100
+	verificationBatchSize = 20
+	verificationPeriod    = 10 * time.Minute
+	archivePurgeBatchSize = 100
+	archivePurgePeriod    = 1 * time.Hour
+	supersededBatchSize   = 100
+	supersededPeriod      = 1 * time.Hour
+)
+
 func SpawnLibraryCleaning(lib *Library, storageName string, maxSize uint64) chan struct{} {
 	stopChan := make(chan struct{})
 	logger.Infow(
@@ -36,11 +45,168 @@ func SpawnLibraryCleaning(lib *Library, storageName string, maxSize uint64) chan
 	return stopChan
 }
 
+// SpawnReplication periodically copies videos to replicas with ReplicateAlways policy.
+func SpawnReplication(lib *Library, interval time.Duration) chan struct{} {
+	stopChan := make(chan struct{})
+	logger.Infow("starting library replication", "replicas", len(lib.replicas))
+
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				copied, err := lib.ReplicateVideos(replicationBatchSize)
+				if err != nil {
+					logger.Infow("error replicating videos", "err", err)
+				} else if copied > 0 {
+					logger.Infow("replicated some videos", "count", copied)
+				}
+			case <-stopChan:
+				ticker.Stop()
+				logger.Info("stopping library replication")
+				return
+			}
+		}
+	}()
+
+	return stopChan
+}
+
+// SpawnVerification periodically checks checksums of stored streams, verifying each one at most once per interval.
+func SpawnVerification(lib *Library, interval time.Duration) chan struct{} {
+	stopChan := make(chan struct{})
+	logger.Infow("starting library verification", "interval", interval)
+
+	ticker := time.NewTicker(verificationPeriod)
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				checked, broken, err := lib.VerifyStreams(interval, verificationBatchSize)
+				if err != nil {
+					logger.Infow("error verifying streams", "err", err)
+				} else if checked > 0 {
+					logger.Infow("streams verified", "checked", checked, "broken", broken)
+				}
+			case <-stopChan:
+				ticker.Stop()
+				logger.Info("stopping library verification")
+				return
+			}
+		}
+	}()
+
+	return stopChan
+}
+
+// SpawnArchivePurging periodically deletes expired files of archived videos.
+func SpawnArchivePurging(lib *Library) chan struct{} {
+	stopChan := make(chan struct{})
+	logger.Info("starting library archive purging")
+
+	ticker := time.NewTicker(archivePurgePeriod)
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				purged, err := lib.PurgeArchive(archivePurgeBatchSize)
+				if err != nil {
+					logger.Infow("error purging archive", "err", err)
+				} else if purged > 0 {
+					logger.Infow("purged expired archived videos", "count", purged)
+				}
+			case <-stopChan:
+				ticker.Stop()
+				logger.Info("stopping library archive purging")
+				return
+			}
+		}
+	}()
+
+	return stopChan
+}
+
+// SpawnSupersededRetirement periodically retires video versions superseded more than grace ago.
+func SpawnSupersededRetirement(lib *Library, grace time.Duration) chan struct{} {
+	stopChan := make(chan struct{})
+	logger.Infow("starting superseded versions retirement", "grace", grace)
+
+	ticker := time.NewTicker(supersededPeriod)
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				retired, err := lib.RetireSuperseded(grace, supersededBatchSize)
+				if err != nil {
+					logger.Infow("error retiring superseded versions", "err", err)
+				} else if retired > 0 {
+					logger.Infow("retired superseded versions", "count", retired)
+				}
+			case <-stopChan:
+				ticker.Stop()
+				logger.Info("stopping superseded versions retirement")
+				return
+			}
+		}
+	}()
+
+	return stopChan
+}
+
+// SpawnAccessRecording makes video access events buffered and written to the database every interval
+// instead of on each request. It must be called before the library starts serving requests.
+// Buffered events are written once more when stopped.
+func SpawnAccessRecording(lib *Library, interval time.Duration) chan struct{} {
+	stopChan := make(chan struct{})
+	logger.Infow("starting buffered access recording", "interval", interval)
+
+	lib.access = newAccessRecorder(accessBufferSize)
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if err := lib.FlushAccess(); err != nil {
+					logger.Infow("error recording video access", "err", err)
+				}
+			case <-stopChan:
+				ticker.Stop()
+				if err := lib.FlushAccess(); err != nil {
+					logger.Infow("error recording video access", "err", err)
+				}
+				logger.Info("stopping buffered access recording")
+				return
+			}
+		}
+	}()
+
+	return stopChan
+}
+
 func toGB(s uint64) string {
 	return fmt.Sprintf("%.2fGB", datasize.ByteSize(s).GBytes())
 }
 
 func retireVideos(lib *Library, storageName string, maxSize uint64) {
+	if lib.retirement.DryRun {
+		plan, err := lib.PlanRetirement(storageName, maxSize)
+		if err != nil {
+			logger.Infow("error planning video retirement", "err", err)
+			return
+		}
+		LibraryBytes.Set(float64(plan.TotalSize))
+		logger.Infow(
+			"videos would be retired (dry run)",
+			"storage", storageName, "count", len(plan.Videos),
+			"total_gb", toGB(plan.TotalSize), "retired_gb", toGB(plan.RetiredSize),
+		)
+		return
+	}
 	logger.Infow("starting library retirement procedure", "max_remote_size", toGB(maxSize))
 	totalSize, retiredSize, err := lib.RetireVideos(storageName, maxSize)
 	ll := logger.With("total_gb", toGB(totalSize), "retired_gb", toGB(retiredSize))
@@ -55,7 +221,8 @@ func retireVideos(lib *Library, storageName string, maxSize uint64) {
 	}
 }
 
-func tailVideos(videos []db.Video, maxSize uint64, call func(v db.Video) error) (totalSize uint64, furloughedSize uint64, err error) {
+// tailVideos calls the function for candidates in order until the total size of videos drops to maxSize.
+func tailVideos(videos, candidates []db.Video, maxSize uint64, call func(v db.Video) error) (totalSize uint64, furloughedSize uint64, err error) {
 	for _, v := range videos {
 		totalSize += uint64(v.Size)
 	}
@@ -63,9 +230,7 @@ func tailVideos(videos []db.Video, maxSize uint64, call func(v db.Video) error)
 		return
 	}
 
-	weight := func(v db.Video) int64 { return v.AccessedAt.Unix() }
-	sort.Slice(videos, func(i, j int) bool { return weight(videos[i]) < weight(videos[j]) })
-	for _, s := range videos {
+	for _, s := range candidates {
 		err := call(s)
 		if err != nil {
 			logger.Warnw("failed to execute function for video", "sd_hash", s.SDHash, "err", err)
@@ -85,7 +85,7 @@ func TestTailSizeables(t *testing.T) {
 
 	removed := []db.Video{}
 
-	totalSize, furloughedSize, err := tailVideos(vs, 75000, func(v db.Video) error { removed = append(removed, v); return nil })
+	totalSize, furloughedSize, err := tailVideos(vs, RetirementPolicy{}.order(vs, nil, time.Now()), 75000, func(v db.Video) error { removed = append(removed, v); return nil })
 	require.NoError(t, err)
 	assert.EqualValues(t, 130000, totalSize)
 	assert.EqualValues(t, 60000, furloughedSize)
@@ -102,7 +102,7 @@ func (s maintenanceSuite) TestRetireVideos() {
 	var totalSize, sizeToKeep, sizeRemote uint64
 	var initialCount, afterCount int64
 
-	dummyStorage := NewDummyStorage("", "")
+	dummyStorage := NewDummyStorage("storage1", "")
 	lib := New(Config{DB: s.DB, Storage: dummyStorage, Log: zapadapter.NewKV(nil)})
 
 	for i := range [100]int{} {
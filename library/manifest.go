@@ -0,0 +1,350 @@
+package library
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/lbryio/transcoder/library/db"
+
+	"github.com/pkg/errors"
+	"github.com/tabbed/pqtype"
+	"gopkg.in/yaml.v3"
+)
+
+// ManifestSchema is the version of the manifest format written by this version of the library.
+//
+// 1: manifests written before the schema was versioned.
+// 2: schema version and source file size.
+// 3: sizes and hashes of individual stream files.
+// 4: encryption method and key ID of encrypted streams.
+const ManifestSchema = 4
+
+// FileOpener reads a stream file by its name.
+type FileOpener func(name string) (io.ReadCloser, error)
+
+// manifestMigrations upgrade manifests from the schema version they are keyed by to the next one.
+// Migrations may read stream files for filling in new fields, opener is nil if files are not available.
+var manifestMigrations = map[int]func(m *Manifest, open FileOpener) error{
+	// Source size cannot be recovered for streams already transcoded.
+	1: func(*Manifest, FileOpener) error { return nil },
+	2: migrateFileEntries,
+	// Streams transcoded before encryption support are all unencrypted.
+	3: func(*Manifest, FileOpener) error { return nil },
+}
+
+// FileEntry is a stream file with its size and hash, as recorded when the stream was transcoded.
+type FileEntry struct {
+	Name string
+	Size int64
+	Hash string
+}
+
+// ManifestStorage can replace the manifest file of a stored stream.
+type ManifestStorage interface {
+	Storage
+	PutManifest(streamTID string, data []byte) error
+}
+
+type ManifestMigrationOptions struct {
+	// Limit is the maximum number of videos to migrate, all outdated videos are migrated if zero.
+	Limit int
+	// Stored makes stream manifest files rewritten on all storages supporting it, not just in the database.
+	Stored bool
+	DryRun bool
+}
+
+type ManifestMigrationReport struct {
+	Migrated []string
+	Failed   map[string]error
+}
+
+type manifestJSON Manifest
+
+var manifestJSONKeys = func() map[string]bool {
+	keys := map[string]bool{}
+	t := reflect.TypeOf(Manifest{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		keys[strings.ToLower(name)] = true
+	}
+	return keys
+}()
+
+// DecodeManifest reads a manifest in YAML format, as stored along with stream files.
+// Manifests of newer schemas are decoded as far as this version understands them, with unknown fields kept in Extra.
+func DecodeManifest(data []byte) (*Manifest, error) {
+	m := &Manifest{}
+	if err := yaml.Unmarshal(data, m); err != nil {
+		return nil, errors.Wrap(err, "cannot unmarshal manifest")
+	}
+	return m, nil
+}
+
+// DecodeManifestJSON reads a manifest in JSON format, as stored in the library database.
+func DecodeManifestJSON(data []byte) (*Manifest, error) {
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, errors.Wrap(err, "cannot unmarshal manifest")
+	}
+	return m, nil
+}
+
+func (m Manifest) EncodeYAML() ([]byte, error) {
+	return yaml.Marshal(m)
+}
+
+func (m Manifest) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(manifestJSON(m))
+	if err != nil || len(m.Extra) == 0 {
+		return data, err
+	}
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for k, v := range m.Extra {
+		if _, ok := fields[k]; !ok {
+			fields[k] = v
+		}
+	}
+	return json.Marshal(fields)
+}
+
+func (m *Manifest) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*manifestJSON)(m)); err != nil {
+		return err
+	}
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	m.Extra = nil
+	for k, v := range fields {
+		if manifestJSONKeys[strings.ToLower(k)] {
+			continue
+		}
+		if m.Extra == nil {
+			m.Extra = map[string]interface{}{}
+		}
+		m.Extra[k] = v
+	}
+	return nil
+}
+
+// SchemaVersion returns the manifest schema version, manifests without one are of the first version.
+func (m *Manifest) SchemaVersion() int {
+	if m.Schema == 0 {
+		return 1
+	}
+	return m.Schema
+}
+
+// Outdated is true if the manifest can be migrated to the current schema.
+func (m *Manifest) Outdated() bool {
+	return m.SchemaVersion() < ManifestSchema
+}
+
+// Migrate upgrades the manifest to the current schema. Manifests of the current or newer schemas are left intact.
+// Returns true if the manifest has been changed.
+func (m *Manifest) Migrate(open FileOpener) (bool, error) {
+	if !m.Outdated() {
+		return false, nil
+	}
+	for v := m.SchemaVersion(); v < ManifestSchema; v++ {
+		migrate, ok := manifestMigrations[v]
+		if !ok {
+			return false, errors.Errorf("no migration for manifest schema %v", v)
+		}
+		if err := migrate(m, open); err != nil {
+			return false, errors.Wrapf(err, "cannot migrate manifest from schema %v", v)
+		}
+		m.Schema = v + 1
+	}
+	return true, nil
+}
+
+// Entry returns the recorded size and hash of the stream file.
+func (m *Manifest) Entry(name string) (FileEntry, bool) {
+	for _, e := range m.Entries {
+		if e.Name == name {
+			return e, true
+		}
+	}
+	return FileEntry{}, false
+}
+
+// EntryMap returns recorded stream files by name, nil if the manifest has no file entries.
+func (m *Manifest) EntryMap() map[string]FileEntry {
+	if len(m.Entries) == 0 {
+		return nil
+	}
+	entries := make(map[string]FileEntry, len(m.Entries))
+	for _, e := range m.Entries {
+		entries[e.Name] = e
+	}
+	return entries
+}
+
+// HashFile reads a stream file entirely, returning its size and hash.
+func HashFile(name string, r io.Reader) (FileEntry, error) {
+	h := GetStreamHasher()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return FileEntry{}, err
+	}
+	return FileEntry{Name: name, Size: n, Hash: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// hashStream computes the stream checksum, hashing files in the same order as Stream.GenerateManifest does,
+// along with sizes and hashes of individual files read in the process.
+func hashStream(open FileOpener) (string, map[string]FileEntry, error) {
+	hash := GetStreamHasher()
+	entries := map[string]FileEntry{}
+	err := WalkStream(
+		"",
+		func(p ...string) (io.ReadCloser, error) {
+			return open(p[len(p)-1])
+		},
+		func(name string, r io.ReadCloser) error {
+			if r == nil {
+				return errors.Errorf("%s is missing", name)
+			}
+			e, err := HashFile(name, io.TeeReader(r, hash))
+			if err != nil {
+				return err
+			}
+			entries[name] = e
+			return nil
+		},
+	)
+	if err != nil {
+		return "", nil, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), entries, nil
+}
+
+// migrateFileEntries records file sizes and hashes from stored stream files,
+// provided the stored copy matches the stream checksum.
+func migrateFileEntries(m *Manifest, open FileOpener) error {
+	if open == nil {
+		return errors.New("stream files are not available")
+	}
+	checksum, entries, err := hashStream(open)
+	if err != nil {
+		return err
+	}
+	if m.Checksum != "" && m.Checksum != SkipChecksum && m.Checksum != checksum {
+		return ErrChecksumMismatch
+	}
+	m.Entries = []FileEntry{}
+	for _, name := range m.Files {
+		e, ok := entries[name]
+		if !ok {
+			r, err := open(name)
+			if err != nil {
+				return err
+			}
+			e, err = HashFile(name, r)
+			r.Close()
+			if err != nil {
+				return err
+			}
+		}
+		m.Entries = append(m.Entries, e)
+	}
+	return nil
+}
+
+// MigrateManifests upgrades manifests of videos in the library to the current schema.
+func (lib *Library) MigrateManifests(opts ManifestMigrationOptions) (*ManifestMigrationReport, error) {
+	ctx := context.Background()
+	report := &ManifestMigrationReport{Migrated: []string{}, Failed: map[string]error{}}
+	var afterID int32
+	for opts.Limit == 0 || len(report.Migrated)+len(report.Failed) < opts.Limit {
+		batch := int32(100)
+		if left := opts.Limit - len(report.Migrated) - len(report.Failed); opts.Limit > 0 && left < int(batch) {
+			batch = int32(left)
+		}
+		items, err := lib.db.GetVideosForManifestMigration(ctx, db.GetVideosForManifestMigrationParams{
+			Schema:  ManifestSchema,
+			AfterID: afterID,
+			Limit:   batch,
+		})
+		if err != nil {
+			return report, err
+		}
+		if len(items) == 0 {
+			break
+		}
+		for _, v := range items {
+			afterID = v.ID
+			if err := lib.migrateManifest(v, opts); err != nil {
+				lib.log.Warn("manifest migration failed", "tid", v.TID, "err", err)
+				report.Failed[v.TID] = err
+				continue
+			}
+			report.Migrated = append(report.Migrated, v.TID)
+		}
+	}
+	return report, nil
+}
+
+func (lib *Library) migrateManifest(v db.Video, opts ManifestMigrationOptions) error {
+	ctx := context.Background()
+	m, err := DecodeManifestJSON(v.Manifest.RawMessage)
+	if err != nil {
+		return err
+	}
+	var open FileOpener
+	if s, ok := lib.getStorage(v.Storage).(FragmentStorage); ok {
+		open = func(name string) (io.ReadCloser, error) {
+			return s.GetFragment(v.Path, name)
+		}
+	}
+	if _, err := m.Migrate(open); err != nil {
+		return err
+	}
+	if opts.DryRun {
+		return nil
+	}
+
+	if opts.Stored {
+		data, err := m.EncodeYAML()
+		if err != nil {
+			return err
+		}
+		locs, err := lib.db.GetVideoLocations(ctx, v.TID)
+		if err != nil {
+			return err
+		}
+		for _, l := range locs {
+			s, ok := lib.getStorage(l.Storage).(ManifestStorage)
+			if !ok {
+				continue
+			}
+			if err := s.PutManifest(l.Path, data); err != nil {
+				return errors.Wrapf(err, "cannot rewrite manifest on %s", l.Storage)
+			}
+		}
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return lib.db.UpdateVideoManifest(ctx, db.UpdateVideoManifestParams{
+		TID:      v.TID,
+		Manifest: pqtype.NullRawMessage{RawMessage: data, Valid: true},
+	})
+}
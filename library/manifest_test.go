@@ -0,0 +1,115 @@
+package library
+
+import (
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/lbryio/transcoder/pkg/logging/zapadapter"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeManifestForwardCompatible(t *testing.T) {
+	newer := []byte(`
+schema: 99
+url: lbry://what
+sdhash: abc
+size: 100
+codecs: [h264, aac]
+`)
+	m, err := DecodeManifest(newer)
+	require.NoError(t, err)
+	assert.Equal(t, 99, m.Schema)
+	assert.Equal(t, "lbry://what", m.URL)
+	assert.EqualValues(t, 100, m.Size)
+	assert.Equal(t, []interface{}{"h264", "aac"}, m.Extra["codecs"])
+	assert.False(t, m.Outdated())
+
+	changed, err := m.Migrate(nil)
+	require.NoError(t, err)
+	assert.False(t, changed, "manifests of newer schemas must be left intact")
+
+	data, err := m.EncodeYAML()
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "codecs:")
+
+	data, err = json.Marshal(m)
+	require.NoError(t, err)
+	mj, err := DecodeManifestJSON(data)
+	require.NoError(t, err)
+	assert.Equal(t, m.URL, mj.URL)
+	assert.Equal(t, 99, mj.Schema)
+	assert.Equal(t, []interface{}{"h264", "aac"}, mj.Extra["codecs"])
+
+	data, err = json.Marshal(Manifest{URL: "lbry://what"})
+	require.NoError(t, err)
+	mj, err = DecodeManifestJSON(data)
+	require.NoError(t, err)
+	assert.Nil(t, mj.Extra)
+}
+
+func TestManifestMigrate(t *testing.T) {
+	dir := t.TempDir()
+	stream := storeDummyStream(t, dir)
+	open := func(name string) (io.ReadCloser, error) {
+		return openFile(stream.LocalPath, name)
+	}
+
+	m, err := DecodeManifest([]byte("url: lbry://what\nsdhash: abc\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, m.SchemaVersion())
+	assert.True(t, m.Outdated())
+	_, err = m.Migrate(nil)
+	assert.Error(t, err, "file entries cannot be migrated without stream files")
+
+	m = &Manifest{Checksum: stream.Checksum(), Files: stream.Manifest.Files}
+	changed, err := m.Migrate(open)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, ManifestSchema, m.Schema)
+	assert.False(t, m.Outdated())
+	assert.Equal(t, stream.Manifest.Entries, m.Entries)
+	e, ok := m.Entry(PopulatedHLSPlaylistFiles[0])
+	assert.True(t, ok)
+	assert.NotEmpty(t, e.Hash)
+
+	m = &Manifest{Schema: 2, Checksum: "wrong", Files: stream.Manifest.Files}
+	_, err = m.Migrate(open)
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+	assert.Equal(t, 2, m.Schema)
+}
+
+func (s *librarySuite) TestMigrateManifests() {
+	dir := s.T().TempDir()
+	lib := New(Config{DB: s.DB, Storage: dirStorage{NewDummyStorage("local", ""), dir}, Log: zapadapter.NewKV(nil)})
+	outdated := storeDummyStream(s.T(), dir)
+	entries := outdated.Manifest.Entries
+	outdated.Manifest.Schema = 0
+	outdated.Manifest.Entries = nil
+	s.Require().NoError(lib.AddRemoteStream(*outdated))
+	current := storeDummyStream(s.T(), dir)
+	s.Require().NoError(lib.AddRemoteStream(*current))
+
+	report, err := lib.MigrateManifests(ManifestMigrationOptions{DryRun: true})
+	s.Require().NoError(err)
+	s.Equal([]string{outdated.TID()}, report.Migrated)
+
+	report, err = lib.MigrateManifests(ManifestMigrationOptions{})
+	s.Require().NoError(err)
+	s.Equal([]string{outdated.TID()}, report.Migrated)
+	s.Empty(report.Failed)
+
+	v, err := lib.GetVideo(outdated.SDHash())
+	s.Require().NoError(err)
+	m, err := DecodeManifestJSON(v.Manifest.RawMessage)
+	s.Require().NoError(err)
+	s.Equal(ManifestSchema, m.Schema)
+	s.Equal(outdated.Manifest.Checksum, m.Checksum)
+	s.Equal(entries, m.Entries)
+
+	report, err = lib.MigrateManifests(ManifestMigrationOptions{})
+	s.Require().NoError(err)
+	s.Empty(report.Migrated)
+}
@@ -11,10 +11,20 @@ var (
 	LibraryRetiredBytes = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "library_retired_bytes",
 	})
+	ChecksumMismatches = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "library_checksum_mismatches",
+	})
+	AccessFlushDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "library_access_flush_seconds",
+	})
+	AccessEventsDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "library_access_events_dropped",
+	})
 )
 
 func RegisterMetrics() {
 	prometheus.MustRegister(
-		LibraryBytes, LibraryRetiredBytes,
+		LibraryBytes, LibraryRetiredBytes, ChecksumMismatches,
+		AccessFlushDuration, AccessEventsDropped,
 	)
 }
@@ -0,0 +1,146 @@
+package library
+
+import (
+	"context"
+	"regexp"
+	"time"
+
+	"github.com/lbryio/transcoder/library/db"
+
+	"github.com/pkg/errors"
+)
+
+var tidRe = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// ValidTID checks that s is formatted as stream TIDs are. Folders named otherwise are not taken for streams
+// when a storage is reconciled with the library.
+func ValidTID(s string) bool {
+	return tidRe.MatchString(s)
+}
+
+// StoredStream describes a stream found in a storage.
+type StoredStream struct {
+	TID  string
+	Size int64
+	// LastModified is the time of the most recent change to any of stream files.
+	LastModified time.Time
+}
+
+// InventoryStorage can enumerate all streams kept in it.
+type InventoryStorage interface {
+	ListingStorage
+	ListStreams() ([]StoredStream, error)
+}
+
+type ReconcileOptions struct {
+	// ImportOrphans adds streams missing from the library using their manifests.
+	ImportOrphans bool
+	// DeleteOrphans deletes streams missing from the library from the storage, unless they were imported.
+	DeleteOrphans bool
+	// RemoveMissing removes library records of streams not found in the storage.
+	RemoveMissing bool
+	// MinAge protects recently modified streams, which may still be uploading, from being considered orphans.
+	MinAge time.Duration
+}
+
+type ReconcileReport struct {
+	// Orphans are sizes of streams found in the storage but not in the library, by TID.
+	Orphans map[string]int64
+	// Missing are TIDs of streams the library expects in the storage which are not there.
+	Missing        []string
+	Imported       []string
+	Deleted        []string
+	Removed        []string
+	Failed         map[string]error
+	ReclaimedBytes int64
+}
+
+// Reconcile compares streams found in the storage to library records.
+func (lib *Library) Reconcile(storageName string, opts ReconcileOptions) (*ReconcileReport, error) {
+	s, ok := lib.getStorage(storageName).(InventoryStorage)
+	if !ok {
+		return nil, errors.Errorf("storage %s is not configured or cannot be listed", storageName)
+	}
+	stored, err := s.ListStreams()
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot list storage")
+	}
+	items, err := lib.db.GetAllVideosForStorage(context.Background(), storageName)
+	if err != nil {
+		return nil, err
+	}
+	known := map[string]db.Video{}
+	for _, v := range items {
+		known[v.Path] = v
+	}
+
+	report := &ReconcileReport{
+		Orphans:  map[string]int64{},
+		Missing:  []string{},
+		Imported: []string{},
+		Deleted:  []string{},
+		Removed:  []string{},
+		Failed:   map[string]error{},
+	}
+	found := map[string]bool{}
+	for _, ss := range stored {
+		found[ss.TID] = true
+		if _, ok := known[ss.TID]; ok || !tidRe.MatchString(ss.TID) || time.Since(ss.LastModified) < opts.MinAge {
+			continue
+		}
+		report.Orphans[ss.TID] = ss.Size
+		ll := lib.log.With("tid", ss.TID, "size", ss.Size, "storage", storageName)
+		ll.Info("orphaned stream found")
+
+		if opts.ImportOrphans {
+			err := lib.importStored(s, ss.TID)
+			if err == nil {
+				ll.Info("orphaned stream imported")
+				report.Imported = append(report.Imported, ss.TID)
+				continue
+			}
+			ll.Info("orphaned stream import failed", "err", err)
+			report.Failed[ss.TID] = err
+		}
+		if opts.DeleteOrphans {
+			if err := s.Delete(ss.TID); err != nil {
+				ll.Info("orphaned stream deletion failed", "err", err)
+				report.Failed[ss.TID] = err
+				continue
+			}
+			ll.Info("orphaned stream deleted")
+			delete(report.Failed, ss.TID)
+			report.Deleted = append(report.Deleted, ss.TID)
+			report.ReclaimedBytes += ss.Size
+		}
+	}
+
+	for p, v := range known {
+		if found[p] {
+			continue
+		}
+		report.Missing = append(report.Missing, v.TID)
+		lib.log.Info("stream missing from storage", "tid", v.TID, "storage", storageName)
+		if opts.RemoveMissing {
+			if err := lib.removeLocation(v.TID, storageName, ArchiveReasonMissing, nil); err != nil {
+				report.Failed[v.TID] = err
+				continue
+			}
+			report.Removed = append(report.Removed, v.TID)
+		}
+	}
+	return report, nil
+}
+
+// importStored adds a stream found in the storage to the library using its manifest.
+func (lib *Library) importStored(s FragmentStorage, tid string) error {
+	m, err := readStoredManifest(s, tid)
+	if err != nil {
+		return err
+	}
+	if m.TID != tid {
+		return errors.Errorf("manifest TID %s does not match the stream", m.TID)
+	}
+	_, err = lib.ImportStream(Stream{RemoteStorage: s.Name(), Manifest: m})
+	return err
+}
@@ -0,0 +1,128 @@
+package library
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"os"
+	"path"
+
+	"github.com/pkg/errors"
+)
+
+// RepairableStorage can replace individual stream files.
+type RepairableStorage interface {
+	FragmentStorage
+	PutFile(streamTID, name string, r io.Reader) error
+}
+
+// RepairResult lists files re-uploaded to a single storage.
+type RepairResult struct {
+	TID, Storage string
+	Repaired     []string
+	Err          error
+}
+
+// RepairStream re-uploads stream files which are missing or do not match their manifest entries
+// from a local copy of the stream, such as the output retained by the worker that transcoded it.
+// Files of the local copy are checked against manifest entries before being uploaded.
+// Streams with manifests lacking file entries can only be repaired from a complete local copy matching the stream checksum.
+func (lib *Library) RepairStream(tid, dir string) ([]RepairResult, error) {
+	ctx := context.Background()
+	v, err := lib.db.GetVideoByTID(ctx, tid)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrStreamNotFound
+		}
+		return nil, err
+	}
+	if !v.Manifest.Valid {
+		return nil, errors.New("stream has no manifest")
+	}
+	m, err := DecodeManifestJSON(v.Manifest.RawMessage)
+	if err != nil {
+		return nil, err
+	}
+	open := func(name string) (io.ReadCloser, error) {
+		return os.Open(path.Join(dir, name))
+	}
+	if len(m.Entries) == 0 {
+		if v.Checksum.Valid {
+			m.Checksum = v.Checksum.String
+		}
+		if err := migrateFileEntries(m, open); err != nil {
+			return nil, errors.Wrap(err, "cannot use local copy")
+		}
+	}
+
+	locs, err := lib.db.GetVideoLocations(ctx, tid)
+	if err != nil {
+		return nil, err
+	}
+	results := []RepairResult{}
+	for _, l := range locs {
+		s, ok := lib.getStorage(l.Storage).(RepairableStorage)
+		if !ok {
+			continue
+		}
+		r := RepairResult{TID: tid, Storage: l.Storage}
+		r.Repaired, r.Err = repairFiles(s, l.Path, m.Entries, open)
+		if r.Err != nil {
+			lib.log.Warn("stream repair failed", "tid", tid, "storage", l.Storage, "repaired", r.Repaired, "err", r.Err)
+		} else if len(r.Repaired) > 0 {
+			lib.log.Info("stream repaired", "tid", tid, "storage", l.Storage, "repaired", r.Repaired)
+		}
+		results = append(results, r)
+	}
+	if len(results) == 0 {
+		return nil, errors.New("no repairable location found")
+	}
+	return results, nil
+}
+
+// repairFiles replaces stored files not matching their entries with local ones, returning names of replaced files.
+func repairFiles(s RepairableStorage, streamPath string, entries []FileEntry, open FileOpener) ([]string, error) {
+	repaired := []string{}
+	for _, e := range entries {
+		if storedFileValid(s, streamPath, e) {
+			continue
+		}
+		if err := putLocalFile(s, streamPath, e, open); err != nil {
+			return repaired, errors.Wrapf(err, "cannot repair %s", e.Name)
+		}
+		repaired = append(repaired, e.Name)
+	}
+	return repaired, nil
+}
+
+func storedFileValid(s FragmentStorage, streamPath string, e FileEntry) bool {
+	r, err := s.GetFragment(streamPath, e.Name)
+	if err != nil {
+		return false
+	}
+	defer r.Close()
+	actual, err := HashFile(e.Name, r)
+	return err == nil && actual == e
+}
+
+// putLocalFile uploads a local file after making sure it matches its entry.
+func putLocalFile(s RepairableStorage, streamPath string, e FileEntry, open FileOpener) error {
+	r, err := open(e.Name)
+	if err != nil {
+		return err
+	}
+	actual, err := HashFile(e.Name, r)
+	r.Close()
+	if err != nil {
+		return err
+	}
+	if actual != e {
+		return errors.New("local copy does not match manifest")
+	}
+	r, err = open(e.Name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return s.PutFile(streamPath, e.Name, r)
+}
@@ -0,0 +1,101 @@
+package library
+
+import (
+	"io"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/lbryio/transcoder/pkg/logging/zapadapter"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func (s dirStorage) PutFile(streamTID, name string, r io.Reader) error {
+	f, err := os.Create(path.Join(s.dir, streamTID, name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, r)
+	return err
+}
+
+// copyStream copies stream files to a new dir, as a worker would have them retained.
+func copyStream(t *testing.T, src, dst string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(dst, os.ModePerm))
+	entries, err := os.ReadDir(src)
+	require.NoError(t, err)
+	for _, e := range entries {
+		data, err := os.ReadFile(path.Join(src, e.Name()))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(path.Join(dst, e.Name()), data, 0644))
+	}
+}
+
+func appendToFile(t *testing.T, name string, data []byte) {
+	t.Helper()
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+func TestRepairFiles(t *testing.T) {
+	dir := t.TempDir()
+	stream := storeDummyStream(t, dir)
+	local := path.Join(t.TempDir(), stream.TID())
+	copyStream(t, stream.LocalPath, local)
+	open := func(name string) (io.ReadCloser, error) {
+		return os.Open(path.Join(local, name))
+	}
+	s := dirStorage{NewDummyStorage("local", ""), dir}
+
+	repaired, err := repairFiles(s, stream.TID(), stream.Manifest.Entries, open)
+	require.NoError(t, err)
+	assert.Empty(t, repaired)
+
+	appendToFile(t, path.Join(dir, stream.TID(), "s1_000003.ts"), []byte{1})
+	require.NoError(t, os.Remove(path.Join(dir, stream.TID(), "stream_2.m3u8")))
+	repaired, err = repairFiles(s, stream.TID(), stream.Manifest.Entries, open)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"s1_000003.ts", "stream_2.m3u8"}, repaired)
+
+	checksum, entries, err := remoteChecksum(s, stream.TID())
+	require.NoError(t, err)
+	assert.Equal(t, stream.Checksum(), checksum)
+	assert.Empty(t, corruptFiles(stream.Manifest.EntryMap(), entries))
+
+	// Broken local copy must not be uploaded.
+	appendToFile(t, path.Join(dir, stream.TID(), "s0_000000.ts"), []byte{1})
+	appendToFile(t, path.Join(local, "s0_000000.ts"), []byte{2})
+	repaired, err = repairFiles(s, stream.TID(), stream.Manifest.Entries, open)
+	require.Error(t, err)
+	assert.Empty(t, repaired)
+}
+
+func (s *librarySuite) TestRepairStream() {
+	dir := s.T().TempDir()
+	lib := New(Config{DB: s.DB, Storage: dirStorage{NewDummyStorage("local", ""), dir}, Log: zapadapter.NewKV(nil)})
+	stream := storeDummyStream(s.T(), dir)
+	s.Require().NoError(lib.AddRemoteStream(*stream))
+	local := path.Join(s.T().TempDir(), stream.TID())
+	copyStream(s.T(), stream.LocalPath, local)
+
+	appendToFile(s.T(), path.Join(dir, stream.TID(), "s1_000003.ts"), []byte{1})
+	results, err := lib.RepairStream(stream.TID(), local)
+	s.Require().NoError(err)
+	s.Require().Len(results, 1)
+	s.NoError(results[0].Err)
+	s.Equal([]string{"s1_000003.ts"}, results[0].Repaired)
+
+	verified, err := lib.VerifyStream(stream.TID())
+	s.Require().NoError(err)
+	s.True(verified[0].Valid())
+
+	_, err = lib.RepairStream("nonexistent", local)
+	s.ErrorIs(err, ErrStreamNotFound)
+}
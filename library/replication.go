@@ -0,0 +1,276 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/lbryio/transcoder/library/db"
+
+	"github.com/pkg/errors"
+)
+
+type ReplicationPolicy string
+
+const (
+	// ReplicateAlways keeps a copy of every stream in the library on the replica.
+	ReplicateAlways ReplicationPolicy = "always"
+	// ReplicateOnRetire copies streams to the replica when they are retired from the primary storage,
+	// making it a cold tier for streams no longer popular enough to be kept on the primary one.
+	ReplicateOnRetire ReplicationPolicy = "retire"
+)
+
+// Replica is a storage holding copies of streams uploaded to the primary storage.
+type Replica struct {
+	Storage Storage
+	Policy  ReplicationPolicy
+}
+
+func ParseReplicationPolicy(p string) (ReplicationPolicy, error) {
+	switch ReplicationPolicy(p) {
+	case ReplicateAlways, ReplicateOnRetire:
+		return ReplicationPolicy(p), nil
+	case "":
+		return ReplicateAlways, nil
+	}
+	return "", fmt.Errorf("unknown replication policy: %s", p)
+}
+
+// getStorage returns a configured storage by name or nil.
+func (lib *Library) getStorage(name string) Storage {
+	if lib.storage != nil && lib.storage.Name() == name {
+		return lib.storage
+	}
+	for _, r := range lib.replicas {
+		if r.Storage.Name() == name {
+			return r.Storage
+		}
+	}
+	return nil
+}
+
+// storageRank orders storages by preference for serving streams: the primary one comes first,
+// then replicas in the configured order and storages not configured in this library last.
+func (lib *Library) storageRank(name string) int {
+	if lib.storage != nil && lib.storage.Name() == name {
+		return 0
+	}
+	for i, r := range lib.replicas {
+		if r.Storage.Name() == name {
+			return i + 1
+		}
+	}
+	return len(lib.replicas) + 1
+}
+
+// bestLocation picks the most preferred location of the video.
+func (lib *Library) bestLocation(v db.Video) (db.VideoLocation, error) {
+	locs, err := lib.db.GetVideoLocations(context.Background(), v.TID)
+	if err != nil {
+		return db.VideoLocation{}, err
+	}
+	if len(locs) == 0 {
+		return db.VideoLocation{}, ErrStreamNotFound
+	}
+	best := locs[0]
+	for _, l := range locs[1:] {
+		if lib.storageRank(l.Storage) < lib.storageRank(best.Storage) {
+			best = l
+		}
+	}
+	return best, nil
+}
+
+// removeLocation forgets the video copy on the storage, archiving the video with the reason
+// if it was the last one. keptOn is the storage holding archived stream files, if any.
+func (lib *Library) removeLocation(tid, storageName, reason string, keptOn ArchivingStorage) error {
+	ctx := context.Background()
+	err := lib.db.DeleteVideoLocation(ctx, db.DeleteVideoLocationParams{TID: tid, Storage: storageName})
+	if err != nil {
+		return err
+	}
+	locs, err := lib.db.GetVideoLocations(ctx, tid)
+	if err != nil {
+		return err
+	}
+	if len(locs) == 0 {
+		return lib.archiveVideo(tid, reason, keptOn)
+	}
+	return nil
+}
+
+// retireFrom deletes the video from a single storage.
+func (lib *Library) retireFrom(v db.Video, storageName string) error {
+	ll := lib.log.With("tid", v.TID, "sd_hash", v.SDHash, "storage", storageName)
+
+	s := lib.getStorage(storageName)
+	if s == nil {
+		return fmt.Errorf("storage %s is not configured", storageName)
+	}
+	if s == lib.storage {
+		for _, r := range lib.replicas {
+			if r.Policy != ReplicateOnRetire {
+				continue
+			}
+			if err := lib.replicate(v, r.Storage); err != nil {
+				ll.Warn("failed to move video to cold storage", "replica", r.Storage.Name(), "err", err)
+				return err
+			}
+		}
+	}
+
+	locs, err := lib.db.GetVideoLocations(context.Background(), v.TID)
+	if err != nil {
+		return err
+	}
+	// Files of the last copy are archived if the storage supports it.
+	var keptOn ArchivingStorage
+	if len(locs) == 1 {
+		keptOn = archivingStorage(s)
+	}
+	for _, l := range locs {
+		if l.Storage != storageName {
+			continue
+		}
+		if keptOn != nil {
+			err = keptOn.Archive(l.Path)
+		} else {
+			err = s.Delete(l.Path)
+		}
+		if err != nil {
+			ll.Warn("failed to delete remote video", "err", err)
+			return err
+		}
+	}
+	if err := lib.removeLocation(v.TID, storageName, ArchiveReasonRetired, keptOn); err != nil {
+		ll.Warn("failed to delete video location", "err", err)
+		return err
+	}
+	ll.Info("video retired", "url", v.URL, "size", v.Size, "age", v.CreatedAt, "accessed", v.AccessedAt)
+	return nil
+}
+
+// ReplicateVideos copies up to limit videos missing from each replica with ReplicateAlways policy.
+// Returns the number of videos copied.
+func (lib *Library) ReplicateVideos(limit int32) (int, error) {
+	var copied int
+	for _, r := range lib.replicas {
+		if r.Policy != ReplicateAlways {
+			continue
+		}
+		items, err := lib.db.GetVideosMissingFromStorage(
+			context.Background(),
+			db.GetVideosMissingFromStorageParams{Storage: r.Storage.Name(), Limit: limit},
+		)
+		if err != nil {
+			return copied, err
+		}
+		for _, v := range items {
+			if err := lib.replicate(v, r.Storage); err != nil {
+				lib.log.Warn("failed to replicate video", "tid", v.TID, "replica", r.Storage.Name(), "err", err)
+				continue
+			}
+			copied++
+		}
+	}
+	return copied, nil
+}
+
+// replicate copies the video from its best location to dst, unless it is already there.
+func (lib *Library) replicate(v db.Video, dst Storage) error {
+	locs, err := lib.db.GetVideoLocations(context.Background(), v.TID)
+	if err != nil {
+		return err
+	}
+	var src *db.VideoLocation
+	for i, l := range locs {
+		if l.Storage == dst.Name() {
+			return nil
+		}
+		if _, ok := lib.getStorage(l.Storage).(FragmentStorage); !ok {
+			continue
+		}
+		if src == nil || lib.storageRank(l.Storage) < lib.storageRank(src.Storage) {
+			src = &locs[i]
+		}
+	}
+	if src == nil {
+		return errors.New("no readable location found")
+	}
+	if !v.Manifest.Valid {
+		return errors.New("video has no manifest")
+	}
+	m, err := DecodeManifestJSON(v.Manifest.RawMessage)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.MkdirTemp("", "replica-"+v.TID+"-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	srcStorage := lib.getStorage(src.Storage).(FragmentStorage)
+	get := func(name string) (io.ReadCloser, error) {
+		return srcStorage.GetFragment(src.Path, name)
+	}
+	saved := map[string]bool{}
+	err = WalkStream(
+		"",
+		func(p ...string) (io.ReadCloser, error) { return get(p[len(p)-1]) },
+		func(name string, r io.ReadCloser) error {
+			saved[path.Base(name)] = true
+			return saveFragment(tmp, name, r)
+		},
+	)
+	if err != nil {
+		return errors.Wrapf(err, "cannot download stream from %s", src.Storage)
+	}
+	// Manifest lists files not referenced by playlists, like sprites, which the replica has to carry as well.
+	for _, name := range append(m.Files, ManifestName) {
+		if saved[name] {
+			continue
+		}
+		r, err := get(name)
+		if err != nil {
+			return errors.Wrapf(err, "cannot download %s from %s", name, src.Storage)
+		}
+		err = saveFragment(tmp, name, r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+		saved[name] = true
+	}
+
+	stream := InitStream(tmp, dst.Name())
+	stream.Manifest = m
+	if err := dst.Put(stream, true); err != nil {
+		return errors.Wrapf(err, "cannot upload stream to %s", dst.Name())
+	}
+	err = lib.db.AddVideoLocation(context.Background(), db.AddVideoLocationParams{
+		TID:     v.TID,
+		Storage: dst.Name(),
+		Path:    v.TID,
+	})
+	if err != nil {
+		return err
+	}
+	lib.log.Info("video replicated", "tid", v.TID, "from", src.Storage, "to", dst.Name())
+	return nil
+}
+
+func saveFragment(dir, name string, r io.Reader) error {
+	f, err := os.Create(path.Join(dir, path.Base(name)))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
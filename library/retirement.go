@@ -0,0 +1,166 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lbryio/transcoder/library/db"
+)
+
+// RetirementScorer rates how much a video is worth retiring, videos with higher scores are retired first.
+type RetirementScorer func(v db.Video, now time.Time) float64
+
+const (
+	ScorerLRU  = "lru"
+	ScorerLFU  = "lfu"
+	ScorerSize = "size"
+)
+
+// channelPriorityFactors scale video scores by priority of their channel.
+var channelPriorityFactors = map[db.ChannelPriority]float64{
+	db.ChannelPriorityHigh:     0.25,
+	db.ChannelPriorityNormal:   1,
+	db.ChannelPriorityLow:      4,
+	db.ChannelPriorityDisabled: 4,
+}
+
+// RetirementPolicy decides which videos are retired from a storage first.
+type RetirementPolicy struct {
+	// Scorer is LRUScorer if not set.
+	Scorer RetirementScorer
+	// GracePeriod protects videos added less than this long ago from being retired.
+	GracePeriod time.Duration
+	// ChannelPriority makes videos of high priority channels retired later and of low priority ones sooner.
+	ChannelPriority bool
+	// DryRun makes library maintenance only log videos it would retire.
+	DryRun bool
+}
+
+// RetirementPlan lists videos to retire from a storage to bring it down to the size limit.
+type RetirementPlan struct {
+	Storage     string
+	Videos      []db.Video
+	TotalSize   uint64
+	RetiredSize uint64
+}
+
+// LRUScorer prefers videos which have not been accessed for the longest time.
+func LRUScorer(v db.Video, now time.Time) float64 {
+	return now.Sub(v.AccessedAt).Hours()
+}
+
+// LFUScorer prefers videos accessed the least number of times.
+func LFUScorer(v db.Video, _ time.Time) float64 {
+	return 1 / float64(1+v.AccessCount.Int32)
+}
+
+// SizeScorer prefers large videos which have not been accessed for a long time.
+func SizeScorer(v db.Video, now time.Time) float64 {
+	return LRUScorer(v, now) * float64(v.Size) / (1 << 30)
+}
+
+func ParseRetirementScorer(name string) (RetirementScorer, error) {
+	switch name {
+	case ScorerLRU, "":
+		return LRUScorer, nil
+	case ScorerLFU:
+		return LFUScorer, nil
+	case ScorerSize:
+		return SizeScorer, nil
+	}
+	return nil, fmt.Errorf("unknown retirement scorer: %s", name)
+}
+
+// order returns videos eligible for retirement, the ones to be retired first going first.
+// Ties are broken by access time so the least recently used videos are retired sooner.
+func (p RetirementPolicy) order(videos []db.Video, priority func(channel string) db.ChannelPriority, now time.Time) []db.Video {
+	scorer := p.Scorer
+	if scorer == nil {
+		scorer = LRUScorer
+	}
+	type scored struct {
+		db.Video
+		score float64
+	}
+	candidates := []scored{}
+	for _, v := range videos {
+		if p.GracePeriod > 0 && now.Sub(v.CreatedAt) < p.GracePeriod {
+			continue
+		}
+		score := scorer(v, now)
+		if p.ChannelPriority && priority != nil {
+			if f, ok := channelPriorityFactors[priority(v.Channel)]; ok {
+				score *= f
+			}
+		}
+		candidates = append(candidates, scored{v, score})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score > candidates[j].score
+		}
+		return candidates[i].AccessedAt.Before(candidates[j].AccessedAt)
+	})
+	ordered := make([]db.Video, len(candidates))
+	for i, c := range candidates {
+		ordered[i] = c.Video
+	}
+	return ordered
+}
+
+// PlanRetirement returns videos RetireVideos would retire from the storage without retiring them.
+func (lib *Library) PlanRetirement(storageName string, maxSize uint64) (*RetirementPlan, error) {
+	plan := &RetirementPlan{Storage: storageName, Videos: []db.Video{}}
+	var err error
+	plan.TotalSize, plan.RetiredSize, err = lib.tailStorage(storageName, maxSize, func(v db.Video) error {
+		plan.Videos = append(plan.Videos, v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+func (lib *Library) tailStorage(storageName string, maxSize uint64, call func(v db.Video) error) (uint64, uint64, error) {
+	// Pending access events affect the order of retirement.
+	if err := lib.FlushAccess(); err != nil {
+		lib.log.Warn("failed to flush video access events", "err", err)
+	}
+	items, err := lib.db.GetAllVideosForStorage(context.Background(), storageName)
+	if err != nil {
+		return 0, 0, err
+	}
+	var priority func(string) db.ChannelPriority
+	if lib.retirement.ChannelPriority {
+		priority, err = lib.channelPriorities()
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return tailVideos(items, lib.retirement.order(items, priority, time.Now()), maxSize, call)
+}
+
+// channelPriorities returns a function looking up channel priority by channel URL,
+// which is matched either entirely or by its claim ID part.
+func (lib *Library) channelPriorities() (func(string) db.ChannelPriority, error) {
+	channels, err := lib.db.GetAllChannels(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return func(url string) db.ChannelPriority {
+		var claimID string
+		if i := strings.Index(url, "#"); i >= 0 {
+			claimID = url[i+1:]
+		}
+		for _, c := range channels {
+			if c.URL == url || (claimID != "" && strings.HasPrefix(c.ClaimID, claimID)) {
+				return c.Priority
+			}
+		}
+		return db.ChannelPriorityNormal
+	}, nil
+}
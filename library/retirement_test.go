@@ -0,0 +1,48 @@
+package library
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/lbryio/transcoder/library/db"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetirementOrder(t *testing.T) {
+	now := time.Now()
+	vs := []db.Video{
+		{TID: "old", Size: 1 << 30, CreatedAt: now.Add(-90 * 24 * time.Hour), AccessedAt: now.Add(-48 * time.Hour), AccessCount: sql.NullInt32{Int32: 100, Valid: true}},
+		{TID: "large", Size: 10 << 30, CreatedAt: now.Add(-90 * 24 * time.Hour), AccessedAt: now.Add(-24 * time.Hour), AccessCount: sql.NullInt32{Int32: 50, Valid: true}},
+		{TID: "unpopular", Size: 1 << 30, CreatedAt: now.Add(-90 * 24 * time.Hour), AccessedAt: now.Add(-1 * time.Hour), AccessCount: sql.NullInt32{Int32: 1, Valid: true}},
+		{TID: "fresh", Size: 1 << 30, CreatedAt: now.Add(-1 * time.Hour), AccessedAt: now.Add(-1 * time.Hour), Channel: "lbry://@low#ab"},
+	}
+	tids := func(vs []db.Video) []string {
+		r := []string{}
+		for _, v := range vs {
+			r = append(r, v.TID)
+		}
+		return r
+	}
+
+	assert.Equal(t, []string{"old", "large", "unpopular", "fresh"}, tids(RetirementPolicy{}.order(vs, nil, now)))
+	assert.Equal(t, []string{"fresh", "unpopular", "large", "old"}, tids(RetirementPolicy{Scorer: LFUScorer}.order(vs, nil, now)))
+	assert.Equal(t, []string{"large", "old", "unpopular", "fresh"}, tids(RetirementPolicy{Scorer: SizeScorer}.order(vs, nil, now)))
+	assert.Equal(t, []string{"old", "large", "unpopular"}, tids(RetirementPolicy{GracePeriod: 7 * 24 * time.Hour}.order(vs, nil, now)))
+
+	priority := func(c string) db.ChannelPriority {
+		if c == "lbry://@low#ab" {
+			return db.ChannelPriorityLow
+		}
+		return db.ChannelPriorityNormal
+	}
+	p := RetirementPolicy{Scorer: LFUScorer, ChannelPriority: true}
+	assert.Equal(t, []string{"fresh", "unpopular", "large", "old"}, tids(p.order(vs, priority, now)))
+	p = RetirementPolicy{ChannelPriority: true}
+	assert.Equal(t, []string{"old", "large", "fresh", "unpopular"}, tids(p.order(vs, priority, now)))
+
+	_, err := ParseRetirementScorer("mru")
+	require.Error(t, err)
+}
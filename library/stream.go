@@ -1,6 +1,7 @@
 package library
 
 import (
+	"crypto/rand"
 	"crypto/sha1"
 	"crypto/sha512"
 	"encoding/hex"
@@ -17,7 +18,6 @@ import (
 	"github.com/karrick/godirwalk"
 	"github.com/lbryio/transcoder/ladder"
 	"github.com/pkg/errors"
-	"gopkg.in/yaml.v3"
 )
 
 const (
@@ -29,8 +29,6 @@ const (
 	FragmentContentType = "video/mp2t"
 
 	SkipChecksum = "SkipChecksumForThisStream"
-
-	tidTimestampFormat = "2006-01-02T15:04"
 )
 
 var SDHashRe = regexp.MustCompile(`/([A-Za-z0-9]{96})/`)
@@ -42,6 +40,9 @@ type Stream struct {
 }
 
 type Manifest struct {
+	// Schema is the manifest format version, see ManifestSchema.
+	Schema int `yaml:"schema,omitempty" json:"schema,omitempty"`
+
 	URL        string
 	ChannelURL string `yaml:",omitempty" json:"channel_url"`
 	SDHash     string
@@ -50,14 +51,24 @@ type Manifest struct {
 	TranscodedBy string    `yaml:"transcoded_by,omitempty" json:"transcoded_by"`
 	TranscodedAt time.Time `yaml:"transcoded_at,omitempty" json:"transcoded_at"`
 	Version      string    `yaml:",omitempty"`
+	// InputSize is the size of the source file the stream was transcoded from.
+	InputSize int64 `yaml:"input_size,omitempty" json:"input_size,omitempty"`
 
 	// Auto-filled attributes
 	TID      string `yaml:",omitempty"`
 	Size     int64  `yaml:",omitempty"`
 	Checksum string `yaml:",omitempty"`
 
-	Ladder ladder.Ladder `yaml:",omitempty"`
-	Files  []string      `yaml:",omitempty"`
+	Ladder  ladder.Ladder         `yaml:",omitempty"`
+	Quality []ladder.QualityScore `yaml:",omitempty" json:",omitempty"`
+	Files   []string              `yaml:",omitempty"`
+	// Entries are sizes and hashes of Files.
+	Entries []FileEntry `yaml:",omitempty" json:",omitempty"`
+	// Encryption is set for streams with encrypted segments.
+	Encryption *Encryption `yaml:",omitempty" json:",omitempty"`
+
+	// Extra keeps fields unknown to this version so they survive rewriting manifests of newer schemas.
+	Extra map[string]interface{} `yaml:",inline" json:"-"`
 }
 
 type StreamWalker func(fi fs.FileInfo, fullPath, name string) error
@@ -68,6 +79,20 @@ func WithTimestamp(ts time.Time) func(*Manifest) {
 	}
 }
 
+// WithEncryption records the encryption method and key ID of a stream with encrypted segments.
+func WithEncryption(e *Encryption) func(*Manifest) {
+	return func(m *Manifest) {
+		m.Encryption = e
+	}
+}
+
+// WithTID sets the stream TID instead of generating a new one, for streams served from a folder named after it.
+func WithTID(tid string) func(*Manifest) {
+	return func(m *Manifest) {
+		m.TID = tid
+	}
+}
+
 func WithWorkerName(n string) func(*Manifest) {
 	return func(m *Manifest) {
 		m.TranscodedBy = n
@@ -80,6 +105,20 @@ func WithVersion(v string) func(*Manifest) {
 	}
 }
 
+// WithInputSize records the size of the transcoded source file.
+func WithInputSize(size int64) func(*Manifest) {
+	return func(m *Manifest) {
+		m.InputSize = size
+	}
+}
+
+// WithQualityScores records per-tier quality scores obtained after encoding.
+func WithQualityScores(scores []ladder.QualityScore) func(*Manifest) {
+	return func(m *Manifest) {
+		m.Quality = scores
+	}
+}
+
 func GetStreamHasher() hash.Hash {
 	return sha512.New512_224()
 }
@@ -89,10 +128,15 @@ func InitStream(dir string, remoteStorage string) *Stream {
 	return &s
 }
 
+// generateTID makes an ID unique to each transcoded version of the stream, so versions of the same SD hash
+// never share a storage prefix. A random nonce is hashed in along with the timestamp, which may be unset or coarse.
 func (s *Stream) generateTID() string {
+	nonce := make([]byte, 8)
+	rand.Read(nonce)
 	h := sha1.New()
 	h.Write([]byte(s.SDHash()))
-	h.Write([]byte(s.Manifest.TranscodedAt.Format(tidTimestampFormat)))
+	h.Write([]byte(s.Manifest.TranscodedAt.Format(time.RFC3339Nano)))
+	h.Write(nonce)
 	return hex.EncodeToString(h.Sum(nil))
 }
 
@@ -100,6 +144,7 @@ func (s *Stream) generateTID() string {
 func (s *Stream) GenerateManifest(url, channel, sdHash string, manifestFuncs ...func(*Manifest)) error {
 	var err error
 	m := &Manifest{
+		Schema:     ManifestSchema,
 		URL:        url,
 		ChannelURL: channel,
 		SDHash:     sdHash,
@@ -110,18 +155,32 @@ func (s *Stream) GenerateManifest(url, channel, sdHash string, manifestFuncs ...
 	}
 
 	s.Manifest = m
-	s.Manifest.TID = s.generateTID()
+	if m.TID == "" {
+		m.TID = s.generateTID()
+	}
 
 	m.Files, m.Size, err = s.getFileList()
 	if err != nil {
 		return errors.Wrap(err, "cannot calculate size")
 	}
-	m.Checksum, err = s.generateChecksum()
+	var entries map[string]FileEntry
+	m.Checksum, entries, err = hashStream(func(name string) (io.ReadCloser, error) {
+		return openFile(s.LocalPath, name)
+	})
 	if err != nil {
 		return errors.Wrap(err, "cannot calculate checksum")
 	}
+	m.Entries, err = s.fileEntries(m.Files, entries)
+	if err != nil {
+		return errors.Wrap(err, "cannot hash stream files")
+	}
+
+	return s.writeManifest()
+}
 
-	d, err := yaml.Marshal(s.Manifest)
+// writeManifest saves the manifest into the stream folder.
+func (s *Stream) writeManifest() error {
+	d, err := s.Manifest.EncodeYAML()
 	if err != nil {
 		return err
 	}
@@ -149,23 +208,25 @@ func (s *Stream) TID() string {
 	return s.Manifest.TID
 }
 
-func (s *Stream) generateChecksum() (string, error) {
-	hash := GetStreamHasher()
-	err := WalkStream(
-		s.LocalPath,
-		openFile,
-		func(_ string, r io.ReadCloser) error {
-			_, err := io.Copy(hash, r)
+// fileEntries returns sizes and hashes of stream files, hashing the ones not already known.
+func (s *Stream) fileEntries(names []string, known map[string]FileEntry) ([]FileEntry, error) {
+	entries := []FileEntry{}
+	for _, name := range names {
+		e, ok := known[name]
+		if !ok {
+			f, err := openFile(s.LocalPath, name)
 			if err != nil {
-				return err
+				return nil, err
 			}
-			return nil
-		},
-	)
-	if err != nil {
-		return "", err
+			e, err = HashFile(name, f)
+			f.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, e)
 	}
-	return hex.EncodeToString(hash.Sum(nil)), nil
+	return entries, nil
 }
 
 func (s *Stream) getFileList() ([]string, int64, error) {
@@ -220,14 +281,13 @@ func (s *Stream) ChecksumValid(checksum string) bool {
 }
 
 func (s *Stream) ReadManifest() error {
-	m := &Manifest{}
 	d, err := os.ReadFile(path.Join(s.LocalPath, ManifestName))
 	if err != nil {
 		return errors.Wrap(err, "cannot read manifest file")
 	}
-	err = yaml.Unmarshal(d, m)
+	m, err := DecodeManifest(d)
 	if err != nil {
-		return errors.Wrap(err, "cannot unmarshal manifest")
+		return err
 	}
 	s.Manifest = m
 	return nil
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/Pallinder/go-randomdata"
+	"github.com/lbryio/transcoder/ladder"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -38,14 +39,19 @@ func TestStream(t *testing.T) {
 			stream1.GenerateManifest(randomdata.SillyName(), randomdata.SillyName(), sdHash, WithTimestamp(ts)),
 		)
 
+		// Versions of the same stream made at the same time must not share a storage prefix.
 		stream2 := InitStream(path.Join(dir, sdHash), "")
 		require.NoError(t,
 			stream2.GenerateManifest(stream1.URL(), stream1.Manifest.ChannelURL, stream1.SDHash(), WithTimestamp(ts)),
 		)
-		err := stream1.GenerateManifest(randomdata.SillyName(), randomdata.SillyName(), sdHash, WithTimestamp(ts))
-		require.NoError(t, err)
 
-		assert.Equal(t, stream1.Manifest.TID, stream2.Manifest.TID)
+		assert.NotEqual(t, stream1.Manifest.TID, stream2.Manifest.TID)
+
+		stream3 := InitStream(path.Join(dir, sdHash), "")
+		require.NoError(t,
+			stream3.GenerateManifest(stream1.URL(), stream1.Manifest.ChannelURL, stream1.SDHash(), WithTID(sdHash)),
+		)
+		assert.Equal(t, sdHash, stream3.TID())
 	})
 
 	t.Run("WithManifestOptions", func(t *testing.T) {
@@ -57,6 +63,10 @@ func TestStream(t *testing.T) {
 		url := randomdata.SillyName()
 		channelURL := randomdata.SillyName()
 
+		scores := []ladder.QualityScore{
+			{Definition: ladder.D720p, Width: 1280, Height: 720, Metric: ladder.QualityVMAF, Score: 92.5, Samples: 3},
+		}
+
 		stream := InitStream(path.Join(dir, sdHash), "")
 		require.NoError(t,
 			stream.GenerateManifest(
@@ -64,6 +74,7 @@ func TestStream(t *testing.T) {
 				WithTimestamp(ts),
 				WithVersion(version),
 				WithWorkerName(workerName),
+				WithQualityScores(scores),
 			),
 		)
 
@@ -73,5 +84,6 @@ func TestStream(t *testing.T) {
 		assert.Equal(t, url, stream.Manifest.URL)
 		assert.Equal(t, channelURL, stream.Manifest.ChannelURL)
 		assert.Equal(t, sdHash, stream.Manifest.SDHash)
+		assert.Equal(t, scores, stream.Manifest.Quality)
 	})
 }
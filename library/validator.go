@@ -1,27 +1,48 @@
 package library
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"net/http"
 	"path"
+	"sort"
 	"strings"
+	"sync"
 
+	"github.com/lbryio/transcoder/library/db"
+
+	"github.com/panjf2000/ants/v2"
 	"github.com/pkg/errors"
 )
 
 type ValidationResult struct {
 	URL              string
 	Present, Missing []string
+	// Corrupt lists present files not matching sizes or hashes recorded in the stream manifest.
+	Corrupt []string
 }
 
+// ValidateStream checks that stream files are available over HTTP. If the stream manifest records file sizes and hashes,
+// playlists are checked against hashes and segments against sizes reported by the server.
 func ValidateStream(baseURL string, failFast bool, skipSegments bool) (*ValidationResult, error) {
 	vr := &ValidationResult{
 		URL:     baseURL,
 		Missing: []string{},
 		Present: []string{},
+		Corrupt: []string{},
 	}
 	baseURL = strings.TrimSuffix(baseURL, "/")
 
+	var entries map[string]FileEntry
+	if m, err := fetchManifest(baseURL + "/" + ManifestName); err == nil {
+		entries = m.EntryMap()
+	} else {
+		logger.Debugf("manifest not available: %v", err)
+	}
+	// Sizes of segments checked with HEAD requests.
+	sizes := map[string]int64{}
+
 	err := WalkStream(baseURL,
 		func(p ...string) (io.ReadCloser, error) {
 			var (
@@ -37,13 +58,17 @@ func ValidateStream(baseURL string, failFast bool, skipSegments bool) (*Validati
 			} else {
 				r, err = http.Get(url)
 			}
-			logger.Debugf("checked %s [%v]", p, r.StatusCode)
 			if err != nil {
 				return nil, err
 			}
+			logger.Debugf("checked %s [%v]", p, r.StatusCode)
 			if r.StatusCode != http.StatusOK {
+				r.Body.Close()
 				return nil, nil
 			}
+			if r.Request.Method == http.MethodHead {
+				sizes[p[len(p)-1]] = r.ContentLength
+			}
 			return r.Body, nil
 		},
 		func(fgName string, r io.ReadCloser) error {
@@ -53,11 +78,184 @@ func ValidateStream(baseURL string, failFast bool, skipSegments bool) (*Validati
 				if failFast {
 					return errors.New("broken stream")
 				}
+				return nil
+			}
+			vr.Present = append(vr.Present, fgName)
+			e, ok := entries[fgName]
+			if !ok {
+				return nil
+			}
+			var valid bool
+			if size, ok := sizes[fgName]; ok {
+				valid = size < 0 || size == e.Size
 			} else {
-				vr.Present = append(vr.Present, fgName)
+				actual, err := HashFile(fgName, r)
+				if err != nil {
+					return err
+				}
+				valid = actual == e
+			}
+			if !valid {
+				logger.Debugf("corrupt: %s", fgName)
+				vr.Corrupt = append(vr.Corrupt, fgName)
+				if failFast {
+					return errors.New("broken stream")
+				}
 			}
 			return nil
 		},
 	)
 	return vr, err
 }
+
+func fetchManifest(url string) (*Manifest, error) {
+	r, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("manifest request failed: %v", r.Status)
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeManifest(data)
+}
+
+// ValidationReport summarizes validation of streams kept in a storage.
+type ValidationReport struct {
+	Valid []string
+	// Broken maps TIDs of incomplete streams to files missing in the storage.
+	Broken map[string][]string
+	// Failed maps TIDs of streams that could not be checked to errors.
+	Failed map[string]error
+}
+
+// ValidateStoredStream checks that all stream files are present in the storage. Playlists are read from the storage,
+// other files are checked against the storage listing, so segments are not downloaded.
+// If manifest lists stream files, they are expected to be present as well.
+func ValidateStoredStream(s ListingStorage, streamPath string, m *Manifest) (*ValidationResult, error) {
+	vr := &ValidationResult{
+		URL:     s.GetURL(streamPath),
+		Missing: []string{},
+		Present: []string{},
+	}
+	names, err := s.ListFiles(streamPath)
+	if err != nil {
+		return nil, err
+	}
+	stored := map[string]bool{}
+	for _, n := range names {
+		stored[n] = true
+	}
+
+	expected := map[string]bool{}
+	if m != nil {
+		for _, n := range m.Files {
+			expected[n] = true
+		}
+		expected[ManifestName] = true
+	}
+	// Walking stops at a missing playlist, which is reported along with other files found missing by then.
+	var missingPlaylist bool
+	err = WalkStream(
+		streamPath,
+		func(p ...string) (io.ReadCloser, error) {
+			name := p[len(p)-1]
+			if path.Ext(name) != PlaylistExt {
+				expected[name] = true
+				return nil, SkipSegment
+			}
+			if !stored[name] {
+				missingPlaylist = true
+				return nil, nil
+			}
+			return s.GetFragment(streamPath, name)
+		},
+		func(name string, _ io.ReadCloser) error {
+			expected[name] = true
+			return nil
+		},
+	)
+	if err != nil && !missingPlaylist {
+		return nil, err
+	}
+
+	for n := range expected {
+		if stored[n] {
+			vr.Present = append(vr.Present, n)
+		} else {
+			vr.Missing = append(vr.Missing, n)
+		}
+	}
+	sort.Strings(vr.Present)
+	sort.Strings(vr.Missing)
+	return vr, nil
+}
+
+// ValidateStreams checks that all files of streams kept in the storage are present.
+// If remove is set, broken stream copies are removed from the library.
+func (lib *Library) ValidateStreams(storageName string, offset, limit int32, remove bool) (*ValidationReport, error) {
+	s, ok := lib.getStorage(storageName).(ListingStorage)
+	if !ok {
+		return nil, fmt.Errorf("storage %s is not configured or cannot be listed", storageName)
+	}
+	items, err := lib.db.GetAllVideosForStorageLimit(
+		context.Background(),
+		db.GetAllVideosForStorageLimitParams{
+			Storage: storageName,
+			Offset:  offset,
+			Limit:   limit,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ValidationReport{Valid: []string{}, Broken: map[string][]string{}, Failed: map[string]error{}}
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	p, _ := ants.NewPoolWithFunc(10, func(i interface{}) {
+		defer wg.Done()
+		v := i.(db.Video)
+		var m *Manifest
+		if v.Manifest.Valid {
+			m, _ = DecodeManifestJSON(v.Manifest.RawMessage)
+		}
+		vr, err := ValidateStoredStream(s, v.Path, m)
+
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case err != nil:
+			report.Failed[v.TID] = err
+			lib.log.Info("stream validation failed", "tid", v.TID, "err", err)
+		case len(vr.Missing) > 0:
+			report.Broken[v.TID] = vr.Missing
+			lib.log.Info("broken stream", "tid", v.TID, "url", vr.URL, "missing", vr.Missing)
+		default:
+			report.Valid = append(report.Valid, v.TID)
+		}
+	})
+	defer p.Release()
+
+	for _, v := range items {
+		wg.Add(1)
+		_ = p.Invoke(v)
+	}
+	wg.Wait()
+
+	if remove {
+		for tid := range report.Broken {
+			err := lib.removeLocation(tid, storageName, ArchiveReasonBroken, nil)
+			if err != nil {
+				lib.log.Info("video removal failed", "tid", tid, "err", err)
+			} else {
+				lib.log.Info("video removed", "tid", tid, "storage", storageName)
+			}
+		}
+	}
+	return report, nil
+}
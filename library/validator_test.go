@@ -0,0 +1,71 @@
+package library
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/Pallinder/go-randomdata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateStoredStream(t *testing.T) {
+	dir := t.TempDir()
+	sdHash := randomdata.Alphanumeric(96)
+	PopulateHLSPlaylist(t, dir, sdHash)
+	stream := InitStream(path.Join(dir, sdHash), "")
+	require.NoError(t, stream.GenerateManifest("url", "channel", sdHash))
+	s := dirStorage{NewDummyStorage("local", "http://local"), dir}
+
+	vr, err := ValidateStoredStream(s, sdHash, stream.Manifest)
+	require.NoError(t, err)
+	assert.Empty(t, vr.Missing)
+	assert.Len(t, vr.Present, len(PopulatedHLSPlaylistFiles)+1)
+	assert.Equal(t, "http://local/"+sdHash, vr.URL)
+
+	require.NoError(t, os.Remove(path.Join(dir, sdHash, "s1_000003.ts")))
+	require.NoError(t, os.Remove(path.Join(dir, sdHash, ManifestName)))
+	vr, err = ValidateStoredStream(s, sdHash, stream.Manifest)
+	require.NoError(t, err)
+	assert.Equal(t, []string{ManifestName, "s1_000003.ts"}, vr.Missing)
+
+	require.NoError(t, os.Remove(path.Join(dir, sdHash, "stream_2.m3u8")))
+	vr, err = ValidateStoredStream(s, sdHash, nil)
+	require.NoError(t, err)
+	assert.Contains(t, vr.Missing, "stream_2.m3u8")
+	assert.NotContains(t, vr.Missing, ManifestName)
+}
+
+func TestValidateStream(t *testing.T) {
+	dir := t.TempDir()
+	sdHash := randomdata.Alphanumeric(96)
+	PopulateHLSPlaylist(t, dir, sdHash)
+	stream := InitStream(path.Join(dir, sdHash), "")
+	require.NoError(t, stream.GenerateManifest("url", "channel", sdHash))
+	ts := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer ts.Close()
+
+	vr, err := ValidateStream(ts.URL+"/"+sdHash+"/", false, false)
+	require.NoError(t, err)
+	assert.Empty(t, vr.Missing)
+	assert.Empty(t, vr.Corrupt)
+	assert.Len(t, vr.Present, len(PopulatedHLSPlaylistFiles))
+
+	f, err := os.OpenFile(path.Join(dir, sdHash, "s1_000003.ts"), os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{1})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	f, err = os.OpenFile(path.Join(dir, sdHash, "stream_2.m3u8"), os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("# changed\n"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	vr, err = ValidateStream(ts.URL+"/"+sdHash, false, false)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"s1_000003.ts", "stream_2.m3u8"}, vr.Corrupt)
+}
@@ -0,0 +1,158 @@
+package library
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/lbryio/transcoder/library/db"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrChecksumMismatch = errors.New("stream checksum mismatch")
+	ErrNoChecksum       = errors.New("stream has no checksum recorded")
+)
+
+// VerificationResult is an outcome of checking a stream copy kept on a single storage.
+type VerificationResult struct {
+	TID, Storage     string
+	Expected, Actual string
+	// Corrupt lists files not matching sizes and hashes recorded in the manifest.
+	Corrupt []string
+	Err     error
+}
+
+func (r VerificationResult) Valid() bool {
+	return r.Err == nil && r.Expected == r.Actual && len(r.Corrupt) == 0
+}
+
+// VerifyStream downloads every copy of the stream from storages it can be read from,
+// recomputes its checksum and compares it to the one recorded when the stream was added.
+// Returns ErrChecksumMismatch if any of the copies is broken.
+func (lib *Library) VerifyStream(tid string) ([]VerificationResult, error) {
+	v, err := lib.db.GetVideoByTID(context.Background(), tid)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrStreamNotFound
+		}
+		return nil, err
+	}
+	return lib.verifyVideo(v)
+}
+
+func (lib *Library) verifyVideo(v db.Video) ([]VerificationResult, error) {
+	if !v.Checksum.Valid || v.Checksum.String == "" || v.Checksum.String == SkipChecksum {
+		return nil, lib.skipVerification(v, ErrNoChecksum)
+	}
+	locs, err := lib.db.GetVideoLocations(context.Background(), v.TID)
+	if err != nil {
+		return nil, err
+	}
+
+	var expected map[string]FileEntry
+	if v.Manifest.Valid {
+		if m, err := DecodeManifestJSON(v.Manifest.RawMessage); err == nil {
+			expected = m.EntryMap()
+		}
+	}
+
+	results := []VerificationResult{}
+	valid := true
+	for _, l := range locs {
+		s, ok := lib.getStorage(l.Storage).(FragmentStorage)
+		if !ok {
+			continue
+		}
+		r := VerificationResult{TID: v.TID, Storage: l.Storage, Expected: v.Checksum.String}
+		var actual map[string]FileEntry
+		r.Actual, actual, r.Err = remoteChecksum(s, l.Path)
+		r.Corrupt = corruptFiles(expected, actual)
+		if !r.Valid() {
+			valid = false
+			ChecksumMismatches.Inc()
+			lib.log.Warn(
+				"stream verification failed",
+				"tid", v.TID, "storage", l.Storage, "actual", r.Actual, "corrupt", r.Corrupt, "err", r.Err,
+			)
+		}
+		results = append(results, r)
+	}
+	if len(results) == 0 {
+		return nil, lib.skipVerification(v, errors.New("no readable location found"))
+	}
+
+	err = lib.db.RecordVideoVerification(context.Background(), db.RecordVideoVerificationParams{
+		TID:           v.TID,
+		ChecksumValid: sql.NullBool{Bool: valid, Valid: true},
+	})
+	if err != nil {
+		return results, err
+	}
+	if !valid {
+		return results, ErrChecksumMismatch
+	}
+	return results, nil
+}
+
+// skipVerification records the video as verified with unknown result, so videos which cannot be verified
+// are not picked again before the ones which can.
+func (lib *Library) skipVerification(v db.Video, reason error) error {
+	err := lib.db.RecordVideoVerification(context.Background(), db.RecordVideoVerificationParams{
+		TID:           v.TID,
+		ChecksumValid: sql.NullBool{},
+	})
+	if err != nil {
+		return err
+	}
+	return reason
+}
+
+// VerifyStreams checks up to limit streams not verified during the last interval, least recently verified first.
+// Returns the number of streams checked and the number of broken ones.
+func (lib *Library) VerifyStreams(interval time.Duration, limit int32) (int, int, error) {
+	items, err := lib.db.GetVideosForVerification(context.Background(), db.GetVideosForVerificationParams{
+		VerifiedAt: sql.NullTime{Time: time.Now().Add(-interval), Valid: true},
+		Limit:      limit,
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	var checked, broken int
+	for _, v := range items {
+		_, err := lib.verifyVideo(v)
+		switch {
+		case errors.Is(err, ErrChecksumMismatch):
+			broken++
+		case err != nil:
+			lib.log.Info("stream verification error", "tid", v.TID, "err", err)
+			continue
+		}
+		checked++
+	}
+	return checked, broken, nil
+}
+
+// remoteChecksum hashes stream files in the same order as Stream.GenerateManifest does,
+// returning sizes and hashes of individual files along with the stream checksum.
+func remoteChecksum(s FragmentStorage, streamPath string) (string, map[string]FileEntry, error) {
+	return hashStream(func(name string) (io.ReadCloser, error) {
+		return s.GetFragment(streamPath, name)
+	})
+}
+
+// corruptFiles returns names of files which sizes or hashes differ from the expected ones.
+// Files not present in both sets are skipped.
+func corruptFiles(expected, actual map[string]FileEntry) []string {
+	corrupt := []string{}
+	for name, e := range expected {
+		if a, ok := actual[name]; ok && a != e {
+			corrupt = append(corrupt, name)
+		}
+	}
+	sort.Strings(corrupt)
+	return corrupt
+}
@@ -0,0 +1,125 @@
+package library
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/lbryio/transcoder/pkg/logging/zapadapter"
+
+	"github.com/Pallinder/go-randomdata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type dirStorage struct {
+	*DummyStorage
+	dir string
+}
+
+func (s dirStorage) GetFragment(streamTID, name string) (StreamFragment, error) {
+	return os.Open(path.Join(s.dir, streamTID, name))
+}
+
+func (s dirStorage) ListFiles(streamTID string) ([]string, error) {
+	entries, err := os.ReadDir(path.Join(s.dir, streamTID))
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names, nil
+}
+
+func (s dirStorage) ListStreams() ([]StoredStream, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	list := []StoredStream{}
+	for _, e := range entries {
+		list = append(list, StoredStream{TID: e.Name(), Size: 1000})
+	}
+	return list, nil
+}
+
+// storeDummyStream puts a stream into a storage dir under its TID.
+func storeDummyStream(t *testing.T, dir string) *Stream {
+	t.Helper()
+	sdHash := randomdata.Alphanumeric(96)
+	PopulateHLSPlaylist(t, dir, sdHash)
+	stream := InitStream(path.Join(dir, sdHash), "local")
+	require.NoError(t, stream.GenerateManifest(randomdata.SillyName(), randomdata.SillyName(), sdHash))
+	stream.LocalPath = path.Join(dir, stream.TID())
+	require.NoError(t, os.Rename(path.Join(dir, sdHash), stream.LocalPath))
+	return stream
+}
+
+func TestRemoteChecksum(t *testing.T) {
+	dir := t.TempDir()
+	sdHash := randomdata.Alphanumeric(96)
+	PopulateHLSPlaylist(t, dir, sdHash)
+
+	stream := InitStream(path.Join(dir, sdHash), "")
+	require.NoError(t, stream.GenerateManifest("url", "channel", sdHash))
+
+	s := dirStorage{NewDummyStorage("local", ""), dir}
+	checksum, entries, err := remoteChecksum(s, sdHash)
+	require.NoError(t, err)
+	assert.Equal(t, stream.Checksum(), checksum)
+	assert.Empty(t, corruptFiles(stream.Manifest.EntryMap(), entries))
+
+	f, err := os.OpenFile(path.Join(dir, sdHash, "s0_000000.ts"), os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{1})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	checksum, entries, err = remoteChecksum(s, sdHash)
+	require.NoError(t, err)
+	assert.NotEqual(t, stream.Checksum(), checksum)
+	assert.Equal(t, []string{"s0_000000.ts"}, corruptFiles(stream.Manifest.EntryMap(), entries))
+
+	r := VerificationResult{Expected: stream.Checksum(), Actual: checksum}
+	assert.False(t, r.Valid())
+}
+
+func (s *librarySuite) TestVerifyStreamsSkipsUnverifiable() {
+	dir := s.T().TempDir()
+	lib := New(Config{DB: s.DB, Storage: dirStorage{NewDummyStorage("local", ""), dir}, Log: zapadapter.NewKV(nil)})
+
+	for range [3]int{} {
+		skipped := storeDummyStream(s.T(), dir)
+		skipped.Manifest.Checksum = SkipChecksum
+		s.Require().NoError(lib.AddRemoteStream(*skipped))
+	}
+	for range [3]int{} {
+		unreadable := GenerateDummyStream()
+		unreadable.Manifest.Checksum = "checksum"
+		s.Require().NoError(lib.AddRemoteStream(*unreadable))
+	}
+	stream := storeDummyStream(s.T(), dir)
+	s.Require().NoError(lib.AddRemoteStream(*stream))
+
+	var checked int
+	for range [4]int{} {
+		n, _, err := lib.VerifyStreams(time.Hour, 2)
+		s.Require().NoError(err)
+		checked += n
+	}
+	s.Equal(1, checked)
+
+	v, err := lib.db.GetVideoByTID(context.Background(), stream.TID())
+	s.Require().NoError(err)
+	s.True(v.VerifiedAt.Valid)
+	s.Equal(sql.NullBool{Bool: true, Valid: true}, v.ChecksumValid)
+
+	n, _, err := lib.VerifyStreams(time.Hour, 2)
+	s.Require().NoError(err)
+	s.Zero(n, "all videos are verified within the interval")
+}
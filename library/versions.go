@@ -0,0 +1,58 @@
+package library
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lbryio/transcoder/library/db"
+
+	"github.com/pkg/errors"
+)
+
+// GetVideoVersions returns all versions of the video kept in the library, newest first.
+func (lib *Library) GetVideoVersions(sdHash string) ([]db.Video, error) {
+	return lib.db.GetVideoVersions(context.Background(), sdHash)
+}
+
+// ActivateVersion makes the video version with the TID served instead of the currently active one.
+func (lib *Library) ActivateVersion(tid string) error {
+	v, err := lib.db.GetVideoByTID(context.Background(), tid)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrStreamNotFound
+		}
+		return err
+	}
+	if v.Active {
+		return nil
+	}
+	return lib.inTx(func(q *db.Queries) error {
+		ctx := context.Background()
+		if err := q.SupersedeVideos(ctx, v.SDHash); err != nil {
+			return err
+		}
+		return q.ActivateVideo(ctx, tid)
+	})
+}
+
+// RetireSuperseded retires up to limit video versions superseded more than grace ago.
+// Returns the number of versions retired.
+func (lib *Library) RetireSuperseded(grace time.Duration, limit int32) (int, error) {
+	items, err := lib.db.GetSupersededVideos(context.Background(), db.GetSupersededVideosParams{
+		SupersededAt: sql.NullTime{Time: time.Now().Add(-grace), Valid: true},
+		Limit:        limit,
+	})
+	if err != nil {
+		return 0, err
+	}
+	var retired int
+	for _, v := range items {
+		if err := lib.retire(v, ArchiveReasonSuperseded); err != nil {
+			lib.log.Warn("failed to retire superseded video", "tid", v.TID, "err", err)
+			continue
+		}
+		retired++
+	}
+	return retired, nil
+}
@@ -0,0 +1,72 @@
+package library
+
+import (
+	"time"
+
+	"github.com/lbryio/transcoder/pkg/logging/zapadapter"
+)
+
+func (s *librarySuite) TestVideoVersions() {
+	lib := New(Config{DB: s.DB, Storage: NewDummyStorage("storage1", ""), Log: zapadapter.NewKV(nil)})
+
+	old := GenerateDummyStream()
+	s.Require().NoError(lib.AddRemoteStream(*old))
+	m := *old.Manifest
+	m.TranscodedAt = m.TranscodedAt.Add(time.Hour)
+	current := &Stream{RemoteStorage: old.RemoteStorage, Manifest: &m}
+	current.Manifest.TID = current.generateTID()
+	s.Require().NoError(lib.AddRemoteStream(*current))
+
+	v, err := lib.GetVideo(old.SDHash())
+	s.Require().NoError(err)
+	s.Equal(current.TID(), v.TID)
+	versions, err := lib.GetVideoVersions(old.SDHash())
+	s.Require().NoError(err)
+	s.Require().Len(versions, 2)
+	for _, v := range versions {
+		s.Equal(v.TID == current.TID(), v.Active)
+		s.Equal(!v.Active, v.SupersededAt.Valid)
+	}
+
+	s.Require().NoError(lib.ActivateVersion(old.TID()))
+	v, err = lib.GetVideo(old.SDHash())
+	s.Require().NoError(err)
+	s.Equal(old.TID(), v.TID)
+	s.ErrorIs(lib.ActivateVersion("nonexistent"), ErrStreamNotFound)
+
+	retired, err := lib.RetireSuperseded(time.Hour, 10)
+	s.Require().NoError(err)
+	s.Equal(0, retired, "versions within grace period must be kept")
+	retired, err = lib.RetireSuperseded(0, 10)
+	s.Require().NoError(err)
+	s.Equal(1, retired)
+
+	versions, err = lib.GetVideoVersions(old.SDHash())
+	s.Require().NoError(err)
+	s.Require().Len(versions, 1)
+	s.Equal(old.TID(), versions[0].TID)
+	archived, err := lib.GetArchivedVideos(10)
+	s.Require().NoError(err)
+	s.Require().Len(archived, 1)
+	s.Equal(current.TID(), archived[0].TID)
+	s.Equal(ArchiveReasonSuperseded, archived[0].Reason)
+}
+
+func (s *librarySuite) TestVideoVersionsSameMinute() {
+	lib := New(Config{DB: s.DB, Storage: NewDummyStorage("storage1", ""), Log: zapadapter.NewKV(nil)})
+
+	first := GenerateDummyStream()
+	s.Require().NoError(lib.AddRemoteStream(*first))
+	m := *first.Manifest
+	second := &Stream{RemoteStorage: first.RemoteStorage, Manifest: &m}
+	second.Manifest.TID = second.generateTID()
+	s.Require().NotEqual(first.TID(), second.TID())
+	s.Require().NoError(lib.AddRemoteStream(*second))
+
+	versions, err := lib.GetVideoVersions(first.SDHash())
+	s.Require().NoError(err)
+	s.Len(versions, 2)
+	v, err := lib.GetVideo(first.SDHash())
+	s.Require().NoError(err)
+	s.Equal(second.TID(), v.TID)
+}
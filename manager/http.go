@@ -1,17 +1,21 @@
 package manager
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strings"
 
 	"github.com/lbryio/transcoder/internal/metrics"
+	"github.com/lbryio/transcoder/library"
 	"github.com/lbryio/transcoder/library/db"
 	"github.com/lbryio/transcoder/pkg/dispatcher"
 	"github.com/lbryio/transcoder/pkg/logging"
 	"github.com/lbryio/transcoder/pkg/resolve"
 	"github.com/lbryio/transcoder/pkg/timer"
+	"github.com/lbryio/transcoder/stats"
 
 	"github.com/fasthttp/router"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -46,10 +50,15 @@ func CreateRoutes(r *router.Router, manager *VideoManager, log logging.KVLogger,
 	r.GET("/api/v3/video", h.handleVideo) // accepts URL as a query param
 
 	r.POST("/api/v1/channel", h.handleChannel)
+	r.PUT("/api/v1/channel", h.handleChannelUpdate)
+	r.GET("/api/v1/videos", h.handleVideos)
+	r.GET("/api/v1/stats", h.handleStats)
+	r.GET("/api/v1/key/{kid}", h.handleKey)
 
 	metrics.RegisterMetrics()
 	dispatcher.RegisterMetrics()
 	RegisterMetrics()
+	stats.RegisterMetrics()
 	r.GET("/metrics", fasthttpadaptor.NewFastHTTPHandler(promhttp.Handler()))
 }
 
@@ -153,12 +162,13 @@ func (h httpVideoHandler) handleVideo(ctx *fasthttp.RequestCtx) {
 	ctx.Redirect(location, http.StatusSeeOther)
 }
 
-func (h httpVideoHandler) handleChannel(ctx *fasthttp.RequestCtx) {
+// authorize checks the bearer token of management requests, responding with 403 if it is not accepted.
+func (h httpVideoHandler) authorize(ctx *fasthttp.RequestCtx) bool {
 	if h.authCallback == nil {
 		h.log.Error("management endpoint called but authenticator function not set")
 		ctx.SetStatusCode(http.StatusForbidden)
 		ctx.SetBodyString("authorization failed")
-		return
+		return false
 	}
 	token := strings.Replace(string(ctx.Request.Header.Peek(AuthHeader)), "Bearer ", "", 1)
 	ctx.SetUserValue(TokenCtxField, token)
@@ -167,6 +177,13 @@ func (h httpVideoHandler) handleChannel(ctx *fasthttp.RequestCtx) {
 		h.log.Info("authorization failed")
 		ctx.SetStatusCode(http.StatusForbidden)
 		ctx.SetBodyString("authorization failed")
+		return false
+	}
+	return true
+}
+
+func (h httpVideoHandler) handleChannel(ctx *fasthttp.RequestCtx) {
+	if !h.authorize(ctx) {
 		return
 	}
 
@@ -178,14 +195,134 @@ func (h httpVideoHandler) handleChannel(ctx *fasthttp.RequestCtx) {
 	}
 	var priority db.ChannelPriority
 	priority.Scan(ctx.FormValue("priority"))
+	encryption, err := library.ParseEncryptionMethod(string(ctx.FormValue("encryption")))
+	if err != nil {
+		ctx.SetStatusCode(http.StatusBadRequest)
+		fmt.Fprint(ctx, err.Error())
+		return
+	}
+	// Checked before the channel is added, so it is not left behind unencrypted.
+	if encryption != "" {
+		if err := h.manager.lib.EncryptionSupported(); err != nil {
+			ctx.SetStatusCode(http.StatusBadRequest)
+			fmt.Fprint(ctx, err.Error())
+			return
+		}
+	}
 	c, err := h.manager.lib.AddChannel(channel, priority)
 	if err != nil {
 		ctx.SetStatusCode(http.StatusBadRequest)
 		fmt.Fprint(ctx, err.Error())
 		return
 	}
+	if encryption != c.Encryption {
+		c, err = h.manager.lib.SetChannelEncryption(c.ClaimID, encryption)
+		if err != nil {
+			ctx.SetStatusCode(http.StatusInternalServerError)
+			fmt.Fprint(ctx, err.Error())
+			return
+		}
+	}
 	ctx.SetStatusCode(http.StatusCreated)
 	fmt.Fprintf(ctx, "channel %s (%s) added with priority %s", c.URL, c.ClaimID, c.Priority)
+	if c.Encryption != "" {
+		fmt.Fprintf(ctx, " and %s encryption", c.Encryption)
+	}
+}
+
+// handleChannelUpdate changes encryption of streams transcoded from now on for a channel already added.
+func (h httpVideoHandler) handleChannelUpdate(ctx *fasthttp.RequestCtx) {
+	if !h.authorize(ctx) {
+		return
+	}
+
+	channel := string(ctx.FormValue(AdminChannelField))
+	if channel == "" {
+		ctx.SetStatusCode(http.StatusBadRequest)
+		fmt.Fprint(ctx, "channel missing")
+		return
+	}
+	encryption, err := library.ParseEncryptionMethod(string(ctx.FormValue("encryption")))
+	if err != nil {
+		ctx.SetStatusCode(http.StatusBadRequest)
+		fmt.Fprint(ctx, err.Error())
+		return
+	}
+	c, err := h.manager.lib.UpdateChannelEncryption(channel, encryption)
+	if errors.Is(err, library.ErrChannelNotFound) || errors.Is(err, resolve.ErrClaimNotFound) {
+		ctx.SetStatusCode(http.StatusNotFound)
+		fmt.Fprint(ctx, err.Error())
+		return
+	} else if errors.Is(err, library.ErrPublicStorage) {
+		ctx.SetStatusCode(http.StatusBadRequest)
+		fmt.Fprint(ctx, err.Error())
+		return
+	} else if err != nil {
+		ctx.SetStatusCode(http.StatusInternalServerError)
+		fmt.Fprint(ctx, err.Error())
+		return
+	}
+	ctx.SetStatusCode(http.StatusOK)
+	fmt.Fprintf(ctx, "channel %s (%s) updated", c.URL, c.ClaimID)
+	if c.Encryption != "" {
+		fmt.Fprintf(ctx, " with %s encryption", c.Encryption)
+	} else {
+		fmt.Fprint(ctx, " with encryption disabled")
+	}
+}
+
+// handleKey serves stream encryption keys to players, as referenced by #EXT-X-KEY tags of encrypted streams.
+// Requests must carry query params of a signed video URL of the stream, so keys are only handed out during playback.
+func (h httpVideoHandler) handleKey(ctx *fasthttp.RequestCtx) {
+	kid := ctx.UserValue("kid").(string)
+	q := url.Values{}
+	ctx.QueryArgs().VisitAll(func(k, v []byte) {
+		q.Add(string(k), string(v))
+	})
+	k, err := h.manager.lib.AuthorizeStreamKey(kid, q)
+	if errors.Is(err, library.ErrKeyNotFound) {
+		ctx.SetStatusCode(http.StatusNotFound)
+		fmt.Fprint(ctx, err.Error())
+		return
+	} else if errors.Is(err, library.ErrKeyAccessDenied) {
+		h.log.Info("key request rejected", "kid", kid)
+		ctx.SetStatusCode(http.StatusForbidden)
+		fmt.Fprint(ctx, err.Error())
+		return
+	} else if err != nil {
+		h.log.Error("failed to retrieve stream key", "kid", kid, "err", err)
+		ctx.SetStatusCode(http.StatusInternalServerError)
+		return
+	}
+	ctx.Response.Header.Set("Cache-Control", "private, no-store")
+	ctx.SetContentType("application/octet-stream")
+	ctx.SetBody(k.Key)
+}
+
+func (h httpVideoHandler) handleStats(ctx *fasthttp.RequestCtx) {
+	if !h.authorize(ctx) {
+		return
+	}
+	if h.manager.stats == nil {
+		ctx.SetStatusCode(http.StatusNotFound)
+		fmt.Fprint(ctx, "library stats are not enabled")
+		return
+	}
+	r, err := h.manager.stats.Get()
+	if err != nil {
+		h.log.Error("failed to compute library stats", "err", err)
+		ctx.SetStatusCode(http.StatusInternalServerError)
+		fmt.Fprint(ctx, err.Error())
+		return
+	}
+	body, err := json.Marshal(r)
+	if err != nil {
+		ctx.SetStatusCode(http.StatusInternalServerError)
+		fmt.Fprint(ctx, err.Error())
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
 }
 
 func handlePanic(ctx *fasthttp.RequestCtx, p interface{}) {
@@ -2,6 +2,7 @@ package manager
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net"
@@ -10,10 +11,13 @@ import (
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/Pallinder/go-randomdata"
 	"github.com/lbryio/transcoder/library"
 	"github.com/lbryio/transcoder/pkg/logging/zapadapter"
+	"github.com/lbryio/transcoder/pkg/urlsign"
+	"github.com/lbryio/transcoder/stats"
 
 	"github.com/fasthttp/router"
 	"github.com/prometheus/client_golang/prometheus"
@@ -137,3 +141,214 @@ func (s *httpSuite) TestAdmin() {
 	}
 
 }
+
+// serve runs router on an in-memory listener until the test is done, returning a client connected to it.
+func (s *httpSuite) serve(r *router.Router) *http.Client {
+	ln := fasthttputil.NewInmemoryListener()
+	server := &fasthttp.Server{
+		Handler:            r.Handler,
+		Name:               "tower",
+		MaxRequestBodySize: 10 * 1024 * 1024 * 1024,
+	}
+	go server.Serve(ln)
+	s.T().Cleanup(func() { server.Shutdown() })
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return ln.Dial()
+			},
+		},
+	}
+}
+
+// get requests path from a server started by serve, with token as a bearer token unless it is empty.
+func (s *httpSuite) get(client *http.Client, path, token string) (*http.Response, []byte) {
+	req, err := http.NewRequest(http.MethodGet, "http://localhost"+path, nil)
+	s.Require().NoError(err)
+	if token != "" {
+		req.Header.Set(AuthHeader, "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	s.Require().NoError(err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	s.Require().NoError(err)
+	return resp, body
+}
+
+// submit sends form data to a server started by serve, with token as a bearer token.
+func (s *httpSuite) submit(client *http.Client, method, path, token string, data url.Values) (*http.Response, []byte) {
+	req, err := http.NewRequest(method, "http://localhost"+path, strings.NewReader(data.Encode()))
+	s.Require().NoError(err)
+	req.Header.Set(AuthHeader, "Bearer "+token)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(req)
+	s.Require().NoError(err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	s.Require().NoError(err)
+	return resp, body
+}
+
+func (s *httpSuite) TestVideos() {
+	router := router.New()
+	client := s.serve(router)
+
+	token := "test-token"
+	lib := library.New(library.Config{DB: s.DB, Log: zapadapter.NewKV(nil)})
+	stream := library.GenerateDummyStream()
+	stream.Manifest.TranscodedBy = "worker1"
+	s.Require().NoError(lib.AddRemoteStream(*stream))
+	s.Require().NoError(lib.AddRemoteStream(*library.GenerateDummyStream()))
+
+	CreateRoutes(router, NewManager(lib, 0), zapadapter.NewKV(nil), func(ctx *fasthttp.RequestCtx) bool {
+		return ctx.UserValue(TokenCtxField).(string) == token
+	})
+
+	resp, _ := s.get(client, "/api/v1/videos", "wrong")
+	s.Equal(http.StatusForbidden, resp.StatusCode)
+
+	resp, body := s.get(client, "/api/v1/videos?min_size=abc", token)
+	s.Equal(http.StatusBadRequest, resp.StatusCode, string(body))
+
+	resp, body = s.get(client, "/api/v1/videos", token)
+	s.Require().Equal(http.StatusOK, resp.StatusCode, string(body))
+	list := videoListResponse{}
+	s.Require().NoError(json.Unmarshal(body, &list))
+	s.EqualValues(2, list.Total)
+	s.Len(list.Videos, 2)
+
+	query := url.Values{"worker": {"worker1"}, "storage": {"storage1"}, "created_after": {"2020-01-01"}}
+	resp, body = s.get(client, "/api/v1/videos?"+query.Encode(), token)
+	s.Require().Equal(http.StatusOK, resp.StatusCode, string(body))
+	list = videoListResponse{}
+	s.Require().NoError(json.Unmarshal(body, &list))
+	s.Require().Len(list.Videos, 1)
+	s.Equal(stream.TID(), list.Videos[0].TID)
+	s.Equal("worker1", list.Videos[0].Worker)
+	s.Equal(stream.Manifest.Size, list.TotalSize)
+}
+
+func (s *httpSuite) TestStats() {
+	router := router.New()
+	client := s.serve(router)
+
+	token := "test-token"
+	lib := library.New(library.Config{DB: s.DB, Log: zapadapter.NewKV(nil)})
+	s.Require().NoError(lib.AddRemoteStream(*library.GenerateDummyStream()))
+	mgr := NewManager(lib, 0)
+	CreateRoutes(router, mgr, zapadapter.NewKV(nil), func(ctx *fasthttp.RequestCtx) bool {
+		return ctx.UserValue(TokenCtxField).(string) == token
+	})
+
+	resp, _ := s.get(client, "/api/v1/stats", token)
+	s.Equal(http.StatusNotFound, resp.StatusCode)
+
+	mgr.SetStats(stats.New(s.DB, zapadapter.NewKV(nil)))
+	resp, body := s.get(client, "/api/v1/stats", token)
+	s.Require().Equal(http.StatusOK, resp.StatusCode, string(body))
+	r := stats.Rollup{}
+	s.Require().NoError(json.Unmarshal(body, &r))
+	s.EqualValues(1, r.Videos)
+	s.Len(r.Storages, 1)
+}
+
+func (s *httpSuite) TestKey() {
+	router := router.New()
+	client := s.serve(router)
+
+	signer, err := urlsign.New(time.Hour, urlsign.Key{ID: "k1", Secret: "secret1"})
+	s.Require().NoError(err)
+	lib := library.New(library.Config{DB: s.DB, URLSigner: signer, Log: zapadapter.NewKV(nil)})
+	stream := library.GenerateDummyStream()
+	s.Require().NoError(lib.AddRemoteStream(*stream))
+	k, err := lib.StreamKey(stream.SDHash(), library.EncryptionAES128, 0)
+	s.Require().NoError(err)
+	CreateRoutes(router, NewManager(lib, 0), zapadapter.NewKV(nil), func(ctx *fasthttp.RequestCtx) bool {
+		return ctx.UserValue(TokenCtxField).(string) == "test-token"
+	})
+
+	videoURL, err := lib.GetVideoURL(stream.SDHash())
+	s.Require().NoError(err)
+	// Storages append query params of the signed video URL to key URIs of playlists they serve.
+	playback := videoURL[strings.Index(videoURL, "?"):]
+	keyURL := "/api/v1/key/" + k.KID
+
+	resp, _ := s.get(client, keyURL, "")
+	s.Equal(http.StatusForbidden, resp.StatusCode)
+	resp, _ = s.get(client, keyURL+"?"+signer.Sign("othertid").Encode(), "")
+	s.Equal(http.StatusForbidden, resp.StatusCode)
+	resp, body := s.get(client, keyURL+playback, "")
+	s.Require().Equal(http.StatusOK, resp.StatusCode, string(body))
+	s.Equal(k.Key, body)
+	s.Equal("private, no-store", resp.Header.Get("Cache-Control"))
+	resp, _ = s.get(client, "/api/v1/key/unknown"+playback, "")
+	s.Equal(http.StatusNotFound, resp.StatusCode)
+}
+
+type privateStorage struct {
+	name string
+}
+
+func (s privateStorage) Name() string                    { return s.name }
+func (s privateStorage) GetURL(tid string) string        { return "https://" + s.name + "/" + tid }
+func (s privateStorage) Put(*library.Stream, bool) error { return nil }
+func (s privateStorage) Delete(string) error             { return nil }
+func (s privateStorage) Private() bool                   { return true }
+
+func (s *httpSuite) TestChannelEncryption() {
+	token := "test-token"
+	authorize := func(ctx *fasthttp.RequestCtx) bool {
+		return ctx.UserValue(TokenCtxField).(string) == token
+	}
+	channel := url.Values{AdminChannelField: {"@specialoperationstest:3"}}
+	encrypted := url.Values{AdminChannelField: channel[AdminChannelField], "encryption": {library.EncryptionAES128}}
+
+	publicRouter := router.New()
+	publicClient := s.serve(publicRouter)
+	publicLib := library.New(library.Config{DB: s.DB, Log: zapadapter.NewKV(nil)})
+	CreateRoutes(publicRouter, NewManager(publicLib, 0), zapadapter.NewKV(nil), authorize)
+
+	resp, body := s.submit(publicClient, http.MethodPost, "/api/v1/channel", token, encrypted)
+	s.Require().Equal(http.StatusBadRequest, resp.StatusCode, string(body))
+	channels, err := publicLib.GetAllChannels()
+	s.Require().NoError(err)
+	s.Empty(channels, "channel must not be added if encryption cannot be enabled")
+
+	resp, body = s.submit(publicClient, http.MethodPost, "/api/v1/channel", token, channel)
+	s.Require().Equal(http.StatusCreated, resp.StatusCode, string(body))
+	resp, body = s.submit(publicClient, http.MethodPut, "/api/v1/channel", token, encrypted)
+	s.Require().Equal(http.StatusBadRequest, resp.StatusCode, string(body))
+
+	signer, err := urlsign.New(time.Hour, urlsign.Key{ID: "k1", Secret: "secret1"})
+	s.Require().NoError(err)
+	privateRouter := router.New()
+	privateClient := s.serve(privateRouter)
+	privateLib := library.New(library.Config{
+		DB:        s.DB,
+		Storage:   privateStorage{"storage1"},
+		URLSigner: signer,
+		Log:       zapadapter.NewKV(nil),
+	})
+	CreateRoutes(privateRouter, NewManager(privateLib, 0), zapadapter.NewKV(nil), authorize)
+
+	resp, body = s.submit(privateClient, http.MethodPut, "/api/v1/channel", token, encrypted)
+	s.Require().Equal(http.StatusOK, resp.StatusCode, string(body))
+	channels, err = privateLib.GetAllChannels()
+	s.Require().NoError(err)
+	s.Require().Len(channels, 1)
+	s.Equal(library.EncryptionAES128, channels[0].Encryption)
+
+	resp, body = s.submit(privateClient, http.MethodPut, "/api/v1/channel", token, url.Values{
+		AdminChannelField: channel[AdminChannelField], "encryption": {"none"},
+	})
+	s.Require().Equal(http.StatusOK, resp.StatusCode, string(body))
+	m, err := privateLib.ChannelEncryption(channels[0].ClaimID)
+	s.Require().NoError(err)
+	s.Empty(m)
+
+	resp, body = s.submit(privateClient, http.MethodPut, "/api/v1/channel", token, url.Values{
+		AdminChannelField: {randomdata.Alphanumeric(25)},
+	})
+	s.Equal(http.StatusNotFound, resp.StatusCode, string(body))
+}
@@ -0,0 +1,170 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lbryio/transcoder/library"
+	"github.com/lbryio/transcoder/library/db"
+
+	"github.com/c2h5oh/datasize"
+	"github.com/pkg/errors"
+	"github.com/valyala/fasthttp"
+)
+
+type videoItem struct {
+	TID         string     `json:"tid"`
+	URL         string     `json:"url"`
+	SDHash      string     `json:"sd_hash"`
+	Channel     string     `json:"channel"`
+	Storage     string     `json:"storage"`
+	Size        int64      `json:"size"`
+	Ladder      string     `json:"ladder,omitempty"`
+	Worker      string     `json:"worker,omitempty"`
+	Active      bool       `json:"active"`
+	CreatedAt   time.Time  `json:"created_at"`
+	AccessedAt  time.Time  `json:"accessed_at"`
+	AccessCount int32      `json:"access_count"`
+	VerifiedAt  *time.Time `json:"verified_at,omitempty"`
+}
+
+type videoListResponse struct {
+	Videos    []videoItem `json:"videos"`
+	Total     int64       `json:"total"`
+	TotalSize int64       `json:"total_size"`
+	Offset    int32       `json:"offset"`
+	Limit     int32       `json:"limit"`
+}
+
+// handleVideos lists videos in the library matching filters supplied as query params.
+func (h httpVideoHandler) handleVideos(ctx *fasthttp.RequestCtx) {
+	if !h.authorize(ctx) {
+		return
+	}
+	filter, err := parseVideoFilter(ctx.QueryArgs())
+	if err != nil {
+		ctx.SetStatusCode(http.StatusBadRequest)
+		fmt.Fprint(ctx, err.Error())
+		return
+	}
+	list, err := h.manager.lib.ListVideos(filter)
+	if err != nil {
+		h.log.Error("failed to list videos", "err", err)
+		ctx.SetStatusCode(http.StatusInternalServerError)
+		fmt.Fprint(ctx, err.Error())
+		return
+	}
+
+	resp := videoListResponse{
+		Videos:    make([]videoItem, len(list.Videos)),
+		Total:     list.Total,
+		TotalSize: list.TotalSize,
+		Offset:    list.Offset,
+		Limit:     list.Limit,
+	}
+	for i, v := range list.Videos {
+		resp.Videos[i] = newVideoItem(v)
+	}
+	body, err := json.Marshal(resp)
+	if err != nil {
+		ctx.SetStatusCode(http.StatusInternalServerError)
+		fmt.Fprint(ctx, err.Error())
+		return
+	}
+	ctx.SetContentType("application/json")
+	ctx.SetBody(body)
+}
+
+func newVideoItem(v db.Video) videoItem {
+	item := videoItem{
+		TID:         v.TID,
+		URL:         v.URL,
+		SDHash:      v.SDHash,
+		Channel:     v.Channel,
+		Storage:     v.Storage,
+		Size:        v.Size,
+		Ladder:      v.Ladder.String,
+		Active:      v.Active,
+		CreatedAt:   v.CreatedAt,
+		AccessedAt:  v.AccessedAt,
+		AccessCount: v.AccessCount.Int32,
+	}
+	if v.VerifiedAt.Valid {
+		item.VerifiedAt = &v.VerifiedAt.Time
+	}
+	if v.Manifest.Valid {
+		if m, err := library.DecodeManifestJSON(v.Manifest.RawMessage); err == nil {
+			item.Worker = m.TranscodedBy
+		}
+	}
+	return item
+}
+
+// parseVideoFilter reads listing filters from query args.
+// Times are accepted in RFC 3339 or YYYY-MM-DD format, sizes either in bytes or with units (500MB).
+func parseVideoFilter(args *fasthttp.Args) (library.VideoFilter, error) {
+	f := library.VideoFilter{
+		Channel: string(args.Peek("channel")),
+		Storage: string(args.Peek("storage")),
+		Ladder:  string(args.Peek("ladder")),
+		Worker:  string(args.Peek("worker")),
+	}
+	times := map[string]*time.Time{
+		"created_after":   &f.CreatedAfter,
+		"created_before":  &f.CreatedBefore,
+		"accessed_after":  &f.AccessedAfter,
+		"accessed_before": &f.AccessedBefore,
+	}
+	for name, t := range times {
+		val := string(args.Peek(name))
+		if val == "" {
+			continue
+		}
+		parsed, err := parseTime(val)
+		if err != nil {
+			return f, errors.Errorf("invalid %s: %s", name, val)
+		}
+		*t = parsed
+	}
+	sizes := map[string]*int64{
+		"min_size": &f.MinSize,
+		"max_size": &f.MaxSize,
+	}
+	for name, s := range sizes {
+		val := string(args.Peek(name))
+		if val == "" {
+			continue
+		}
+		var size datasize.ByteSize
+		if err := size.UnmarshalText([]byte(val)); err != nil {
+			return f, errors.Errorf("invalid %s: %s", name, val)
+		}
+		*s = int64(size.Bytes())
+	}
+	ints := map[string]*int32{
+		"offset": &f.Offset,
+		"limit":  &f.Limit,
+	}
+	for name, n := range ints {
+		val := string(args.Peek(name))
+		if val == "" {
+			continue
+		}
+		parsed, err := strconv.ParseInt(val, 10, 32)
+		if err != nil || parsed < 0 {
+			return f, errors.Errorf("invalid %s: %s", name, val)
+		}
+		*n = int32(parsed)
+	}
+	return f, nil
+}
+
+func parseTime(val string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, val); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", val)
+}
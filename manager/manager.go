@@ -13,6 +13,7 @@ import (
 	"github.com/lbryio/transcoder/pkg/logging/zapadapter"
 	"github.com/lbryio/transcoder/pkg/mfr"
 	"github.com/lbryio/transcoder/pkg/resolve"
+	"github.com/lbryio/transcoder/stats"
 	"github.com/valyala/fasthttp"
 	"github.com/valyala/fasthttp/pprofhandler"
 
@@ -37,6 +38,8 @@ type TranscodingRequest struct {
 type HttpServerConfig struct {
 	ManagerToken string
 	Bind         string
+	// Routes registers additional routes, like local storage handler.
+	Routes func(*router.Router)
 }
 
 type VideoLibrary interface {
@@ -52,6 +55,7 @@ type VideoManager struct {
 	pool     *Pool
 	cache    *ccache.Cache
 	channels *channelList
+	stats    *stats.Collector
 }
 
 // NewManager creates a video library manager with a pool for future transcoding requests.
@@ -137,6 +141,11 @@ func (m *VideoManager) RequestStatus(sdHash string) int {
 	return mfr.StatusNone
 }
 
+// SetStats enables library statistics served over HTTP.
+func (m *VideoManager) SetStats(c *stats.Collector) {
+	m.stats = c
+}
+
 func (m *VideoManager) Library() *library.Library {
 	return m.lib
 }
@@ -191,6 +200,9 @@ func (m *VideoManager) StartHttpServer(config HttpServerConfig) (chan struct{},
 	})
 
 	router.GET("/debug/pprof/{profile:*}", pprofhandler.PprofHandler)
+	if config.Routes != nil {
+		config.Routes(router)
+	}
 
 	logger.Infow("starting tower http server", "addr", config.Bind)
 	server := &fasthttp.Server{
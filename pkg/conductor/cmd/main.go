@@ -1,14 +1,18 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/lbryio/transcoder/encoder"
+	imetrics "github.com/lbryio/transcoder/internal/metrics"
 	"github.com/lbryio/transcoder/ladder"
 	"github.com/lbryio/transcoder/library"
 	ldb "github.com/lbryio/transcoder/library/db"
@@ -22,10 +26,13 @@ import (
 	"github.com/lbryio/transcoder/pkg/mfr"
 	"github.com/lbryio/transcoder/pkg/migrator"
 	"github.com/lbryio/transcoder/pkg/resolve"
+	"github.com/lbryio/transcoder/pkg/urlsign"
+	"github.com/lbryio/transcoder/stats"
 	"github.com/lbryio/transcoder/storage"
 	"github.com/lbryio/transcoder/tower/queue"
 
 	"github.com/alecthomas/kong"
+	"github.com/c2h5oh/datasize"
 	"github.com/fasthttp/router"
 	"github.com/hibiken/asynq"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -44,18 +51,64 @@ var CLI struct {
 		HttpBind string `optional:"" help:"Address for HTTP server to listen on" default:"0.0.0.0:8080"`
 	} `cmd:"" help:"Start conductor server"`
 	Worker struct {
-		StreamsDir  string `optional:"" help:"Directory for storing downloaded files"`
-		OutputDir   string `optional:"" help:"Directory for storing encoder output files"`
-		BlobServer  string `optional:"" help:"LBRY blobserver address."`
-		Concurrency int    `optional:"" help:"Number of task slots" default:"5"`
-		HttpBind    string `optional:"" help:"Address for prom metrics HTTP server to listen on" default:"0.0.0.0:8080"`
+		StreamsDir    string        `optional:"" help:"Directory for storing downloaded files"`
+		OutputDir     string        `optional:"" help:"Directory for storing encoder output files"`
+		BlobServer    string        `optional:"" help:"LBRY blobserver address."`
+		Concurrency   int           `optional:"" help:"Number of task slots" default:"5"`
+		HttpBind      string        `optional:"" help:"Address for prom metrics HTTP server to listen on" default:"0.0.0.0:8080"`
+		Quality       string        `optional:"" help:"Score encoded streams against the source with a specified metric (vmaf, ssim or psnr)"`
+		ChunkSize     time.Duration `optional:"" help:"Split sources longer than two chunks into chunks of this duration encoded in parallel by all workers (e.g. 5m)"`
+		CheckpointTTL time.Duration `optional:"" name:"checkpoint-ttl" help:"Keep checkpoints to resume interrupted encodes on retry, discarding ones older than this (requires --streams-dir and --output-dir)"`
 	} `cmd:"" help:"Start worker"`
 	ValidateStreams struct {
 		Remove  bool   `optional:"" help:"Remove broken streams from the database"`
 		Storage string `help:"Storage name"`
 		Offset  int32  `optional:"" help:"Starting stream index"`
 		Limit   int32  `optional:"" help:"Stream count" default:"999999999"`
-	} `cmd:"" help:"Verify a list of streams supplied by stdin"`
+	} `cmd:"" help:"Check that files of streams kept in the storage are all present"`
+	VerifyStreams struct {
+		TIDs  []string `arg:"" optional:"" name:"tid" help:"Stream TIDs to verify"`
+		Limit int32    `optional:"" help:"Number of least recently verified streams to check when no TIDs are given" default:"100"`
+	} `cmd:"" help:"Verify checksums of stored streams"`
+	Reconcile struct {
+		Storage       string        `help:"Storage name"`
+		ImportOrphans bool          `optional:"" help:"Add streams missing from the library using their manifests"`
+		DeleteOrphans bool          `optional:"" help:"Delete streams missing from the library from the storage, unless imported"`
+		RemoveMissing bool          `optional:"" help:"Remove library records of streams not found in the storage"`
+		MinAge        time.Duration `optional:"" help:"Ignore streams modified more recently than this, as they may be still uploading" default:"24h"`
+	} `cmd:"" help:"Find streams present in the storage but not in the library and vice versa"`
+	ImportStreams struct {
+		Dir     string `arg:"" optional:"" help:"Directory with stream folders, storage is scanned if omitted" type:"existingdir"`
+		Storage string `help:"Storage name"`
+		Prefix  string `optional:"" help:"Import only stream folders with names starting with this prefix"`
+		Workers int    `optional:"" help:"Number of streams imported in parallel" default:"10"`
+		Upload  bool   `optional:"" help:"Upload local streams to the storage before importing"`
+		URLs    string `optional:"" name:"urls" help:"File with '<folder name> <lbry url>' lines for generating missing manifests" type:"existingfile"`
+	} `cmd:"" help:"Add existing transcoded streams from a directory or storage to the library"`
+	Restore struct {
+		TIDs []string `arg:"" name:"tid" help:"TIDs of archived streams to restore"`
+	} `cmd:"" help:"Restore retired streams from the archive"`
+	Archived struct {
+		Limit int32 `optional:"" help:"Number of most recently archived streams to list" default:"100"`
+	} `cmd:"" help:"List archived streams"`
+	Versions struct {
+		SDHash   string `arg:"" name:"sd-hash" help:"SD hash of the video"`
+		Activate string `optional:"" help:"TID of the version to serve instead of the active one"`
+	} `cmd:"" help:"List transcoded versions of a video or switch the active one"`
+	MigrateManifests struct {
+		Limit  int  `optional:"" help:"Maximum number of videos to migrate, all if zero"`
+		Stored bool `optional:"" help:"Also rewrite manifest files kept with streams on storages"`
+		DryRun bool `optional:"" help:"Only list videos with outdated manifests"`
+	} `cmd:"" help:"Upgrade stream manifests to the current schema version"`
+	RepairStream struct {
+		TID string `arg:"" name:"tid" help:"TID of the stream to repair"`
+		Dir string `arg:"" help:"Directory with a good copy of stream files, such as retained worker output" type:"existingdir"`
+	} `cmd:"" help:"Re-upload stream files not matching their manifest entries from a local copy"`
+	Retire struct {
+		Storage string `help:"Storage name"`
+		MaxSize string `optional:"" help:"Size to bring the storage down to, configured storage MaxSize is used if omitted"`
+		DryRun  bool   `optional:"" help:"Only list videos that would be retired"`
+	} `cmd:"" help:"Retire videos from the storage according to the retirement policy"`
 	Redis string `optional:"" help:"Redis server address"`
 	Debug bool   `optional:"" help:"Enable debug logging" default:"false"`
 }
@@ -81,6 +134,24 @@ func main() {
 		migrateDown()
 	case "validate-streams":
 		validateStreams()
+	case "verify-streams", "verify-streams <tid>":
+		verifyStreams()
+	case "reconcile":
+		reconcile()
+	case "import-streams", "import-streams <dir>":
+		importStreams()
+	case "retire":
+		retire()
+	case "restore <tid>":
+		restore()
+	case "archived":
+		listArchived()
+	case "versions <sd-hash>":
+		videoVersions()
+	case "migrate-manifests":
+		migrateManifests()
+	case "repair-stream <tid> <dir>":
+		repairStream()
 	default:
 		panic(ctx.Command())
 	}
@@ -102,7 +173,6 @@ func startConductor() {
 		dispatcher.SetLogger(logging.Create("dispatcher", logging.Prod))
 	}
 
-	s3cfg := cfg.GetStringMapString("s3")
 	libCfg := cfg.GetStringMapString("library")
 
 	libDB, err := migrator.ConnectDB(migrator.DefaultDBConfig().DSN(libCfg["dsn"]).AppName("library"), ldb.MigrationsFS)
@@ -110,34 +180,115 @@ func startConductor() {
 		log.Fatal("library db initialization failed", err)
 	}
 
-	s3storage, err := storage.InitS3Driver(
-		storage.S3Configure().
-			Endpoint(s3cfg["endpoint"]).
-			Credentials(s3cfg["key"], s3cfg["secret"]).
-			Bucket(s3cfg["bucket"]).
-			Name(s3cfg["name"]),
-	)
+	strg, strgCfg, err := initStorage(cfg)
+	if err != nil {
+		log.Fatal("storage initialization failed", err)
+	}
+	replicas, replicaCfgs, err := initReplicas(cfg)
+	if err != nil {
+		log.Fatal("replica storage initialization failed", err)
+	}
+	retirement, err := initRetirementPolicy(cfg)
 	if err != nil {
-		log.Fatal("s3 driver initialization failed", err)
+		log.Fatal("retirement policy initialization failed", err)
+	}
+	signer, err := initSigner(cfg)
+	if err != nil {
+		log.Fatal("url signing initialization failed", err)
 	}
-	log.Infow("s3 storage configured", "endpoint", s3cfg["endpoint"], "bucket", s3cfg["bucket"])
 
 	lib := library.New(library.Config{
-		DB:      libDB,
-		Storage: s3storage,
-		Log:     zapadapter.NewKV(nil),
+		DB:         libDB,
+		Storage:    strg,
+		Replicas:   replicas,
+		Retirement: retirement,
+		URLSigner:  signer,
+		Log:        zapadapter.NewKV(nil),
 	})
 
-	cleanStopChan := library.SpawnLibraryCleaning(lib, s3storage.Name(), library.StringToSize(s3cfg["maxsize"]))
+	maintenanceStopChans := []chan struct{}{
+		library.SpawnLibraryCleaning(lib, strg.Name(), library.StringToSize(strgCfg["maxsize"])),
+	}
+	for _, rc := range replicaCfgs {
+		if rc.MaxSize != "" {
+			maintenanceStopChans = append(maintenanceStopChans, library.SpawnLibraryCleaning(lib, rc.Name, library.StringToSize(rc.MaxSize)))
+		}
+	}
+	if len(replicas) > 0 {
+		maintenanceStopChans = append(maintenanceStopChans, library.SpawnReplication(lib, 10*time.Minute))
+	}
+	maintenanceStopChans = append(maintenanceStopChans, library.SpawnArchivePurging(lib))
+	supersededGrace := 72 * time.Hour
+	if libCfg["supersededgrace"] != "" {
+		supersededGrace, err = time.ParseDuration(libCfg["supersededgrace"])
+		if err != nil {
+			log.Fatal("malformed superseded versions grace period", err)
+		}
+	}
+	if supersededGrace > 0 {
+		maintenanceStopChans = append(maintenanceStopChans, library.SpawnSupersededRetirement(lib, supersededGrace))
+	}
+	accessInterval := 5 * time.Second
+	if libCfg["accessflushinterval"] != "" {
+		accessInterval, err = time.ParseDuration(libCfg["accessflushinterval"])
+		if err != nil {
+			log.Fatal("malformed access flush interval", err)
+		}
+	}
+	if accessInterval > 0 {
+		maintenanceStopChans = append(maintenanceStopChans, library.SpawnAccessRecording(lib, accessInterval))
+	}
+	if libCfg["verifyinterval"] != "" {
+		interval, err := time.ParseDuration(libCfg["verifyinterval"])
+		if err != nil {
+			log.Fatal("malformed verification interval", err)
+		}
+		maintenanceStopChans = append(maintenanceStopChans, library.SpawnVerification(lib, interval))
+	}
 
 	adQueue := cfg.GetStringMapString("adaptivequeue")
 	minHits, _ := strconv.Atoi(adQueue["minhits"])
 	mgr := manager.NewManager(lib, minHits)
 
-	httpStopChan, _ := mgr.StartHttpServer(manager.HttpServerConfig{
+	libStats := stats.New(libDB, zapadapter.NewKV(log.Desugar()))
+	mgr.SetStats(libStats)
+	statsInterval := 5 * time.Minute
+	if libCfg["statsinterval"] != "" {
+		statsInterval, err = time.ParseDuration(libCfg["statsinterval"])
+		if err != nil {
+			log.Fatal("malformed stats interval", err)
+		}
+	}
+	if statsInterval > 0 {
+		maintenanceStopChans = append(maintenanceStopChans, stats.Spawn(libStats, statsInterval))
+	}
+
+	httpCfg := manager.HttpServerConfig{
 		ManagerToken: libCfg["managertoken"],
 		Bind:         CLI.Conductor.HttpBind,
-	})
+	}
+	// Local storage and private S3 storages are served through the conductor.
+	routed := []interface{ Route(*router.Router) error }{}
+	if ls, ok := strg.(*storage.LocalStorage); ok {
+		routed = append(routed, ls)
+	} else if strgCfg["private"] == "true" && strgCfg["url"] != "" {
+		routed = append(routed, strg.(*storage.S3Driver))
+	}
+	for i, rc := range replicaCfgs {
+		if rc.Private && rc.URL != "" {
+			routed = append(routed, replicas[i].Storage.(*storage.S3Driver))
+		}
+	}
+	if len(routed) > 0 {
+		httpCfg.Routes = func(r *router.Router) {
+			for _, s := range routed {
+				if err := s.Route(r); err != nil {
+					log.Fatal("cannot serve storage", err)
+				}
+			}
+		}
+	}
+	httpStopChan, _ := mgr.StartHttpServer(httpCfg)
 
 	var redisURI string
 	if CLI.Redis != "" {
@@ -149,7 +300,24 @@ func startConductor() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	cnd, err := conductor.NewConductor(redisOpts, mgr.Requests(), lib, conductor.WithLogger(zapadapter.NewKV(log.Desugar())))
+	cndOpts := []func(*conductor.ConductorOptions){conductor.WithLogger(zapadapter.NewKV(log.Desugar()))}
+	if cfg.IsSet("ladders") {
+		exp, err := loadLadderExperiment(cfg)
+		if err != nil {
+			log.Fatal("ladder experiment configuration failed", err)
+		}
+		for _, v := range exp.Variants() {
+			log.Infow("ladder variant configured", "name", v.Name, "share", v.Share, "tiers", len(v.Ladder.Tiers))
+		}
+		cndOpts = append(cndOpts, conductor.WithLadderExperiment(exp))
+	}
+	if libCfg["keyurl"] != "" {
+		if err := lib.EncryptionSupported(); err != nil {
+			log.Fatal("key url cannot be used with this storage configuration", err)
+		}
+		cndOpts = append(cndOpts, conductor.WithKeyURL(libCfg["keyurl"]))
+	}
+	cnd, err := conductor.NewConductor(redisOpts, mgr.Requests(), lib, cndOpts...)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -167,8 +335,10 @@ func startConductor() {
 
 	log.Infof("conductor stopped")
 
-	close(cleanStopChan)
-	log.Infof("storage cleanup shut down")
+	for _, c := range maintenanceStopChans {
+		close(c)
+	}
+	log.Infof("storage maintenance shut down")
 
 	mgr.Pool().Stop()
 	log.Infof("manager shut down")
@@ -182,8 +352,6 @@ func startWorker() {
 		log.Fatal("unable to read config", err)
 	}
 
-	s3opts := cfg.GetStringMapString("s3")
-
 	if CLI.Worker.StreamsDir != "" {
 		err = os.MkdirAll(CLI.Worker.StreamsDir, os.ModePerm)
 		if err != nil {
@@ -197,23 +365,21 @@ func startWorker() {
 		}
 	}
 
-	s3cfg := storage.S3Configure().
-		Endpoint(s3opts["endpoint"]).
-		Credentials(s3opts["key"], s3opts["secret"]).
-		Bucket(s3opts["bucket"]).
-		Name(s3opts["name"])
-	if s3opts["createbucket"] == "true" {
-		s3cfg = s3cfg.CreateBucket()
-	}
-	s3storage, err := storage.InitS3Driver(s3cfg)
+	strg, _, err := initStorage(cfg)
 	if err != nil {
-		log.Fatal("s3 driver initialization failed", err)
+		log.Fatal("storage initialization failed", err)
 	}
-	log.Infow("s3 storage configured", "endpoint", s3opts["endpoint"])
 
-	enc, err := encoder.NewEncoder(encoder.Configure().
-		Log(zapadapter.NewKV(log.Desugar())),
-	)
+	encCfg := encoder.Configure().
+		Log(zapadapter.NewKV(log.Desugar()))
+	if CLI.Worker.Quality != "" {
+		qm, err := ladder.ParseQualityMetric(CLI.Worker.Quality)
+		if err != nil {
+			log.Fatal(err)
+		}
+		encCfg = encCfg.QualityMetric(qm)
+	}
+	enc, err := encoder.NewEncoder(encCfg)
 	if err != nil {
 		log.Fatal("encoder initialization failed", err)
 	}
@@ -241,10 +407,13 @@ func startWorker() {
 	}
 
 	runner, err := tasks.NewEncoderRunner(
-		s3storage, enc, tasks.NewResultWriter(redisOpts),
+		strg, enc, tasks.NewResultWriter(redisOpts),
 		tasks.WithLogger(zapadapter.NewKV(log.Desugar())),
 		tasks.WithOutputDir(CLI.Worker.OutputDir),
 		tasks.WithStreamsDir(CLI.Worker.StreamsDir),
+		tasks.WithRedis(redisOpts),
+		tasks.WithChunkDuration(CLI.Worker.ChunkSize),
+		tasks.WithCheckpoints(CLI.Worker.CheckpointTTL),
 	)
 	if err != nil {
 		log.Fatal(err)
@@ -267,10 +436,6 @@ func startWorker() {
 
 func validateStreams() {
 	log := logger.Sugar()
-	cfg, err := readConfig("conductor")
-	if err != nil {
-		log.Fatal("unable to read config", err)
-	}
 
 	if !CLI.Debug {
 		encoder.SetLogger(logging.Create("encoder", logging.Prod))
@@ -282,37 +447,525 @@ func validateStreams() {
 		library.SetLogger(logging.Create("library", logging.Prod))
 	}
 
-	s3cfg := cfg.GetStringMapString("s3")
-	libCfg := cfg.GetStringMapString("library")
+	lib := openLibrary()
+	report, err := lib.ValidateStreams(
+		CLI.ValidateStreams.Storage, CLI.ValidateStreams.Offset, CLI.ValidateStreams.Limit, CLI.ValidateStreams.Remove)
+	if err != nil {
+		log.Fatal("failed to validate streams:", err)
+	}
+	for tid, missing := range report.Broken {
+		fmt.Printf("%s\tmissing %v files: %s\n", tid, len(missing), strings.Join(missing, " "))
+	}
+	for tid, err := range report.Failed {
+		fmt.Printf("%s\tcheck failed: %s\n", tid, err)
+	}
+	fmt.Printf(
+		"%v streams checked, %v valid, %v broken, %v failed to check\n",
+		len(report.Valid)+len(report.Broken)+len(report.Failed), len(report.Valid), len(report.Broken), len(report.Failed))
+}
 
-	libDB, err := migrator.ConnectDB(migrator.DefaultDBConfig().DSN(libCfg["dsn"]).AppName("library"), ldb.MigrationsFS)
+func verifyStreams() {
+	log := logger.Sugar()
+	lib := openLibrary()
+
+	if len(CLI.VerifyStreams.TIDs) == 0 {
+		checked, broken, err := lib.VerifyStreams(0, CLI.VerifyStreams.Limit)
+		if err != nil {
+			log.Fatal("failed to verify streams:", err)
+		}
+		fmt.Printf("%v streams checked, %v broken\n", checked, broken)
+		return
+	}
+
+	var broken int
+	for _, tid := range CLI.VerifyStreams.TIDs {
+		results, err := lib.VerifyStream(tid)
+		for _, r := range results {
+			status := "ok"
+			if r.Err != nil {
+				status = r.Err.Error()
+			} else if !r.Valid() {
+				status = "checksum mismatch: " + r.Actual
+			}
+			fmt.Printf("%s\t%s\t%s\n", r.TID, r.Storage, status)
+		}
+		if err != nil {
+			broken++
+			if !errors.Is(err, library.ErrChecksumMismatch) {
+				fmt.Printf("%s\t-\t%s\n", tid, err)
+			}
+		}
+	}
+	if broken > 0 {
+		os.Exit(1)
+	}
+}
+
+func reconcile() {
+	log := logger.Sugar()
+	lib := openLibrary()
+
+	storageName := CLI.Reconcile.Storage
+	if storageName == "" {
+		storageName = lib.storage.Name()
+	}
+	report, err := lib.Reconcile(storageName, library.ReconcileOptions{
+		ImportOrphans: CLI.Reconcile.ImportOrphans,
+		DeleteOrphans: CLI.Reconcile.DeleteOrphans,
+		RemoveMissing: CLI.Reconcile.RemoveMissing,
+		MinAge:        CLI.Reconcile.MinAge,
+	})
 	if err != nil {
-		log.Fatal("library db initialization failed", err)
+		log.Fatal("reconciliation failed:", err)
+	}
+
+	var orphanedSize int64
+	for tid, size := range report.Orphans {
+		orphanedSize += size
+		fmt.Printf("orphan\t%s\t%s\n", tid, datasize.ByteSize(size).HR())
+	}
+	for _, tid := range report.Missing {
+		fmt.Printf("missing\t%s\n", tid)
+	}
+	for tid, err := range report.Failed {
+		fmt.Printf("failed\t%s\t%s\n", tid, err)
+	}
+	fmt.Printf(
+		"%v orphans (%s), %v imported, %v deleted (%s reclaimed), %v missing, %v removed, %v failed\n",
+		len(report.Orphans), datasize.ByteSize(orphanedSize).HR(), len(report.Imported),
+		len(report.Deleted), datasize.ByteSize(report.ReclaimedBytes).HR(),
+		len(report.Missing), len(report.Removed), len(report.Failed),
+	)
+}
+
+func importStreams() {
+	log := logger.Sugar()
+	lib := openLibrary()
+
+	opts := library.ImportOptions{
+		Workers: CLI.ImportStreams.Workers,
+		Prefix:  CLI.ImportStreams.Prefix,
+		Upload:  CLI.ImportStreams.Upload,
+	}
+	if CLI.ImportStreams.URLs != "" {
+		f, err := os.Open(CLI.ImportStreams.URLs)
+		if err != nil {
+			log.Fatal("cannot open url map", err)
+		}
+		urls, err := library.ReadURLMap(f)
+		f.Close()
+		if err != nil {
+			log.Fatal("cannot read url map", err)
+		}
+		opts.Generate = library.ResolvingGenerator(urls, library.WithWorkerName("import"))
 	}
 
-	s3storage, err := storage.InitS3Driver(
-		storage.S3Configure().
-			Endpoint(s3cfg["endpoint"]).
-			Credentials(s3cfg["key"], s3cfg["secret"]).
-			Bucket(s3cfg["bucket"]).
-			Name(s3cfg["name"]),
+	storageName := CLI.ImportStreams.Storage
+	if storageName == "" {
+		storageName = lib.storage.Name()
+	}
+	var (
+		report *library.ImportReport
+		err    error
 	)
+	if CLI.ImportStreams.Dir != "" {
+		report, err = lib.ImportDir(CLI.ImportStreams.Dir, storageName, opts)
+	} else {
+		report, err = lib.ImportStored(storageName, opts)
+	}
+	if err != nil {
+		log.Fatal("import failed:", err)
+	}
+
+	for name, err := range report.Failed {
+		fmt.Printf("failed\t%s\t%s\n", name, err)
+	}
+	fmt.Printf("%v imported, %v skipped, %v failed\n", len(report.Imported), len(report.Skipped), len(report.Failed))
+}
+
+func retire() {
+	log := logger.Sugar()
+	lib := openLibrary()
+
+	storageName, maxSize := lib.storage.Name(), lib.storageCfg["maxsize"]
+	if CLI.Retire.Storage != "" && CLI.Retire.Storage != storageName {
+		storageName, maxSize = CLI.Retire.Storage, ""
+		for _, rc := range lib.replicaCfgs {
+			if rc.Name == storageName {
+				maxSize = rc.MaxSize
+			}
+		}
+	}
+	if CLI.Retire.MaxSize != "" {
+		maxSize = CLI.Retire.MaxSize
+	}
+	if maxSize == "" {
+		log.Fatal("storage size limit is not set")
+	}
+
+	if !CLI.Retire.DryRun {
+		totalSize, retiredSize, err := lib.RetireVideos(storageName, library.StringToSize(maxSize))
+		if err != nil {
+			log.Fatal("retirement failed:", err)
+		}
+		fmt.Printf("%s retired, %s left\n", datasize.ByteSize(retiredSize).HR(), datasize.ByteSize(totalSize-retiredSize).HR())
+		return
+	}
+
+	plan, err := lib.PlanRetirement(storageName, library.StringToSize(maxSize))
 	if err != nil {
-		log.Fatal("s3 driver initialization failed", err)
+		log.Fatal("retirement planning failed:", err)
 	}
-	log.Infow("s3 storage configured", "endpoint", s3cfg["endpoint"], "bucket", s3cfg["bucket"])
+	for _, v := range plan.Videos {
+		fmt.Printf(
+			"%s\t%s\t%s\t%v\t%s\n",
+			v.TID, datasize.ByteSize(v.Size).HR(), v.AccessedAt.Format(time.RFC3339), v.AccessCount.Int32, v.URL,
+		)
+	}
+	fmt.Printf(
+		"%v videos would be retired, %s of %s freed\n",
+		len(plan.Videos), datasize.ByteSize(plan.RetiredSize).HR(), datasize.ByteSize(plan.TotalSize).HR(),
+	)
+}
 
+func restore() {
+	log := logger.Sugar()
+	lib := openLibrary()
+	var failed bool
+	for _, tid := range CLI.Restore.TIDs {
+		v, err := lib.Restore(tid)
+		if err != nil {
+			failed = true
+			fmt.Printf("failed\t%s\t%s\n", tid, err)
+			continue
+		}
+		fmt.Printf("restored\t%s\t%s\n", tid, v.URL)
+	}
+	if failed {
+		log.Fatal("some streams were not restored")
+	}
+}
+
+func listArchived() {
+	log := logger.Sugar()
+	lib := openLibrary()
+	items, err := lib.GetArchivedVideos(CLI.Archived.Limit)
+	if err != nil {
+		log.Fatal("cannot list archived streams:", err)
+	}
+	for _, av := range items {
+		kept := "-"
+		if av.ArchiveStorage.Valid {
+			kept = fmt.Sprintf("%s until %s", av.ArchiveStorage.String, av.ExpiresAt.Time.Format(time.RFC3339))
+		}
+		fmt.Printf(
+			"%s\t%s\t%s\t%s\t%s\t%s\n",
+			av.TID, av.ArchivedAt.Format(time.RFC3339), av.Reason, datasize.ByteSize(av.Size).HR(), kept, av.URL,
+		)
+	}
+}
+
+func videoVersions() {
+	log := logger.Sugar()
+	lib := openLibrary()
+	if CLI.Versions.Activate != "" {
+		if err := lib.ActivateVersion(CLI.Versions.Activate); err != nil {
+			log.Fatal("cannot activate version:", err)
+		}
+	}
+	items, err := lib.GetVideoVersions(CLI.Versions.SDHash)
+	if err != nil {
+		log.Fatal("cannot list versions:", err)
+	}
+	for _, v := range items {
+		state := "active"
+		if !v.Active {
+			state = "superseded " + v.SupersededAt.Time.Format(time.RFC3339)
+		}
+		fmt.Printf(
+			"%s\t%s\t%s\t%s\t%s\n",
+			v.TID, v.CreatedAt.Format(time.RFC3339), v.Ladder.String, datasize.ByteSize(v.Size).HR(), state,
+		)
+	}
+}
+
+func migrateManifests() {
+	log := logger.Sugar()
+	lib := openLibrary()
+	report, err := lib.MigrateManifests(library.ManifestMigrationOptions{
+		Limit:  CLI.MigrateManifests.Limit,
+		Stored: CLI.MigrateManifests.Stored,
+		DryRun: CLI.MigrateManifests.DryRun,
+	})
+	if err != nil {
+		log.Fatal("manifest migration failed:", err)
+	}
+	state := "migrated"
+	if CLI.MigrateManifests.DryRun {
+		state = "outdated"
+	}
+	for _, tid := range report.Migrated {
+		fmt.Printf("%s\t%s\n", state, tid)
+	}
+	for tid, err := range report.Failed {
+		fmt.Printf("failed\t%s\t%s\n", tid, err)
+	}
+	log.Infow(
+		"manifests migrated", "schema", library.ManifestSchema,
+		"migrated", len(report.Migrated), "failed", len(report.Failed), "dry_run", CLI.MigrateManifests.DryRun,
+	)
+}
+
+func repairStream() {
+	log := logger.Sugar()
+	lib := openLibrary()
+	results, err := lib.RepairStream(CLI.RepairStream.TID, CLI.RepairStream.Dir)
+	if err != nil {
+		log.Fatal("stream repair failed:", err)
+	}
+	var failed bool
+	for _, r := range results {
+		status := fmt.Sprintf("%v files repaired", len(r.Repaired))
+		if r.Err != nil {
+			failed = true
+			status = r.Err.Error()
+		}
+		fmt.Printf("%s\t%s\t%s\n", r.TID, r.Storage, status)
+		for _, name := range r.Repaired {
+			fmt.Printf("\t%s\n", name)
+		}
+	}
+	if failed {
+		log.Fatal("some stream copies were not repaired")
+	}
+}
+
+// cliLibrary is the library opened by command line tools, along with the storage configuration it uses.
+type cliLibrary struct {
+	*library.Library
+	storage     storage.Driver
+	storageCfg  map[string]string
+	replicaCfgs []replicaConfig
+}
+
+// openLibrary initializes the library with storages and retirement policy from conductor config for command line tools.
+func openLibrary() cliLibrary {
+	log := logger.Sugar()
+	cfg, err := readConfig("conductor")
+	if err != nil {
+		log.Fatal("unable to read config", err)
+	}
+
+	libCfg := cfg.GetStringMapString("library")
+	libDB, err := migrator.ConnectDB(migrator.DefaultDBConfig().DSN(libCfg["dsn"]).AppName("library"), ldb.MigrationsFS)
+	if err != nil {
+		log.Fatal("library db initialization failed", err)
+	}
+	strg, strgCfg, err := initStorage(cfg)
+	if err != nil {
+		log.Fatal("storage initialization failed", err)
+	}
+	replicas, replicaCfgs, err := initReplicas(cfg)
+	if err != nil {
+		log.Fatal("replica storage initialization failed", err)
+	}
+	retirement, err := initRetirementPolicy(cfg)
+	if err != nil {
+		log.Fatal("retirement policy initialization failed", err)
+	}
 	lib := library.New(library.Config{
-		DB:      libDB,
-		Storage: s3storage,
-		Log:     zapadapter.NewKV(nil),
+		DB:         libDB,
+		Storage:    strg,
+		Replicas:   replicas,
+		Retirement: retirement,
+		Log:        zapadapter.NewKV(logger),
 	})
-	valid, broken, err := lib.ValidateStreams(
-		CLI.ValidateStreams.Storage, CLI.ValidateStreams.Offset, CLI.ValidateStreams.Limit, CLI.ValidateStreams.Remove)
+	return cliLibrary{Library: lib, storage: strg, storageCfg: strgCfg, replicaCfgs: replicaCfgs}
+}
+
+type retirementConfig struct {
+	Scorer          string
+	GracePeriod     time.Duration
+	ChannelPriority bool
+	DryRun          bool
+}
+
+// initRetirementPolicy reads the Library.Retirement config section.
+func initRetirementPolicy(cfg *viper.Viper) (library.RetirementPolicy, error) {
+	var rc retirementConfig
+	if err := cfg.UnmarshalKey("library.retirement", &rc); err != nil {
+		return library.RetirementPolicy{}, err
+	}
+	scorer, err := library.ParseRetirementScorer(rc.Scorer)
 	if err != nil {
-		log.Fatal("failed to validate streams:", err)
+		return library.RetirementPolicy{}, err
+	}
+	return library.RetirementPolicy{
+		Scorer:          scorer,
+		GracePeriod:     rc.GracePeriod,
+		ChannelPriority: rc.ChannelPriority,
+		DryRun:          rc.DryRun,
+	}, nil
+}
+
+// loadLadderExperiment reads named ladders from the config. Variants without a path use the default ladder.
+func loadLadderExperiment(cfg *viper.Viper) (*ladder.Experiment, error) {
+	var variantsCfg []struct {
+		Name  string
+		Share int
+		Path  string
+	}
+	err := cfg.UnmarshalKey("ladders", &variantsCfg)
+	if err != nil {
+		return nil, err
+	}
+	variants := []ladder.Variant{}
+	for _, vc := range variantsCfg {
+		l := ladder.Default
+		if vc.Path != "" {
+			l, err = ladder.LoadFile(vc.Path)
+			if err != nil {
+				return nil, fmt.Errorf("cannot load ladder %s: %w", vc.Name, err)
+			}
+		}
+		variants = append(variants, ladder.Variant{Name: vc.Name, Share: vc.Share, Ladder: l})
+	}
+	return ladder.NewExperiment(variants...)
+}
+
+type signingConfig struct {
+	TTL  time.Duration
+	Keys []urlsign.Key
+}
+
+// initSigner configures signing of stream URLs, returning nil if the Signing config section is absent.
+func initSigner(cfg *viper.Viper) (*urlsign.Signer, error) {
+	if !cfg.IsSet("signing") {
+		return nil, nil
+	}
+	var sc signingConfig
+	if err := cfg.UnmarshalKey("signing", &sc); err != nil {
+		return nil, err
+	}
+	return urlsign.New(sc.TTL, sc.Keys...)
+}
+
+// initStorage configures local storage if there is a Local config section and S3 otherwise.
+// Returns the config section used.
+func initStorage(cfg *viper.Viper) (storage.Driver, map[string]string, error) {
+	log := logger.Sugar()
+	signer, err := initSigner(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg.IsSet("local") {
+		lcfg := cfg.GetStringMapString("local")
+		lc := storage.LocalConfigure().
+			Name(lcfg["name"]).
+			Path(lcfg["path"]).
+			URL(lcfg["url"])
+		if n, err := strconv.Atoi(lcfg["archivedays"]); err == nil {
+			lc = lc.Archive(n)
+		}
+		if signer != nil {
+			lc = lc.Signer(signer)
+		}
+		ls, err := storage.InitLocalStorage(lc)
+		if err != nil {
+			return nil, nil, err
+		}
+		log.Infow("local storage configured", "path", lcfg["path"], "url", lcfg["url"])
+		return ls, lcfg, nil
+	}
+
+	s3cfg := cfg.GetStringMapString("s3")
+	s3storage, err := initS3Storage(s3cfg, signer)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s3storage, s3cfg, nil
+}
+
+func initS3Storage(s3cfg map[string]string, signer *urlsign.Signer) (*storage.S3Driver, error) {
+	s3c := storage.S3Configure().
+		Endpoint(s3cfg["endpoint"]).
+		Credentials(s3cfg["key"], s3cfg["secret"]).
+		Bucket(s3cfg["bucket"]).
+		Name(s3cfg["name"]).
+		URL(s3cfg["url"]).
+		Signer(signer)
+	if s3cfg["private"] == "true" {
+		s3c = s3c.Private()
+	}
+	if s3cfg["createbucket"] == "true" {
+		s3c = s3c.CreateBucket()
+	}
+	if n, err := strconv.Atoi(s3cfg["uploadconcurrency"]); err == nil {
+		s3c = s3c.UploadConcurrency(n)
+	}
+	if n, err := strconv.Atoi(s3cfg["uploadretries"]); err == nil {
+		s3c = s3c.UploadRetries(n)
+	}
+	if n, err := strconv.Atoi(s3cfg["archivedays"]); err == nil {
+		s3c = s3c.Archive(s3cfg["archiveprefix"], n)
+	}
+	s3storage, err := storage.InitS3Driver(s3c)
+	if err != nil {
+		return nil, err
+	}
+	logger.Sugar().Infow("s3 storage configured", "name", s3cfg["name"], "endpoint", s3cfg["endpoint"], "bucket", s3cfg["bucket"])
+	return s3storage, nil
+}
+
+type replicaConfig struct {
+	Name          string
+	Endpoint      string
+	Bucket        string
+	Key           string
+	Secret        string
+	CreateBucket  bool
+	Policy        string
+	MaxSize       string
+	ArchivePrefix string
+	ArchiveDays   int
+	Private       bool
+	URL           string
+}
+
+// initReplicas configures S3 storages listed in the Replicas config section.
+func initReplicas(cfg *viper.Viper) ([]library.Replica, []replicaConfig, error) {
+	var rcs []replicaConfig
+	if err := cfg.UnmarshalKey("replicas", &rcs); err != nil {
+		return nil, nil, err
+	}
+	signer, err := initSigner(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	replicas := []library.Replica{}
+	for _, rc := range rcs {
+		policy, err := library.ParseReplicationPolicy(rc.Policy)
+		if err != nil {
+			return nil, nil, err
+		}
+		s, err := initS3Storage(map[string]string{
+			"name":          rc.Name,
+			"endpoint":      rc.Endpoint,
+			"bucket":        rc.Bucket,
+			"key":           rc.Key,
+			"secret":        rc.Secret,
+			"createbucket":  strconv.FormatBool(rc.CreateBucket),
+			"archiveprefix": rc.ArchivePrefix,
+			"archivedays":   strconv.Itoa(rc.ArchiveDays),
+			"private":       strconv.FormatBool(rc.Private),
+			"url":           rc.URL,
+		}, signer)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot configure replica %s: %w", rc.Name, err)
+		}
+		replicas = append(replicas, library.Replica{Storage: s, Policy: policy})
 	}
-	fmt.Printf("%v streams checked, %v valid, %v broken\n", len(valid)+len(broken), len(valid), len(broken))
+	return replicas, rcs, nil
 }
 
 func readConfig(name string) (*viper.Viper, error) {
@@ -335,6 +988,7 @@ func startMetricsServer(bind string, stopChan chan struct{}) error {
 	router := router.New()
 
 	metrics.RegisterWorkerMetrics()
+	imetrics.RegisterMetrics()
 	router.GET("/metrics", fasthttpadaptor.NewFastHTTPHandler(promhttp.Handler()))
 
 	log.Info("starting worker http server", "addr", bind)
@@ -4,8 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/lbryio/transcoder/ladder"
 	"github.com/lbryio/transcoder/library"
 	"github.com/lbryio/transcoder/manager"
 	"github.com/lbryio/transcoder/pkg/conductor/metrics"
@@ -26,8 +28,14 @@ type Conductor struct {
 	options        *ConductorOptions
 }
 
+// taskUniqueTTL is how long a dispatched stream is deduplicated for.
+// Stream keys are reused for the same period so duplicate tasks carry identical payloads.
+const taskUniqueTTL = 24 * time.Hour
+
 type ConductorOptions struct {
-	Logger logging.KVLogger
+	Logger     logging.KVLogger
+	Experiment *ladder.Experiment
+	KeyURL     string
 }
 
 func WithLogger(logger logging.KVLogger) func(options *ConductorOptions) {
@@ -36,6 +44,22 @@ func WithLogger(logger logging.KVLogger) func(options *ConductorOptions) {
 	}
 }
 
+// WithLadderExperiment makes conductor assign one of experiment ladders to each dispatched task.
+// Without it workers use their default ladder.
+func WithLadderExperiment(e *ladder.Experiment) func(options *ConductorOptions) {
+	return func(options *ConductorOptions) {
+		options.Experiment = e
+	}
+}
+
+// WithKeyURL sets the base URL of stream key endpoint, written into playlists of encrypted streams.
+// Streams of channels requiring encryption are not dispatched without it.
+func WithKeyURL(u string) func(options *ConductorOptions) {
+	return func(options *ConductorOptions) {
+		options.KeyURL = strings.TrimRight(u, "/")
+	}
+}
+
 func NewConductor(
 	redisOpts asynq.RedisConnOpt, incoming <-chan *manager.TranscodingRequest, library *library.Library,
 	optionFuncs ...func(*ConductorOptions),
@@ -77,6 +101,8 @@ func StartWorker(redisOpts asynq.RedisConnOpt, concurrency int, runner *tasks.En
 	// mux maps a type to a handler
 	mux := asynq.NewServeMux()
 	mux.HandleFunc(tasks.TypeTranscodingRequest, runner.Run)
+	mux.HandleFunc(tasks.TypeChunkEncode, runner.RunChunk)
+	mux.HandleFunc(tasks.TypeChunkStitch, runner.RunStitch)
 
 	if err := srv.Run(mux); err != nil {
 		log.Fatal("could not run server: %v", err)
@@ -150,17 +176,31 @@ func (c *Conductor) DispatchNextTask() error {
 	req.URL = trReq.URI
 	req.SDHash = trReq.SDHash
 	logger := c.options.Logger.With("url", req.URL, "sd_hash", req.SDHash)
+	if c.options.Experiment != nil {
+		l := c.options.Experiment.Assign(req.SDHash)
+		req.Ladder = &l
+		logger = logger.With("ladder", l.Name)
+	}
+	if err := c.assignStreamKey(req, trReq.ChannelClaimID); err != nil {
+		return fmt.Errorf("stream key error: %w", err)
+	}
+	if req.Encryption != nil {
+		logger = logger.With("key_id", req.Encryption.KeyID)
+	}
 	t, err := tasks.NewTranscodingTask(*req)
 	if err != nil {
 		return fmt.Errorf("task creation error: %w", err)
 	}
-	info, err := c.asynqClient.Enqueue(
-		t,
-		asynq.Unique(24*time.Hour),
-		asynq.Timeout(24*time.Hour),
-		asynq.Retention(72*time.Hour),
+	opts := []asynq.Option{
+		asynq.Unique(taskUniqueTTL),
+		asynq.Timeout(24 * time.Hour),
 		// asynq.Queue("critical"),
-	)
+	}
+	// Payloads of encrypted streams carry their keys, so they are not kept in redis once done.
+	if req.Encryption == nil {
+		opts = append(opts, asynq.Retention(72*time.Hour))
+	}
+	info, err := c.asynqClient.Enqueue(t, opts...)
 	if errors.Is(err, asynq.ErrDuplicateTask) {
 		logger.Info("task deemed duplicate, skipping")
 		return c.DispatchNextTask()
@@ -172,6 +212,35 @@ func (c *Conductor) DispatchNextTask() error {
 	return nil
 }
 
+// assignStreamKey sets an encryption key on the request when the stream channel requires encrypted output.
+func (c *Conductor) assignStreamKey(req *tasks.TranscodingRequest, channelClaimID string) error {
+	method, err := c.library.ChannelEncryption(channelClaimID)
+	if err != nil {
+		return err
+	}
+	if method == "" {
+		return nil
+	}
+	if c.options.KeyURL == "" {
+		return fmt.Errorf("channel %v requires encryption but key url is not configured", channelClaimID)
+	}
+	// Storage configuration might have changed since encryption was enabled for the channel.
+	if err := c.library.EncryptionSupported(); err != nil {
+		return fmt.Errorf("channel %v requires encryption: %w", channelClaimID, err)
+	}
+	k, err := c.library.StreamKey(req.SDHash, method, taskUniqueTTL)
+	if err != nil {
+		return err
+	}
+	req.Encryption = &tasks.StreamKey{
+		Method: k.Method,
+		KeyID:  k.KID,
+		URI:    c.options.KeyURL + "/" + k.KID,
+		Key:    k.Key,
+	}
+	return nil
+}
+
 func (c *Conductor) ProcessNextResult() error {
 	res := &tasks.TranscodingResult{}
 	r, err := c.rdb.BLPop(context.Background(), 0, tasks.QueueTranscodingResults).Result()
@@ -183,6 +252,11 @@ func (c *Conductor) ProcessNextResult() error {
 	if err != nil {
 		return fmt.Errorf("message parsing error: %w", err)
 	}
+	if res.Error != "" {
+		c.options.Logger.Error("transcoding failed", "url", res.URL, "sd_hash", res.SDHash, "err", res.Error)
+		metrics.RequestsFailed.Inc()
+		return nil
+	}
 	logger := c.options.Logger.With("url", res.Stream.URL(), "sd_hash", res.Stream.SDHash())
 	if err := c.library.AddRemoteStream(*res.Stream); err != nil {
 		logger.Info("error adding remote stream", "err", err)
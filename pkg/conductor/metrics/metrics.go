@@ -13,6 +13,9 @@ const (
 	StageAccepted     = "accepted"
 	StageDownloading  = "downloading"
 	StageEncoding     = "encoding"
+	StageQuality      = "quality_scoring"
+	StageSplitting    = "splitting"
+	StageStitching    = "stitching"
 	StageUploading    = "uploading"
 	StageMetadataFill = "metadata_fill"
 	StageLibraryAdd   = "library_add"
@@ -27,6 +30,9 @@ var (
 	RequestsCompleted = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "requests_completed",
 	}, []string{LabelWorkerName})
+	RequestsFailed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "requests_failed",
+	})
 	Capacity = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "capacity",
 	}, []string{LabelWorkerName})
@@ -62,7 +68,7 @@ var (
 func RegisterConductorMetrics() {
 	once.Do(func() {
 		prometheus.MustRegister(
-			RequestsPublished, RequestsCompleted, Capacity, Running)
+			RequestsPublished, RequestsCompleted, RequestsFailed, Capacity, Running)
 	})
 }
 
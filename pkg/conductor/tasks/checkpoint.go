@@ -0,0 +1,108 @@
+package tasks
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	checkpointExt = ".checkpoint"
+	// checkpointRefreshInterval is how often checkpoints of running encodes are saved again to keep them fresh.
+	checkpointRefreshInterval = time.Minute
+)
+
+// checkpoint records progress of a transcoding task so it can be resumed by a retry after a worker crash.
+// It is stored in the output dir next to the encoder output, both keyed by SD hash.
+type checkpoint struct {
+	SDHash     string    `json:"sd_hash"`
+	URL        string    `json:"url"`
+	ChannelURI string    `json:"channel_uri"`
+	Source     string    `json:"source"`
+	SourceSize int64     `json:"source_size"`
+	Output     string    `json:"output"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+func (r *EncoderRunner) checkpointPath(sdHash string) string {
+	return path.Join(r.options.OutputDir, sdHash+checkpointExt)
+}
+
+// loadCheckpoint returns nil if there is no usable checkpoint for the stream.
+func (r *EncoderRunner) loadCheckpoint(sdHash string) *checkpoint {
+	data, err := os.ReadFile(r.checkpointPath(sdHash))
+	if err != nil {
+		return nil
+	}
+	cp := &checkpoint{}
+	if err := json.Unmarshal(data, cp); err != nil {
+		r.options.Logger.Warn("malformed checkpoint", "sd_hash", sdHash, "err", err)
+		return nil
+	}
+	fi, err := os.Stat(cp.Source)
+	if err != nil || fi.Size() != cp.SourceSize {
+		r.options.Logger.Info("checkpointed source is missing or incomplete", "sd_hash", sdHash)
+		return nil
+	}
+	return cp
+}
+
+func (r *EncoderRunner) saveCheckpoint(cp *checkpoint) error {
+	cp.UpdatedAt = time.Now()
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp := r.checkpointPath(cp.SDHash) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.checkpointPath(cp.SDHash))
+}
+
+func (r *EncoderRunner) removeCheckpoint(sdHash string) {
+	os.Remove(r.checkpointPath(sdHash))
+}
+
+// cleanCheckpoints removes checkpoints older than CheckpointTTL along with their files, as well as
+// anything in work dirs not belonging to a live checkpoint. Must be called before the runner starts taking tasks.
+func (r *EncoderRunner) cleanCheckpoints() error {
+	keep := map[string]bool{}
+	entries, err := os.ReadDir(r.options.OutputDir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), checkpointExt) {
+			continue
+		}
+		sdHash := strings.TrimSuffix(e.Name(), checkpointExt)
+		cp := r.loadCheckpoint(sdHash)
+		if cp == nil || time.Since(cp.UpdatedAt) > r.options.CheckpointTTL {
+			r.options.Logger.Info("removing stale checkpoint", "sd_hash", sdHash)
+			continue
+		}
+		r.options.Logger.Info("checkpoint found", "sd_hash", sdHash, "updated_at", cp.UpdatedAt)
+		keep[e.Name()] = true
+		keep[path.Base(cp.Source)] = true
+		keep[path.Base(cp.Output)] = true
+	}
+
+	for _, dir := range []string{r.options.StreamsDir, r.options.OutputDir} {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if keep[e.Name()] {
+				continue
+			}
+			if err := os.RemoveAll(path.Join(dir, e.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package tasks
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpointsSurviveRestart(t *testing.T) {
+	streamsDir, outputDir := t.TempDir(), t.TempDir()
+	r, err := NewEncoderRunner(nil, nil, nil,
+		WithStreamsDir(streamsDir), WithOutputDir(outputDir), WithCheckpoints(time.Hour))
+	require.NoError(t, err)
+
+	source := path.Join(streamsDir, "source.mp4")
+	require.NoError(t, os.WriteFile(source, []byte("source"), 0644))
+	output := path.Join(outputDir, "sdhash")
+	require.NoError(t, os.MkdirAll(output, os.ModePerm))
+	require.NoError(t, r.saveCheckpoint(&checkpoint{SDHash: "sdhash", Source: source, SourceSize: 6, Output: output}))
+	r.Cleanup()
+
+	r, err = NewEncoderRunner(nil, nil, nil,
+		WithStreamsDir(streamsDir), WithOutputDir(outputDir), WithCheckpoints(time.Hour))
+	require.NoError(t, err)
+	cp := r.loadCheckpoint("sdhash")
+	require.NotNil(t, cp, "checkpoint should survive worker restart")
+	assert.DirExists(t, cp.Output)
+
+	// Checkpoints not updated within TTL are removed on start along with their files.
+	r.options.CheckpointTTL = time.Nanosecond
+	require.NoError(t, r.cleanCheckpoints())
+	assert.NoFileExists(t, r.checkpointPath("sdhash"))
+	assert.NoFileExists(t, source)
+	assert.NoDirExists(t, output)
+}
@@ -0,0 +1,368 @@
+package tasks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/lbryio/transcoder/encoder"
+	"github.com/lbryio/transcoder/library"
+	"github.com/lbryio/transcoder/pkg/conductor/metrics"
+	"github.com/lbryio/transcoder/pkg/logging"
+	"go.etcd.io/etcd/api/v3/version"
+
+	"github.com/hibiken/asynq"
+	"github.com/pkg/errors"
+)
+
+const (
+	TypeChunkEncode = "transcoder:encode_chunk"
+	TypeChunkStitch = "transcoder:stitch_chunks"
+
+	// stagingPrefix is a storage prefix for passing chunks between workers, removed after stitching.
+	stagingPrefix = "chunks"
+	// chunksDoneKey is a redis set of encoded chunk indexes for a split attempt.
+	chunksDoneKey = "transcoder:chunks_done:%s:%s"
+	// chunksFailedKey is set once a split attempt fails for good so remaining chunks are skipped.
+	chunksFailedKey = "transcoder:chunks_failed:%s:%s"
+	chunksDoneTTL   = 72 * time.Hour
+)
+
+func stagingKey(sdHash, attempt string, elem ...string) string {
+	return path.Join(append([]string{stagingPrefix, sdHash, attempt}, elem...)...)
+}
+
+func encodedChunkKey(sdHash, attempt string, n int) string {
+	return stagingKey(sdHash, attempt, "encoded", fmt.Sprintf("%04d", n))
+}
+
+// newAttempt returns a random ID for a split of the source, so retranscoding the same stream
+// never reuses subtask IDs or staging data of a previous split.
+func newAttempt() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// splitIfLong cuts sources longer than two chunk durations into chunks, uploads them to staging storage
+// and dispatches a subtask per chunk. Returns false if the source should be encoded in one piece.
+func (r *EncoderRunner) splitIfLong(
+	payload TranscodingRequest, channelURI, origFile string, sourceSize int64, log logging.KVLogger,
+) (bool, error) {
+	meta, err := r.encoder.GetMetadata(origFile)
+	if err != nil {
+		return false, fmt.Errorf("cannot read metadata: %v: %w", err, asynq.SkipRetry)
+	}
+	dur, _ := strconv.ParseFloat(meta.FMeta.GetFormat().GetDuration(), 64)
+	if dur < 2*r.options.ChunkDuration.Seconds() {
+		return false, nil
+	}
+
+	timer := time.Now()
+	runMtr := metrics.StageRunning.WithLabelValues(metrics.StageSplitting)
+	spentMtr := metrics.SpentSeconds.WithLabelValues(metrics.StageSplitting)
+	runMtr.Inc()
+	defer runMtr.Dec()
+	defer func() { spentMtr.Add(time.Since(timer).Seconds()) }()
+
+	splitDir := path.Join(r.options.OutputDir, payload.SDHash+"_split")
+	defer os.RemoveAll(splitDir)
+	split, err := r.encoder.Split(origFile, splitDir, payload.Ladder, r.options.ChunkDuration)
+	if err != nil {
+		log.Error("splitting failed", "err", err)
+		metrics.ErrorsCount.WithLabelValues(metrics.StageSplitting).Inc()
+		return false, fmt.Errorf("splitting failed: %v: %w", err, asynq.SkipRetry)
+	}
+	os.Remove(origFile)
+
+	ctx := context.Background()
+	attempt := newAttempt()
+	if err := r.storage.PutDir(ctx, stagingKey(payload.SDHash, attempt, "source"), path.Dir(split.Chunks[0])); err != nil {
+		metrics.ErrorsCount.WithLabelValues(metrics.StageSplitting).Inc()
+		return false, fmt.Errorf("chunks upload failed: %w", err)
+	}
+	if err := r.storage.PutDir(ctx, stagingKey(payload.SDHash, attempt, "extras"), split.Extras); err != nil {
+		metrics.ErrorsCount.WithLabelValues(metrics.StageSplitting).Inc()
+		return false, fmt.Errorf("extras upload failed: %w", err)
+	}
+
+	for n := range split.Chunks {
+		req := ChunkRequest{
+			URL:        payload.URL,
+			SDHash:     payload.SDHash,
+			ChannelURI: channelURI,
+			Attempt:    attempt,
+			Index:      n,
+			Total:      len(split.Chunks),
+			Duration:   dur,
+			SourceSize: sourceSize,
+			Ladder:     split.Ladder,
+		}
+		_, err := r.asynqClient.Enqueue(
+			asynq.NewTask(TypeChunkEncode, []byte(req.String()), asynq.MaxRetry(5)),
+			asynq.TaskID(fmt.Sprintf("chunk:%s:%s:%d", payload.SDHash, attempt, n)),
+			asynq.Timeout(24*time.Hour),
+		)
+		if err != nil {
+			// The transcoding task is retried with a new split, chunks already dispatched are skipped.
+			r.abandonAttempt(payload.SDHash, attempt, log)
+			return false, fmt.Errorf("chunk task enqueue error: %w", err)
+		}
+	}
+	log.Info("chunk tasks dispatched", "chunks", len(split.Chunks), "duration", dur, "attempt", attempt)
+	return true, nil
+}
+
+// RunChunk encodes a single chunk and uploads the output to staging storage.
+// The worker finishing the last chunk dispatches a stitching task.
+// A chunk failing for good fails the whole stream, see failChunked.
+func (r *EncoderRunner) RunChunk(ctx context.Context, t *asynq.Task) (err error) {
+	if t.Type() != TypeChunkEncode {
+		return fmt.Errorf("can only handle %s", TypeChunkEncode)
+	}
+	if r.asynqClient == nil {
+		return fmt.Errorf("redis is not configured for chunk tasks: %w", asynq.SkipRetry)
+	}
+	var payload ChunkRequest
+	if err := json.Unmarshal(t.Payload(), &payload); err != nil {
+		return fmt.Errorf("json.Unmarshal failed: %v: %w", err, asynq.SkipRetry)
+	}
+	log := logging.AddLogRef(r.options.Logger, payload.SDHash).With(
+		"chunk", payload.Index, "total", payload.Total, "attempt", payload.Attempt,
+	)
+	failedKey := fmt.Sprintf(chunksFailedKey, payload.SDHash, payload.Attempt)
+	if n, _ := r.rdb.Exists(ctx, failedKey).Result(); n > 0 {
+		log.Info("stream failed in another chunk, skipping")
+		return nil
+	}
+	defer func() {
+		if err != nil && lastAttempt(ctx, err) {
+			r.failChunked(payload.URL, payload.SDHash, payload.Attempt, err, log)
+		}
+	}()
+
+	chunkName := fmt.Sprintf(encoder.ChunkName, payload.Index)
+	chunkFile := path.Join(r.options.StreamsDir, payload.SDHash+"_"+chunkName)
+	outDir := path.Join(r.options.OutputDir, fmt.Sprintf("%s_chunk%04d", payload.SDHash, payload.Index))
+	defer os.Remove(chunkFile)
+	defer os.RemoveAll(outDir)
+
+	if err := r.fetchStaged(stagingKey(payload.SDHash, payload.Attempt, "source"), chunkName, chunkFile); err != nil {
+		metrics.ErrorsCount.WithLabelValues(metrics.StageDownloading).Inc()
+		return fmt.Errorf("chunk download failed: %w", err)
+	}
+
+	timer := time.Now()
+	runMtr := metrics.StageRunning.WithLabelValues(metrics.StageEncoding)
+	spentMtr := metrics.SpentSeconds.WithLabelValues(metrics.StageEncoding)
+	runMtr.Inc()
+	res, err := r.encoder.EncodeChunk(chunkFile, outDir, payload.Ladder)
+	if err != nil {
+		log.Error("encoder failure", "err", err)
+		metrics.ErrorsCount.WithLabelValues(metrics.StageEncoding).Inc()
+		spentMtr.Add(time.Since(timer).Seconds())
+		runMtr.Dec()
+		return fmt.Errorf("encoder failure: %v: %w", err, asynq.SkipRetry)
+	}
+	seen := map[int]bool{}
+	for p := range res.Progress {
+		pg := int(math.Ceil(p.GetProgress()))
+		if pg%25 == 0 && !seen[pg] {
+			seen[pg] = true
+			log.Info("encoding chunk", "progress", pg)
+		}
+	}
+	spentMtr.Add(time.Since(timer).Seconds())
+	runMtr.Dec()
+
+	chunkKey := encodedChunkKey(payload.SDHash, payload.Attempt, payload.Index)
+	if err := r.storage.PutDir(ctx, chunkKey, outDir); err != nil {
+		metrics.ErrorsCount.WithLabelValues(metrics.StageUploading).Inc()
+		return fmt.Errorf("encoded chunk upload failed: %w", err)
+	}
+
+	key := fmt.Sprintf(chunksDoneKey, payload.SDHash, payload.Attempt)
+	pipe := r.rdb.TxPipeline()
+	pipe.SAdd(ctx, key, payload.Index)
+	pipe.Expire(ctx, key, chunksDoneTTL)
+	done := pipe.SCard(ctx, key)
+	failed := pipe.Exists(ctx, failedKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("cannot record chunk progress: %w", err)
+	}
+	if failed.Val() > 0 {
+		// Staging data was already removed when the stream failed, the upload above is left over.
+		log.Info("stream failed in another chunk, discarding")
+		if err := r.storage.DeleteDir(chunkKey); err != nil {
+			log.Warn("failed to remove encoded chunk", "err", err)
+		}
+		return nil
+	}
+	log.Info("chunk encoded", "done", done.Val())
+	if done.Val() < int64(payload.Total) {
+		return nil
+	}
+
+	req := StitchRequest{
+		URL:        payload.URL,
+		SDHash:     payload.SDHash,
+		ChannelURI: payload.ChannelURI,
+		Attempt:    payload.Attempt,
+		Total:      payload.Total,
+		Duration:   payload.Duration,
+		SourceSize: payload.SourceSize,
+		Ladder:     payload.Ladder,
+	}
+	_, err = r.asynqClient.Enqueue(
+		asynq.NewTask(TypeChunkStitch, []byte(req.String()), asynq.MaxRetry(5)),
+		asynq.TaskID(fmt.Sprintf("stitch:%s:%s", payload.SDHash, payload.Attempt)),
+		asynq.Timeout(24*time.Hour),
+	)
+	if err != nil && !errors.Is(err, asynq.ErrTaskIDConflict) {
+		return fmt.Errorf("stitch task enqueue error: %w", err)
+	}
+	log.Info("stitch task dispatched")
+	return nil
+}
+
+// RunStitch joins encoded chunks into a stream, publishes it the same way as a regular transcoding task
+// and removes staging data. Quality scoring is not performed for chunked streams.
+func (r *EncoderRunner) RunStitch(ctx context.Context, t *asynq.Task) (err error) {
+	if t.Type() != TypeChunkStitch {
+		return fmt.Errorf("can only handle %s", TypeChunkStitch)
+	}
+	var payload StitchRequest
+	if err := json.Unmarshal(t.Payload(), &payload); err != nil {
+		return fmt.Errorf("json.Unmarshal failed: %v: %w", err, asynq.SkipRetry)
+	}
+	log := logging.AddLogRef(r.options.Logger, payload.SDHash).With("chunks", payload.Total, "attempt", payload.Attempt)
+	defer func() {
+		if err != nil && lastAttempt(ctx, err) {
+			r.failChunked(payload.URL, payload.SDHash, payload.Attempt, err, log)
+		}
+	}()
+
+	timer := time.Now()
+	runMtr := metrics.StageRunning.WithLabelValues(metrics.StageStitching)
+	spentMtr := metrics.SpentSeconds.WithLabelValues(metrics.StageStitching)
+	runMtr.Inc()
+
+	workDir := path.Join(r.options.OutputDir, payload.SDHash+"_stitch")
+	streamDir := path.Join(r.options.OutputDir, payload.SDHash)
+	defer os.RemoveAll(workDir)
+	defer os.RemoveAll(streamDir)
+
+	chunkDirs := make([]string, payload.Total)
+	for n := range chunkDirs {
+		chunkDirs[n] = path.Join(workDir, fmt.Sprintf("%04d", n))
+		if err := r.storage.GetDir(ctx, encodedChunkKey(payload.SDHash, payload.Attempt, n), chunkDirs[n]); err != nil {
+			metrics.ErrorsCount.WithLabelValues(metrics.StageStitching).Inc()
+			spentMtr.Add(time.Since(timer).Seconds())
+			runMtr.Dec()
+			return fmt.Errorf("encoded chunk %v download failed: %w", n, err)
+		}
+	}
+	err = encoder.StitchChunks(chunkDirs, streamDir)
+	if err == nil {
+		err = r.storage.GetDir(ctx, stagingKey(payload.SDHash, payload.Attempt, "extras"), streamDir)
+	}
+	spentMtr.Add(time.Since(timer).Seconds())
+	runMtr.Dec()
+	if err != nil {
+		log.Error("stitching failed", "err", err)
+		metrics.ErrorsCount.WithLabelValues(metrics.StageStitching).Inc()
+		return fmt.Errorf("stitching failed: %v: %w", err, asynq.SkipRetry)
+	}
+
+	stream := library.InitStream(streamDir, r.storage.Name())
+	err = stream.GenerateManifest(
+		payload.URL, payload.ChannelURI, payload.SDHash,
+		library.WithTimestamp(time.Now()),
+		library.WithWorkerName(r.options.Name),
+		library.WithVersion(version.Version),
+		library.WithInputSize(payload.SourceSize),
+	)
+	if err != nil {
+		metrics.ErrorsCount.WithLabelValues(metrics.StageMetadataFill).Inc()
+		return fmt.Errorf("failed to fill manifest: %w", err)
+	}
+	stream.Manifest.Ladder = payload.Ladder
+	metrics.OutputBytes.Add(float64(stream.Size()))
+	metrics.TranscodedCount.Inc()
+	metrics.TranscodedSeconds.Add(payload.Duration)
+	log.Info("chunks stitched", "stream_size", stream.Size())
+
+	if err := r.publish(t, stream, log); err != nil {
+		return err
+	}
+	if err := r.storage.DeleteDir(stagingKey(payload.SDHash, payload.Attempt)); err != nil {
+		log.Warn("failed to remove staging data", "err", err)
+	}
+	r.rdb.Del(ctx, fmt.Sprintf(chunksDoneKey, payload.SDHash, payload.Attempt))
+	return nil
+}
+
+// lastAttempt reports whether asynq is not going to retry a task failed with err.
+func lastAttempt(ctx context.Context, err error) bool {
+	if errors.Is(err, asynq.SkipRetry) {
+		return true
+	}
+	retried, ok := asynq.GetRetryCount(ctx)
+	maxRetry, _ := asynq.GetMaxRetry(ctx)
+	return ok && retried >= maxRetry
+}
+
+// abandonAttempt makes remaining chunks of a split attempt skipped and removes its staging data.
+// Returns false if the attempt has already been abandoned.
+func (r *EncoderRunner) abandonAttempt(sdHash, attempt string, log logging.KVLogger) bool {
+	ctx := context.Background()
+	first, err := r.rdb.SetNX(ctx, fmt.Sprintf(chunksFailedKey, sdHash, attempt), 1, chunksDoneTTL).Result()
+	if err != nil {
+		log.Warn("cannot mark split attempt as failed", "err", err)
+	} else if !first {
+		return false
+	}
+	if err := r.storage.DeleteDir(stagingKey(sdHash, attempt)); err != nil {
+		log.Warn("failed to remove staging data", "err", err)
+	}
+	r.rdb.Del(ctx, fmt.Sprintf(chunksDoneKey, sdHash, attempt))
+	return true
+}
+
+// failChunked gives up on a stream when one of its subtasks is not going to be retried:
+// the split attempt is abandoned and the failure is reported to conductor, once per attempt.
+func (r *EncoderRunner) failChunked(url, sdHash, attempt string, cause error, log logging.KVLogger) {
+	if !r.abandonAttempt(sdHash, attempt, log) {
+		return
+	}
+	log.Error("chunked transcoding failed", "err", cause)
+	res, err := json.Marshal(TranscodingResult{URL: url, SDHash: sdHash, Error: cause.Error()})
+	if err != nil {
+		log.Warn("cannot serialize transcoding result", "err", err)
+		return
+	}
+	r.resultWriter.Write(res)
+}
+
+func (r *EncoderRunner) fetchStaged(prefix, name, dst string) error {
+	src, err := r.storage.GetFragment(prefix, name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, src)
+	return err
+}
@@ -0,0 +1,97 @@
+package tasks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/lbryio/transcoder/storage"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/hibiken/asynq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeEncodedChunk creates a minimal single-variant HLS output of a chunk encode.
+func writeEncodedChunk(t *testing.T, dir string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(dir, os.ModePerm))
+	require.NoError(t, os.WriteFile(path.Join(dir, "v0_s000000.ts"), []byte(dir), 0644))
+	pl := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:10\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n" +
+		"#EXTINF:10.000000,\nv0_s000000.ts\n#EXT-X-ENDLIST\n"
+	require.NoError(t, os.WriteFile(path.Join(dir, "v0.m3u8"), []byte(pl), 0644))
+	master := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-STREAM-INF:BANDWIDTH=500000,RESOLUTION=640x360\nv0.m3u8\n"
+	require.NoError(t, os.WriteFile(path.Join(dir, "master.m3u8"), []byte(master), 0644))
+}
+
+func TestRunStitchRemovesStaging(t *testing.T) {
+	strg, err := storage.InitLocalStorage(
+		storage.LocalConfigure().Name("local").Path(t.TempDir()).URL("http://localhost/streams/"),
+	)
+	require.NoError(t, err)
+	sdHash, attempt := "sdhash", newAttempt()
+	ctx := context.Background()
+	for n := 0; n < 2; n++ {
+		dir := path.Join(t.TempDir(), fmt.Sprintf("%04d", n))
+		writeEncodedChunk(t, dir)
+		require.NoError(t, strg.PutDir(ctx, encodedChunkKey(sdHash, attempt, n), dir))
+	}
+	require.NoError(t, strg.PutDir(ctx, stagingKey(sdHash, attempt, "extras"), t.TempDir()))
+
+	results := &bytes.Buffer{}
+	r, err := NewEncoderRunner(strg, nil, results, WithOutputDir(t.TempDir()))
+	require.NoError(t, err)
+	// Redis is unreachable, clearing done chunks is best effort.
+	r.rdb = redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	payload, err := json.Marshal(StitchRequest{URL: "lbry://stitched", SDHash: sdHash, Attempt: attempt, Total: 2, Duration: 20})
+	require.NoError(t, err)
+
+	require.NoError(t, r.RunStitch(ctx, asynq.NewTask(TypeChunkStitch, payload)))
+	res := TranscodingResult{}
+	require.NoError(t, json.Unmarshal(results.Bytes(), &res))
+	files, err := strg.ListFiles(res.Stream.TID())
+	require.NoError(t, err)
+	assert.Contains(t, files, "v0_s000001.ts")
+
+	err = strg.GetDir(ctx, stagingKey(sdHash, attempt, "extras"), t.TempDir())
+	assert.Error(t, err, "staging data should be removed after stitching")
+	err = strg.GetDir(ctx, encodedChunkKey(sdHash, attempt, 0), t.TempDir())
+	assert.Error(t, err, "staging data should be removed after stitching")
+}
+
+func TestRunStitchFailureReported(t *testing.T) {
+	strg, err := storage.InitLocalStorage(
+		storage.LocalConfigure().Name("local").Path(t.TempDir()).URL("http://localhost/streams/"),
+	)
+	require.NoError(t, err)
+	sdHash, attempt := "sdhash", newAttempt()
+	ctx := context.Background()
+	// Encoded chunk without playlists cannot be stitched.
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(path.Join(dir, "v0_s000000.ts"), []byte(dir), 0644))
+	require.NoError(t, strg.PutDir(ctx, encodedChunkKey(sdHash, attempt, 0), dir))
+
+	results := &bytes.Buffer{}
+	r, err := NewEncoderRunner(strg, nil, results, WithOutputDir(t.TempDir()))
+	require.NoError(t, err)
+	r.rdb = redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	payload, err := json.Marshal(StitchRequest{URL: "lbry://stitched", SDHash: sdHash, Attempt: attempt, Total: 1})
+	require.NoError(t, err)
+
+	err = r.RunStitch(ctx, asynq.NewTask(TypeChunkStitch, payload))
+	require.ErrorIs(t, err, asynq.SkipRetry)
+	res := TranscodingResult{}
+	require.NoError(t, json.Unmarshal(results.Bytes(), &res))
+	assert.Nil(t, res.Stream)
+	assert.Equal(t, sdHash, res.SDHash)
+	assert.Equal(t, "lbry://stitched", res.URL)
+	assert.NotEmpty(t, res.Error)
+
+	err = strg.GetDir(ctx, encodedChunkKey(sdHash, attempt, 0), t.TempDir())
+	assert.Error(t, err, "staging data should be removed after failure")
+}
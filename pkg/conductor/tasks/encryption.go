@@ -0,0 +1,29 @@
+package tasks
+
+import (
+	"fmt"
+	"os"
+	"path"
+)
+
+const keyInfoName = "keyinfo"
+
+// writeKeyInfo saves the key along with ffmpeg key info file into a new temporary directory, returning the key info path.
+// The directory is kept apart from encoder output so the key never gets uploaded with stream files.
+func writeKeyInfo(k *StreamKey) (string, error) {
+	dir, err := os.MkdirTemp("", "streamkey")
+	if err != nil {
+		return "", err
+	}
+	keyFile := path.Join(dir, k.KeyID+".key")
+	if err := os.WriteFile(keyFile, k.Key, 0600); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	info := path.Join(dir, keyInfoName)
+	if err := os.WriteFile(info, []byte(fmt.Sprintf("%s\n%s\n", k.URI, keyFile)), 0600); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return info, nil
+}
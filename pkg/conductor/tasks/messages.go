@@ -3,16 +3,64 @@ package tasks
 import (
 	"encoding/json"
 
+	"github.com/lbryio/transcoder/ladder"
 	"github.com/lbryio/transcoder/library"
 )
 
 type TranscodingRequest struct {
 	URL    string `json:"url"`
 	SDHash string `json:"sd_hash"`
+	// Ladder overrides worker's default encoding ladder when set.
+	Ladder *ladder.Ladder `json:"ladder,omitempty"`
+	// Encryption makes stream segments encrypted, it is set for channels requiring content protection.
+	Encryption *StreamKey `json:"encryption,omitempty"`
+}
+
+// StreamKey is an encryption key for stream segments.
+type StreamKey struct {
+	Method string `json:"method"`
+	KeyID  string `json:"key_id"`
+	// URI is where players fetch the key from, it is written into #EXT-X-KEY tags of stream playlists.
+	URI string `json:"uri"`
+	// Key is the raw key, tasks carrying it are not retained after completion.
+	Key []byte `json:"key"`
+}
+
+// ChunkRequest is a subtask for encoding a single chunk of a split source.
+type ChunkRequest struct {
+	URL        string `json:"url"`
+	SDHash     string `json:"sd_hash"`
+	ChannelURI string `json:"channel_uri"`
+	// Attempt identifies a single split of the source, staging data and subtask IDs are scoped to it.
+	Attempt string `json:"attempt"`
+	Index   int    `json:"index"`
+	Total   int    `json:"total"`
+	// Duration is the whole source duration in seconds.
+	Duration float64 `json:"duration"`
+	// SourceSize is the whole source size in bytes.
+	SourceSize int64 `json:"source_size,omitempty"`
+	// Ladder is already adjusted to the source and is applied to the chunk as-is.
+	Ladder ladder.Ladder `json:"ladder"`
+}
+
+// StitchRequest is a finalizing task for joining encoded chunks into a stream.
+type StitchRequest struct {
+	URL        string        `json:"url"`
+	SDHash     string        `json:"sd_hash"`
+	ChannelURI string        `json:"channel_uri"`
+	Attempt    string        `json:"attempt"`
+	Total      int           `json:"total"`
+	Duration   float64       `json:"duration"`
+	SourceSize int64         `json:"source_size,omitempty"`
+	Ladder     ladder.Ladder `json:"ladder"`
 }
 
 type TranscodingResult struct {
 	Stream *library.Stream `json:"stream"`
+	// Error is set when transcoding failed for good, Stream is empty then.
+	Error  string `json:"error,omitempty"`
+	URL    string `json:"url,omitempty"`
+	SDHash string `json:"sd_hash,omitempty"`
 }
 
 func (m TranscodingRequest) String() string {
@@ -32,3 +80,13 @@ func (m TranscodingResult) String() string {
 func (m *TranscodingResult) FromString(s string) error {
 	return json.Unmarshal([]byte(s), m)
 }
+
+func (m ChunkRequest) String() string {
+	out, _ := json.Marshal(m)
+	return string(out)
+}
+
+func (m StitchRequest) String() string {
+	out, _ := json.Marshal(m)
+	return string(out)
+}
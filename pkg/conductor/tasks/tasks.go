@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/lbryio/transcoder/encoder"
+	"github.com/lbryio/transcoder/ladder"
 	"github.com/lbryio/transcoder/library"
 	"github.com/lbryio/transcoder/pkg/conductor/metrics"
 	"github.com/lbryio/transcoder/pkg/logging"
@@ -38,14 +39,21 @@ type ResultWriter interface {
 type EncoderRunner struct {
 	resultWriter ResultWriter
 	encoder      encoder.Encoder
-	storage      *storage.S3Driver
+	storage      storage.Driver
 	options      *EncoderRunnerOptions
+
+	rdb         redis.UniversalClient
+	asynqClient *asynq.Client
 }
 
 type EncoderRunnerOptions struct {
 	StreamsDir, OutputDir string
 	Name                  string
 	Logger                logging.KVLogger
+
+	RedisOpts     asynq.RedisConnOpt
+	ChunkDuration time.Duration
+	CheckpointTTL time.Duration
 }
 
 type RedisResultWriter struct {
@@ -77,12 +85,36 @@ func WithName(name string) func(options *EncoderRunnerOptions) {
 	}
 }
 
+// WithRedis enables processing of chunk subtasks, which need to coordinate through redis.
+func WithRedis(redisOpts asynq.RedisConnOpt) func(options *EncoderRunnerOptions) {
+	return func(options *EncoderRunnerOptions) {
+		options.RedisOpts = redisOpts
+	}
+}
+
+// WithChunkDuration enables split-encode mode: sources longer than two chunks are cut into chunks of
+// approximately d length which are encoded as separate tasks by any worker. Requires WithRedis.
+func WithChunkDuration(d time.Duration) func(options *EncoderRunnerOptions) {
+	return func(options *EncoderRunnerOptions) {
+		options.ChunkDuration = d
+	}
+}
+
+// WithCheckpoints makes runner keep downloaded source and encoder output between task retries
+// so an encode interrupted by a worker crash is resumed instead of restarted.
+// Checkpoints older than ttl are removed at runner start. Requires StreamsDir and OutputDir to be set.
+func WithCheckpoints(ttl time.Duration) func(options *EncoderRunnerOptions) {
+	return func(options *EncoderRunnerOptions) {
+		options.CheckpointTTL = ttl
+	}
+}
+
 func NewTranscodingTask(req TranscodingRequest) (*asynq.Task, error) {
 	return asynq.NewTask(TypeTranscodingRequest, []byte(req.String()), asynq.MaxRetry(5)), nil
 }
 
 func NewEncoderRunner(
-	storage *storage.S3Driver, encoder encoder.Encoder, resultWriter ResultWriter, optionFuncs ...func(*EncoderRunnerOptions),
+	storage storage.Driver, encoder encoder.Encoder, resultWriter ResultWriter, optionFuncs ...func(*EncoderRunnerOptions),
 ) (*EncoderRunner, error) {
 	options := &EncoderRunnerOptions{
 		Logger: logging.NoopKVLogger{},
@@ -90,6 +122,9 @@ func NewEncoderRunner(
 	for _, optionFunc := range optionFuncs {
 		optionFunc(options)
 	}
+	if options.CheckpointTTL > 0 && (options.StreamsDir == "" || options.OutputDir == "") {
+		return nil, errors.New("checkpoints require streams and output dirs to be set")
+	}
 	if options.StreamsDir == "" {
 		d, err := os.MkdirTemp("", "streams")
 		if err != nil {
@@ -107,17 +142,29 @@ func NewEncoderRunner(
 	if options.Name == "" {
 		options.Name, _ = os.Hostname()
 	}
+	if options.ChunkDuration > 0 && options.RedisOpts == nil {
+		return nil, errors.New("chunked encoding requires redis to be configured")
+	}
 	r := &EncoderRunner{
 		encoder:      encoder,
 		resultWriter: resultWriter,
 		storage:      storage,
 		options:      options,
 	}
+	if options.RedisOpts != nil {
+		r.rdb = options.RedisOpts.MakeRedisClient().(redis.UniversalClient)
+		r.asynqClient = asynq.NewClient(options.RedisOpts)
+	}
+	if options.CheckpointTTL > 0 {
+		if err := r.cleanCheckpoints(); err != nil {
+			return nil, fmt.Errorf("cannot clean up checkpoints: %w", err)
+		}
+	}
 
 	return r, nil
 }
 
-func (r *EncoderRunner) Run(ctx context.Context, t *asynq.Task) error {
+func (r *EncoderRunner) Run(ctx context.Context, t *asynq.Task) (err error) {
 	if t.Type() != TypeTranscodingRequest {
 		return fmt.Errorf("can only handle %s", TypeTranscodingRequest)
 	}
@@ -132,12 +179,20 @@ func (r *EncoderRunner) Run(ctx context.Context, t *asynq.Task) error {
 		log = log.With("tid", t.ResultWriter().TaskID())
 	}
 
-	var origFile, encodedPath string
+	var origFile, encodedPath, channelURI string
+	var sourceSize int64
 	errMtr := metrics.ErrorsCount
+	resumable := r.options.CheckpointTTL > 0
 
-	var resolved *resolve.ResolvedStream
-
-	{
+	var cp *checkpoint
+	if resumable {
+		cp = r.loadCheckpoint(payload.SDHash)
+	}
+	if cp != nil {
+		log.Info("resuming from checkpoint", "updated_at", cp.UpdatedAt)
+		origFile, encodedPath, channelURI = cp.Source, cp.Output, cp.ChannelURI
+		sourceSize = cp.SourceSize
+	} else {
 		timer := time.Now()
 		runMtr := metrics.StageRunning.WithLabelValues(metrics.StageDownloading)
 		spentMtr := metrics.SpentSeconds.WithLabelValues(metrics.StageDownloading)
@@ -156,9 +211,38 @@ func (r *EncoderRunner) Run(ctx context.Context, t *asynq.Task) error {
 		spentMtr.Add(time.Since(timer).Seconds())
 		encodedPath = path.Join(r.options.OutputDir, dl.Resolved.SDHash)
 		origFile = dl.File.Name()
-		defer os.RemoveAll(encodedPath)
-		defer os.RemoveAll(origFile)
-		resolved = dl.Resolved
+		channelURI = dl.Resolved.ChannelURI
+		sourceSize = dl.Size
+		if resumable {
+			cp = &checkpoint{
+				SDHash: payload.SDHash, URL: payload.URL, ChannelURI: channelURI,
+				Source: origFile, SourceSize: dl.Size, Output: encodedPath,
+			}
+			if err := r.saveCheckpoint(cp); err != nil {
+				log.Warn("cannot save checkpoint", "err", err)
+			}
+		}
+	}
+	defer func() {
+		// Retryable failures keep everything in place for the next attempt to pick up.
+		if resumable && err != nil && !errors.Is(err, asynq.SkipRetry) {
+			log.Info("keeping checkpoint for retry", "err", err)
+			return
+		}
+		os.RemoveAll(encodedPath)
+		os.RemoveAll(origFile)
+		r.removeCheckpoint(payload.SDHash)
+	}()
+
+	// Encrypted streams are encoded in one piece as stitching chunks does not carry over encryption.
+	if r.options.ChunkDuration > 0 && payload.Encryption == nil {
+		split, err := r.splitIfLong(payload, channelURI, origFile, sourceSize, log)
+		if err != nil {
+			return err
+		}
+		if split {
+			return nil
+		}
 	}
 
 	{
@@ -167,7 +251,29 @@ func (r *EncoderRunner) Run(ctx context.Context, t *asynq.Task) error {
 		spentMtr := metrics.SpentSeconds.WithLabelValues(metrics.StageEncoding)
 
 		runMtr.Inc()
-		res, err := r.encoder.Encode(origFile, encodedPath)
+		var res *encoder.Result
+		var err error
+		if payload.Ladder != nil {
+			log.Info("encoding with assigned ladder", "ladder", payload.Ladder.Name)
+		}
+		enc := r.encoder
+		if payload.Encryption != nil {
+			keyInfo, err := writeKeyInfo(payload.Encryption)
+			if err != nil {
+				runMtr.Dec()
+				return fmt.Errorf("cannot write stream key: %w", err)
+			}
+			defer os.RemoveAll(path.Dir(keyInfo))
+			log.Info("encrypting stream", "method", payload.Encryption.Method, "key_id", payload.Encryption.KeyID)
+			enc = enc.Encrypted(keyInfo)
+		}
+		if resumable {
+			res, err = enc.Resume(origFile, encodedPath, payload.Ladder)
+		} else if payload.Ladder != nil {
+			res, err = enc.EncodeWithLadder(origFile, encodedPath, *payload.Ladder)
+		} else {
+			res, err = enc.Encode(origFile, encodedPath)
+		}
 		if err != nil {
 			log.Error("encoder failure", "err", err)
 			spentMtr.Add(time.Since(timer).Seconds())
@@ -184,19 +290,46 @@ func (r *EncoderRunner) Run(ctx context.Context, t *asynq.Task) error {
 				seen[pg] = true
 				log.Info("encoding", "progress", pg)
 			}
+			// Long encodes making progress must not be mistaken for stale ones.
+			if cp != nil && time.Since(cp.UpdatedAt) > checkpointRefreshInterval {
+				if err := r.saveCheckpoint(cp); err != nil {
+					log.Warn("cannot update checkpoint", "err", err)
+				}
+			}
 		}
 
 		time.Sleep(10 * time.Second)
-		// This is removed twice to not wait for upload to finish before freeing up disk space
-		os.RemoveAll(origFile)
 
-		stream = library.InitStream(encodedPath, r.storage.Name())
-		err = stream.GenerateManifest(
-			payload.URL, resolved.ChannelURI, payload.SDHash,
+		// Encrypted segments cannot be decoded for scoring without fetching the key from its URI.
+		var scores []ladder.QualityScore
+		if payload.Encryption == nil {
+			qTimer := time.Now()
+			scores, err = r.encoder.ScoreQuality(res)
+			if err != nil {
+				log.Warn("quality scoring failed", "err", err)
+				errMtr.WithLabelValues(metrics.StageQuality).Inc()
+			}
+			metrics.SpentSeconds.WithLabelValues(metrics.StageQuality).Add(time.Since(qTimer).Seconds())
+		}
+
+		// This is removed twice to not wait for upload to finish before freeing up disk space.
+		// Checkpointed source is kept as resuming requires it even when encoding is complete.
+		if !resumable {
+			os.RemoveAll(origFile)
+		}
+
+		manifestOpts := []func(*library.Manifest){
 			library.WithTimestamp(time.Now()),
 			library.WithWorkerName(r.options.Name),
 			library.WithVersion(version.Version),
-		)
+			library.WithInputSize(sourceSize),
+			library.WithQualityScores(scores),
+		}
+		if e := payload.Encryption; e != nil {
+			manifestOpts = append(manifestOpts, library.WithEncryption(&library.Encryption{Method: e.Method, KeyID: e.KeyID}))
+		}
+		stream = library.InitStream(encodedPath, r.storage.Name())
+		err = stream.GenerateManifest(payload.URL, channelURI, payload.SDHash, manifestOpts...)
 		if err != nil {
 			log.Error("failed to fill manifest", "err", err)
 			runMtr.Dec()
@@ -213,38 +346,49 @@ func (r *EncoderRunner) Run(ctx context.Context, t *asynq.Task) error {
 		runMtr.Dec()
 
 		log.Info("encoding done", "stream_size", stream.Size())
-		defer os.RemoveAll(stream.LocalPath)
 	}
 
-	{
-		timer := time.Now()
-		runMtr := metrics.StageRunning.WithLabelValues(metrics.StageUploading)
-		spentMtr := metrics.SpentSeconds.WithLabelValues(metrics.StageUploading)
+	return r.publish(t, stream, log)
+}
 
-		runMtr.Inc()
-		err := r.storage.PutWithContext(context.Background(), stream, true)
-		if err != nil {
-			errMtr.WithLabelValues(metrics.StageUploading).Inc()
-			spentMtr.Add(time.Since(timer).Seconds())
-			runMtr.Dec()
-			if errors.Is(err, storage.ErrStreamExists) {
-				return fmt.Errorf("stream already exists: %v: %w", err, asynq.SkipRetry)
-			}
-			return fmt.Errorf("stream upload failed: %w", err)
+// publish uploads encoded stream and sends the result to conductor.
+func (r *EncoderRunner) publish(t *asynq.Task, stream *library.Stream, log logging.KVLogger) error {
+	timer := time.Now()
+	errMtr := metrics.ErrorsCount
+	runMtr := metrics.StageRunning.WithLabelValues(metrics.StageUploading)
+	spentMtr := metrics.SpentSeconds.WithLabelValues(metrics.StageUploading)
+
+	runMtr.Inc()
+	seen := map[int]bool{}
+	ctx := storage.WithProgress(context.Background(), func(p storage.UploadProgress) {
+		pg := int(math.Floor(p.Percent()))
+		if pg%10 == 0 && !seen[pg] {
+			seen[pg] = true
+			log.Info("uploading", "progress", pg, "files", p.FilesDone, "files_total", p.FilesTotal)
 		}
-		log.Info("stream uploaded")
+	})
+	err := r.storage.PutWithContext(ctx, stream, true)
+	if err != nil {
+		errMtr.WithLabelValues(metrics.StageUploading).Inc()
 		spentMtr.Add(time.Since(timer).Seconds())
 		runMtr.Dec()
-		res, err := json.Marshal(TranscodingResult{Stream: stream})
-		if err != nil {
-			return fmt.Errorf("cannot serialize transcoding result: %w", err)
-		}
-		if t.ResultWriter() != nil {
-			t.ResultWriter().Write(res)
+		if errors.Is(err, storage.ErrStreamExists) {
+			return fmt.Errorf("stream already exists: %v: %w", err, asynq.SkipRetry)
 		}
-		r.resultWriter.Write(res)
-		log.Info("stream processed")
+		return fmt.Errorf("stream upload failed: %w", err)
+	}
+	log.Info("stream uploaded")
+	spentMtr.Add(time.Since(timer).Seconds())
+	runMtr.Dec()
+	res, err := json.Marshal(TranscodingResult{Stream: stream})
+	if err != nil {
+		return fmt.Errorf("cannot serialize transcoding result: %w", err)
 	}
+	if t.ResultWriter() != nil {
+		t.ResultWriter().Write(res)
+	}
+	r.resultWriter.Write(res)
+	log.Info("stream processed")
 
 	return nil
 }
@@ -266,9 +410,17 @@ func (r *EncoderRunner) RetryDelay(n int, e error, t *asynq.Task) time.Duration
 	return 1 * time.Minute
 }
 
+// Cleanup releases runner resources. With checkpoints enabled work dirs are kept for tasks to resume
+// after restart, cleanCheckpoints removes what is left of them on the next start.
 func (r *EncoderRunner) Cleanup() {
-	os.RemoveAll(r.options.StreamsDir)
-	os.RemoveAll(r.options.OutputDir)
+	if r.options.CheckpointTTL <= 0 {
+		os.RemoveAll(r.options.StreamsDir)
+		os.RemoveAll(r.options.OutputDir)
+	}
+	if r.asynqClient != nil {
+		r.asynqClient.Close()
+		r.rdb.Close()
+	}
 }
 
 // func (r *EncoderRunner) err(format string, a ...interface{}) error {
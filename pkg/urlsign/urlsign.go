@@ -0,0 +1,130 @@
+// Package urlsign issues and checks HMAC-signed, expiring URLs for stream files.
+//
+// A signature covers a stream directory rather than a single file, so a player can fetch
+// every playlist and segment of a stream with the same query params.
+package urlsign
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ParamExpires   = "expires"
+	ParamKeyID     = "kid"
+	ParamSignature = "signature"
+
+	DefaultTTL = time.Hour
+)
+
+var (
+	ErrMissingSignature = errors.New("url is not signed")
+	ErrExpired          = errors.New("url signature expired")
+	ErrUnknownKey       = errors.New("url signed with unknown key")
+	ErrInvalidSignature = errors.New("invalid url signature")
+)
+
+// Key is a named signing secret. IDs are sent along with signatures so keys can be rotated.
+type Key struct {
+	ID     string
+	Secret string
+}
+
+// Signer signs URLs with the first of its keys and accepts signatures made with any of them.
+// Keys are rotated by putting a new key first and dropping the old one once URLs signed with it have expired.
+type Signer struct {
+	keys map[string][]byte
+	kid  string
+	ttl  time.Duration
+}
+
+func New(ttl time.Duration, keys ...Key) (*Signer, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("at least one signing key is required")
+	}
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	s := &Signer{keys: map[string][]byte{}, kid: keys[0].ID, ttl: ttl}
+	for _, k := range keys {
+		if k.ID == "" || k.Secret == "" {
+			return nil, errors.New("signing key id and secret must be set")
+		}
+		if _, ok := s.keys[k.ID]; ok {
+			return nil, fmt.Errorf("duplicate signing key id: %v", k.ID)
+		}
+		s.keys[k.ID] = []byte(k.Secret)
+	}
+	return s, nil
+}
+
+// TTL is how long signed URLs stay valid.
+func (s *Signer) TTL() time.Duration {
+	return s.ttl
+}
+
+// Sign returns query params granting access to files under dir until TTL passes.
+func (s *Signer) Sign(dir string) url.Values {
+	return s.SignAt(dir, time.Now().Add(s.ttl))
+}
+
+// SignAt returns query params granting access to files under dir until expires.
+func (s *Signer) SignAt(dir string, expires time.Time) url.Values {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return url.Values{
+		ParamExpires:   {exp},
+		ParamKeyID:     {s.kid},
+		ParamSignature: {s.signature(s.keys[s.kid], cleanDir(dir), exp)},
+	}
+}
+
+// Verify checks that query params grant access to the file at p.
+func (s *Signer) Verify(p string, q url.Values) error {
+	exp, kid, sig := q.Get(ParamExpires), q.Get(ParamKeyID), q.Get(ParamSignature)
+	if exp == "" || sig == "" {
+		return ErrMissingSignature
+	}
+	ts, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if time.Now().Unix() > ts {
+		return ErrExpired
+	}
+	secret, ok := s.keys[kid]
+	if !ok {
+		return ErrUnknownKey
+	}
+	expected := s.signature(secret, cleanDir(path.Dir(path.Clean("/"+p))), exp)
+	if !hmac.Equal([]byte(sig), []byte(expected)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Expires returns the expiration time of signed query params, zero time if they are not signed.
+func Expires(q url.Values) time.Time {
+	ts, err := strconv.ParseInt(q.Get(ParamExpires), 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(ts, 0)
+}
+
+func (s *Signer) signature(secret []byte, dir, exp string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(dir + "\n" + exp))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func cleanDir(dir string) string {
+	return strings.TrimRight(path.Clean("/"+dir), "/") + "/"
+}
@@ -0,0 +1,52 @@
+package urlsign
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignVerify(t *testing.T) {
+	s, err := New(time.Minute, Key{"k1", "secret1"})
+	require.NoError(t, err)
+
+	q := s.Sign("tid1")
+	assert.NoError(t, s.Verify("/tid1/master.m3u8", q))
+	assert.NoError(t, s.Verify("tid1/s0_000000.ts", q))
+	assert.ErrorIs(t, s.Verify("/tid2/master.m3u8", q), ErrInvalidSignature)
+	assert.ErrorIs(t, s.Verify("/tid1/../tid2/master.m3u8", q), ErrInvalidSignature)
+	assert.ErrorIs(t, s.Verify("/tid1/master.m3u8", nil), ErrMissingSignature)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), Expires(q), 2*time.Second)
+
+	expired := s.SignAt("tid1", time.Now().Add(-time.Second))
+	assert.ErrorIs(t, s.Verify("/tid1/master.m3u8", expired), ErrExpired)
+
+	tampered := s.Sign("tid1")
+	tampered.Set(ParamExpires, "99999999999")
+	assert.ErrorIs(t, s.Verify("/tid1/master.m3u8", tampered), ErrInvalidSignature)
+}
+
+func TestKeyRotation(t *testing.T) {
+	old, err := New(time.Minute, Key{"k1", "secret1"})
+	require.NoError(t, err)
+	rotated, err := New(time.Minute, Key{"k2", "secret2"}, Key{"k1", "secret1"})
+	require.NoError(t, err)
+	retired, err := New(time.Minute, Key{"k2", "secret2"})
+	require.NoError(t, err)
+
+	q := old.Sign("tid1")
+	assert.NoError(t, rotated.Verify("/tid1/master.m3u8", q))
+	assert.ErrorIs(t, retired.Verify("/tid1/master.m3u8", q), ErrUnknownKey)
+
+	q = rotated.Sign("tid1")
+	assert.Equal(t, "k2", q.Get(ParamKeyID))
+	assert.NoError(t, retired.Verify("/tid1/master.m3u8", q))
+	assert.ErrorIs(t, old.Verify("/tid1/master.m3u8", q), ErrUnknownKey)
+
+	_, err = New(time.Minute)
+	assert.Error(t, err)
+	_, err = New(time.Minute, Key{"k1", "a"}, Key{"k1", "b"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,59 @@
+package stats
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	once = sync.Once{}
+
+	LibraryVideos = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "library_videos",
+	})
+	StorageBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "library_storage_bytes",
+	}, []string{"storage"})
+	StorageVideos = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "library_storage_videos",
+	}, []string{"storage"})
+	// ChannelBytes only covers top channels by size to keep the number of series bounded.
+	ChannelBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "library_channel_bytes",
+	}, []string{"channel"})
+	TierVideos = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "library_tier_videos",
+	}, []string{"tier"})
+	CompressionRatio = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "library_compression_ratio",
+		Help: "Average ratio of output stream size to source size",
+	})
+)
+
+func RegisterMetrics() {
+	once.Do(func() {
+		prometheus.MustRegister(
+			LibraryVideos, StorageBytes, StorageVideos, ChannelBytes, TierVideos, CompressionRatio,
+		)
+	})
+}
+
+func updateMetrics(r *Rollup) {
+	LibraryVideos.Set(float64(r.Videos))
+	StorageBytes.Reset()
+	StorageVideos.Reset()
+	for _, g := range r.Storages {
+		StorageBytes.WithLabelValues(g.Name).Set(float64(g.TotalSize))
+		StorageVideos.WithLabelValues(g.Name).Set(float64(g.Videos))
+	}
+	ChannelBytes.Reset()
+	for _, g := range r.Channels {
+		ChannelBytes.WithLabelValues(g.Name).Set(float64(g.TotalSize))
+	}
+	TierVideos.Reset()
+	for _, g := range r.Tiers {
+		TierVideos.WithLabelValues(g.Name).Set(float64(g.Videos))
+	}
+	CompressionRatio.Set(r.Compression.AvgRatio)
+}
@@ -1 +1,196 @@
 package stats
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/lbryio/transcoder/library/db"
+	"github.com/lbryio/transcoder/pkg/logging"
+)
+
+const (
+	DefaultTopLimit = 20
+	DefaultMaxAge   = time.Minute
+)
+
+// Rollup is a set of library-wide statistics.
+type Rollup struct {
+	ComputedAt  time.Time       `json:"computed_at"`
+	Videos      int64           `json:"videos"`
+	TotalSize   int64           `json:"total_size"`
+	Storages    []Group         `json:"storages"`
+	Channels    []Group         `json:"channels"`
+	Tiers       []Group         `json:"tiers"`
+	Compression Compression     `json:"compression"`
+	TopAccessed []AccessedVideo `json:"top_accessed"`
+}
+
+// Group is a number of videos and their total size for a storage, channel or definition tier.
+type Group struct {
+	Name      string `json:"name"`
+	Videos    int64  `json:"videos"`
+	TotalSize int64  `json:"total_size"`
+}
+
+// Compression compares output stream sizes to sizes of their sources.
+// Only videos with the source size recorded in their manifest are counted.
+type Compression struct {
+	Videos     int64   `json:"videos"`
+	InputSize  int64   `json:"input_size"`
+	OutputSize int64   `json:"output_size"`
+	AvgRatio   float64 `json:"avg_ratio"`
+}
+
+type AccessedVideo struct {
+	TID         string    `json:"tid"`
+	URL         string    `json:"url"`
+	SDHash      string    `json:"sd_hash"`
+	Channel     string    `json:"channel"`
+	Size        int64     `json:"size"`
+	AccessCount int32     `json:"access_count"`
+	AccessedAt  time.Time `json:"accessed_at"`
+}
+
+// Collector computes rollups with SQL aggregates and keeps the last one around.
+type Collector struct {
+	db     *db.Queries
+	log    logging.KVLogger
+	top    int32
+	maxAge time.Duration
+
+	mu     sync.Mutex
+	latest *Rollup
+}
+
+func New(conn db.DBTX, log logging.KVLogger) *Collector {
+	return &Collector{
+		db:     db.New(conn),
+		log:    log,
+		top:    DefaultTopLimit,
+		maxAge: DefaultMaxAge,
+	}
+}
+
+// Configure sets the number of top channels and accessed videos to include
+// and how long a computed rollup is served before it is recomputed.
+func (c *Collector) Configure(top int32, maxAge time.Duration) *Collector {
+	c.top = top
+	c.maxAge = maxAge
+	return c
+}
+
+// Compute queries the database for a fresh rollup.
+func (c *Collector) Compute() (*Rollup, error) {
+	ctx := context.Background()
+	r := &Rollup{
+		ComputedAt:  time.Now(),
+		Storages:    []Group{},
+		Channels:    []Group{},
+		Tiers:       []Group{},
+		TopAccessed: []AccessedVideo{},
+	}
+
+	totals, err := c.db.CountVideos(ctx, db.CountVideosParams{})
+	if err != nil {
+		return nil, err
+	}
+	r.Videos, r.TotalSize = totals.Count, totals.TotalSize
+
+	storages, err := c.db.GetStorageStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range storages {
+		r.Storages = append(r.Storages, Group{Name: s.Storage, Videos: s.Count, TotalSize: s.TotalSize})
+	}
+
+	channels, err := c.db.GetChannelStats(ctx, c.top)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range channels {
+		r.Channels = append(r.Channels, Group{Name: s.Channel, Videos: s.Count, TotalSize: s.TotalSize})
+	}
+
+	tiers, err := c.db.GetTierStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range tiers {
+		r.Tiers = append(r.Tiers, Group{Name: s.Tier, Videos: s.Count, TotalSize: s.TotalSize})
+	}
+
+	comp, err := c.db.GetCompressionStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r.Compression = Compression{
+		Videos:     comp.Count,
+		InputSize:  comp.InputSize,
+		OutputSize: comp.OutputSize,
+		AvgRatio:   comp.AvgRatio,
+	}
+
+	top, err := c.db.GetTopAccessedVideos(ctx, c.top)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range top {
+		r.TopAccessed = append(r.TopAccessed, AccessedVideo{
+			TID:         v.TID,
+			URL:         v.URL,
+			SDHash:      v.SDHash,
+			Channel:     v.Channel,
+			Size:        v.Size,
+			AccessCount: v.AccessCount.Int32,
+			AccessedAt:  v.AccessedAt,
+		})
+	}
+
+	c.mu.Lock()
+	c.latest = r
+	c.mu.Unlock()
+	return r, nil
+}
+
+// Get returns the last computed rollup if it is recent enough, computing a fresh one otherwise.
+func (c *Collector) Get() (*Rollup, error) {
+	c.mu.Lock()
+	latest := c.latest
+	c.mu.Unlock()
+	if latest != nil && time.Since(latest.ComputedAt) < c.maxAge {
+		return latest, nil
+	}
+	return c.Compute()
+}
+
+// Spawn periodically computes rollups and exposes them as Prometheus gauges.
+func Spawn(c *Collector, interval time.Duration) chan struct{} {
+	stopChan := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	update := func() {
+		r, err := c.Compute()
+		if err != nil {
+			c.log.Warn("failed to compute library stats", "err", err)
+			return
+		}
+		updateMetrics(r)
+	}
+
+	go func() {
+		update()
+		for {
+			select {
+			case <-ticker.C:
+				update()
+			case <-stopChan:
+				ticker.Stop()
+				c.log.Info("stopped library stats collection")
+				return
+			}
+		}
+	}()
+	return stopChan
+}
@@ -0,0 +1,64 @@
+package stats
+
+import (
+	"testing"
+
+	"github.com/lbryio/transcoder/library"
+	"github.com/lbryio/transcoder/pkg/logging/zapadapter"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/suite"
+)
+
+type statsSuite struct {
+	suite.Suite
+	library.LibraryTestHelper
+}
+
+func TestStats(t *testing.T) {
+	suite.Run(t, new(statsSuite))
+}
+
+func (s *statsSuite) SetupTest() {
+	s.Require().NoError(s.SetupLibraryDB())
+}
+
+func (s *statsSuite) TearDownTest() {
+	s.Require().NoError(s.TearDownLibraryDB())
+}
+
+func (s *statsSuite) TestCompute() {
+	lib := library.New(library.Config{DB: s.DB, Log: zapadapter.NewKV(nil)})
+	var totalSize int64
+	for i := 0; i < 3; i++ {
+		stream := library.GenerateDummyStream()
+		stream.Manifest.ChannelURL = "@stats#1"
+		if i > 0 {
+			stream.Manifest.InputSize = stream.Manifest.Size * 2
+		}
+		totalSize += stream.Manifest.Size
+		s.Require().NoError(lib.AddRemoteStream(*stream))
+	}
+
+	c := New(s.DB, zapadapter.NewKV(nil))
+	r, err := c.Compute()
+	s.Require().NoError(err)
+	s.EqualValues(3, r.Videos)
+	s.Equal(totalSize, r.TotalSize)
+	s.Equal([]Group{{Name: "storage1", Videos: 3, TotalSize: totalSize}}, r.Storages)
+	s.Equal([]Group{{Name: "@stats#1", Videos: 3, TotalSize: totalSize}}, r.Channels)
+	s.Require().Len(r.Tiers, 1)
+	s.Equal("1080p", r.Tiers[0].Name)
+	s.EqualValues(2, r.Compression.Videos)
+	s.InDelta(0.5, r.Compression.AvgRatio, 0.001)
+	s.Len(r.TopAccessed, 3)
+
+	cached, err := c.Get()
+	s.Require().NoError(err)
+	s.Same(r, cached)
+
+	RegisterMetrics()
+	updateMetrics(r)
+	s.EqualValues(3, testutil.ToFloat64(StorageVideos.WithLabelValues("storage1")))
+	s.InDelta(0.5, testutil.ToFloat64(CompressionRatio), 0.001)
+}
@@ -1,22 +1,522 @@
 package storage
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
 	"os"
 	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lbryio/transcoder/library"
+	"github.com/lbryio/transcoder/pkg/urlsign"
+
+	"github.com/fasthttp/router"
+	"github.com/valyala/fasthttp"
 )
 
+const (
+	fileRouteParam   = "filepath"
+	localArchiveName = ".archive"
+)
+
+type LocalConfiguration struct {
+	name, path, url string
+	archiveDays     int
+	signer          *urlsign.Signer
+}
+
+// LocalStorage keeps streams in a local (or network-mounted) directory, one subdirectory per stream TID.
 type LocalStorage struct {
-	path string
+	*LocalConfiguration
+}
+
+func LocalConfigure() *LocalConfiguration {
+	return &LocalConfiguration{}
+}
+
+// Name is storage type name (for internal use)
+func (c *LocalConfiguration) Name(n string) *LocalConfiguration {
+	c.name = n
+	return c
 }
 
-func Local(path string) LocalStorage {
-	return LocalStorage{path}
+// Path is a root directory for storing streams.
+func (c *LocalConfiguration) Path(p string) *LocalConfiguration {
+	c.path = p
+	return c
 }
 
-func (s LocalStorage) Delete(sdHash string) error {
-	return os.RemoveAll(path.Join(s.path, sdHash))
+// URL is a base URL streams are served from, see LocalStorage.Route.
+func (c *LocalConfiguration) URL(u string) *LocalConfiguration {
+	c.url = strings.TrimRight(u, "/")
+	return c
 }
 
-func (s LocalStorage) Path() string {
+// Archive makes files of retired streams kept for the number of days so they can be restored.
+func (c *LocalConfiguration) Archive(days int) *LocalConfiguration {
+	c.archiveDays = days
+	return c
+}
+
+// Signer makes stream files served only for requests with valid signatures.
+func (c *LocalConfiguration) Signer(s *urlsign.Signer) *LocalConfiguration {
+	c.signer = s
+	return c
+}
+
+func InitLocalStorage(cfg *LocalConfiguration) (*LocalStorage, error) {
+	if cfg.name == "" {
+		return nil, errors.New("storage name must be configured")
+	}
+	if cfg.path == "" {
+		return nil, errors.New("storage path must be configured")
+	}
+	if _, err := url.Parse(cfg.url); err != nil {
+		return nil, fmt.Errorf("malformed storage url: %w", err)
+	}
+	if err := os.MkdirAll(cfg.path, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return &LocalStorage{cfg}, nil
+}
+
+func (s *LocalStorage) Name() string {
+	return s.name
+}
+
+// Private reports whether stream files are served only to requests with valid signatures.
+func (s *LocalStorage) Private() bool {
+	return s.signer != nil
+}
+
+func (s *LocalStorage) Path() string {
 	return s.path
 }
+
+func (s *LocalStorage) GetURL(streamTID string) string {
+	return fmt.Sprintf("%s/%s", s.url, streamTID)
+}
+
+func (s *LocalStorage) Put(stream *library.Stream, overwrite bool) error {
+	return s.PutWithContext(context.Background(), stream, overwrite)
+}
+
+// PutWithContext copies stream files into a temporary directory next to the destination
+// and renames it into place, so a stream is never visible partially copied.
+func (s *LocalStorage) PutWithContext(ctx context.Context, stream *library.Stream, overwrite bool) error {
+	dst := path.Join(s.path, stream.TID())
+	if _, err := os.Stat(dst); err == nil && !overwrite {
+		return ErrStreamExists
+	}
+
+	tmp, err := os.MkdirTemp(s.path, ".put-"+stream.TID()+"-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	var files int
+	var size int64
+	err = stream.Walk(
+		func(fi fs.FileInfo, _, _ string) error {
+			files++
+			size += fi.Size()
+			return nil
+		},
+	)
+	if err != nil {
+		return err
+	}
+	progress := newProgressTracker(ctx, files, size)
+	err = stream.Walk(
+		func(fi fs.FileInfo, fullPath, name string) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			logger.Debugw("copying", "name", name, "size", fi.Size(), "path", s.path)
+			if err := copyFile(fullPath, path.Join(tmp, name)); err != nil {
+				return err
+			}
+			progress.add(fi.Size())
+			return nil
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, 0755); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(dst); err != nil {
+		return os.Rename(tmp, dst)
+	}
+	// The existing stream is only removed once the new one is in place.
+	old := tmp + ".old"
+	if err := os.Rename(dst, old); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		if rerr := os.Rename(old, dst); rerr != nil {
+			logger.Errorw("cannot restore overwritten stream", "path", dst, "err", rerr)
+		}
+		return err
+	}
+	if err := os.RemoveAll(old); err != nil {
+		logger.Warnw("cannot remove overwritten stream", "path", old, "err", err)
+	}
+	return nil
+}
+
+func (s *LocalStorage) Delete(streamTID string) error {
+	if err := validateLocalTID(streamTID); err != nil {
+		return err
+	}
+	return os.RemoveAll(path.Join(s.path, streamTID))
+}
+
+func (s *LocalStorage) ArchiveTTL() time.Duration {
+	return time.Duration(s.archiveDays) * 24 * time.Hour
+}
+
+// Archive moves the stream directory into the hidden archive directory.
+func (s *LocalStorage) Archive(streamTID string) error {
+	if err := validateLocalTID(streamTID); err != nil {
+		return err
+	}
+	archivePath := path.Join(s.path, localArchiveName)
+	if err := os.MkdirAll(archivePath, os.ModePerm); err != nil {
+		return err
+	}
+	dst := path.Join(archivePath, streamTID)
+	if err := os.RemoveAll(dst); err != nil {
+		return err
+	}
+	return os.Rename(path.Join(s.path, streamTID), dst)
+}
+
+// Unarchive moves the archived stream directory back.
+func (s *LocalStorage) Unarchive(streamTID string) error {
+	if err := validateLocalTID(streamTID); err != nil {
+		return err
+	}
+	dst := path.Join(s.path, streamTID)
+	if _, err := os.Stat(dst); err == nil {
+		return ErrStreamExists
+	}
+	return os.Rename(path.Join(s.path, localArchiveName, streamTID), dst)
+}
+
+func (s *LocalStorage) DeleteArchived(streamTID string) error {
+	if err := validateLocalTID(streamTID); err != nil {
+		return err
+	}
+	return os.RemoveAll(path.Join(s.path, localArchiveName, streamTID))
+}
+
+// PutManifest replaces the manifest file of a stored stream.
+func (s *LocalStorage) PutManifest(streamTID string, data []byte) error {
+	if err := validateLocalTID(streamTID); err != nil {
+		return err
+	}
+	dir := path.Join(s.path, streamTID)
+	if _, err := os.Stat(dir); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".manifest-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path.Join(dir, library.ManifestName))
+}
+
+// PutFile replaces a single file of a stored stream.
+func (s *LocalStorage) PutFile(streamTID, name string, r io.Reader) error {
+	if err := validateLocalTID(streamTID); err != nil {
+		return err
+	}
+	if name == "" || path.Base(name) != name || name == ".." {
+		return fmt.Errorf("invalid file name: %q", name)
+	}
+	dir := path.Join(s.path, streamTID)
+	if _, err := os.Stat(dir); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, "."+name+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path.Join(dir, name))
+}
+
+func (s *LocalStorage) GetFragment(streamTID, name string) (StreamFragment, error) {
+	return os.Open(path.Join(s.path, path.Clean("/"+streamTID), path.Clean("/"+name)))
+}
+
+func (s *LocalStorage) ListFiles(streamTID string) ([]string, error) {
+	entries, err := os.ReadDir(path.Join(s.path, path.Clean("/"+streamTID)))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, e := range entries {
+		if e.Type().IsRegular() {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+// ListStreams returns stream directories, skipping hidden ones with uploads in progress.
+func (s *LocalStorage) ListStreams() ([]library.StoredStream, error) {
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		return nil, err
+	}
+	list := []library.StoredStream{}
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		ss := library.StoredStream{TID: e.Name()}
+		files, err := os.ReadDir(path.Join(s.path, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			fi, err := f.Info()
+			if err != nil {
+				return nil, err
+			}
+			ss.Size += fi.Size()
+			if fi.ModTime().After(ss.LastModified) {
+				ss.LastModified = fi.ModTime()
+			}
+		}
+		list = append(list, ss)
+	}
+	return list, nil
+}
+
+// PutDir copies all regular files from dir (non-recursively) under prefix.
+func (s *LocalStorage) PutDir(ctx context.Context, prefix, dir string) error {
+	dst := path.Join(s.path, path.Clean("/"+prefix))
+	if err := os.MkdirAll(dst, os.ModePerm); err != nil {
+		return err
+	}
+	return copyDir(ctx, dir, dst)
+}
+
+// GetDir copies all files stored directly under prefix into dir.
+func (s *LocalStorage) GetDir(ctx context.Context, prefix, dir string) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	return copyDir(ctx, path.Join(s.path, path.Clean("/"+prefix)), dir)
+}
+
+// DeleteDir removes everything stored under prefix.
+func (s *LocalStorage) DeleteDir(prefix string) error {
+	p := path.Clean("/" + prefix)
+	if p == "/" {
+		return fmt.Errorf("invalid prefix: %q", prefix)
+	}
+	return os.RemoveAll(path.Join(s.path, p))
+}
+
+// Route mounts a static file handler for stored streams on r at the path part of the configured URL.
+func (s *LocalStorage) Route(r *router.Router) error {
+	u, err := url.Parse(s.url)
+	if err != nil {
+		return err
+	}
+	r.GET(strings.TrimRight(u.Path, "/")+"/{"+fileRouteParam+":*}", s.Handler)
+	return nil
+}
+
+// Handler serves stream files with content types matching the ones set by S3Driver.
+func (s *LocalStorage) Handler(ctx *fasthttp.RequestCtx) {
+	name, _ := ctx.UserValue(fileRouteParam).(string)
+	name = path.Clean("/" + name)
+	// Directories of uploads in progress are hidden.
+	if strings.HasPrefix(name, "/.") {
+		ctx.SetStatusCode(http.StatusNotFound)
+		return
+	}
+	// Only files of published streams are served, other data like chunk staging is kept under nested prefixes.
+	tid, file := path.Split(strings.TrimPrefix(name, "/"))
+	if tid == "" || file == "" || strings.Count(tid, "/") != 1 {
+		ctx.SetStatusCode(http.StatusNotFound)
+		return
+	}
+	if s.signer != nil && !verifyRequest(ctx, s.signer, name) {
+		return
+	}
+	if _, err := os.Stat(path.Join(s.path, tid, library.ManifestName)); err != nil {
+		ctx.SetStatusCode(http.StatusNotFound)
+		return
+	}
+	fullPath := path.Join(s.path, name)
+	if fi, err := os.Stat(fullPath); err != nil || fi.IsDir() {
+		ctx.SetStatusCode(http.StatusNotFound)
+		return
+	}
+	if s.signer != nil && path.Ext(name) == library.PlaylistExt {
+		f, err := os.Open(fullPath)
+		if err != nil {
+			ctx.SetStatusCode(http.StatusNotFound)
+			return
+		}
+		defer f.Close()
+		serveSignedPlaylist(ctx, f)
+		return
+	}
+	fasthttp.ServeFileUncompressed(ctx, fullPath)
+	switch path.Ext(name) {
+	case library.PlaylistExt:
+		ctx.SetContentType(library.PlaylistContentType)
+	case library.FragmentExt:
+		ctx.SetContentType(library.FragmentContentType)
+	}
+}
+
+// verifyRequest checks the signature of a request for a stream file, responding with 403 if it is not valid.
+func verifyRequest(ctx *fasthttp.RequestCtx, signer *urlsign.Signer, name string) bool {
+	if err := signer.Verify(name, queryValues(ctx)); err != nil {
+		ctx.SetStatusCode(http.StatusForbidden)
+		fmt.Fprint(ctx, err.Error())
+		return false
+	}
+	return true
+}
+
+// serveSignedPlaylist responds with the playlist having query params of the signed request appended to URIs it references.
+// Stock HLS players don't carry params of the master playlist URL over to variant playlists, segments and keys.
+func serveSignedPlaylist(ctx *fasthttp.RequestCtx, r io.Reader) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		logger.Warnw("cannot read playlist", "path", string(ctx.Path()), "err", err)
+		ctx.SetStatusCode(http.StatusInternalServerError)
+		return
+	}
+	// Signatures expire, so rewritten playlists must not be cached for other requests.
+	ctx.Response.Header.Set("Cache-Control", "private, no-store")
+	ctx.SetContentType(library.PlaylistContentType)
+	ctx.SetBody(signPlaylist(data, queryValues(ctx)))
+}
+
+var uriAttrRe = regexp.MustCompile(`URI="([^"]*)"`)
+
+// signPlaylist appends q to URI lines and URI attributes of tags, such as #EXT-X-KEY, of the playlist.
+func signPlaylist(data []byte, q url.Values) []byte {
+	query := q.Encode()
+	sign := func(uri string) string {
+		if strings.Contains(uri, "?") {
+			return uri + "&" + query
+		}
+		return uri + "?" + query
+	}
+	lines := strings.Split(string(data), "\n")
+	for i, l := range lines {
+		l = strings.TrimRight(l, "\r")
+		switch {
+		case l == "":
+		case strings.HasPrefix(l, "#"):
+			lines[i] = uriAttrRe.ReplaceAllStringFunc(l, func(attr string) string {
+				return `URI="` + sign(uriAttrRe.FindStringSubmatch(attr)[1]) + `"`
+			})
+		default:
+			lines[i] = sign(l)
+		}
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+func queryValues(ctx *fasthttp.RequestCtx) url.Values {
+	q := url.Values{}
+	ctx.QueryArgs().VisitAll(func(k, v []byte) {
+		q.Add(string(k), string(v))
+	})
+	return q
+}
+
+func validateLocalTID(streamTID string) error {
+	if streamTID == "" || strings.Contains(streamTID, "..") || strings.Contains(streamTID, "/") {
+		return fmt.Errorf("invalid stream TID: %q", streamTID)
+	}
+	return nil
+}
+
+func copyDir(ctx context.Context, src, dst string) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !e.Type().IsRegular() {
+			continue
+		}
+		if err := copyFile(path.Join(src, e.Name()), path.Join(dst, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	if err := os.MkdirAll(path.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,284 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Pallinder/go-randomdata"
+	"github.com/lbryio/transcoder/library"
+	"github.com/lbryio/transcoder/pkg/urlsign"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"github.com/valyala/fasthttp"
+)
+
+type localSuite struct {
+	suite.Suite
+	sdHash      string
+	streamsPath string
+	storage     *LocalStorage
+}
+
+func TestLocalSuite(t *testing.T) {
+	suite.Run(t, new(localSuite))
+}
+
+func (s *localSuite) SetupTest() {
+	var err error
+	s.streamsPath = s.T().TempDir()
+	s.sdHash = randomdata.Alphanumeric(96)
+	library.PopulateHLSPlaylist(s.T(), s.streamsPath, s.sdHash)
+
+	s.storage, err = InitLocalStorage(
+		LocalConfigure().
+			Name("local").
+			Path(path.Join(s.T().TempDir(), "storage")).
+			URL("http://localhost:8080/streams/"),
+	)
+	s.Require().NoError(err)
+}
+
+func (s *localSuite) TestPutDelete() {
+	stream := library.InitStream(path.Join(s.streamsPath, s.sdHash), "")
+	err := stream.GenerateManifest("url", "channel", s.sdHash)
+	s.Require().NoError(err)
+
+	err = s.storage.Put(stream, false)
+	s.Require().NoError(err)
+	s.Equal("http://localhost:8080/streams/"+stream.TID(), s.storage.GetURL(stream.TID()))
+
+	for _, n := range []string{library.MasterPlaylistName, library.ManifestName} {
+		sf, err := s.storage.GetFragment(stream.TID(), n)
+		s.Require().NoError(err)
+		sf.Close()
+	}
+
+	names, err := s.storage.ListFiles(stream.TID())
+	s.Require().NoError(err)
+	s.Len(names, len(library.PopulatedHLSPlaylistFiles)+1)
+
+	streams, err := s.storage.ListStreams()
+	s.Require().NoError(err)
+	s.Require().Len(streams, 1)
+	s.Equal(stream.TID(), streams[0].TID)
+	s.Greater(streams[0].Size, stream.Size(), "stream size plus manifest")
+
+	err = s.storage.Put(stream, false)
+	s.ErrorIs(err, ErrStreamExists)
+
+	err = s.storage.Put(stream, true)
+	s.NoError(err)
+
+	entries, err := os.ReadDir(s.storage.Path())
+	s.Require().NoError(err)
+	s.Len(entries, 1, "temporary upload directories must be removed")
+
+	err = s.storage.Delete(stream.TID())
+	s.Require().NoError(err)
+
+	_, err = s.storage.GetFragment(stream.TID(), library.MasterPlaylistName)
+	s.True(os.IsNotExist(err))
+
+	s.Error(s.storage.Delete(""))
+	s.Error(s.storage.Delete("../storage"))
+}
+
+func (s *localSuite) TestPutGetDir() {
+	src := path.Join(s.streamsPath, s.sdHash)
+	dst := s.T().TempDir()
+
+	err := s.storage.PutDir(context.Background(), "chunks/"+s.sdHash, src)
+	s.Require().NoError(err)
+	err = s.storage.GetDir(context.Background(), "chunks/"+s.sdHash, dst)
+	s.Require().NoError(err)
+
+	srcEntries, err := os.ReadDir(src)
+	s.Require().NoError(err)
+	dstEntries, err := os.ReadDir(dst)
+	s.Require().NoError(err)
+	s.Equal(len(srcEntries), len(dstEntries))
+
+	s.Require().NoError(s.storage.DeleteDir("chunks/" + s.sdHash))
+	s.Error(s.storage.GetDir(context.Background(), "chunks/"+s.sdHash, s.T().TempDir()))
+	s.Error(s.storage.DeleteDir("/"))
+}
+
+func (s *localSuite) TestHandler() {
+	stream := library.InitStream(path.Join(s.streamsPath, s.sdHash), "")
+	err := stream.GenerateManifest("url", "channel", s.sdHash)
+	s.Require().NoError(err)
+	s.Require().NoError(s.storage.Put(stream, false))
+
+	serve := func(name string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.SetUserValue(fileRouteParam, name)
+		s.storage.Handler(ctx)
+		return ctx
+	}
+
+	ctx := serve(stream.TID() + "/" + library.MasterPlaylistName)
+	s.Equal(http.StatusOK, ctx.Response.StatusCode())
+	s.Equal(library.PlaylistContentType, string(ctx.Response.Header.ContentType()))
+
+	f, err := os.Open(path.Join(s.streamsPath, s.sdHash, library.MasterPlaylistName))
+	s.Require().NoError(err)
+	defer f.Close()
+	expected, err := io.ReadAll(f)
+	s.Require().NoError(err)
+	s.Equal(expected, ctx.Response.Body())
+
+	s.Equal(http.StatusNotFound, serve(stream.TID()).Response.StatusCode())
+	s.Equal(http.StatusNotFound, serve(".put-"+stream.TID()+"/"+library.MasterPlaylistName).Response.StatusCode())
+	s.Equal(http.StatusNotFound, serve("../../etc/passwd").Response.StatusCode())
+
+	s.Require().NoError(s.storage.PutDir(context.Background(), "chunks/"+s.sdHash, path.Join(s.streamsPath, s.sdHash)))
+	s.Equal(http.StatusNotFound, serve("chunks/"+s.sdHash+"/"+library.MasterPlaylistName).Response.StatusCode())
+}
+
+func (s *localSuite) TestSignedHandler() {
+	signer, err := urlsign.New(time.Minute, urlsign.Key{ID: "k1", Secret: "secret"})
+	s.Require().NoError(err)
+	ls, err := InitLocalStorage(LocalConfigure().Name("local").Path(s.storage.path).Signer(signer))
+	s.Require().NoError(err)
+	stream := library.InitStream(path.Join(s.streamsPath, s.sdHash), "")
+	s.Require().NoError(stream.GenerateManifest("url", "channel", s.sdHash))
+	s.Require().NoError(ls.Put(stream, false))
+
+	serve := func(name string, q url.Values) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/" + name + "?" + q.Encode())
+		ctx.SetUserValue(fileRouteParam, name)
+		ls.Handler(ctx)
+		return ctx
+	}
+
+	name := stream.TID() + "/" + library.MasterPlaylistName
+	q := signer.Sign(stream.TID())
+	ctx := serve(name, q)
+	s.Equal(http.StatusOK, ctx.Response.StatusCode())
+	s.Equal(library.PlaylistContentType, string(ctx.Response.Header.ContentType()))
+	s.Equal("private, no-store", string(ctx.Response.Header.Peek("Cache-Control")))
+	s.Contains(string(ctx.Response.Body()), "stream_0.m3u8?"+q.Encode())
+	s.Equal(http.StatusOK, serve(stream.TID()+"/stream_0.m3u8", q).Response.StatusCode())
+	s.Equal(http.StatusForbidden, serve(name, nil).Response.StatusCode())
+	s.Equal(http.StatusForbidden, serve(name, signer.Sign("other")).Response.StatusCode())
+	expired := signer.SignAt(stream.TID(), time.Now().Add(-time.Minute))
+	s.Equal(http.StatusForbidden, serve(name, expired).Response.StatusCode())
+}
+
+func TestSignPlaylist(t *testing.T) {
+	q := url.Values{"sig": {"abc"}}
+	pl := "#EXTM3U\n" +
+		"#EXT-X-KEY:METHOD=AES-128,URI=\"https://conductor/api/v1/key/k1\",IV=0x01\n" +
+		"#EXTINF:10.0,\n" +
+		"s0_000000.ts\n" +
+		"#EXTINF:10.0,\n" +
+		"s0_000001.ts?v=1\n" +
+		"#EXT-X-ENDLIST\n"
+	expected := "#EXTM3U\n" +
+		"#EXT-X-KEY:METHOD=AES-128,URI=\"https://conductor/api/v1/key/k1?sig=abc\",IV=0x01\n" +
+		"#EXTINF:10.0,\n" +
+		"s0_000000.ts?sig=abc\n" +
+		"#EXTINF:10.0,\n" +
+		"s0_000001.ts?v=1&sig=abc\n" +
+		"#EXT-X-ENDLIST\n"
+	assert.Equal(t, expected, string(signPlaylist([]byte(pl), q)))
+}
+
+func (s *localSuite) TestPutProgress() {
+	stream := library.InitStream(path.Join(s.streamsPath, s.sdHash), "")
+	err := stream.GenerateManifest("url", "channel", s.sdHash)
+	s.Require().NoError(err)
+
+	reports := []UploadProgress{}
+	ctx := WithProgress(context.Background(), func(p UploadProgress) {
+		reports = append(reports, p)
+	})
+	s.Require().NoError(s.storage.PutWithContext(ctx, stream, false))
+
+	s.Require().NotEmpty(reports)
+	last := reports[len(reports)-1]
+	s.Equal(len(reports), last.FilesTotal)
+	s.Equal(last.FilesTotal, last.FilesDone)
+	s.Equal(last.BytesTotal, last.BytesDone)
+	s.EqualValues(100, last.Percent())
+	s.Less(reports[0].Percent(), float64(100))
+}
+
+func (s *localSuite) TestArchive() {
+	stream := library.InitStream(path.Join(s.streamsPath, s.sdHash), "")
+	err := stream.GenerateManifest("url", "channel", s.sdHash)
+	s.Require().NoError(err)
+	s.Require().NoError(s.storage.Put(stream, false))
+
+	s.Require().NoError(s.storage.Archive(stream.TID()))
+	_, err = s.storage.GetFragment(stream.TID(), library.MasterPlaylistName)
+	s.True(os.IsNotExist(err))
+	streams, err := s.storage.ListStreams()
+	s.Require().NoError(err)
+	s.Empty(streams, "archived streams must not be listed")
+
+	s.Require().NoError(s.storage.Unarchive(stream.TID()))
+	sf, err := s.storage.GetFragment(stream.TID(), library.MasterPlaylistName)
+	s.Require().NoError(err)
+	sf.Close()
+
+	s.Require().NoError(s.storage.Archive(stream.TID()))
+	s.Require().NoError(s.storage.DeleteArchived(stream.TID()))
+	s.Error(s.storage.Unarchive(stream.TID()))
+	s.Error(s.storage.Archive("../" + stream.TID()))
+}
+
+func (s *localSuite) TestPutManifest() {
+	stream := library.InitStream(path.Join(s.streamsPath, s.sdHash), "")
+	err := stream.GenerateManifest("url", "channel", s.sdHash)
+	s.Require().NoError(err)
+	s.Require().NoError(s.storage.Put(stream, false))
+
+	stream.Manifest.URL = "updated"
+	data, err := stream.Manifest.EncodeYAML()
+	s.Require().NoError(err)
+	s.Require().NoError(s.storage.PutManifest(stream.TID(), data))
+
+	sf, err := s.storage.GetFragment(stream.TID(), library.ManifestName)
+	s.Require().NoError(err)
+	defer sf.Close()
+	stored, err := io.ReadAll(sf)
+	s.Require().NoError(err)
+	m, err := library.DecodeManifest(stored)
+	s.Require().NoError(err)
+	s.Equal("updated", m.URL)
+
+	files, err := s.storage.ListFiles(stream.TID())
+	s.Require().NoError(err)
+	s.Len(files, len(stream.Manifest.Files)+1)
+	s.Error(s.storage.PutManifest("nonexistent", data))
+}
+
+func (s *localSuite) TestPutFile() {
+	stream := library.InitStream(path.Join(s.streamsPath, s.sdHash), "")
+	err := stream.GenerateManifest("url", "channel", s.sdHash)
+	s.Require().NoError(err)
+	s.Require().NoError(s.storage.Put(stream, false))
+
+	s.Require().NoError(s.storage.PutFile(stream.TID(), "s0_000000.ts", strings.NewReader("replaced")))
+	sf, err := s.storage.GetFragment(stream.TID(), "s0_000000.ts")
+	s.Require().NoError(err)
+	defer sf.Close()
+	data, err := io.ReadAll(sf)
+	s.Require().NoError(err)
+	s.Equal("replaced", string(data))
+
+	files, err := s.storage.ListFiles(stream.TID())
+	s.Require().NoError(err)
+	s.Len(files, len(stream.Manifest.Files)+1)
+	s.Error(s.storage.PutFile(stream.TID(), "../s0_000000.ts", strings.NewReader("x")))
+	s.Error(s.storage.PutFile("nonexistent", "s0_000000.ts", strings.NewReader("x")))
+}
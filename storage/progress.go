@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"context"
+	"sync"
+)
+
+// UploadProgress describes the state of a stream upload.
+type UploadProgress struct {
+	FilesDone, FilesTotal int
+	BytesDone, BytesTotal int64
+}
+
+// ProgressFunc is called after every file uploaded. Calls are never concurrent.
+type ProgressFunc func(UploadProgress)
+
+type progressKey struct{}
+
+// WithProgress returns a context that makes PutWithContext report upload progress to fn.
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// Percent returns the share of bytes uploaded.
+func (p UploadProgress) Percent() float64 {
+	if p.BytesTotal == 0 {
+		if p.FilesTotal == 0 {
+			return 100
+		}
+		return float64(p.FilesDone) / float64(p.FilesTotal) * 100
+	}
+	return float64(p.BytesDone) / float64(p.BytesTotal) * 100
+}
+
+type progressTracker struct {
+	sync.Mutex
+	progress UploadProgress
+	fn       ProgressFunc
+}
+
+func newProgressTracker(ctx context.Context, files int, size int64) *progressTracker {
+	fn, _ := ctx.Value(progressKey{}).(ProgressFunc)
+	return &progressTracker{
+		progress: UploadProgress{FilesTotal: files, BytesTotal: size},
+		fn:       fn,
+	}
+}
+
+func (t *progressTracker) add(size int64) {
+	t.Lock()
+	defer t.Unlock()
+	t.progress.FilesDone++
+	t.progress.BytesDone += size
+	if t.fn != nil {
+		t.fn(t.progress)
+	}
+}
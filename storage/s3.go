@@ -1,12 +1,19 @@
 package storage
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
+	"math/rand"
+	"net/http"
+	"net/url"
 	"os"
 	"path"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -16,24 +23,38 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/lbryio/transcoder/library"
-)
+	"github.com/lbryio/transcoder/pkg/urlsign"
 
-var ErrStreamExists = errors.New("stream already exists")
+	"github.com/fasthttp/router"
+	"github.com/valyala/fasthttp"
+)
 
-type discardAt struct{}
+var ErrStreamExists = library.ErrStreamExists
 
-func (discardAt) WriteAt(p []byte, off int64) (int, error) {
-	return len(p), nil
-}
+const (
+	defaultUploadConcurrency = 10
+	defaultUploadRetries     = 3
+	uploadRetryDelay         = 500 * time.Millisecond
+)
 
 type S3Configuration struct {
 	name, endpoint, region,
 	accessKey, secretKey, bucket string
-	disableSSL, createBucket bool
+	disableSSL, createBucket         bool
+	uploadConcurrency, uploadRetries int
+	archivePrefix                    string
+	archiveDays                      int
+	private                          bool
+	url                              string
+	signer                           *urlsign.Signer
 }
 
 func S3Configure() *S3Configuration {
-	return &S3Configuration{region: "us-east-1"}
+	return &S3Configuration{
+		region:            "us-east-1",
+		uploadConcurrency: defaultUploadConcurrency,
+		uploadRetries:     defaultUploadRetries,
+	}
 }
 
 // Name is storage type name (for internal use)
@@ -80,10 +101,56 @@ func (c *S3Configuration) Credentials(accessKey, secretKey string) *S3Configurat
 	return c
 }
 
+// UploadConcurrency is a number of stream files uploaded in parallel.
+func (c *S3Configuration) UploadConcurrency(n int) *S3Configuration {
+	c.uploadConcurrency = n
+	return c
+}
+
+// UploadRetries is a number of times a failed file upload is retried, with exponentially growing delay.
+func (c *S3Configuration) UploadRetries(n int) *S3Configuration {
+	c.uploadRetries = n
+	return c
+}
+
+// Archive makes files of retired streams moved under prefix and kept there for the number of days
+// so they can be restored. When the bucket is created by the driver, a lifecycle rule expiring objects
+// under prefix is set up as well, otherwise it should be configured for the bucket separately.
+func (c *S3Configuration) Archive(prefix string, days int) *S3Configuration {
+	c.archivePrefix = strings.Trim(prefix, "/")
+	c.archiveDays = days
+	return c
+}
+
+// Private keeps uploaded objects and a created bucket private. Streams are then served by S3Driver.Route,
+// which redirects requests carrying valid signatures to presigned object URLs.
+func (c *S3Configuration) Private() *S3Configuration {
+	c.private = true
+	return c
+}
+
+// URL is a base URL private streams are served from, see S3Driver.Route.
+func (c *S3Configuration) URL(u string) *S3Configuration {
+	c.url = strings.TrimRight(u, "/")
+	return c
+}
+
+// Signer checks signatures of requests for private streams, required for S3Driver.Route.
+func (c *S3Configuration) Signer(s *urlsign.Signer) *S3Configuration {
+	c.signer = s
+	return c
+}
+
 func InitS3Driver(cfg *S3Configuration) (*S3Driver, error) {
 	if cfg.name == "" {
 		return nil, errors.New("storage name must me configured")
 	}
+	if cfg.uploadConcurrency < 1 {
+		return nil, errors.New("upload concurrency must be positive")
+	}
+	if cfg.archiveDays > 0 && cfg.archivePrefix == "" {
+		return nil, errors.New("archive prefix must be configured")
+	}
 	s3cfg := &aws.Config{
 		Credentials:      credentials.NewStaticCredentials(cfg.accessKey, cfg.secretKey, ""),
 		Endpoint:         aws.String(cfg.endpoint),
@@ -105,7 +172,7 @@ func InitS3Driver(cfg *S3Configuration) (*S3Driver, error) {
 		client := s3.New(sess)
 		_, err := client.CreateBucket(&s3.CreateBucketInput{
 			Bucket: aws.String(s.bucket),
-			ACL:    aws.String("public-read"),
+			ACL:    aws.String(s.bucketACL()),
 		})
 		if err != nil {
 			if awsErr, ok := err.(awserr.Error); ok {
@@ -115,6 +182,22 @@ func InitS3Driver(cfg *S3Configuration) (*S3Driver, error) {
 			}
 		}
 	}
+	if cfg.createBucket && cfg.archiveDays > 0 {
+		_, err := s3.New(sess).PutBucketLifecycleConfiguration(&s3.PutBucketLifecycleConfigurationInput{
+			Bucket: aws.String(s.bucket),
+			LifecycleConfiguration: &s3.BucketLifecycleConfiguration{
+				Rules: []*s3.LifecycleRule{{
+					ID:         aws.String("archive-expiration"),
+					Status:     aws.String(s3.ExpirationStatusEnabled),
+					Filter:     &s3.LifecycleRuleFilter{Prefix: aws.String(cfg.archivePrefix + "/")},
+					Expiration: &s3.LifecycleExpiration{Days: aws.Int64(int64(cfg.archiveDays))},
+				}},
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("cannot configure archive expiration: %w", err)
+		}
+	}
 
 	return s, nil
 }
@@ -128,6 +211,26 @@ func (s *S3Driver) Name() string {
 	return s.name
 }
 
+// Private reports whether stream objects are kept private and served only to signed requests through S3Driver.Route.
+func (s *S3Driver) Private() bool {
+	return s.private && s.signer != nil
+}
+
+// objectACL is the canned ACL stream objects are uploaded with.
+func (s *S3Driver) objectACL() string {
+	if s.private {
+		return s3.ObjectCannedACLPrivate
+	}
+	return s3.ObjectCannedACLPublicRead
+}
+
+func (s *S3Driver) bucketACL() string {
+	if s.private {
+		return s3.BucketCannedACLPrivate
+	}
+	return s3.BucketCannedACLPublicRead
+}
+
 func (s *S3Driver) GetURL(streamTID string) string {
 	return fmt.Sprintf("%s/%s/%s", s.endpoint, s.bucket, streamTID)
 }
@@ -136,62 +239,243 @@ func (s *S3Driver) Put(stream *library.Stream, overwrite bool) error {
 	return s.PutWithContext(aws.BackgroundContext(), stream, overwrite)
 }
 
+// PutWithContext uploads stream files in order making stream playable only when it is complete:
+// segments go first, then variant playlists, master playlist and finally the manifest, which marks the upload complete.
+// Streams without the manifest are considered partially uploaded and are overwritten.
+// If the upload fails, objects uploaded by this call are deleted, leaving other objects of the stream intact.
+// When overwriting, objects of a complete stream are copied aside and the existing manifest is deleted,
+// so a stream left incomplete is never marked as complete. If the upload fails, the previous stream is restored.
 func (s *S3Driver) PutWithContext(ctx context.Context, stream *library.Stream, overwrite bool) error {
-	if !overwrite {
-		dl := s3manager.NewDownloader(s.session)
-		_, err := dl.Download(discardAt{}, &s3.GetObjectInput{
+	exists, err := s.exists(ctx, stream.TID())
+	if err != nil {
+		return err
+	}
+	if exists && !overwrite {
+		return ErrStreamExists
+	}
+	if exists {
+		err := s.copyObjects(stream.TID()+"/", s.backupKey(stream.TID()), s3.ObjectCannedACLPrivate)
+		if err != nil {
+			return fmt.Errorf("cannot back up existing stream: %w", err)
+		}
+	}
+	if overwrite {
+		_, err := s3.New(s.session).DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
 			Bucket: aws.String(s.bucket),
-			Key:    aws.String(s3FileKey(stream.TID(), library.MasterPlaylistName)),
+			Key:    aws.String(s3FileKey(stream.TID(), library.ManifestName)),
 		})
-		if err == nil {
-			return ErrStreamExists
+		if err != nil {
+			return fmt.Errorf("cannot delete existing manifest: %w", err)
 		}
-
 	}
 
-	ul := s3manager.NewUploader(s.session)
-	err := stream.Walk(
+	phases := make([][]uploadFile, uploadPhases)
+	var files int
+	var size int64
+	err = stream.Walk(
 		func(fi fs.FileInfo, fullPath, name string) error {
-			var ctype string
-			f, err := os.Open(fullPath)
-			if err != nil {
-				return err
-			}
-			defer f.Close()
-
-			switch path.Ext(name) {
-			case library.PlaylistExt:
-				ctype = library.PlaylistContentType
-			case library.FragmentExt:
-				ctype = library.FragmentContentType
-			default:
-				ctype = "text/plain"
-			}
-			logger.Debugw("uploading", "key", s3FileKey(stream.TID(), name), "ctype", ctype, "size", fi.Size(), "bucket", s.bucket)
-			_, err = ul.UploadWithContext(ctx, &s3manager.UploadInput{
-				Bucket:      aws.String(s.bucket),
-				Key:         aws.String(s3FileKey(stream.TID(), name)),
-				ContentType: aws.String(ctype),
-				Body:        f,
-				ACL:         aws.String("public-read"),
+			p := uploadPhase(name)
+			phases[p] = append(phases[p], uploadFile{
+				path: fullPath,
+				key:  s3FileKey(stream.TID(), name),
+				size: fi.Size(),
 			})
-			if err != nil {
-				return err
-			}
+			files++
+			size += fi.Size()
 			return nil
 		},
 	)
+	if err != nil {
+		return err
+	}
 
+	progress := newProgressTracker(ctx, files, size)
+	uploaded := []string{}
+	for _, pf := range phases {
+		keys, err := s.uploadFiles(ctx, pf, progress)
+		uploaded = append(uploaded, keys...)
+		if err != nil {
+			s.rollback(stream.TID(), uploaded, exists, err)
+			return err
+		}
+	}
+	if exists {
+		if err := s.deletePrefix(s.backupKey(stream.TID())); err != nil {
+			logger.Warnw("cannot delete backup of overwritten stream", "tid", stream.TID(), "err", err)
+		}
+	}
+	return nil
+}
+
+const uploadPhases = 4
+
+// uploadPhase returns the stage of a stream upload the file belongs to.
+func uploadPhase(name string) int {
+	switch {
+	case name == library.ManifestName:
+		return 3
+	case name == library.MasterPlaylistName:
+		return 2
+	case path.Ext(name) == library.PlaylistExt:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// exists checks for the stream manifest, which is uploaded last.
+func (s *S3Driver) exists(ctx context.Context, streamTID string) (bool, error) {
+	client := s3.New(s.session)
+	_, err := client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s3FileKey(streamTID, library.ManifestName)),
+	})
+	if err == nil {
+		return true, nil
+	}
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && (awsErr.Code() == "NotFound" || awsErr.Code() == s3.ErrCodeNoSuchKey) {
+		return false, nil
+	}
+	return false, err
+}
+
+// rollback deletes objects uploaded before the upload failed and moves back the backup of the overwritten stream,
+// replacing objects it had with their previous versions.
+func (s *S3Driver) rollback(streamTID string, keys []string, restore bool, uploadErr error) {
+	logger.Infow("upload failed, deleting uploaded objects", "tid", streamTID, "count", len(keys), "err", uploadErr)
+	if err := s.deleteKeys(keys); err != nil {
+		logger.Warnw("failed to delete partially uploaded stream", "tid", streamTID, "err", err)
+	}
+	if !restore {
+		return
+	}
+	if err := s.moveObjects(s.backupKey(streamTID), streamTID+"/", s.objectACL()); err != nil {
+		logger.Errorw("cannot restore overwritten stream", "tid", streamTID, "err", err)
+	}
+}
+
+// backupKey is the prefix objects of a stream being overwritten are kept under until the upload is complete.
+func (s *S3Driver) backupKey(streamTID string) string {
+	return fmt.Sprintf(".overwrite/%v/", streamTID)
+}
+
+type uploadFile struct {
+	path, key string
+	size      int64
+}
+
+// uploadFiles uploads files using a configured number of parallel uploaders, returning keys of uploaded objects.
+// The first file failing after all retries cancels the rest of uploads.
+func (s *S3Driver) uploadFiles(ctx context.Context, files []uploadFile, progress *progressTracker) ([]string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ul := s3manager.NewUploader(s.session)
+	jobs := make(chan uploadFile)
+	wg := sync.WaitGroup{}
+	errOnce := sync.Once{}
+	var uploadErr error
+	uploaded := []string{}
+	uploadedLock := sync.Mutex{}
+
+	for i := 0; i < s.uploadConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range jobs {
+				if err := s.uploadWithRetry(ctx, ul, f); err != nil {
+					errOnce.Do(func() {
+						uploadErr = err
+						cancel()
+					})
+					continue
+				}
+				uploadedLock.Lock()
+				uploaded = append(uploaded, f.key)
+				uploadedLock.Unlock()
+				progress.add(f.size)
+			}
+		}()
+	}
+
+send:
+	for _, f := range files {
+		select {
+		case jobs <- f:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if uploadErr != nil {
+		return uploaded, uploadErr
+	}
+	return uploaded, ctx.Err()
+}
+
+func contentType(key string) string {
+	switch path.Ext(key) {
+	case library.PlaylistExt:
+		return library.PlaylistContentType
+	case library.FragmentExt:
+		return library.FragmentContentType
+	default:
+		return "text/plain"
+	}
+}
+
+func (s *S3Driver) uploadWithRetry(ctx context.Context, ul *s3manager.Uploader, f uploadFile) error {
+	ctype := contentType(f.key)
+	for attempt := 0; ; attempt++ {
+		err := s.upload(ctx, ul, f, ctype)
+		if err == nil {
+			return nil
+		}
+		if attempt >= s.uploadRetries || ctx.Err() != nil {
+			return fmt.Errorf("failed to upload %s: %w", f.key, err)
+		}
+		delay := uploadRetryDelay<<attempt + time.Duration(rand.Int63n(int64(uploadRetryDelay)))
+		logger.Infow("retrying upload", "key", f.key, "attempt", attempt+1, "delay", delay, "err", err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *S3Driver) upload(ctx context.Context, ul *s3manager.Uploader, f uploadFile, ctype string) error {
+	fh, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	logger.Debugw("uploading", "key", f.key, "ctype", ctype, "size", f.size, "bucket", s.bucket)
+	_, err = ul.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(f.key),
+		ContentType: aws.String(ctype),
+		Body:        fh,
+		ACL:         aws.String(s.objectACL()),
+	})
 	return err
 }
 
 func (s *S3Driver) Delete(streamTID string) error {
+	return s.deletePrefix(streamTID + "/")
+}
+
+// deletePrefix deletes all objects with keys under prefix, which should end with "/"
+// so objects of other streams sharing the same key beginning are not matched.
+func (s *S3Driver) deletePrefix(prefix string) error {
 	ctx, cancelFn := context.WithTimeout(context.Background(), 600*time.Second)
 	client := s3.New(s.session)
-	bucket := aws.String(s.bucket)
 	objects, err := client.ListObjectsWithContext(ctx, &s3.ListObjectsInput{
-		Bucket:  bucket,
-		Prefix:  aws.String(streamTID),
+		Bucket:  aws.String(s.bucket),
+		Prefix:  aws.String(prefix),
 		MaxKeys: aws.Int64(500),
 	})
 	cancelFn()
@@ -199,50 +483,157 @@ func (s *S3Driver) Delete(streamTID string) error {
 		return err
 	}
 
-	delObjects := []*s3.ObjectIdentifier{}
+	keys := []string{}
 	for _, o := range objects.Contents {
-		delObjects = append(delObjects, &s3.ObjectIdentifier{Key: o.Key})
-		if len(delObjects) >= 100 {
-			delInput := &s3.DeleteObjectsInput{
-				Bucket: bucket,
-				Delete: &s3.Delete{
-					Objects: delObjects,
-					Quiet:   aws.Bool(false),
-				},
-			}
-			ctx, cancelFn := context.WithTimeout(context.Background(), 600*time.Second)
-			_, err = client.DeleteObjectsWithContext(ctx, delInput)
-			cancelFn()
-			if err != nil {
-				return err
-			}
-			delObjects = []*s3.ObjectIdentifier{}
-		}
+		keys = append(keys, *o.Key)
+	}
+	if err := s.deleteKeys(keys); err != nil {
+		return err
 	}
 
-	if len(delObjects) > 0 {
+	if *objects.IsTruncated {
+		return s.deletePrefix(prefix)
+	}
+
+	return nil
+}
+
+// deleteKeys deletes objects in batches of 100.
+func (s *S3Driver) deleteKeys(keys []string) error {
+	client := s3.New(s.session)
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > 100 {
+			n = 100
+		}
+		delObjects := []*s3.ObjectIdentifier{}
+		for _, k := range keys[:n] {
+			delObjects = append(delObjects, &s3.ObjectIdentifier{Key: aws.String(k)})
+		}
+		keys = keys[n:]
 		delInput := &s3.DeleteObjectsInput{
-			Bucket: bucket,
+			Bucket: aws.String(s.bucket),
 			Delete: &s3.Delete{
 				Objects: delObjects,
 				Quiet:   aws.Bool(false),
 			},
 		}
 		ctx, cancelFn := context.WithTimeout(context.Background(), 600*time.Second)
-		_, err = client.DeleteObjectsWithContext(ctx, delInput)
+		_, err := client.DeleteObjectsWithContext(ctx, delInput)
 		cancelFn()
 		if err != nil {
 			return err
 		}
 	}
+	return nil
+}
+
+func (s *S3Driver) ArchiveTTL() time.Duration {
+	return time.Duration(s.archiveDays) * 24 * time.Hour
+}
+
+// Archive moves stream objects under the archive prefix, making them private.
+func (s *S3Driver) Archive(streamTID string) error {
+	if s.archivePrefix == "" {
+		return errors.New("archive is not configured")
+	}
+	return s.moveObjects(streamTID+"/", s.archiveKey(streamTID, ""), s3.ObjectCannedACLPrivate)
+}
 
-	if *objects.IsTruncated {
-		return s.Delete(streamTID)
+// Unarchive moves archived stream objects back, making them public again.
+func (s *S3Driver) Unarchive(streamTID string) error {
+	if s.archivePrefix == "" {
+		return errors.New("archive is not configured")
+	}
+	exists, err := s.exists(aws.BackgroundContext(), streamTID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrStreamExists
+	}
+	return s.moveObjects(s.archiveKey(streamTID, ""), streamTID+"/", s.objectACL())
+}
+
+func (s *S3Driver) DeleteArchived(streamTID string) error {
+	if s.archivePrefix == "" {
+		return errors.New("archive is not configured")
+	}
+	return s.deletePrefix(s.archiveKey(streamTID, ""))
+}
+
+func (s *S3Driver) archiveKey(tid, name string) string {
+	return fmt.Sprintf("%v/%v/%v", s.archivePrefix, tid, name)
+}
+
+// moveObjects copies all objects under src prefix to dst prefix and deletes the originals.
+// Object metadata, including content type, is preserved.
+func (s *S3Driver) moveObjects(src, dst, acl string) error {
+	if err := s.copyObjects(src, dst, acl); err != nil {
+		return err
 	}
+	return s.deletePrefix(src)
+}
 
+// copyObjects copies all objects under src prefix to dst prefix, preserving object metadata.
+func (s *S3Driver) copyObjects(src, dst, acl string) error {
+	client := s3.New(s.session)
+	keys := []string{}
+	err := client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(src),
+	}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, o := range page.Contents {
+			keys = append(keys, *o.Key)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no objects found under %s", src)
+	}
+	for _, k := range keys {
+		_, err := client.CopyObject(&s3.CopyObjectInput{
+			Bucket:     aws.String(s.bucket),
+			CopySource: aws.String(path.Join(s.bucket, k)),
+			Key:        aws.String(dst + strings.TrimPrefix(k, src)),
+			ACL:        aws.String(acl),
+		})
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// PutManifest replaces the manifest object of a stored stream.
+func (s *S3Driver) PutManifest(streamTID string, data []byte) error {
+	client := s3.New(s.session)
+	_, err := client.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s3FileKey(streamTID, library.ManifestName)),
+		ContentType: aws.String("text/plain"),
+		Body:        bytes.NewReader(data),
+		ACL:         aws.String(s.objectACL()),
+	})
+	return err
+}
+
+// PutFile replaces a single stream file object.
+func (s *S3Driver) PutFile(streamTID, name string, r io.Reader) error {
+	key := s3FileKey(streamTID, name)
+	_, err := s3manager.NewUploader(s.session).Upload(&s3manager.UploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType(key)),
+		Body:        r,
+		ACL:         aws.String(s.objectACL()),
+	})
+	return err
+}
+
 func (s *S3Driver) GetFragment(streamTID, name string) (StreamFragment, error) {
 	client := s3.New(s.session)
 	obj, err := client.GetObject(&s3.GetObjectInput{
@@ -255,6 +646,189 @@ func (s *S3Driver) GetFragment(streamTID, name string) (StreamFragment, error) {
 	return obj.Body, nil
 }
 
+// ListFiles returns names of all objects stored under the stream prefix.
+func (s *S3Driver) ListFiles(streamTID string) ([]string, error) {
+	client := s3.New(s.session)
+	prefix := streamTID + "/"
+	names := []string{}
+	err := client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, o := range page.Contents {
+			names = append(names, strings.TrimPrefix(*o.Key, prefix))
+		}
+		return true
+	})
+	return names, err
+}
+
+// ListStreams sums sizes of all objects in the bucket by their top-level prefix.
+func (s *S3Driver) ListStreams() ([]library.StoredStream, error) {
+	client := s3.New(s.session)
+	streams := map[string]*library.StoredStream{}
+	err := client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+	}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, o := range page.Contents {
+			parts := strings.SplitN(*o.Key, "/", 2)
+			if len(parts) < 2 {
+				continue
+			}
+			ss, ok := streams[parts[0]]
+			if !ok {
+				ss = &library.StoredStream{TID: parts[0]}
+				streams[parts[0]] = ss
+			}
+			ss.Size += aws.Int64Value(o.Size)
+			if lm := aws.TimeValue(o.LastModified); lm.After(ss.LastModified) {
+				ss.LastModified = lm
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	list := []library.StoredStream{}
+	for _, ss := range streams {
+		list = append(list, *ss)
+	}
+	return list, nil
+}
+
+// PutDir uploads all regular files from dir (non-recursively) under prefix.
+// It is used for passing intermediate data between workers and does not make objects public.
+func (s *S3Driver) PutDir(ctx context.Context, prefix, dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	ul := s3manager.NewUploader(s.session)
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		f, err := os.Open(path.Join(dir, e.Name()))
+		if err != nil {
+			return err
+		}
+		logger.Debugw("uploading", "key", s3FileKey(prefix, e.Name()), "bucket", s.bucket)
+		_, err = ul.UploadWithContext(ctx, &s3manager.UploadInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(s3FileKey(prefix, e.Name())),
+			Body:   f,
+		})
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetDir downloads all objects stored directly under prefix into dir.
+func (s *S3Driver) GetDir(ctx context.Context, prefix, dir string) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	client := s3.New(s.session)
+	dl := s3manager.NewDownloader(s.session)
+	var dlErr error
+	err := client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.bucket),
+		Prefix:    aws.String(prefix + "/"),
+		Delimiter: aws.String("/"),
+	}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, o := range page.Contents {
+			f, err := os.Create(path.Join(dir, path.Base(*o.Key)))
+			if err != nil {
+				dlErr = err
+				return false
+			}
+			_, err = dl.DownloadWithContext(ctx, f, &s3.GetObjectInput{Bucket: aws.String(s.bucket), Key: o.Key})
+			f.Close()
+			if err != nil {
+				dlErr = err
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return dlErr
+}
+
+// DeleteDir deletes all objects stored under prefix.
+func (s *S3Driver) DeleteDir(prefix string) error {
+	p := strings.Trim(path.Clean("/"+prefix), "/")
+	if p == "" {
+		return fmt.Errorf("invalid prefix: %q", prefix)
+	}
+	return s.deletePrefix(p + "/")
+}
+
 func s3FileKey(tid, name string) string {
 	return fmt.Sprintf("%v/%v", tid, name)
 }
+
+// PresignURL returns a temporary URL for reading a stream file from a private bucket.
+func (s *S3Driver) PresignURL(streamTID, name string, ttl time.Duration) (string, error) {
+	req, _ := s3.New(s.session).GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s3FileKey(streamTID, name)),
+	})
+	return req.Presign(ttl)
+}
+
+// Route mounts a handler redirecting signed requests for stream files to presigned object URLs
+// on r at the path part of the configured URL.
+func (s *S3Driver) Route(r *router.Router) error {
+	if s.signer == nil {
+		return errors.New("url signer is not configured")
+	}
+	u, err := url.Parse(s.url)
+	if err != nil {
+		return err
+	}
+	r.GET(strings.TrimRight(u.Path, "/")+"/{"+fileRouteParam+":*}", s.Handler)
+	return nil
+}
+
+// Handler checks the request signature and redirects to a presigned object URL valid until the signature expires.
+// Playlists are served directly, with the signature passed on to URIs they reference.
+func (s *S3Driver) Handler(ctx *fasthttp.RequestCtx) {
+	name, _ := ctx.UserValue(fileRouteParam).(string)
+	name = path.Clean("/" + name)
+	if !verifyRequest(ctx, s.signer, name) {
+		return
+	}
+	ttl := time.Until(urlsign.Expires(queryValues(ctx)))
+	if ttl > s.signer.TTL() {
+		ttl = s.signer.TTL()
+	}
+	tid, file := path.Split(strings.TrimPrefix(name, "/"))
+	if tid == "" || file == "" {
+		ctx.SetStatusCode(http.StatusNotFound)
+		return
+	}
+	if path.Ext(file) == library.PlaylistExt {
+		f, err := s.GetFragment(strings.TrimSuffix(tid, "/"), file)
+		if err != nil {
+			ctx.SetStatusCode(http.StatusNotFound)
+			return
+		}
+		defer f.Close()
+		serveSignedPlaylist(ctx, f)
+		return
+	}
+	presigned, err := s.PresignURL(strings.TrimSuffix(tid, "/"), file, ttl)
+	if err != nil {
+		logger.Warnw("cannot presign url", "name", name, "err", err)
+		ctx.SetStatusCode(http.StatusInternalServerError)
+		return
+	}
+	ctx.Redirect(presigned, http.StatusFound)
+}
@@ -3,18 +3,29 @@ package storage
 import (
 	"context"
 	"fmt"
+	"io"
 	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
 	"path"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/Pallinder/go-randomdata"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/docker/go-connections/nat"
 	"github.com/lbryio/transcoder/library"
+	"github.com/lbryio/transcoder/pkg/urlsign"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
+	"github.com/valyala/fasthttp"
 )
 
 type s3Container struct {
@@ -82,9 +93,23 @@ func (s *s3suite) TestPutDelete() {
 	err = s3drv.Put(stream, true)
 	s.NoError(err)
 
+	// Objects of other streams starting with the same TID must survive deletion.
+	neighbour := stream.TID() + "x/" + library.ManifestName
+	_, err = s3.New(s3drv.session).PutObject(&s3.PutObjectInput{
+		Bucket: aws.String("storage-s3-test"),
+		Key:    aws.String(neighbour),
+		Body:   strings.NewReader("neighbour"),
+	})
+	s.Require().NoError(err)
+
 	err = s3drv.Delete(stream.TID())
 	s.Require().NoError(err)
 
+	nf, err := s3drv.GetFragment(stream.TID()+"x", library.ManifestName)
+	s.Require().NoError(err)
+	nf.Close()
+	s.Require().NoError(s3drv.Delete(stream.TID() + "x"))
+
 	deletedPieces := []string{"", library.MasterPlaylistName, "stream_0.m3u8", "stream_1.m3u8", "stream_2.m3u8", "stream_3.m3u8"}
 	for _, n := range deletedPieces {
 		p, err := s3drv.GetFragment(stream.TID(), n)
@@ -95,6 +120,152 @@ func (s *s3suite) TestPutDelete() {
 	}
 }
 
+func (s *s3suite) TestPutConcurrentProgress() {
+	s3drv, err := InitS3Driver(
+		S3Configure().
+			Name("test").
+			Endpoint(s.s3container.URI).
+			Region("us-east-1").
+			Credentials("s3-test", "s3-test").
+			Bucket("storage-s3-test").
+			DisableSSL().
+			UploadConcurrency(4).
+			UploadRetries(1),
+	)
+	s.Require().NoError(err)
+
+	stream := library.InitStream(path.Join(s.streamsPath, s.sdHash), "")
+	err = stream.GenerateManifest("url", "channel", s.sdHash)
+	s.Require().NoError(err)
+
+	var last UploadProgress
+	ctx := WithProgress(context.Background(), func(p UploadProgress) { last = p })
+	s.Require().NoError(s3drv.PutWithContext(ctx, stream, false))
+	s.Equal(len(library.PopulatedHLSPlaylistFiles)+1, last.FilesDone)
+	s.EqualValues(100, last.Percent())
+
+	for _, n := range library.PopulatedHLSPlaylistFiles {
+		f, err := s3drv.GetFragment(stream.TID(), n)
+		s.Require().NoError(err, n)
+		f.Close()
+	}
+	s.Require().NoError(s3drv.Delete(stream.TID()))
+}
+
+func (s *s3suite) TestPutIncomplete() {
+	s3drv, err := InitS3Driver(
+		S3Configure().
+			Name("test").
+			Endpoint(s.s3container.URI).
+			Region("us-east-1").
+			Credentials("s3-test", "s3-test").
+			Bucket("storage-s3-test").
+			DisableSSL(),
+	)
+	s.Require().NoError(err)
+
+	stream := library.InitStream(path.Join(s.streamsPath, s.sdHash), "")
+	err = stream.GenerateManifest("url", "channel", s.sdHash)
+	s.Require().NoError(err)
+	s.Require().NoError(s3drv.Put(stream, false))
+
+	// Stream without the manifest is left by an interrupted upload and should not block the next one.
+	_, err = s3.New(s3drv.session).DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String("storage-s3-test"),
+		Key:    aws.String(s3FileKey(stream.TID(), library.ManifestName)),
+	})
+	s.Require().NoError(err)
+	s.Require().NoError(s3drv.Put(stream, false))
+	s.ErrorIs(s3drv.Put(stream, false), ErrStreamExists)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.Error(s3drv.PutWithContext(ctx, stream, true))
+	mf, err := s3drv.GetFragment(stream.TID(), library.ManifestName)
+	s.Require().NoError(err, "failed overwrite should leave the stored stream intact")
+	mf.Close()
+
+	// Overwrite failing midway must restore objects of the previous stream it has replaced.
+	segment := "s0_000000.ts"
+	original := s.readObject(s3drv, stream.TID(), segment)
+	s.Require().NoError(os.WriteFile(path.Join(stream.LocalPath, segment), []byte("replaced"), 0644))
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	ctx = WithProgress(ctx, func(UploadProgress) { cancel() })
+	s.Error(s3drv.PutWithContext(ctx, stream, true))
+	s.Equal(original, s.readObject(s3drv, stream.TID(), segment))
+	mf, err = s3drv.GetFragment(stream.TID(), library.ManifestName)
+	s.Require().NoError(err, "previous stream should be restored")
+	mf.Close()
+
+	s.Require().NoError(s3drv.Put(stream, true))
+	s.Equal([]byte("replaced"), s.readObject(s3drv, stream.TID(), segment))
+	streams, err := s3drv.ListStreams()
+	s.Require().NoError(err)
+	for _, ss := range streams {
+		s.NotEqual(".overwrite", ss.TID, "backup of the overwritten stream should be removed")
+	}
+	s.Require().NoError(s3drv.Delete(stream.TID()))
+}
+
+func (s *s3suite) readObject(s3drv *S3Driver, tid, name string) []byte {
+	f, err := s3drv.GetFragment(tid, name)
+	s.Require().NoError(err, name)
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	s.Require().NoError(err)
+	return data
+}
+
+func (s *s3suite) TestPrivate() {
+	signer, err := urlsign.New(time.Minute, urlsign.Key{ID: "k1", Secret: "secret"})
+	s.Require().NoError(err)
+	s3drv, err := InitS3Driver(
+		S3Configure().
+			Name("test").
+			Endpoint(s.s3container.URI).
+			Region("us-east-1").
+			Credentials("s3-test", "s3-test").
+			Bucket("storage-s3-private").
+			CreateBucket().
+			Private().
+			Signer(signer).
+			DisableSSL(),
+	)
+	s.Require().NoError(err)
+
+	stream := library.InitStream(path.Join(s.streamsPath, s.sdHash), "")
+	s.Require().NoError(stream.GenerateManifest("url", "channel", s.sdHash))
+	s.Require().NoError(s3drv.Put(stream, false))
+
+	r, err := http.Get(s3drv.GetURL(stream.TID()) + "/" + library.MasterPlaylistName)
+	s.Require().NoError(err)
+	r.Body.Close()
+	s.Equal(http.StatusForbidden, r.StatusCode)
+
+	serve := func(q url.Values) *fasthttp.RequestCtx {
+		name := stream.TID() + "/" + library.MasterPlaylistName
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/" + name + "?" + q.Encode())
+		ctx.SetUserValue(fileRouteParam, name)
+		s3drv.Handler(ctx)
+		return ctx
+	}
+	s.Equal(http.StatusForbidden, serve(nil).Response.StatusCode())
+	ctx := serve(signer.Sign(stream.TID()))
+	s.Require().Equal(http.StatusFound, ctx.Response.StatusCode())
+	r, err = http.Get(string(ctx.Response.Header.Peek("Location")))
+	s.Require().NoError(err)
+	r.Body.Close()
+	s.Equal(http.StatusOK, r.StatusCode)
+}
+
+func TestUploadPhase(t *testing.T) {
+	names := []string{library.ManifestName, "stream_0.m3u8", library.MasterPlaylistName, "s0_000001.ts", "sprite.png"}
+	sort.SliceStable(names, func(i, j int) bool { return uploadPhase(names[i]) < uploadPhase(names[j]) })
+	assert.Equal(t, []string{"s0_000001.ts", "sprite.png", "stream_0.m3u8", library.MasterPlaylistName, library.ManifestName}, names)
+}
+
 func (s *s3suite) TearDownSuite() {
 	// s.NoError(s.cleanup())
 }
@@ -1,9 +1,22 @@
 package storage
 
 import (
-	"io"
+	"context"
+
+	"github.com/lbryio/transcoder/library"
 )
 
-type StreamFragment interface {
-	io.ReadCloser
+type StreamFragment = library.StreamFragment
+
+// Driver is a storage backend capable of storing streams for the library
+// as well as passing intermediate files between workers.
+type Driver interface {
+	library.Storage
+	PutWithContext(ctx context.Context, stream *library.Stream, overwrite bool) error
+	GetFragment(streamTID, name string) (StreamFragment, error)
+	ListFiles(streamTID string) ([]string, error)
+	ListStreams() ([]library.StoredStream, error)
+	PutDir(ctx context.Context, prefix, dir string) error
+	GetDir(ctx context.Context, prefix, dir string) error
+	DeleteDir(prefix string) error
 }
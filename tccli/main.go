@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/url"
@@ -14,14 +15,17 @@ import (
 
 	"github.com/lbryio/transcoder/client"
 	"github.com/lbryio/transcoder/encoder"
+	"github.com/lbryio/transcoder/ladder"
 	"github.com/lbryio/transcoder/library"
 	ldb "github.com/lbryio/transcoder/library/db"
 	"github.com/lbryio/transcoder/pkg/logging"
 	"github.com/lbryio/transcoder/pkg/logging/zapadapter"
 	"github.com/lbryio/transcoder/pkg/migrator"
 	"github.com/lbryio/transcoder/pkg/resolve"
+	"github.com/lbryio/transcoder/storage"
 
 	"github.com/alecthomas/kong"
+	"github.com/c2h5oh/datasize"
 	"github.com/panjf2000/ants/v2"
 	"github.com/spf13/viper"
 )
@@ -38,13 +42,15 @@ var CLI struct {
 		URL    string `name:"url" help:"LBRY URL"`
 	} `cmd help:"Get video URL"`
 	GenerateManifests struct {
-		VideoDir string `help:"Directory containing videos"`
-		DBPath   string `help:"Path to the SQLite DB file"`
-	} `cmd help:"Generate manifest files for videos"`
+		VideoDir string `arg:"" help:"Directory containing stream folders" type:"existingdir"`
+		URLs     string `name:"urls" required:"" help:"File with '<folder name> <lbry url>' lines" type:"existingfile"`
+		Workers  int    `optional:"" help:"Number of streams processed in parallel" default:"10"`
+	} `cmd:"" help:"Generate manifest files for stream folders missing them"`
 	Retire struct {
-		VideoDir string `help:"Directory containing videos"`
+		VideoDir string `help:"Directory containing videos" type:"existingdir"`
 		MaxSize  int    `help:"Max size of videos to keep in gigabytes"`
-	} `cmd help:"Generate manifest files for videos"`
+		Storage  string `optional:"" help:"Storage name videos in the directory are recorded with in the library" default:"local"`
+	} `cmd help:"Retire videos from a local storage directory according to the retirement policy"`
 	Genstream struct {
 		Path string `arg:"" help:"Path containing transcoded stream"`
 		URL  string `arg:"" help:"Stream URL"`
@@ -55,6 +61,15 @@ var CLI struct {
 	ValidateStream struct {
 		URL string `arg:"" help:"HTTP URL for stream to verify"`
 	} `cmd help:"Verify a specified stream"`
+	Ladder struct {
+		Validate struct {
+			Ladder string `arg:"" help:"Ladder YAML file" type:"existingfile"`
+		} `cmd:"" help:"Check a ladder file for mistakes"`
+		Plan struct {
+			Ladder string `optional:"" help:"Ladder YAML file, default ladder is used if omitted" type:"existingfile"`
+			Probe  string `required:"" help:"Input video file to build encoding plan for" type:"existingfile"`
+		} `cmd:"" help:"Print tiers and ffmpeg arguments for an input file without encoding"`
+	} `cmd:"" help:"Encoding ladder tools"`
 }
 
 func main() {
@@ -181,6 +196,16 @@ func main() {
 		if err := lib.AddRemoteStream(*ls); err != nil {
 			fmt.Println("error adding remote stream", "err", err)
 		}
+	case "generate-manifests <video-dir>":
+		if err := generateManifests(CLI.GenerateManifests.VideoDir, CLI.GenerateManifests.URLs, CLI.GenerateManifests.Workers); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	case "retire":
+		if err := retireVideos(CLI.Retire.VideoDir, CLI.Retire.Storage, CLI.Retire.MaxSize); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 	case "validate-stream <url>":
 		res, err := library.ValidateStream(CLI.ValidateStream.URL, false, false)
 
@@ -188,14 +213,14 @@ func main() {
 			fmt.Printf("error validating stream: %s\n", err)
 			return
 		}
-		fmt.Printf("%v parts present, %v missing", len(res.Present), len(res.Missing))
+		fmt.Printf("%v parts present, %v missing, %v corrupt\n", len(res.Present), len(res.Missing), len(res.Corrupt))
 	case "validate-streams":
 		wg := sync.WaitGroup{}
 		results := make(chan *library.ValidationResult)
 
 		go func() {
 			for vr := range results {
-				if len(vr.Missing) > 0 {
+				if len(vr.Missing) > 0 || len(vr.Corrupt) > 0 {
 					fmt.Printf("%s broken\n", vr.URL)
 				}
 			}
@@ -229,7 +254,174 @@ func main() {
 			fmt.Fprintln(os.Stderr, "reading standard input:", err)
 		}
 		wg.Wait()
+	case "ladder validate <ladder>":
+		l, err := ladder.LoadFile(CLI.Ladder.Validate.Ladder)
+		if err != nil {
+			fmt.Printf("error loading ladder: %s\n", err)
+			os.Exit(1)
+		}
+		if err := l.Validate(); err != nil {
+			var verr ladder.ValidationError
+			if errors.As(err, &verr) {
+				for _, p := range verr.Problems {
+					fmt.Println(p)
+				}
+			}
+			os.Exit(1)
+		}
+		fmt.Printf("ladder is valid, %v tiers\n", len(l.Tiers))
+	case "ladder plan":
+		err := planLadder(CLI.Ladder.Plan.Ladder, CLI.Ladder.Plan.Probe, log)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 	default:
 		panic(ctx.Command())
 	}
 }
+
+// generateManifests writes manifests for stream folders in dir which do not have one yet.
+// Resulting folders can be added to the library with conductor import-streams command.
+func generateManifests(dir, urlsPath string, workers int) error {
+	f, err := os.Open(urlsPath)
+	if err != nil {
+		return err
+	}
+	urls, err := library.ReadURLMap(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	wg := sync.WaitGroup{}
+	p, err := ants.NewPoolWithFunc(workers, func(i interface{}) {
+		defer wg.Done()
+		// Folders keep their names, so those become TIDs for the streams to be imported in place.
+		name := i.(string)
+		if !library.ValidTID(name) {
+			fmt.Printf("failed\t%s\tfolder name is not a valid TID\n", name)
+			return
+		}
+		stream := library.InitStream(path.Join(dir, name), "")
+		generate := library.ResolvingGenerator(urls, library.WithWorkerName("manual"), library.WithTID(name))
+		if err := generate(stream); err != nil {
+			fmt.Printf("failed\t%s\t%s\n", name, err)
+			return
+		}
+		fmt.Printf("generated\t%s\t%s\n", name, stream.TID())
+	})
+	if err != nil {
+		return err
+	}
+	defer p.Release()
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(path.Join(dir, e.Name(), library.ManifestName)); err == nil {
+			continue
+		}
+		wg.Add(1)
+		_ = p.Invoke(e.Name())
+	}
+	wg.Wait()
+	return nil
+}
+
+// retireVideos removes videos from a local storage dir, keeping the ones ranking higher in retirement order
+// up to maxSize gigabytes. Retired videos are recorded in the library as archived.
+func retireVideos(dir, storageName string, maxSize int) error {
+	if dir == "" || maxSize <= 0 {
+		return errors.New("video dir and max size must be set")
+	}
+	strg, err := storage.InitLocalStorage(storage.LocalConfigure().Name(storageName).Path(dir))
+	if err != nil {
+		return err
+	}
+
+	cfg := viper.New()
+	cfg.SetConfigName("conductor")
+	cfg.AddConfigPath(".")
+	if err := cfg.ReadInConfig(); err != nil {
+		return err
+	}
+	libCfg := cfg.GetStringMapString("library")
+	libDB, err := migrator.ConnectDB(migrator.DefaultDBConfig().DSN(libCfg["dsn"]).AppName("library"), ldb.MigrationsFS)
+	if err != nil {
+		return err
+	}
+	lib := library.New(library.Config{
+		DB:      libDB,
+		Storage: strg,
+		Log:     zapadapter.NewKV(nil),
+	})
+
+	totalSize, retiredSize, err := lib.RetireVideos(storageName, uint64(maxSize)*uint64(datasize.GB))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s retired, %s left\n", datasize.ByteSize(retiredSize).HR(), datasize.ByteSize(totalSize-retiredSize).HR())
+	return nil
+}
+
+// planLadder prints ladder tiers adjusted to the input file and ffmpeg command that would be run for it.
+func planLadder(ladderPath, input string, log logging.KVLogger) error {
+	l := ladder.Default
+	if ladderPath != "" {
+		var err error
+		l, err = ladder.LoadFile(ladderPath)
+		if err != nil {
+			return fmt.Errorf("error loading ladder: %w", err)
+		}
+	}
+	if err := l.Validate(); err != nil {
+		return err
+	}
+
+	e, err := encoder.NewEncoder(encoder.Configure().Log(log).NoSprites().Ladder(l))
+	if err != nil {
+		return err
+	}
+	meta, err := e.GetMetadata(input)
+	if err != nil {
+		return err
+	}
+	vs := meta.VideoStream
+	fmt.Printf(
+		"input: %vx%v, %s bps, %.2f fps, %ss\n",
+		vs.GetWidth(), vs.GetHeight(), meta.FMeta.GetFormat().GetBitRate(), meta.FPS, meta.FMeta.GetFormat().GetDuration(),
+	)
+
+	tl, err := l.Tweak(meta)
+	if err != nil {
+		return err
+	}
+	if len(tl.Tiers) == 0 {
+		return errors.New("no tiers left after adjusting ladder to the input")
+	}
+	fmt.Println("tiers:")
+	for n, t := range tl.Tiers {
+		rc := t.RateControl
+		if rc == ladder.RateControlDefault {
+			rc = "default"
+		}
+		fmt.Printf("  %v: %vx%v video=%v audio=%v framerate=%v rate_control=%v\n", n, t.Width, t.Height, t.VideoBitrate, t.AudioBitrate, t.Framerate, rc)
+	}
+
+	as := tl.ArgumentSet("", meta)
+	if !tl.TwoPass() {
+		fmt.Println("command:")
+		fmt.Printf("  ffmpeg -i %s %s %s\n", input, strings.Join(as.GetStrArguments(), " "), encoder.VariantPlaylist)
+		return nil
+	}
+	as.PassLogPrefix = "passlog"
+	fmt.Println("commands:")
+	fmt.Printf("  ffmpeg -i %s %s %s\n", input, strings.Join(as.BuildFirstPass().Strings(), " "), strings.Join(encoder.FirstPassOutput, " "))
+	as.Pass = 2
+	fmt.Printf("  ffmpeg -i %s %s %s\n", input, strings.Join(as.GetStrArguments(), " "), encoder.VariantPlaylist)
+	return nil
+}
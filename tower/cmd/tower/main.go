@@ -22,6 +22,7 @@ import (
 	"github.com/lbryio/transcoder/tower/queue"
 
 	"github.com/alecthomas/kong"
+	"github.com/fasthttp/router"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
@@ -85,25 +86,44 @@ func serve() {
 		log.Fatal("library db initialization failed", err)
 	}
 
-	s3storage, err := storage.InitS3Driver(
-		storage.S3Configure().
-			Endpoint(s3cfg["endpoint"]).
-			Credentials(s3cfg["key"], s3cfg["secret"]).
-			Bucket(s3cfg["bucket"]).
-			Name(s3cfg["name"]),
-	)
-	if err != nil {
-		log.Fatal("s3 driver initialization failed", err)
+	var strg storage.Driver
+	var strgCfg map[string]string
+	var localStorage *storage.LocalStorage
+	if cfg.IsSet("local") {
+		strgCfg = cfg.GetStringMapString("local")
+		localStorage, err = storage.InitLocalStorage(
+			storage.LocalConfigure().
+				Name(strgCfg["name"]).
+				Path(strgCfg["path"]).
+				URL(strgCfg["url"]),
+		)
+		if err != nil {
+			log.Fatal("local storage initialization failed", err)
+		}
+		log.Infow("local storage configured", "path", strgCfg["path"], "url", strgCfg["url"])
+		strg = localStorage
+	} else {
+		strgCfg = s3cfg
+		strg, err = storage.InitS3Driver(
+			storage.S3Configure().
+				Endpoint(s3cfg["endpoint"]).
+				Credentials(s3cfg["key"], s3cfg["secret"]).
+				Bucket(s3cfg["bucket"]).
+				Name(s3cfg["name"]),
+		)
+		if err != nil {
+			log.Fatal("s3 driver initialization failed", err)
+		}
+		log.Infow("s3 storage configured", "bucket", s3cfg["bucket"])
 	}
-	log.Infow("s3 storage configured", "bucket", s3cfg["bucket"])
 
 	lib := library.New(library.Config{
 		DB:      libDB,
-		Storage: s3storage,
+		Storage: strg,
 		Log:     zapadapter.NewKV(nil),
 	})
 
-	cleanStopChan := library.SpawnLibraryCleaning(lib, s3storage.Name(), library.StringToSize(s3cfg["maxsize"]))
+	cleanStopChan := library.SpawnLibraryCleaning(lib, strg.Name(), library.StringToSize(strgCfg["maxsize"]))
 
 	qCfg := cfg.GetStringMapString("queue")
 	// queueDB, err := queue.ConnectDB(queue.DefaultDBConfig().DSN(qCfg["dsn"]))
@@ -128,6 +148,13 @@ func serve() {
 	if CLI.Serve.DevMode {
 		serverConfig = serverConfig.DevMode()
 	}
+	if localStorage != nil {
+		serverConfig = serverConfig.Routes(func(r *router.Router) {
+			if err := localStorage.Route(r); err != nil {
+				log.Fatal("cannot serve local storage", err)
+			}
+		})
+	}
 
 	server, err := tower.NewServer(serverConfig)
 	if err != nil {
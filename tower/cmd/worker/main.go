@@ -58,19 +58,34 @@ func main() {
 			log.Fatal(err)
 		}
 
-		s3cfg := storage.S3Configure().
-			Endpoint(s3opts["endpoint"]).
-			Credentials(s3opts["key"], s3opts["secret"]).
-			Bucket(s3opts["bucket"]).
-			Name(s3opts["name"])
-		if s3opts["createbucket"] == "true" {
-			s3cfg = s3cfg.CreateBucket()
+		var strg storage.Driver
+		if cfg.IsSet("local") {
+			localOpts := cfg.GetStringMapString("local")
+			strg, err = storage.InitLocalStorage(
+				storage.LocalConfigure().
+					Name(localOpts["name"]).
+					Path(localOpts["path"]).
+					URL(localOpts["url"]),
+			)
+			if err != nil {
+				log.Fatal("local storage initialization failed", err)
+			}
+			log.Infow("local storage configured", "path", localOpts["path"])
+		} else {
+			s3cfg := storage.S3Configure().
+				Endpoint(s3opts["endpoint"]).
+				Credentials(s3opts["key"], s3opts["secret"]).
+				Bucket(s3opts["bucket"]).
+				Name(s3opts["name"])
+			if s3opts["createbucket"] == "true" {
+				s3cfg = s3cfg.CreateBucket()
+			}
+			strg, err = storage.InitS3Driver(s3cfg)
+			if err != nil {
+				log.Fatal("s3 driver initialization failed", err)
+			}
+			log.Infow("s3 storage configured", "endpoint", s3opts["endpoint"])
 		}
-		s3storage, err := storage.InitS3Driver(s3cfg)
-		if err != nil {
-			log.Fatal("s3 driver initialization failed", err)
-		}
-		log.Infow("s3 storage configured", "endpoint", s3opts["endpoint"])
 
 		wrkCfg := tower.DefaultWorkerConfig().
 			WorkerID(CLI.Start.WorkerID).
@@ -79,7 +94,7 @@ func main() {
 			WorkDir(CLI.Start.WorkDir).
 			RMQAddr(CLI.Start.RMQAddr).
 			HttpServerBind(CLI.Start.HttpBind).
-			S3Driver(s3storage)
+			Storage(strg)
 		c, err := tower.NewWorker(wrkCfg)
 		if err != nil {
 			log.Fatal(err)
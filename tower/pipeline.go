@@ -43,7 +43,7 @@ type pipeline struct {
 	workerID string
 	workDirs map[string]string
 	encoder  encoder.Encoder
-	s3       *storage.S3Driver
+	storage  storage.Driver
 	log      logging.KVLogger
 }
 
@@ -52,7 +52,7 @@ type streamUploadResult struct {
 	url string // sd hash
 }
 
-func newPipeline(workDir, workerID string, s3 *storage.S3Driver, encoder encoder.Encoder, logger logging.KVLogger) (*pipeline, error) {
+func newPipeline(workDir, workerID string, storage storage.Driver, encoder encoder.Encoder, logger logging.KVLogger) (*pipeline, error) {
 	p := pipeline{
 		workDir:  workDir,
 		encoder:  encoder,
@@ -64,7 +64,7 @@ func newPipeline(workDir, workerID string, s3 *storage.S3Driver, encoder encoder
 		dirStreams:    path.Join(p.workDir, dirStreams),
 		dirTranscoded: path.Join(p.workDir, dirTranscoded),
 	}
-	p.s3 = s3
+	p.storage = storage
 
 	return &p, nil
 }
@@ -78,6 +78,7 @@ func (c *pipeline) Process(task workerTask) {
 		errMtr := metrics.TranscodingErrorsCount
 
 		var resolved *resolve.ResolvedStream
+		var inputSize int64
 
 		{
 			timer := time.Now()
@@ -103,6 +104,7 @@ func (c *pipeline) Process(task workerTask) {
 			defer os.RemoveAll(origFile)
 			defer os.RemoveAll(encodedPath)
 			resolved = dl.Resolved
+			inputSize = dl.Size
 		}
 
 		{
@@ -133,8 +135,8 @@ func (c *pipeline) Process(task workerTask) {
 			}
 
 			time.Sleep(5 * time.Second)
-			stream = library.InitStream(encodedPath, c.s3.Name())
-			err = stream.GenerateManifest(task.payload.URL, resolved.ChannelURI, task.payload.SDHash)
+			stream = library.InitStream(encodedPath, c.storage.Name())
+			err = stream.GenerateManifest(task.payload.URL, resolved.ChannelURI, task.payload.SDHash, library.WithInputSize(inputSize))
 			if err != nil {
 				log.Error("failed to fill manifest", "err", err)
 				runMtr.Dec()
@@ -159,7 +161,7 @@ func (c *pipeline) Process(task workerTask) {
 
 			task.progress <- taskProgress{Stage: StageUploading, Percent: 0}
 			runMtr.Inc()
-			err := c.s3.PutWithContext(context.Background(), stream, true)
+			err := c.storage.PutWithContext(context.Background(), stream, true)
 			if err != nil {
 				e := taskError{err: errors.Wrap(err, "stream upload failed")}
 				if errors.Is(err, storage.ErrStreamExists) {
@@ -47,7 +47,7 @@ func NewTestTowerRPC(t *testing.T, db *sql.DB) *towerRPC {
 	return tower
 }
 
-func NewTestTowerLite(t *testing.T, storage *storage.S3Driver, mgr *manager.VideoManager) (*ServerLite, error) {
+func NewTestTowerLite(t *testing.T, storage storage.Driver, mgr *manager.VideoManager) (*ServerLite, error) {
 	logger := zapadapter.NewKV(nil)
 
 	enc, err := encoder.NewEncoder(encoder.Configure().Log(logger))
@@ -42,6 +42,7 @@ type ServerConfig struct {
 	managerToken            string
 	timings                 map[string]time.Duration
 	devMode                 bool
+	routes                  func(*router.Router)
 }
 
 type Server struct {
@@ -116,6 +117,12 @@ func (c *ServerConfig) DB(db *sql.DB) *ServerConfig {
 	return c
 }
 
+// Routes registers additional HTTP routes on the tower server, like local storage handler.
+func (c *ServerConfig) Routes(fn func(*router.Router)) *ServerConfig {
+	c.routes = fn
+	return c
+}
+
 func (c *ServerConfig) DevMode() *ServerConfig {
 	c.devMode = true
 	return c
@@ -276,6 +283,9 @@ func (s *Server) startHttpServer() error {
 	})
 
 	router.GET("/debug/pprof/{profile:*}", pprofhandler.PprofHandler)
+	if s.routes != nil {
+		s.routes(router)
+	}
 
 	s.log.Info("starting tower http server", "addr", s.httpServerBind, "url", s.HttpServerURL)
 	// TODO: Cleanup middleware attachment.
@@ -23,7 +23,7 @@ type WorkerConfig struct {
 	httpServerBind string
 	log            logging.KVLogger
 	timings        map[string]time.Duration
-	s3             *storage.S3Driver
+	storage        storage.Driver
 }
 
 type Worker struct {
@@ -56,8 +56,8 @@ func NewWorker(config *WorkerConfig) (*Worker, error) {
 	if err != nil {
 		return nil, err
 	}
-	if config.s3 == nil {
-		return nil, errors.New("s3 configuration not set")
+	if config.storage == nil {
+		return nil, errors.New("storage not configured")
 	}
 	w := Worker{
 		WorkerConfig:        config,
@@ -78,7 +78,7 @@ func NewWorker(config *WorkerConfig) (*Worker, error) {
 	w.id = config.id
 	w.rpc.id = config.id
 
-	p, err := newPipeline(config.workDir, w.id, config.s3, enc, w.log)
+	p, err := newPipeline(config.workDir, w.id, config.storage, enc, w.log)
 	if err != nil {
 		return nil, err
 	}
@@ -104,7 +104,13 @@ func (c *WorkerConfig) WorkDir(workDir string) *WorkerConfig {
 }
 
 func (c *WorkerConfig) S3Driver(s3 *storage.S3Driver) *WorkerConfig {
-	c.s3 = s3
+	c.storage = s3
+	return c
+}
+
+// Storage sets a storage driver for uploading transcoded streams.
+func (c *WorkerConfig) Storage(d storage.Driver) *WorkerConfig {
+	c.storage = d
 	return c
 }
 